@@ -8,8 +8,5 @@ echo "Compiling the source..."
 cd "${PWD}/../src"
 go build
 mv src ../build/saga-mikron
-cp -R tpl ../build/tpl
 cp -R ver ../build/ver
-cp -R dat ../build/dat
-rm ../build/dat/.gitignore
 echo "Compilation has finished. Have a good day :)"
@@ -3,10 +3,7 @@
 package main
 
 import (
-	"bytes"
-	"encoding/base64"
-	"fmt"
-	"log"
+	"strconv"
 	"time"
 )
 
@@ -22,6 +19,11 @@ type tActiveClient struct {
 	sid            string // Session ID of a Client
 	address        string // Address of a Client
 	lastActiveTime int64  // Time of last Activity of a Client
+	typingTime     int64  // Time of the last "typing" Signal of a Client
+	read_mid       uint16 // ID of the last Message seen by a Client
+	lastInputTime  int64  // Time of last Input (Message, typing) of a Client
+	presence       uint8  // Presence chosen by a Client, presenceOnline or presenceDnd
+	status         string // Custom Status Text of a Client
 }
 type tActiveClients map[uint64]tActiveClient // Key = UID
 
@@ -29,7 +31,11 @@ type tActiveJob struct {
 	uid           uint64
 	client        tActiveClient
 	returnChannel chan tActiveJob
+	rid           string // ID of the Request which made the Job, for the Log
 	action        uint8
+	list_v1       string   // List of active Clients in the JSON Format
+	names         []string // Names of typing Clients
+	count         int      // Number of active Clients
 }
 
 //------------------------------------------------------------------------------
@@ -38,16 +44,35 @@ const activeRevisorInterval_default = 30 // Interval for Monitoring Active Clien
 const userIdleTimeout = 120              // Idle Client Timeout, in Seconds
 const activeManagerChanBufferLen = 64    // Buffer Length of the Active Manager's Channel
 
-const activeJobDelete = 1      // Action Code for Active Manager to Delete User from List
-const activeJobUpdateUser = 2  // Action Code for Active Manager to Update User's L.A.T.
-const activeJobGetUser = 3     // Action Code for Active Manager to Get User's Information
-const activeJobGetList = 4     // Action Code for Active Manager to Get List of active Clients
-const activeJobUpdateCache = 5 // Action Code for Active Manager to update the cached List (in JSON Format)
+const activeJobDelete = 1       // Action Code for Active Manager to Delete User from List
+const activeJobUpdateUser = 2   // Action Code for Active Manager to Update User's L.A.T.
+const activeJobGetUser = 3      // Action Code for Active Manager to Get User's Information
+const activeJobGetList = 4      // Action Code for Active Manager to Get List of active Clients
+const activeJobUpdateCache = 5  // Action Code for Active Manager to update the cached List (in JSON Format)
+const activeJobSetTyping = 6    // Action Code for Active Manager to mark User as typing
+const activeJobSetRead = 7      // Action Code for Active Manager to set User's last read Message
+const activeJobGetTyping = 8    // Action Code for Active Manager to Get List of typing Clients
+const activeJobSetInput = 9     // Action Code for Active Manager to Update User's Input Time
+const activeJobSetPresence = 10 // Action Code for Active Manager to set User's Presence & Status
+const activeJobPing = 11        // Action Code for Active Manager to only answer (Health Check)
+const activeJobCount = 12       // Action Code for Active Manager to count active Clients
+
+const typingTimeout = 6 // A "typing" Signal is shown for this Time, in Seconds
+
+// Presence
+const awayTimeout_default = 5 // Client without Input is "away" after this Time, in Minutes
+const presenceOnline = 0      // Presence: Client is online (or "away", if idle)
+const presenceDnd = 1         // Presence: Client does not want to be disturbed
+const presence_online = "online"
+const presence_away = "away"
+const presence_dnd = "dnd"
+const status_maxLen = 64 // Maximum Length of the Status Text, in Symbols
 
 //------------------------------------------------------------------------------
 
 // Internal Parameters
 var activeRevisorInterval int
+var awayTimeout int64 // in Seconds
 
 // Lists
 var activeClientsList tActiveClients
@@ -101,13 +126,18 @@ func activeRevisor() {
 
 				// Get Feedback
 				*activeJob = <-rcvChan
+
+				announce(i, announceEventTimeout)
+				webhook_emit(webhookEventLeave, i, webhookReasonTimeout, 0, false)
 			}
 
 		}
 
 		// After all idle Clients are deleted from active Clients's List,
-		// Ask activeManager to resync List of active Users
-		if count > 0 {
+		// Ask activeManager to resync List of active Users. Idle Times and
+		// "away" States change with Time, so the List is re-synchronized
+		// even if nobody was deleted.
+		if (count > 0) || (len(activeClientsList) > 0) {
 
 			// Modify previous Job
 			activeJob.action = activeJobUpdateCache // Update Cache
@@ -120,7 +150,7 @@ func activeRevisor() {
 		select {
 		case <-activeRevisorQuit:
 			loop = false
-			log.Println("Closing Activity Revisor...") //
+			log_info("", "Closing Activity Revisor...") //
 		default:
 
 		}
@@ -139,13 +169,14 @@ func activeManager() {
 	var loop bool = true
 	var job tActiveJob
 	var tmp_client tActiveClient
-	var key uint64
-	var activeUsersListJSON, text string // A cached List of active Clients
-	var buffer bytes.Buffer
-	var count, cur int
+	var exists bool
+	var activeUsersListJSON string // A cached List of active Clients, legacy Format
+	var activeUsersListV1 string   // A cached List of active Clients, JSON Format
+	var cacheIsOld bool            // Cached List must be re-created before Use
 
 	// Preparations
-	activeUsersListJSON = "{\"names\":[]}" // Initial is empty, Server has just started.
+	// Initial is empty, Server has just started.
+	activeUsersListJSON, activeUsersListV1 = activeList_JSON()
 
 	for loop {
 
@@ -159,8 +190,16 @@ func activeManager() {
 
 		} else if job.action == activeJobGetList { // Get List
 
+			// Read Marks change often, so the List is re-created only when
+			// somebody asks for it.
+			if cacheIsOld {
+				activeUsersListJSON, activeUsersListV1 = activeList_JSON()
+				cacheIsOld = false
+			}
+
 			// Pack List into "address" field, as it is the same string
 			job.client.address = activeUsersListJSON
+			job.list_v1 = activeUsersListV1
 
 		} else if job.action == activeJobGetUser { // Get User
 
@@ -173,26 +212,62 @@ func activeManager() {
 		} else if job.action == activeJobUpdateCache { // Update Cache
 
 			// Re-Create the List of active Users
-			// Output in JSON Format
-			buffer.WriteString("{\"names\":[")
-			count = len(activeClientsList)
-			cur = 1
-			for key, _ = range activeClientsList {
-
-				// Write next User
-				text = base64.StdEncoding.EncodeToString([]byte(userDataList[key].name))
-				if cur < count {
-					// not last
-					buffer.WriteString(fmt.Sprintf("\"%s\",", text))
-				} else {
-					// last element
-					buffer.WriteString(fmt.Sprintf("\"%s\"", text))
+			activeUsersListJSON, activeUsersListV1 = activeList_JSON()
+			cacheIsOld = false
+
+		} else if job.action == activeJobSetTyping { // Typing
+
+			tmp_client, exists = activeClientsList[job.uid]
+			if exists {
+				tmp_client.typingTime = time.Now().Unix()
+				if activeList_isAway(&tmp_client, tmp_client.typingTime) {
+					cacheIsOld = true // back from "away"
 				}
-				cur++
+				tmp_client.lastInputTime = tmp_client.typingTime
+				activeClientsList[job.uid] = tmp_client
+			}
+
+		} else if job.action == activeJobSetInput { // Input
+
+			// A late Job must not bring a logged-out Client back
+			tmp_client, exists = activeClientsList[job.uid]
+			if exists {
+				tmp_client.lastInputTime = time.Now().Unix()
+				activeClientsList[job.uid] = tmp_client
+				cacheIsOld = true // Idle Time has changed
+			}
+
+		} else if job.action == activeJobSetPresence { // Presence
+
+			tmp_client, exists = activeClientsList[job.uid]
+			if exists {
+				tmp_client.presence = job.client.presence
+				tmp_client.status = job.client.status
+				tmp_client.lastInputTime = time.Now().Unix()
+				activeClientsList[job.uid] = tmp_client
+				cacheIsOld = true
+			}
+
+		} else if job.action == activeJobSetRead { // Read
+
+			tmp_client, exists = activeClientsList[job.uid]
+			if exists && (tmp_client.read_mid != job.client.read_mid) {
+				tmp_client.read_mid = job.client.read_mid
+				activeClientsList[job.uid] = tmp_client
+				cacheIsOld = true
 			}
-			buffer.WriteString("]}")
-			activeUsersListJSON = buffer.String()
-			buffer.Reset()
+
+		} else if job.action == activeJobGetTyping { // Get typing Users
+
+			job.names = activeList_typing(job.uid)
+
+		} else if job.action == activeJobPing { // Ping
+
+			// Only the Feedback
+
+		} else if job.action == activeJobCount { // Count
+
+			job.count = len(activeClientsList)
 
 		}
 
@@ -203,10 +278,134 @@ func activeManager() {
 		select {
 		case <-activeManagerQuit:
 			loop = false
-			log.Println("Closing Active Manager...") //
+			log_info("", "Closing Active Manager...") //
 		default:
 		}
 	}
 }
 
 //------------------------------------------------------------------------------
+
+func activeList_JSON() (legacy, v1 string) {
+
+	// Creates the List of active Users in both Formats.
+
+	var key uint64
+	var v tActiveClient
+	var ud tUserData
+	var users []tApiUser
+	var u tApiUser
+	var now int64
+
+	now = time.Now().Unix()
+	for key, v = range activeClientsList {
+		u.Uid = strconv.FormatUint(key, 10)
+		ud, _ = user_get(key)
+		u.Name = ud.name
+		u.Reg = ud.reg_time
+		u.Idle = now - v.lastInputTime
+		u.St = activeList_presence(&v, now)
+		u.Stx = v.status
+		u.Read = v.read_mid
+		users = append(users, u)
+	}
+
+	return legacy_users(users, now), api_users(users, now)
+}
+
+//------------------------------------------------------------------------------
+
+func activeList_isAway(client *tActiveClient, now int64) (away bool) {
+
+	// Checks whether the Client has had no Input for too long.
+
+	return (client.presence == presenceOnline) && (now-client.lastInputTime > awayTimeout)
+}
+
+//------------------------------------------------------------------------------
+
+func activeList_presence(client *tActiveClient, now int64) (presence string) {
+
+	// Returns the Name of the Client's Presence.
+
+	if client.presence == presenceDnd {
+		return presence_dnd
+	}
+	if activeList_isAway(client, now) {
+		return presence_away
+	}
+
+	return presence_online
+}
+
+//------------------------------------------------------------------------------
+
+func activeList_typing(uid uint64) (names []string) {
+
+	// Returns the Names of Users who are typing now, except the User who
+	// asks.
+
+	var key uint64
+	var v tActiveClient
+	var criterion int64
+
+	criterion = time.Now().Unix() - typingTimeout
+	for key, v = range activeClientsList {
+		if (key != uid) && (v.typingTime >= criterion) {
+			names = append(names, user_name(key))
+		}
+	}
+
+	return names
+}
+
+//------------------------------------------------------------------------------
+
+func activeList_count(rid string) (count int) {
+
+	// Gives the Number of active Clients, as the activeManager sees it.
+
+	var rcvChan chan tActiveJob
+	var activeJob *tActiveJob
+
+	// Create Job
+	rcvChan = make(chan tActiveJob)
+	activeJob = new(tActiveJob)
+	activeJob.action = activeJobCount // Count
+	activeJob.rid = rid
+	activeJob.returnChannel = rcvChan
+
+	// Send Job
+	activeManagerChan <- *activeJob
+
+	// Get Feedback
+	*activeJob = <-rcvChan
+
+	return activeJob.count
+}
+
+//------------------------------------------------------------------------------
+
+func activeList_setInput(uid uint64, rid string) {
+
+	// Tells the activeManager that the User has made some Input.
+
+	var rcvChan chan tActiveJob
+	var activeJob *tActiveJob
+
+	// Create Job
+	rcvChan = make(chan tActiveJob)
+	activeJob = new(tActiveJob)
+	activeJob.action = activeJobSetInput // Input
+	activeJob.uid = uid
+	activeJob.rid = rid
+	activeJob.returnChannel = rcvChan
+
+	// Send Job
+	activeManagerChan <- *activeJob
+
+	// Get Feedback
+	*activeJob = <-rcvChan
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,209 @@
+// activity_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+func activity_testJob(action uint8, uid uint64, client tActiveClient) (job tActiveJob) {
+
+	// Sends a Job to the activeManager, as a Handler does, and gives the
+	// Feedback.
+
+	job.action = action
+	job.uid = uid
+	job.client = client
+	job.returnChannel = make(chan tActiveJob)
+	activeManagerChan <- job
+
+	return <-job.returnChannel
+}
+
+//------------------------------------------------------------------------------
+
+func TestActiveLateJobs(t *testing.T) {
+
+	// Jobs which come after the Log-Out do not bring the Session back, so
+	// the User can log in again.
+
+	var tests = []struct {
+		name   string
+		action uint8
+		client tActiveClient
+	}{
+		{"input", activeJobSetInput, tActiveClient{}},
+		{"presence", activeJobSetPresence, tActiveClient{presence: presenceDnd, status: "gone"}},
+		{"typing", activeJobSetTyping, tActiveClient{}},
+		{"read mark", activeJobSetRead, tActiveClient{read_mid: 1}},
+	}
+
+	var c *tTestClient
+	var uid uint64
+	var reply string
+	var i int
+
+	harness_quiet(t)
+	c = harness_client(t, false)
+	uid = c.register("Peggy", "peggy-pwd")
+
+	for i = 0; i < len(tests); i++ {
+		reply = c.login(uid, "peggy-pwd")
+		if !strings.Contains(reply, "You are now logged in") {
+			t.Fatalf("%s: login: %s", tests[i].name, reply)
+		}
+		c.get(path_logout)
+
+		activity_testJob(tests[i].action, uid, tests[i].client)
+		if activity_testJob(activeJobGetUser, uid, tActiveClient{}).client != (tActiveClient{}) {
+			t.Errorf("%s: logged-out user is active again", tests[i].name)
+		}
+	}
+
+	reply = c.login(uid, "peggy-pwd")
+	if !strings.Contains(reply, "You are now logged in") {
+		t.Errorf("login after late jobs: %s", reply)
+	}
+	c.get(path_logout)
+}
+
+//------------------------------------------------------------------------------
+
+func activity_testLogin(t *testing.T, name string) (c *tTestClient, mid, ts string) {
+
+	// Logs a new User in with the JSON Format and gives the Cursor.
+
+	var uid uint64
+	var delta tApiDelta
+
+	c = harness_client(t, true)
+	uid = c.register(name, name+"-pwd")
+	c.login(uid, name+"-pwd")
+	t.Cleanup(func() {
+		c.get(path_logout)
+	})
+	_, delta, _ = c.delta(param_unknownVal, param_unknownVal)
+
+	return c, strconv.FormatUint(uint64(delta.X.Mid), 10), strconv.FormatInt(delta.X.Ts, 10)
+}
+
+//------------------------------------------------------------------------------
+
+func activity_testRead(c *tTestClient, name string) (read uint16, found bool) {
+
+	// Gives the Read Mark of the User from the List of active Users.
+
+	var list tApiUsers
+	var reply string
+	var i int
+
+	_, reply = c.get(path_activeList)
+	if json.Unmarshal([]byte(reply), &list) != nil {
+		c.t.Fatalf("users: %s", reply)
+	}
+	for i = 0; i < len(list.Users); i++ {
+		if list.Users[i].Name == name {
+			return list.Users[i].Read, true
+		}
+	}
+
+	return 0, false
+}
+
+//------------------------------------------------------------------------------
+
+func TestActiveTyping(t *testing.T) {
+
+	// A "typing" Signal is shown to the other Users in the Delta until it
+	// expires.
+
+	var quentin, rupert *tTestClient
+	var mid, ts, reply string
+	var delta tApiDelta
+
+	harness_quiet(t)
+	quentin, mid, ts = activity_testLogin(t, "Quentin")
+	rupert, _, _ = activity_testLogin(t, "Rupert")
+
+	_, reply = rupert.get(path_typing)
+	if !strings.Contains(reply, code_messageSent) {
+		t.Fatalf("typing: %s", reply)
+	}
+	_, delta, reply = quentin.delta(mid, ts)
+	if (len(delta.Typing) != 1) || (delta.Typing[0] != "Rupert") {
+		t.Errorf("delta of another user: %s", reply)
+	}
+	_, delta, reply = rupert.delta(mid, ts)
+	if len(delta.Typing) != 0 {
+		t.Errorf("delta of the typing user: %s", reply)
+	}
+
+	if testing.Short() {
+		return
+	}
+	time.Sleep(time.Second * (typingTimeout + 1))
+	_, delta, reply = quentin.delta(mid, ts)
+	if len(delta.Typing) != 0 {
+		t.Errorf("typing has not expired: %s", reply)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestActiveRead(t *testing.T) {
+
+	// The Read Mark sent with a Delta is seen by the other Users in the List
+	// of active Users. A bad Mark is ignored.
+
+	var tests = []struct {
+		name string
+		read string
+		want uint16
+	}{
+		{"first mark", "1", 1},
+		{"same mark", "1", 1},
+		{"next mark", "2", 2},
+		{"no mark", "", 2},
+		{"bad mark", "two", 2},
+		{"mark out of range", "65536", 2},
+	}
+
+	var sybil, trent *tTestClient
+	var mid, ts, reply string
+	var form url.Values
+	var read uint16
+	var found bool
+	var status, i int
+
+	harness_quiet(t)
+	sybil, mid, ts = activity_testLogin(t, "Sybil")
+	trent, _, _ = activity_testLogin(t, "Trent")
+
+	for i = 0; i < len(tests); i++ {
+		form = url.Values{}
+		form.Set(param_req_mid, mid)
+		form.Set(param_req_ts, ts)
+		if len(tests[i].read) > 0 {
+			form.Set(param_req_read, tests[i].read)
+		}
+		status, reply = sybil.postForm(path_news, form)
+		if status != http.StatusOK {
+			t.Fatalf("%s: delta: status %d: %s", tests[i].name, status, reply)
+		}
+
+		read, found = activity_testRead(trent, "Sybil")
+		if !found || (read != tests[i].want) {
+			t.Errorf("%s: read mark is %d (%v), want %d", tests[i].name, read, found, tests[i].want)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,187 @@
+// announce.go
+
+package main
+
+import (
+	"html"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+type tAnnounceJob struct {
+	uid   uint64
+	event uint8
+}
+
+// Users of one Event in an Announcement; the Client writes the Text in its
+// own Language
+type tAnnounceNames struct {
+	event uint8
+	names []string
+}
+
+//------------------------------------------------------------------------------
+
+const announceDelay = 3              // Events are collected during this Time before being posted, in Seconds
+const announceChanBufferLen = 64     // Buffer Length of the Announce Manager's Channel
+const announceEventJoin uint8 = 1    // Event: User has logged in
+const announceEventLeave uint8 = 2   // Event: User has logged out
+const announceEventTimeout uint8 = 3 // Event: User has been idle for too long
+
+//------------------------------------------------------------------------------
+
+// Internal Parameters
+var announceEnabled bool
+
+// Events in the API and in the Keys of their Texts
+var announce_kinds = map[uint8]string{
+	announceEventJoin:    "join",
+	announceEventLeave:   "leave",
+	announceEventTimeout: "timeout",
+}
+
+// Channels
+var announceChan chan tAnnounceJob
+var announcePingChan chan chan bool // Health Check
+var announceManagerQuit chan int
+
+//------------------------------------------------------------------------------
+
+func announce(uid uint64, event uint8) {
+
+	// Reports an Event to the Announce Manager.
+	// Does nothing if Announcements are disabled.
+
+	var job tAnnounceJob
+
+	if !announceEnabled {
+		return
+	}
+
+	job.uid = uid
+	job.event = event
+	announceChan <- job
+}
+
+//------------------------------------------------------------------------------
+
+func announceManager() {
+
+	// Collects Join, Leave and Timeout Events and posts them into the Chat as
+	// Messages of the System User.
+	// Events which come in a Burst are joined into a single Message, so that
+	// a Crowd of Users does not flood the Chat.
+
+	var loop bool = true
+	var job tAnnounceJob
+	var pending []tAnnounceJob
+	var timer <-chan time.Time
+	var pong chan bool
+
+	for loop {
+
+		select {
+
+		case job = <-announceChan:
+			pending = append(pending, job)
+			if timer == nil {
+				timer = time.After(time.Second * announceDelay)
+			}
+
+		case <-timer:
+			announce_post(pending)
+			pending = nil
+			timer = nil
+
+		case pong = <-announcePingChan:
+			pong <- true
+
+		case <-announceManagerQuit:
+			loop = false
+			log_info("", "Closing Announce Manager...") //
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func announce_post(events []tAnnounceJob) {
+
+	// Posts collected Events as one System Message.
+	// Only the last Event of each User is taken into Account, so that a User
+	// who has quickly re-joined is not announced twice.
+
+	var last map[uint64]uint8
+	var order []uint64
+	var byEvent map[uint8][]string
+	var announcement []tAnnounceNames
+	var event uint8
+	var uid uint64
+	var exists bool
+	var i int
+	var text string
+	var chatJob *tChatJob
+	var rcvChan chan tChatJob
+
+	last = make(map[uint64]uint8)
+	for i = 0; i < len(events); i++ {
+		_, exists = last[events[i].uid]
+		if !exists {
+			order = append(order, events[i].uid)
+		}
+		last[events[i].uid] = events[i].event
+	}
+
+	byEvent = make(map[uint8][]string)
+	for _, uid = range order {
+		byEvent[last[uid]] = append(byEvent[last[uid]], user_name(uid))
+	}
+	for _, event = range []uint8{announceEventJoin, announceEventLeave, announceEventTimeout} {
+		if len(byEvent[event]) > 0 {
+			announcement = append(announcement, tAnnounceNames{event: event, names: byEvent[event]})
+		}
+	}
+	if len(announcement) == 0 {
+		return
+	}
+
+	// Text for Bridges, Webhooks and old Clients, in the Server's Language
+	text = announce_text(announcement, i18n_default)
+
+	// Create Job for ChatManager
+	rcvChan = make(chan tChatJob)
+	chatJob = new(tChatJob)
+	chatJob.chatRecord.author = chat_systemUserUID
+	chatJob.chatRecord.kind = chat_recordKindSystem
+	chatJob.chatRecord.text = text
+	chatJob.chatRecord.message = html.EscapeString(text)
+	chatJob.chatRecord.announcement = announcement
+	chatJob.returnChannel = rcvChan
+
+	// Send Job
+	chatManagerChan <- *chatJob
+
+	// Wait for Manager
+	*chatJob = <-rcvChan
+}
+
+//------------------------------------------------------------------------------
+
+func announce_text(announcement []tAnnounceNames, lang string) (text string) {
+
+	// Writes an Announcement in the Language.
+
+	var parts []string
+	var i int
+
+	for i = 0; i < len(announcement); i++ {
+		parts = append(parts, strings.Replace(i18n_text(lang, "announce_"+announce_kinds[announcement[i].event]),
+			"{names}", strings.Join(announcement[i].names, ", "), 1))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,66 @@
+// announce_test.go
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func TestAnnouncePost(t *testing.T) {
+
+	// Announcements keep the Event and the Names, the Client writes them in
+	// its Language. Only the last Event of each User is announced.
+
+	var c *tTestClient
+	var a, b, d uint64
+	var msg tApiMessage
+	var want []tApiAnnounce
+	var u *url.URL
+	var page string
+	var status int
+
+	harness_quiet(t)
+	c = harness_client(t, false)
+	a = c.register("Mallory", "mallory-pwd")
+	b = c.register("Niaj", "niaj-pwd")
+	d = c.register("Olivia", "olivia-pwd")
+
+	announce_post([]tAnnounceJob{
+		{a, announceEventJoin},
+		{b, announceEventLeave},
+		{d, announceEventTimeout},
+		{b, announceEventJoin},
+	})
+
+	msg = api_message(chat_recordLastNum)
+	want = []tApiAnnounce{
+		{"join", []string{"Mallory", "Niaj"}},
+		{"timeout", []string{"Olivia"}},
+	}
+	if !msg.Sys || !reflect.DeepEqual(msg.Ann, want) {
+		t.Fatalf("message is %+v, want %+v", msg, want)
+	}
+	if msg.Txt != "Joined: Mallory, Niaj. Timed out: Olivia." {
+		t.Errorf("text is %q", msg.Txt)
+	}
+	if announce_text(chatRecordsList[chat_recordLastNum].announcement, "ru") != "Вошли: Mallory, Niaj. Отключены по бездействию: Olivia." {
+		t.Errorf("russian text is %q", announce_text(chatRecordsList[chat_recordLastNum].announcement, "ru"))
+	}
+
+	// The Page has the Texts in its Language
+	c.login(a, "mallory-pwd")
+	u, _ = url.Parse(harness_server.URL)
+	c.http.Jar.SetCookies(u, []*http.Cookie{{Name: i18n_cookie, Value: "ru"}})
+	status, page = c.get(path_chat)
+	if (status != http.StatusOK) || !strings.Contains(page, "'join': \"Вошли: {names}.\"") {
+		t.Errorf("chat page %d has no texts of announcements", status)
+	}
+}
+
+//------------------------------------------------------------------------------
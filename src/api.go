@@ -0,0 +1,483 @@
+// api.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	Replies of the Server to dynamic Requests.
+
+	There are two Formats of Replies.
+
+	1. Versioned JSON ("v":1). It is used when the Client asks for it with
+	the "Accept: application/json" Header. All Replies are JSON Objects,
+	Numbers are Numbers, Texts are UTF-8 Strings. Errors are Objects with the
+	same Codes which are used by the legacy Format:
+
+		{"v":1, "error":{"code":"L", "text":"not logged in"}}
+
+	2. Legacy Format, as it was before: a single Letter for Codes and JSON
+	with quoted Numbers and base64 Texts for Data. It is used when the Client
+	does not ask for JSON, or when the Path has the "/v0" Prefix.
+
+*/
+
+//------------------------------------------------------------------------------
+
+// Delta of Messages
+type tApiDelta struct {
+	V        int           `json:"v"`
+	Messages []tApiMessage `json:"messages"`
+	X        tApiCursor    `json:"x"`
+	Typing   []string      `json:"typ,omitempty"`
+
+	noNews bool // Legacy Clients get code_NoNews instead of an empty List
+}
+
+type tApiMessage struct {
+	Mid uint16          `json:"mid"`
+	Tim string          `json:"tim"` // Time of the Day on the Server, for old Clients
+	Ts  int64           `json:"ts"`  // Unix Timestamp, UTC
+	Atr string          `json:"atr"`
+	Txt string          `json:"txt"`
+	Att *tApiAttachment `json:"att,omitempty"`
+	Sys bool            `json:"sys,omitempty"`
+	Ann []tApiAnnounce  `json:"ann,omitempty"` // Announcement of the System User; "txt" has it in the Server's Language
+}
+
+type tApiAnnounce struct {
+	Kind  string   `json:"kind"` // "join", "leave" or "timeout"
+	Names []string `json:"names"`
+}
+
+type tApiAttachment struct {
+	Id    string `json:"id"`
+	Name  string `json:"n"`
+	Mime  string `json:"m"`
+	Size  int64  `json:"s"`
+	Thumb bool   `json:"th"`
+}
+
+type tApiCursor struct {
+	Mid uint16 `json:"mid"`
+	Ts  int64  `json:"ts"`
+}
+
+// List of active Users
+type tApiUsers struct {
+	V     int        `json:"v"`
+	Users []tApiUser `json:"users"`
+	Ts    int64      `json:"ts"`
+}
+
+type tApiUser struct {
+	Uid  string `json:"uid"` // String, as JavaScript can not hold 64-bit Integers
+	Name string `json:"name"`
+	Reg  int64  `json:"reg"`
+	Idle int64  `json:"idle"`
+	St   string `json:"st"`
+	Stx  string `json:"stx"`
+	Read uint16 `json:"read"`
+}
+
+// Anti-Spam Question
+type tApiAsq struct {
+	V   int    `json:"v"`
+	Qid string `json:"qid"`
+	Msg string `json:"msg"` // PNG Image, base64
+}
+
+// Reply with a Code only
+type tApiCode struct {
+	V     int           `json:"v"`
+	Code  string        `json:"code,omitempty"`
+	Error *tApiErrorObj `json:"error,omitempty"`
+}
+
+type tApiErrorObj struct {
+	Code string `json:"code"`
+	Text string `json:"text"`
+}
+
+type tApiContextKey int
+
+//------------------------------------------------------------------------------
+
+const api_version = 1 // Version of the JSON Format
+const api_mimeJSON = "application/json"
+const api_contentJSON = "application/json; charset=utf-8"
+const api_contentText = "text/plain; charset=utf-8"
+const api_contentHTML = "text/html; charset=utf-8"
+const path_legacyPrefix = "/v0"        // Prefix of Paths which always get the legacy Format
+const api_ctxLegacy tApiContextKey = 1 // Context Key: legacy Format is requested by Path
+const api_ctxRid tApiContextKey = 2    // Context Key: ID of the Request, for the Log
+
+// HTTP Status and Description of each Code in the JSON Format
+var api_codeStatus = map[string]int{
+	code_messageSent:     http.StatusOK,
+	code_NoNews:          http.StatusOK,
+	code_NotLoggedIn:     http.StatusUnauthorized,
+	code_EmptyMessage:    http.StatusBadRequest,
+	code_BadPOSTdata:     http.StatusBadRequest,
+	code_BadRequest:      http.StatusBadRequest,
+	code_msgTooLong:      http.StatusRequestEntityTooLarge,
+	code_badFileType:     http.StatusUnsupportedMediaType,
+	code_noSuchPath:      http.StatusNotFound,
+	code_tooManyRequests: http.StatusTooManyRequests,
+	code_forbidden:       http.StatusForbidden,
+	code_serverError:     http.StatusInternalServerError,
+}
+var api_codeText = map[string]string{
+	code_messageSent:     "ok",
+	code_NoNews:          "no news",
+	code_NotLoggedIn:     "not logged in",
+	code_EmptyMessage:    "empty message",
+	code_BadPOSTdata:     "bad POST data",
+	code_BadRequest:      "bad request",
+	code_msgTooLong:      "message is too long",
+	code_badFileType:     "file type is not allowed",
+	code_noSuchPath:      "no such method or path",
+	code_tooManyRequests: "too many requests",
+	code_forbidden:       "forbidden",
+	code_serverError:     "server error",
+}
+
+//------------------------------------------------------------------------------
+
+func api_stripLegacyPrefix(req *http.Request) (r *http.Request) {
+
+	// Removes the "/v0" Prefix from the Path and remembers that the legacy
+	// Format is requested.
+
+	if (req.URL.Path != path_legacyPrefix) &&
+		!strings.HasPrefix(req.URL.Path, path_legacyPrefix+"/") {
+		return req
+	}
+
+	req.URL.Path = strings.TrimPrefix(req.URL.Path, path_legacyPrefix)
+	if len(req.URL.Path) == 0 {
+		req.URL.Path = path_index
+	}
+
+	return req.WithContext(context.WithValue(req.Context(), api_ctxLegacy, true))
+}
+
+//------------------------------------------------------------------------------
+
+func api_isJSON(req *http.Request) (ok bool) {
+
+	// Checks whether the Client wants the versioned JSON Format.
+	// The REST API has no legacy Format.
+
+	if strings.HasPrefix(req.URL.Path, path_api+"/") {
+		return true
+	}
+	if req.Context().Value(api_ctxLegacy) != nil {
+		return false
+	}
+
+	return strings.Contains(req.Header.Get("Accept"), api_mimeJSON)
+}
+
+//------------------------------------------------------------------------------
+
+func api_writeJSON(w http.ResponseWriter, status int, v interface{}) {
+
+	// Marshals the Value and sends it to the Client. If the Value can not
+	// be marshalled, the Client gets a typed Error, as any JSON Client.
+
+	var data []byte
+	var failure tApiCode
+	var err error
+
+	data, err = json.Marshal(v)
+	if err != nil {
+		log_error("", "Error encoding JSON", "err", err) //
+		failure.V = api_version
+		failure.Error = &tApiErrorObj{Code: code_serverError, Text: api_codeText[code_serverError]}
+		data, _ = json.Marshal(&failure)
+		status = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", api_contentJSON)
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+//------------------------------------------------------------------------------
+
+func reply_code(w http.ResponseWriter, req *http.Request, code string) {
+
+	// Sends a Code to the Client: a Letter in the legacy Format, an Object
+	// in the JSON Format. Codes other than code_messageSent are Errors.
+
+	var reply tApiCode
+	var status int
+	var exists bool
+
+	reply_remember(w, code, 0)
+
+	if !api_isJSON(req) {
+		w.Header().Set("Content-Type", api_contentText)
+		fmt.Fprint(w, code)
+		return
+	}
+
+	status, exists = api_codeStatus[code]
+	if !exists {
+		status = http.StatusBadRequest
+	}
+
+	reply.V = api_version
+	if code == code_messageSent {
+		reply.Code = code
+	} else {
+		reply.Error = new(tApiErrorObj)
+		reply.Error.Code = code
+		reply.Error.Text = api_codeText[code]
+	}
+
+	api_writeJSON(w, status, &reply)
+}
+
+//------------------------------------------------------------------------------
+
+func reply_delta(w http.ResponseWriter, req *http.Request, delta *tApiDelta) {
+
+	// Sends a Delta of Messages to the Client.
+
+	if api_isJSON(req) {
+		delta.V = api_version
+		if delta.Messages == nil {
+			delta.Messages = []tApiMessage{}
+		}
+		api_writeJSON(w, http.StatusOK, delta)
+		return
+	}
+
+	if delta.noNews && (len(delta.Typing) == 0) {
+		reply_code(w, req, code_NoNews)
+		return
+	}
+
+	w.Header().Set("Content-Type", api_contentJSON)
+	w.Write(legacy_delta(delta))
+}
+
+//------------------------------------------------------------------------------
+
+func reply_asq(w http.ResponseWriter, req *http.Request, asq *tApiAsq) {
+
+	// Sends an Anti-Spam Question to the Client.
+
+	if api_isJSON(req) {
+		asq.V = api_version
+		api_writeJSON(w, http.StatusOK, asq)
+		return
+	}
+
+	// {"qid":"123","msg":"AbRaKaDaBrA="}
+	w.Header().Set("Content-Type", api_contentJSON)
+	fmt.Fprint(w, "{\"qid\":\"", asq.Qid, "\",\"msg\":\"", asq.Msg, "\"}")
+}
+
+//------------------------------------------------------------------------------
+
+func reply_users(w http.ResponseWriter, req *http.Request, legacy, v1 string) {
+
+	// Sends the cached List of active Users to the Client.
+
+	w.Header().Set("Content-Type", api_contentJSON)
+	if api_isJSON(req) {
+		fmt.Fprint(w, v1)
+	} else {
+		fmt.Fprint(w, legacy)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func api_message(mid uint16) (msg tApiMessage) {
+
+	// Converts a Chat Record into the Message of a Delta.
+
+	var rec *tChatRecord
+	var i int
+
+	rec = &chatRecordsList[mid]
+	msg.Mid = mid
+	msg.Tim = time_clock(rec.time)
+	msg.Ts = rec.time
+	msg.Atr = user_name(rec.author)
+	msg.Txt = rec.message
+	msg.Sys = (rec.kind == chat_recordKindSystem)
+	for i = 0; i < len(rec.announcement); i++ {
+		msg.Ann = append(msg.Ann, tApiAnnounce{Kind: announce_kinds[rec.announcement[i].event], Names: rec.announcement[i].names})
+	}
+	if len(rec.attachment.hash) > 0 {
+		msg.Att = new(tApiAttachment)
+		msg.Att.Id = rec.attachment.hash
+		msg.Att.Name = rec.attachment.name
+		msg.Att.Mime = rec.attachment.mime
+		msg.Att.Size = rec.attachment.size
+		msg.Att.Thumb = rec.attachment.thumb
+	}
+
+	return msg
+}
+
+//------------------------------------------------------------------------------
+
+func legacy_b64(s string) (b64 string) {
+
+	// Texts in the legacy Format are base64-encoded UTF-8.
+
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+//------------------------------------------------------------------------------
+
+func legacy_delta(delta *tApiDelta) (data []byte) {
+
+	// Writes the Delta in the legacy Format.
+
+	/*
+		{
+		 "messages":
+				[
+					{"mid":"123", "tim":"00", "atr":"Вася", "txt":"AU8Xv745cd=="},
+					{"mid":"124", "tim":"00", "atr":"Петя", "txt":"BU8Xv745cd=="},
+					{"mid":"125", "tim":"00", "atr":"Коля", "txt":"CU8Xv745cd==",
+					 "att":{"id":"9f86d0...", "n":"cGljLnBuZw==", "m":"image/png", "s":"1234", "th":"1"}}
+				],
+		 "x":
+				{"mid":"125", "ts":"1234567"},
+		 "typ":
+				["0JLQsNGB0Y8="]
+		}
+		"typ" is present only when somebody is typing.
+	*/
+
+	var buffer bytes.Buffer
+	var i, th int
+	var m *tApiMessage
+	var names []string
+
+	buffer.WriteString("{\"messages\": [")
+	for i = 0; i < len(delta.Messages); i++ {
+
+		m = &delta.Messages[i]
+		if i > 0 {
+			buffer.WriteString(",")
+		}
+		fmt.Fprintf(&buffer, "{\"mid\":\"%d\",\"tim\":\"%s\",\"atr\":\"%s\",\"txt\":\"%s\"",
+			m.Mid, m.Tim, legacy_b64(m.Atr), legacy_b64(m.Txt))
+		if m.Att != nil {
+			th = 0
+			if m.Att.Thumb {
+				th = 1
+			}
+			fmt.Fprintf(&buffer, ",\"att\":{\"id\":\"%s\",\"n\":\"%s\",\"m\":\"%s\",\"s\":\"%d\",\"th\":\"%d\"}",
+				m.Att.Id, legacy_b64(m.Att.Name), m.Att.Mime, m.Att.Size, th)
+		}
+		if m.Sys {
+			buffer.WriteString(",\"sys\":\"1\"")
+		}
+		buffer.WriteString("}")
+	}
+
+	// Updated "mid" & "ts"
+	fmt.Fprintf(&buffer, "], \"x\":{\"%s\":\"%d\", \"%s\":\"%d\"}",
+		param_req_mid, delta.X.Mid, param_req_ts, delta.X.Ts)
+
+	if len(delta.Typing) > 0 {
+		for i = 0; i < len(delta.Typing); i++ {
+			names = append(names, "\""+legacy_b64(delta.Typing[i])+"\"")
+		}
+		buffer.WriteString(", \"typ\":[" + strings.Join(names, ",") + "]")
+	}
+	buffer.WriteString(" }")
+
+	return buffer.Bytes()
+}
+
+//------------------------------------------------------------------------------
+
+func legacy_users(users []tApiUser, ts int64) (list string) {
+
+	// Writes the List of active Users in the legacy Format.
+	// "read" holds the ID of the last Message seen by each User, in the same
+	// Order as "names". "users" has the full Information about each User:
+	// UID, Name, Time of Registration, Idle Time (in Seconds, at the Time of
+	// the List's Creation, "ts"), Presence and Status Text.
+
+	/*
+		{"names":["0JLQsNGB0Y8="], "read":["123"],
+		 "users":[{"uid":"123456", "name":"0JLQsNGB0Y8=", "reg":"1497000000",
+				  "idle":"42", "st":"away", "stx":"0L3QsCDQvtCx0LXQtNC1"}],
+		 "ts":"1497100000"}
+	*/
+
+	var buffer bytes.Buffer
+	var names, reads, items []string
+	var i int
+	var u *tApiUser
+
+	for i = 0; i < len(users); i++ {
+		u = &users[i]
+		names = append(names, "\""+legacy_b64(u.Name)+"\"")
+		reads = append(reads, fmt.Sprintf("\"%d\"", u.Read))
+		items = append(items, fmt.Sprintf(
+			"{\"uid\":\"%s\",\"name\":\"%s\",\"reg\":\"%d\",\"idle\":\"%d\",\"st\":\"%s\",\"stx\":\"%s\"}",
+			u.Uid, legacy_b64(u.Name), u.Reg, u.Idle, u.St, legacy_b64(u.Stx)))
+	}
+
+	buffer.WriteString("{\"names\":[")
+	buffer.WriteString(strings.Join(names, ","))
+	buffer.WriteString("],\"read\":[")
+	buffer.WriteString(strings.Join(reads, ","))
+	buffer.WriteString("],\"users\":[")
+	buffer.WriteString(strings.Join(items, ","))
+	buffer.WriteString(fmt.Sprintf("],\"ts\":\"%d\"}", ts))
+
+	return buffer.String()
+}
+
+//------------------------------------------------------------------------------
+
+func api_users(users []tApiUser, ts int64) (list string) {
+
+	// Writes the List of active Users in the JSON Format.
+
+	var reply tApiUsers
+	var data []byte
+	var err error
+
+	reply.V = api_version
+	reply.Users = users
+	reply.Ts = ts
+	if reply.Users == nil {
+		reply.Users = []tApiUser{}
+	}
+
+	data, err = json.Marshal(&reply)
+	if err != nil {
+		log_error("", "Error encoding JSON", "err", err) //
+		return ""
+	}
+
+	return string(data)
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,60 @@
+// api_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func TestApiWriteJSON(t *testing.T) {
+
+	// A Value which can not be marshalled gives a typed Error of the Server,
+	// not a bare Letter.
+
+	var tests = []struct {
+		name   string
+		value  interface{}
+		status int
+		body   string
+	}{
+		{"value", map[string]int{"v": 1}, http.StatusOK, `{"v":1}`},
+		{"NaN", math.NaN(), http.StatusInternalServerError, ""},
+		{"channel", make(chan int), http.StatusInternalServerError, ""},
+	}
+
+	var rec *httptest.ResponseRecorder
+	var reply tApiCode
+	var i int
+
+	harness_quiet(t)
+
+	for i = 0; i < len(tests); i++ {
+
+		rec = httptest.NewRecorder()
+		api_writeJSON(rec, http.StatusOK, tests[i].value)
+		if (rec.Code != tests[i].status) || (rec.Header().Get("Content-Type") != api_contentJSON) {
+			t.Errorf("%s: %d %q", tests[i].name, rec.Code, rec.Header().Get("Content-Type"))
+			continue
+		}
+
+		if len(tests[i].body) > 0 {
+			if rec.Body.String() != tests[i].body {
+				t.Errorf("%s: body %q, want %q", tests[i].name, rec.Body.String(), tests[i].body)
+			}
+			continue
+		}
+		reply = tApiCode{}
+		if (json.Unmarshal(rec.Body.Bytes(), &reply) != nil) || (reply.V != api_version) ||
+			(reply.Error == nil) || (reply.Error.Code != code_serverError) || (reply.Error.Text != "server error") {
+			t.Errorf("%s: body %q", tests[i].name, rec.Body.String())
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -7,9 +7,9 @@ import (
 	"image"
 	"image/color"
 	"image/png"
-	"log"
 	"math"
 	"math/rand"
+	"sync/atomic"
 	"time"
 
 	"github.com/llgcode/draw2d/draw2dimg"
@@ -31,6 +31,8 @@ type tAsqJob struct {
 	returnChannel chan tAsqJob
 	result        bool
 	action        uint8
+	rid           string // ID of the Request which made the Job, for the Log
+	count         int    // Number of Questions
 }
 
 //------------------------------------------------------------------------------
@@ -42,11 +44,14 @@ const asqJobGet = 1                   // Action Code for ASQ Manager to Get ASQ
 const asqJobSet = 2                   // Action Code for ASQ Manager to Set ASQ
 const asqJobDelete = 3                // Action Code for ASQ Manager to Delete ASQ
 const asqJobClearData = 4             // Action Code for ASQ Manager to Clear Question in ASQ
+const asqJobPing = 5                  // Action Code for ASQ Manager to only answer (Health Check)
+const asqJobCount = 6                 // Action Code for ASQ Manager to count ASQs
 
 //------------------------------------------------------------------------------
 
 // Internal Parameters
 var asqRevisorInterval int
+var asq_maker func(asq *tAntiSpamQuestion) = asq_createData // Tests put a known Question here
 
 // Lists
 var asqsList tAntiSpamQuestions
@@ -58,7 +63,7 @@ var asqManagerQuit chan int
 
 //------------------------------------------------------------------------------
 
-func asq_create() (qid uint64) {
+func asq_create(rid string) (qid uint64) {
 
 	// Creates Anti-Spam Question.
 
@@ -69,7 +74,7 @@ func asq_create() (qid uint64) {
 
 	// Create a Question
 	asq = new(tAntiSpamQuestion)
-	asq_createData(asq)
+	asq_maker(asq)
 
 	for {
 
@@ -90,6 +95,7 @@ func asq_create() (qid uint64) {
 		job = new(tAsqJob)
 		job.action = asqJobSet // Set
 		job.qid = qid
+		job.rid = rid
 		job.asq = *asq
 		job.returnChannel = rcvChan
 
@@ -103,6 +109,7 @@ func asq_create() (qid uint64) {
 			break
 		}
 	}
+	atomic.AddUint64(&metrics_asqIssued, 1)
 
 	return job.qid
 }
@@ -185,7 +192,7 @@ func asq_createData(asq *tAntiSpamQuestion) {
 
 //------------------------------------------------------------------------------
 
-func asq_clearQuestionData(qid uint64) {
+func asq_clearQuestionData(qid uint64, rid string) {
 
 	// Clears Data (Image) of an Anti-Spam Question.
 
@@ -197,6 +204,7 @@ func asq_clearQuestionData(qid uint64) {
 	asqJob = new(tAsqJob)
 	asqJob.action = asqJobClearData // CQD
 	asqJob.qid = qid
+	asqJob.rid = rid
 	asqJob.returnChannel = rcvChan
 
 	// Send Job
@@ -208,6 +216,31 @@ func asq_clearQuestionData(qid uint64) {
 
 //------------------------------------------------------------------------------
 
+func asq_count(rid string) (count int) {
+
+	// Gives the Number of Anti-Spam Questions, as the asqManager sees it.
+
+	var rcvChan chan tAsqJob
+	var asqJob *tAsqJob
+
+	// Create Job
+	rcvChan = make(chan tAsqJob)
+	asqJob = new(tAsqJob)
+	asqJob.action = asqJobCount // Count
+	asqJob.rid = rid
+	asqJob.returnChannel = rcvChan
+
+	// Send Job
+	asqManagerChan <- *asqJob
+
+	// Wait for Feedback
+	*asqJob = <-rcvChan
+
+	return asqJob.count
+}
+
+//------------------------------------------------------------------------------
+
 func asqRevisor() {
 
 	// ASQ Revisor periodically checks created Anti-Spam Questions
@@ -249,6 +282,7 @@ func asqRevisor() {
 				// Wait for Feedback
 				*asqJob = <-rcvChan
 
+				atomic.AddUint64(&metrics_asqExpired, 1)
 			}
 		}
 
@@ -256,7 +290,7 @@ func asqRevisor() {
 		select {
 		case <-asqRevisorQuit:
 			loop = false
-			log.Println("Closing ASQ Revisor...") //
+			log_info("", "Closing ASQ Revisor...") //
 		default:
 
 		}
@@ -349,6 +383,17 @@ func asqManager() {
 			} else {
 				job.result = false
 			}
+
+		} else if job.action == asqJobPing {
+
+			// Only the Feedback
+			job.result = true
+
+		} else if job.action == asqJobCount {
+
+			// Count
+			job.count = len(asqsList)
+			job.result = true
 		}
 
 		job.returnChannel <- *job // Send back
@@ -357,7 +402,7 @@ func asqManager() {
 		select {
 		case <-asqManagerQuit:
 			loop = false
-			log.Println("Closing ASQ Manager...") //
+			log_info("", "Closing ASQ Manager...") //
 		default:
 		}
 	}
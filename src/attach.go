@@ -0,0 +1,492 @@
+// attach.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"html"
+	"image"
+	_ "image/gif"  // Decoder for Thumbnails
+	_ "image/jpeg" // Decoder for Thumbnails
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//------------------------------------------------------------------------------
+
+// Attachment of a Chat Record
+type tAttachment struct {
+	hash  string // SHA-256 of the Contents, hex; empty if there is no Attachment
+	name  string // Original File Name
+	mime  string // Detected MIME Type
+	size  int64  // Size of the File, in Bytes
+	thumb bool   // Does the Attachment have a Thumbnail ?
+}
+
+//------------------------------------------------------------------------------
+
+const attach_dir_default = "dat/att"   // Directory of the Attachment Store
+const attach_maxSize = 4 * 1024 * 1024 // Maximum Size of an uploaded File, in Bytes
+const attach_nameMaxLen = 255          // Maximum Length of the File Name
+const attach_thumbSize = 160           // Maximum Width and Height of a Thumbnail, in Pixels
+const attach_maxPixels = 4096 * 4096   // Maximum Width × Height of an uploaded Image
+const attach_thumbSuffix = ".th"       // Suffix of a Thumbnail's File Name
+const attach_hashLen = sha256.Size * 2 // Length of the hex Hash
+
+// Client's HTML Form Parameter Names, POST/GET Variable Names
+const param_file = "file" // Uploaded File
+const param_fid = "fid"   // ID (Hash) of a stored File
+const param_thumb = "th"  // Thumbnail is requested
+
+// MIME Types which may be uploaded
+var attach_mimeAllowed = map[string]bool{
+	"image/png":                 true,
+	"image/jpeg":                true,
+	"image/gif":                 true,
+	"image/webp":                true,
+	"application/pdf":           true,
+	"application/zip":           true,
+	"application/x-gzip":        true,
+	"text/plain; charset=utf-8": true,
+}
+
+// MIME Types which get a Thumbnail
+var attach_mimeImage = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+//------------------------------------------------------------------------------
+
+// Internal Parameters
+var attach_dir string
+
+//------------------------------------------------------------------------------
+
+func attach_init() (ok bool) {
+
+	// Prepares the Attachment Store.
+
+	var err error
+
+	err = os.MkdirAll(attach_dir, 0755)
+	if err != nil {
+		log_error("", "Error creating attachment directory", "dir", attach_dir, "err", err) //
+		return false
+	}
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func attach_store(data []byte, name string, rid string) (att tAttachment, ok bool) {
+
+	// Puts the File into the content-addressed Store.
+	// Files with the same Contents are stored only once.
+
+	var sum [sha256.Size]byte
+	var path, tmp string
+	var err error
+
+	att.mime = http.DetectContentType(data)
+	if !attach_mimeAllowed[att.mime] {
+		log_warn(rid, "Attachment type is not allowed", "mime", att.mime) //
+		return att, false
+	}
+
+	sum = sha256.Sum256(data)
+	att.hash = hex.EncodeToString(sum[:])
+	att.name = attach_cleanName(name)
+	att.size = int64(len(data))
+	path = filepath.Join(attach_dir, att.hash)
+
+	// Already stored ?
+	_, err = os.Stat(path)
+	if err != nil {
+
+		// Write into a temporary File, then rename, so that a half-written
+		// File is never served.
+		tmp = path + ".tmp"
+		err = ioutil.WriteFile(tmp, data, 0644)
+		if err != nil {
+			log_error(rid, "Error writing attachment", "file", tmp, "err", err) //
+			return att, false
+		}
+		err = os.Rename(tmp, path)
+		if err != nil {
+			log_error(rid, "Error renaming attachment", "file", tmp, "err", err) //
+			return att, false
+		}
+	}
+
+	// Thumbnail
+	if attach_mimeImage[att.mime] {
+		att.thumb = attach_createThumb(data, path+attach_thumbSuffix, rid)
+	}
+
+	return att, true
+}
+
+//------------------------------------------------------------------------------
+
+func attach_createThumb(data []byte, path string, rid string) (ok bool) {
+
+	// Creates a small PNG Copy of the Image.
+
+	var src image.Image
+	var dst *image.RGBA
+	var bounds image.Rectangle
+	var w, h, tw, th, x, y int
+	var buf *bytes.Buffer
+	var encoder *png.Encoder
+	var err error
+
+	_, err = os.Stat(path)
+	if err == nil {
+		return true // already exists
+	}
+
+	// A small File may be a huge Image
+	if attach_imageTooLarge(data) {
+		log_warn(rid, "Image is too large for thumbnail") //
+		return false
+	}
+
+	src, _, err = image.Decode(bytes.NewReader(data))
+	if err != nil {
+		log_warn(rid, "Error decoding image for thumbnail", "err", err) //
+		return false
+	}
+
+	// Keep Proportions
+	bounds = src.Bounds()
+	w = bounds.Dx()
+	h = bounds.Dy()
+	if (w == 0) || (h == 0) {
+		return false
+	}
+	tw = w
+	th = h
+	if (tw > attach_thumbSize) || (th > attach_thumbSize) {
+		if w > h {
+			tw = attach_thumbSize
+			th = h * attach_thumbSize / w
+		} else {
+			th = attach_thumbSize
+			tw = w * attach_thumbSize / h
+		}
+		if th == 0 {
+			th = 1
+		}
+		if tw == 0 {
+			tw = 1
+		}
+	}
+
+	// Nearest Neighbour is enough for a Preview
+	dst = image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y = 0; y < th; y++ {
+		for x = 0; x < tw; x++ {
+			dst.Set(x, y, src.At(bounds.Min.X+x*w/tw, bounds.Min.Y+y*h/th))
+		}
+	}
+
+	buf = new(bytes.Buffer)
+	encoder = new(png.Encoder)
+	encoder.CompressionLevel = png.BestCompression
+	err = encoder.Encode(buf, dst)
+	if err != nil {
+		log_error(rid, "Error encoding thumbnail", "err", err) //
+		return false
+	}
+
+	err = ioutil.WriteFile(path, buf.Bytes(), 0644)
+	if err != nil {
+		log_error(rid, "Error writing thumbnail", "file", path, "err", err) //
+		return false
+	}
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func attach_imageTooLarge(data []byte) (tooLarge bool) {
+
+	// Tells whether the Image has more Pixels than may be decoded. The Size
+	// is read from the Header, the Image is not decoded.
+
+	var config image.Config
+	var err error
+
+	config, _, err = image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return false // not an Image of a known Format, it is never decoded
+	}
+
+	return int64(config.Width)*int64(config.Height) > attach_maxPixels
+}
+
+//------------------------------------------------------------------------------
+
+func attach_cleanName(name string) (clean string) {
+
+	// Makes the File Name safe for Display and for HTTP Headers.
+
+	clean = filepath.Base(strings.Replace(name, "\\", "/", -1))
+	clean = strings.Map(func(r rune) rune {
+		if (r < 32) || (r == '"') || (r == 127) {
+			return '_'
+		}
+		return r
+	}, clean)
+	if (clean == ".") || (clean == "/") {
+		clean = ""
+	}
+	if len(clean) > attach_nameMaxLen {
+		clean = strings.ToValidUTF8(clean[:attach_nameMaxLen], "")
+	}
+	if len(clean) == 0 {
+		clean = "file"
+	}
+
+	return clean
+}
+
+//------------------------------------------------------------------------------
+
+func attach_isHash(s string) (ok bool) {
+
+	// Checks that the String is a Hash of the Store and nothing else
+	// (no Path Separators, no Dots).
+
+	var i int
+
+	if len(s) != attach_hashLen {
+		return false
+	}
+	for i = 0; i < len(s); i++ {
+		if !(((s[i] >= '0') && (s[i] <= '9')) || ((s[i] >= 'a') && (s[i] <= 'f'))) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func page_upload(w http.ResponseWriter, req *http.Request) {
+
+	// Processes and serves User's Request to upload a File into Chat.
+
+	// Client sends a 'multipart/form-data' Request with one File.
+
+	// Server replies to client (see reply_code) one of the following:
+	//		1. code_NotLoggedIn ('L')
+	//		2. code_BadPOSTdata ('X')
+	//		3. code_msgTooLong ('M')
+	//		4. code_badFileType ('T')
+	//		5. code_messageSent ('O')
+
+	var ok bool
+	var uid uint64
+	var upload http.Handler
+
+	// Correct Cookies & Not Idle ?  & update User's Last Activity Time
+	ok, uid, _, _ = user_check(w, req)
+	if !ok {
+		reply_code(w, req, code_NotLoggedIn) // Error: Not Logged In or Idle
+		return
+	}
+
+	// A slow Client sends Megabytes for long, it must not hold the Jobs
+	// Manager
+	upload = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attach_upload(w, req, uid)
+	})
+	if !reply_after(w, upload) {
+		upload.ServeHTTP(w, req)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func attach_upload(w http.ResponseWriter, req *http.Request, uid uint64) {
+
+	// Reads the uploaded File of the logged-in User, stores it and posts it
+	// into the Chat. It is run in the Go-Routine of the Request.
+
+	var ok bool
+	var err error
+	var file multipart.File
+	var header *multipart.FileHeader
+	var data []byte
+	var att tAttachment
+	var chatJob *tChatJob
+	var rcvChan chan tChatJob
+
+	// Some Space for the Multipart Headers
+	req.Body = http.MaxBytesReader(w, req.Body, attach_maxSize+4096)
+	err = req.ParseMultipartForm(attach_maxSize)
+	if err != nil {
+		log_warn(log_rid(req), "Error reading upload", "err", err) //
+		reply_code(w, req, code_msgTooLong)                        // Too large or broken
+		return
+	}
+	defer req.MultipartForm.RemoveAll()
+
+	file, header, err = req.FormFile(param_file)
+	if err != nil {
+		log_warn(log_rid(req), "Error reading upload", "err", err) //
+		reply_code(w, req, code_BadPOSTdata)                       // Error in Data
+		return
+	}
+	defer file.Close()
+
+	data, err = ioutil.ReadAll(file)
+	if err != nil {
+		log_warn(log_rid(req), "Error reading upload", "err", err) //
+		reply_code(w, req, code_BadPOSTdata)                       // Error in Data
+		return
+	}
+	if len(data) == 0 {
+		reply_code(w, req, code_EmptyMessage) // Empty File
+		return
+	}
+	if len(data) > attach_maxSize {
+		reply_code(w, req, code_msgTooLong) // Too large File
+		return
+	}
+	if attach_imageTooLarge(data) {
+		log_warn(log_rid(req), "Uploaded image has too many pixels", "uid", uid) //
+		reply_code(w, req, code_msgTooLong)                                      // Decompression Bomb
+		return
+	}
+
+	// Store
+	att, ok = attach_store(data, header.Filename, log_rid(req))
+	if !ok {
+		reply_code(w, req, code_badFileType) // Type is not allowed or Store failed
+		return
+	}
+
+	// Create Job for ChatManager
+	rcvChan = make(chan tChatJob)
+	chatJob = new(tChatJob)
+	chatJob.rid = log_rid(req)
+	chatJob.chatRecord.author = uid
+	chatJob.chatRecord.text = att.name
+	chatJob.chatRecord.message = html.EscapeString(att.name)
+	chatJob.chatRecord.attachment = att
+	chatJob.returnChannel = rcvChan
+
+	// Send Job
+	chatManagerChan <- *chatJob
+
+	// Wait for Manager
+	*chatJob = <-rcvChan
+
+	// User is not "away" any more
+	activeList_setInput(uid, log_rid(req))
+
+	reply_code(w, req, code_messageSent) // OK, File is Sent
+}
+
+//------------------------------------------------------------------------------
+
+func page_file(w http.ResponseWriter, req *http.Request) {
+
+	// Processes and serves User's Request of an attached File.
+	// Only logged-in Users can download Files.
+
+	var ok bool
+	var fid, path string
+	var download http.Handler
+
+	// Correct Cookies & Not Idle ?  & update User's Last Activity Time
+	ok, _, _, _ = user_check(w, req)
+	if !ok {
+		http.Error(w, code_NotLoggedIn, http.StatusForbidden)
+		return
+	}
+
+	fid = req.FormValue(param_fid)
+	if !attach_isHash(fid) {
+		http.Error(w, code_BadRequest, http.StatusBadRequest)
+		return
+	}
+
+	path = filepath.Join(attach_dir, fid)
+	if len(req.FormValue(param_thumb)) > 0 {
+		path += attach_thumbSuffix
+	}
+
+	// A slow Client reads Megabytes for long, it must not hold the Jobs
+	// Manager
+	download = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attach_download(w, req, path)
+	})
+	if !reply_after(w, download) {
+		download.ServeHTTP(w, req)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func attach_download(w http.ResponseWriter, req *http.Request, path string) {
+
+	// Serves the stored File. It is run in the Go-Routine of the Request.
+
+	var file *os.File
+	var info os.FileInfo
+	var head []byte
+	var n int
+	var mime string
+	var err error
+
+	file, err = os.Open(path)
+	if err == nil {
+		info, err = file.Stat()
+		if err != nil {
+			file.Close()
+		}
+	}
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	defer file.Close()
+
+	// The Store accepts only known Types, so the Type is detected again
+	// instead of being kept somewhere.
+	head = make([]byte, 512)
+	n, _ = io.ReadFull(file, head)
+	mime = http.DetectContentType(head[:n])
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		log_warn(log_rid(req), "Error reading attachment", "err", err) //
+		http.Error(w, code_BadRequest, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", mime)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	if !attach_mimeImage[mime] {
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+	http.ServeContent(w, req, "", info.ModTime(), file)
+}
+
+//------------------------------------------------------------------------------
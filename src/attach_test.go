@@ -0,0 +1,233 @@
+// attach_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func attach_testPng(t *testing.T, w, h int) (data []byte) {
+
+	// Encodes a PNG Image of the Size.
+
+	var img *image.RGBA
+	var buf bytes.Buffer
+	var err error
+
+	img = image.NewRGBA(image.Rect(0, 0, w, h))
+	img.Set(0, 0, color.RGBA{0xff, 0, 0, 0xff})
+	err = png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+//------------------------------------------------------------------------------
+
+func attach_testBomb(w, h uint32) (data []byte) {
+
+	// Makes the Beginning of a PNG File whose Header tells a huge Size.
+	// The Pixels are not there: only the Header is read before the Check.
+
+	var ihdr []byte
+	var crc [4]byte
+	var chunk string
+
+	ihdr = make([]byte, 4+13)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], w)
+	binary.BigEndian.PutUint32(ihdr[8:], h)
+	ihdr[12] = 8 // Bit Depth
+	ihdr[13] = 6 // RGBA
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(ihdr))
+
+	data = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0d")
+	data = append(data, ihdr...)
+	data = append(data, crc[:]...)
+
+	// Empty Data
+	chunk = "IDAT"
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE([]byte(chunk)))
+	data = append(data, 0, 0, 0, 0)
+	data = append(data, chunk...)
+	data = append(data, crc[:]...)
+
+	return data
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tTestClient) upload(name string, data []byte) (status int, reply string) {
+
+	// Uploads a File as the Chat Page does.
+
+	var body bytes.Buffer
+	var mw *multipart.Writer
+	var err error
+
+	mw = multipart.NewWriter(&body)
+	_, err = mw.CreateFormFile(param_file, name)
+	if err == nil {
+		_, err = body.Write(data)
+	}
+	if err == nil {
+		err = mw.Close()
+	}
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return c.do(http.MethodPost, path_upload, mw.FormDataContentType(), body.String())
+}
+
+//------------------------------------------------------------------------------
+
+func TestAttachThumb(t *testing.T) {
+
+	// Thumbnails keep the Proportions of the Image and fit into the Square.
+	// Huge Images are not decoded.
+
+	var tests = []struct {
+		name string
+		data []byte
+		ok   bool
+		w, h int
+	}{
+		{"wide", attach_testPng(t, 400, 200), true, attach_thumbSize, attach_thumbSize / 2},
+		{"tall", attach_testPng(t, 10, 800), true, 2, attach_thumbSize},
+		{"small", attach_testPng(t, 30, 20), true, 30, 20},
+		{"bomb", attach_testBomb(100000, 100000), false, 0, 0},
+		{"not an image", []byte("%PDF-1.4"), false, 0, 0},
+	}
+
+	var dir, path string
+	var img image.Image
+	var data []byte
+	var ok bool
+	var i int
+	var err error
+
+	harness_quiet(t)
+	dir = t.TempDir()
+
+	for i = 0; i < len(tests); i++ {
+
+		path = filepath.Join(dir, tests[i].name+attach_thumbSuffix)
+		ok = attach_createThumb(tests[i].data, path, "")
+		if ok != tests[i].ok {
+			t.Errorf("%s: thumbnail is made: %v", tests[i].name, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		data, err = ioutil.ReadFile(path)
+		if err == nil {
+			img, err = png.Decode(bytes.NewReader(data))
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tests[i].name, err)
+			continue
+		}
+		if (img.Bounds().Dx() != tests[i].w) || (img.Bounds().Dy() != tests[i].h) {
+			t.Errorf("%s: thumbnail is %dx%d, want %dx%d", tests[i].name,
+				img.Bounds().Dx(), img.Bounds().Dy(), tests[i].w, tests[i].h)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestAttachUpload(t *testing.T) {
+
+	// Uploaded Files are posted into the Chat and served to logged-in Users.
+
+	var tests = []struct {
+		name string
+		file string
+		data []byte
+		code string
+	}{
+		{"image", "cat.png", attach_testPng(t, 320, 240), code_messageSent},
+		{"text", `..\evil "name".txt`, []byte("hello, file\n"), code_messageSent},
+		{"bomb", "bomb.png", attach_testBomb(60000, 60000), code_msgTooLong},
+		{"executable", "run.exe", []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xff\xff"), code_badFileType},
+		{"empty", "empty.txt", nil, code_EmptyMessage},
+		{"too large", "big.txt", bytes.Repeat([]byte("a"), attach_maxSize+1), code_msgTooLong},
+	}
+
+	var c, stranger *tTestClient
+	var uid uint64
+	var last uint16
+	var rec tChatRecord
+	var reply string
+	var status int
+	var i int
+
+	harness_quiet(t)
+	c = harness_client(t, false)
+	uid = c.register("Judy", "judy-pwd")
+	c.login(uid, "judy-pwd")
+
+	stranger = harness_client(t, false)
+	_, reply = stranger.upload("cat.png", tests[0].data)
+	if reply != code_NotLoggedIn {
+		t.Errorf("upload of a stranger: %q", reply)
+	}
+
+	for i = 0; i < len(tests); i++ {
+
+		last = chat_recordLastNum
+		_, reply = c.upload(tests[i].file, tests[i].data)
+		if reply != tests[i].code {
+			t.Errorf("%s: reply %q, want %q", tests[i].name, reply, tests[i].code)
+			continue
+		}
+		if tests[i].code != code_messageSent {
+			if chat_recordLastNum != last {
+				t.Errorf("%s: refused file is posted", tests[i].name)
+			}
+			continue
+		}
+
+		rec = chatRecordsList[chat_recordLastNum]
+		if (chat_recordLastNum != last+1) || (rec.author != uid) || (len(rec.attachment.hash) == 0) {
+			t.Errorf("%s: record is %+v", tests[i].name, rec)
+			continue
+		}
+		if strings.ContainsAny(rec.attachment.name, `\/"`) {
+			t.Errorf("%s: name is %q", tests[i].name, rec.attachment.name)
+		}
+
+		status, reply = c.get(path_file + "?" + param_fid + "=" + rec.attachment.hash)
+		if (status != http.StatusOK) || (reply != string(tests[i].data)) {
+			t.Errorf("%s: download gives %d", tests[i].name, status)
+		}
+		status, _ = stranger.get(path_file + "?" + param_fid + "=" + rec.attachment.hash)
+		if status != http.StatusForbidden {
+			t.Errorf("%s: stranger gets %d", tests[i].name, status)
+		}
+		status, _ = c.get(path_file + "?" + param_fid + "=" + rec.attachment.hash + "&" + param_thumb + "=1")
+		if rec.attachment.thumb != (status == http.StatusOK) {
+			t.Errorf("%s: thumbnail %v gives %d", tests[i].name, rec.attachment.thumb, status)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,278 @@
+// bridge.go
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	Bridges to other Chat Networks.
+
+	A Bridge gets every new Record of the Chat and sends it to the remote
+	Network. Messages from the remote Network are put into the Chat on Behalf
+	of Puppets: in-memory Users which stand for remote Users.
+
+	Loops are prevented in two Places:
+
+	1. Each Record knows its Origin, the Name of the Bridge which has brought
+	it. A Record is never given back to the Bridge it came from.
+
+	2. Each Bridge must ignore the Echo of its own Messages on the remote
+	Side (e.g. Messages of its own remote Puppets).
+
+	Remote Users are shown with the Name of the Network, "Alice (matrix)", so
+	that they can not pretend to be local Users.
+
+*/
+
+//------------------------------------------------------------------------------
+
+// Bridge to a remote Chat Network
+type tBridge interface {
+	name() string                    // Short Name, also the Origin of Records
+	start() bool                     // Prepares the Bridge, before the Chat starts
+	relay(mid uint16, r tChatRecord) // Sends a new Record to the remote Network
+	stop()
+}
+
+// New Record for a Bridge
+type tBridgeJob struct {
+	mid    uint16
+	record tChatRecord
+}
+
+//------------------------------------------------------------------------------
+
+const bridgeChanBufferLen = 256 // Buffer Length of each Bridge's Channel
+
+//------------------------------------------------------------------------------
+
+// Lists
+var bridgeList []tBridge
+var bridgeChans []chan tBridgeJob
+var bridgePuppets = make(map[uint64]string) // Remote IDs of Puppets, Key = UID
+
+// Channels
+var bridgeQuit chan int
+
+//------------------------------------------------------------------------------
+
+func bridge_register(b tBridge) {
+
+	// Adds a Bridge. Must be done before the Server starts.
+
+	bridgeList = append(bridgeList, b)
+	bridgeChans = append(bridgeChans, make(chan tBridgeJob, bridgeChanBufferLen))
+}
+
+//------------------------------------------------------------------------------
+
+func bridge_init() (ok bool) {
+
+	// Prepares all registered Bridges.
+
+	var i int
+
+	for i = 0; i < len(bridgeList); i++ {
+		ok = bridgeList[i].start()
+		if !ok {
+			log_error("", "Error starting bridge", "bridge", bridgeList[i].name()) //
+			return false
+		}
+		log_info("", "Bridge started", "bridge", bridgeList[i].name()) //
+	}
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func bridge_start() {
+
+	// Starts a Go-Routine for each Bridge, so that a slow Network does not
+	// hold the others.
+
+	var i int
+
+	for i = 0; i < len(bridgeList); i++ {
+		go bridgeManager(bridgeList[i], bridgeChans[i])
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func bridge_stop() {
+
+	// Stops all Bridges.
+
+	var i int
+
+	for i = 0; i < len(bridgeList); i++ {
+		bridgeQuit <- 1
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func bridgeManager(b tBridge, jobs chan tBridgeJob) {
+
+	// Gives new Records to the Bridge.
+
+	var loop bool = true
+	var job tBridgeJob
+
+	for loop {
+
+		select {
+
+		case job = <-jobs:
+			b.relay(job.mid, job.record)
+
+		case <-bridgeQuit:
+			loop = false
+			b.stop()
+			log_info("", "Closing Bridge...", "bridge", b.name()) //
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func bridge_publish(mid uint16, record tChatRecord) {
+
+	// Reports a new Record to all Bridges except the one it came from.
+	// Never waits: if a Bridge is busy, the Record is dropped for it.
+
+	var i int
+
+	for i = 0; i < len(bridgeList); i++ {
+
+		if record.origin == bridgeList[i].name() {
+			continue
+		}
+
+		select {
+		case bridgeChans[i] <- tBridgeJob{mid, record}:
+		default:
+			log_warn("", "Bridge is busy, record dropped", "bridge", bridgeList[i].name(), "mid", mid) //
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func bridge_puppet(b tBridge, remoteId string, displayName string) (uid uint64, ok bool) {
+
+	// Finds or creates the Puppet of a remote User. The Puppet's UID is made
+	// from the Bridge's Name and the remote ID, so it stays the same after a
+	// Restart. The Name follows the remote Display Name.
+	// Must be called from the Server's Jobs (see serverJobsManager), which
+	// own the List of Puppets. Readers of the List of Users do not wait for
+	// them: userDataLock guards it.
+
+	var sum [sha256.Size]byte
+	var ud tUserData
+	var exists bool
+	var name, key string
+
+	key = b.name() + "\x00" + remoteId
+	sum = sha256.Sum256([]byte(key))
+	uid = binary.LittleEndian.Uint64(sum[:8])
+	if uid == chat_systemUserUID {
+		return 0, false
+	}
+
+	name = bridge_displayName(displayName, remoteId) + " (" + b.name() + ")"
+
+	userDataLock.Lock()
+	defer userDataLock.Unlock()
+
+	ud, exists = userDataList[uid]
+	if exists {
+		if bridgePuppets[uid] != key {
+			// A real User has this UID, very unlikely
+			log_warn("", "UID of the puppet is taken", "bridge", b.name(), "remote", remoteId) //
+			return 0, false
+		}
+		if ud.name != name {
+			ud.name = name
+			userDataList[uid] = ud
+		}
+		return uid, true
+	}
+
+	// Puppet, in Memory only. Nobody knows its Password, as for Bots.
+	ud.name = name
+	ud.pwd = inhook_password()
+	ud.reg_time = time.Now().Unix()
+	userDataList[uid] = ud
+	bridgePuppets[uid] = key
+
+	return uid, true
+}
+
+//------------------------------------------------------------------------------
+
+func bridge_displayName(displayName string, remoteId string) (name string) {
+
+	// Cleans the remote Display Name.
+
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, displayName)
+	name = strings.TrimSpace(name)
+
+	if len(name) == 0 {
+		name = remoteId
+	}
+	if len(name) > userName_maxLen/2 {
+		name = strings.ToValidUTF8(name[:userName_maxLen/2], "")
+	}
+
+	return name
+}
+
+//------------------------------------------------------------------------------
+
+func bridge_inject(b tBridge, uid uint64, text string, rid string) (mid uint16) {
+
+	// Puts a remote Message into the Chat on Behalf of the Puppet.
+
+	var chatJob *tChatJob
+	var rcvChan chan tChatJob
+
+	if len(text) > msgMaxSize {
+		text = strings.ToValidUTF8(text[:msgMaxSize], "")
+	}
+
+	// Create Job for ChatManager
+	rcvChan = make(chan tChatJob)
+	chatJob = new(tChatJob)
+	chatJob.rid = rid
+	chatJob.chatRecord.author = uid
+	chatJob.chatRecord.message = markup_render(text)
+	chatJob.chatRecord.text = text
+	chatJob.chatRecord.origin = b.name()
+	chatJob.returnChannel = rcvChan
+
+	// Send Job
+	chatManagerChan <- *chatJob
+
+	// Wait for Manager
+	*chatJob = <-rcvChan
+
+	return chatJob.mid
+}
+
+//------------------------------------------------------------------------------
@@ -27,9 +27,10 @@ package main
 
 import (
 	"flag"
-	"log"
 	"math"
 	"math/rand"
+	"os"
+	"sync/atomic"
 	"time"
 )
 
@@ -39,13 +40,34 @@ import (
 type tChatRecord struct {
 	time    int64  // Post Time, Unix Timestamp
 	author  uint64 // UID of the Author
-	message string // Message
+	message string // Message, rendered into safe HTML
+	text    string // Raw Text of the Message, as typed by the Author
+
+	attachment tAttachment // Attached File, if any
+	kind       uint8       // Kind of the Record, chat_recordKindUser or chat_recordKindSystem
+	origin     string      // Name of the Bridge which has brought the Record, empty for local Records
+
+	announcement []tAnnounceNames // Users who have joined, left or timed out, for System Announcements
 }
 type tChatRecords [chat_recordsMaxLast + 1]tChatRecord
 
 type tChatJob struct {
 	chatRecord    tChatRecord
+	mid           uint16 // ID given to the Record by the chatManager
 	returnChannel chan tChatJob
+	rid           string     // ID of the Request which made the Job, for the Log
+	ping          bool       // Health Check: the Manager only answers
+	poll          *tChatPoll // Reading: the Manager only fills the Poll
+}
+
+// New Records for a Reader which is not served by the serverJobsManager
+type tChatPoll struct {
+	mid     uint16    // Cursor of the Reader
+	ts      int64     // ~
+	start   bool      // The Cursor is only set to the last Record
+	reply   tApiDelta // New Messages and the new Cursor
+	authors []uint64  // UIDs of the Authors of the Messages
+	texts   []string  // Raw Texts of the Messages
 }
 
 type tLoginJob struct {
@@ -53,6 +75,8 @@ type tLoginJob struct {
 	uid           uint64
 	result        bool
 	returnChannel chan tLoginJob
+	rid           string // ID of the Request which made the Job, for the Log
+	ping          bool   // Health Check: the Manager only answers
 }
 
 type tRegisterJob struct {
@@ -60,12 +84,16 @@ type tRegisterJob struct {
 	uid           uint64
 	returnChannel chan tRegisterJob
 	result        bool
+	rid           string // ID of the Request which made the Job, for the Log
+	ping          bool   // Health Check: the Manager only answers
 }
 
 //------------------------------------------------------------------------------
 
 const chat_systemUserUID uint64 = 0         // UID of the Chat's System User
 const chat_systemUserName string = "SYSTEM" // Name of the Chat's System User
+const chat_recordKindUser uint8 = 0         // Record is a Message of a User
+const chat_recordKindSystem uint8 = 1       // Record is an Announcement of the System User
 const chatJobBufferLength = 64              // Buffer Length of the Chat Jobs Channel
 const loginManagerChanBufferLen = 64        // Buffer Length of the Login Manager's Channel
 const registerManagerChanBufferLen = 64     // Buffer Length of the Register Manager's Channel
@@ -89,14 +117,38 @@ var flag_userDataFile_ptr = flag.String("udf", file_userData_default,
 var flag_createUserDataFile_ptr = flag.Bool("cudf", false,
 	"Create User Data File if it does not exist.")
 
-var flag_indexFile_ptr = flag.String("if", file_index_default,
-	"Path to Index File Template.")
+var flag_indexFile_ptr = flag.String("if", "",
+	"Path to Index File Template. The embedded one is used without it.")
+
+var flag_chatFile_ptr = flag.String("cf", "",
+	"Path to Chat File Template. The embedded one is used without it.")
+
+var flag_userRegdFile_ptr = flag.String("urf", "",
+	"Path to 'User Registered' File Template. The embedded one is used without it.")
+
+var flag_tplDir_ptr = flag.String("tpl-dir", "",
+	"Path to the Directory of customized Templates. Missing Templates are the embedded ones.")
+
+var flag_dumpTemplates_ptr = flag.Bool("dump-templates", false,
+	"Write the embedded Templates into the Directory of '-tpl-dir' (or 'tpl') and exit.")
 
-var flag_chatFile_ptr = flag.String("cf", file_chat_default,
-	"Path to Chat File Template.")
+var flag_title_ptr = flag.String("title", html_headTitle_default,
+	"Title of the Pages.")
 
-var flag_userRegdFile_ptr = flag.String("urf", file_userRegistered_default,
-	"Path to 'User Registered' File Template.")
+var flag_logo_ptr = flag.String("logo", "",
+	"Text of the Logo in the Corner of the Pages. It is the Title without it.")
+
+var flag_motd_ptr = flag.String("motd", "",
+	"Message of the Day, shown on the Index and Chat Pages, with the Markup of Messages.")
+
+var flag_theme_ptr = flag.String("theme", theme_default_default,
+	"Theme for Users who have not chosen one: green, blue, dark or auto.")
+
+var flag_lang_ptr = flag.String("lang", i18n_base,
+	"Language for Users whose Browsers ask for none of the known ones: en, ru, ...")
+
+var flag_langDir_ptr = flag.String("lang-dir", "",
+	"Path to the Directory of Message Catalogs ('<lang>.txt'). They add to or change the embedded ones.")
 
 var flag_ari_ptr = flag.Int("ari", activeRevisorInterval_default,
 	"Activity Revisor Interval, in Seconds.")
@@ -104,6 +156,51 @@ var flag_ari_ptr = flag.Int("ari", activeRevisorInterval_default,
 var flag_asqRevInt_ptr = flag.Int("asqri", asqRevisorIntervalDefault,
 	"Anti-Spam Questions Revisor Interval, in Seconds.")
 
+var flag_away_ptr = flag.Int("away", awayTimeout_default,
+	"Time without Input after which a User is shown as away, in Minutes.")
+
+var flag_announce_ptr = flag.Bool("ann", false,
+	"Announce Users joining and leaving the Chat.")
+
+var flag_attachDir_ptr = flag.String("attd", attach_dir_default,
+	"Path to the Directory of attached Files.")
+
+var flag_webhooksFile_ptr = flag.String("whf", "",
+	"Path to the Webhooks Configuration File. Webhooks are off without it.")
+
+var flag_webhookQueueDir_ptr = flag.String("whq", webhook_queueDir_default,
+	"Path to the Directory of the Webhook Delivery Queue.")
+
+var flag_inHooksFile_ptr = flag.String("ihf", "",
+	"Path to the incoming Webhooks Configuration File. They are off without it.")
+
+var flag_irc_ptr = flag.String("irc", "",
+	"Address of the IRC Gateway, e.g. ':6667'. The Gateway is off without it.")
+
+var flag_ircChannel_ptr = flag.String("ircch", irc_channel_default,
+	"Name of the IRC Channel of the Chat.")
+
+var flag_matrixFile_ptr = flag.String("mxc", "",
+	"Path to the Matrix Bridge Configuration File. The Bridge is off without it.")
+
+var flag_admins_ptr = flag.String("adm", "",
+	"UIDs of Administrators, separated by Commas.")
+
+var flag_apiTokensFile_ptr = flag.String("tokf", file_apiTokens_default,
+	"Path to the File with Hashes of API Tokens.")
+
+var flag_metricsToken_ptr = flag.String("mtok", "",
+	"Token which the Scraper of Metrics must send. Metrics are open without it.")
+
+var flag_logLevel_ptr = flag.String("logl", log_levelNames[logLevelInfo],
+	"Log Level: debug, info, warn or error.")
+
+var flag_logFormat_ptr = flag.String("logf", log_formatLogfmt,
+	"Log Format: logfmt or json.")
+
+var flag_pprof_ptr = flag.Bool("pprof", false,
+	"Serve Profiles of the Server at '/debug/pprof/', for Administrators.")
+
 // Lists
 var chatRecordsList tChatRecords
 
@@ -124,7 +221,8 @@ var chat_recordLastTimestamp int64  // Timestamp of the Last actual Element in L
 // can help such Client (when he fixes his Network Connection) to partially
 // restore the Messages which he has missed.
 
-var firstCircle bool // Shows whether any Overflow (Circle) happened or not
+var firstCircle bool      // Shows whether any Overflow (Circle) happened or not
+var chat_ringWraps uint64 // Count of Overflows (Circles), for Metrics
 
 // Channels
 var chatManagerChan chan tChatJob
@@ -140,8 +238,31 @@ func main() {
 
 	var ok bool = false
 
+	// Sub-Commands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "client": // Terminal Client
+			client_main(os.Args[2:])
+			return
+		case "export": // Transcript Export
+			export_main(os.Args[2:])
+			return
+		}
+	}
+
 	// Preparations
 	flags_init()
+	ok = logger_init()
+	if !ok {
+		return
+	}
+
+	// Templates for Editing
+	if tpl_dump {
+		templates_dump()
+		return
+	}
+
 	chat_init()
 
 	// Templates
@@ -156,7 +277,48 @@ func main() {
 		return
 	}
 
-	saveRegisteredUsersToTpl() // Must be run after userData_init() !
+	// Administrators, must be run after userData_init() !
+	ok = admin_init()
+	if !ok {
+		return
+	}
+
+	// Attachments
+	ok = attach_init()
+	if !ok {
+		return
+	}
+
+	// Incoming Webhooks, must be run after userData_init() !
+	ok = inhook_init()
+	if !ok {
+		return
+	}
+
+	// API Tokens
+	ok = token_init()
+	if !ok {
+		return
+	}
+
+	// Webhooks
+	ok = webhook_init()
+	if !ok {
+		return
+	}
+
+	// Bridges, must be run after userData_init() !
+	ok = matrix_init()
+	if !ok {
+		return
+	}
+	ok = bridge_init()
+	if !ok {
+		return
+	}
+
+	// All Initializations have succeeded
+	atomic.StoreUint32(&health_initDone, 1)
 
 	// Server
 	server.ipAddress = srv_ipAddress
@@ -186,10 +348,51 @@ func flags_init() {
 	file_indexTemplate = *flag_indexFile_ptr
 	file_chatTemplate = *flag_chatFile_ptr
 	file_userRegdTemplate = *flag_userRegdFile_ptr
+	tpl_dir = *flag_tplDir_ptr
+	tpl_dump = *flag_dumpTemplates_ptr
+
+	// Branding
+	html_headTitle = *flag_title_ptr
+	html_tdTitle = *flag_logo_ptr
+	theme_motd = *flag_motd_ptr
+	theme_default = *flag_theme_ptr
+
+	// Languages
+	i18n_default = *flag_lang_ptr
+	i18n_dir = *flag_langDir_ptr
+	attach_dir = *flag_attachDir_ptr
+	file_apiTokens = *flag_apiTokensFile_ptr
+	file_webhooks = *flag_webhooksFile_ptr
+	file_inHooks = *flag_inHooksFile_ptr
+	file_matrix = *flag_matrixFile_ptr
+
+	// IRC Gateway
+	irc_address = *flag_irc_ptr
+	irc_channel = *flag_ircChannel_ptr
+	webhook_queueDir = *flag_webhookQueueDir_ptr
 
 	// Revisors
 	activeRevisorInterval = *flag_ari_ptr
 	asqRevisorInterval = *flag_asqRevInt_ptr
+
+	// Administrators
+	admins_str = *flag_admins_ptr
+
+	// Metrics
+	metrics_token = *flag_metricsToken_ptr
+
+	// Log
+	log_level_str = *flag_logLevel_ptr
+	log_format_str = *flag_logFormat_ptr
+
+	// Health & Debugging
+	health_pprof = *flag_pprof_ptr
+
+	// Presence
+	awayTimeout = int64(*flag_away_ptr) * 60
+
+	// Announcements
+	announceEnabled = *flag_announce_ptr
 }
 
 //------------------------------------------------------------------------------
@@ -214,9 +417,11 @@ func chat_init() {
 	// First Message ever
 	chat_recordLastNum = 0
 	chat_recordLastTimestamp = time.Now().Unix()
-	chatRecordsList[chat_recordLastNum].message = "Chat Server started."
+	chatRecordsList[chat_recordLastNum].text = "Chat Server started."
+	chatRecordsList[chat_recordLastNum].message = markup_render(chatRecordsList[chat_recordLastNum].text)
 	chatRecordsList[chat_recordLastNum].time = time.Now().Unix()
 	chatRecordsList[chat_recordLastNum].author = chat_systemUserUID
+	chatRecordsList[chat_recordLastNum].kind = chat_recordKindSystem
 }
 
 //------------------------------------------------------------------------------
@@ -233,41 +438,68 @@ func chatManager() {
 
 		job = <-chatManagerChan // Get Job from Channel
 
-		now = time.Now().Unix()
-		job.chatRecord.time = now
+		if job.ping {
 
-		// First Circle?
-		if chat_recordLastNum == chat_recordsMaxLast {
-			firstCircle = false
-		}
+			job.returnChannel <- job // Send back
 
-		// Manipulate Counters (Start-End Pointers) and their Timestamps
-		chat_recordLastNum++ // Automatic Overflow makes it "endless"
+		} else if job.poll != nil {
 
-		if firstCircle { // First Circle
+			chat_poll(job.poll, job.rid)
+			job.returnChannel <- job // Send back
 
-			// Change only last Element
-			chat_recordLastTimestamp = now
+		} else {
 
-		} else { // Circle #2, #3, ...
+			now = time.Now().Unix()
+			job.chatRecord.time = now
 
-			// Change both Elements
-			chat_recordFirstNum = chat_recordLastNum + 1
-			chat_recordLastTimestamp = now
-			chat_recordFirstTimestamp = chatRecordsList[chat_recordFirstNum].time
+			// First Circle?
+			if chat_recordLastNum == chat_recordsMaxLast {
+				firstCircle = false
+				atomic.AddUint64(&chat_ringWraps, 1)
+			}
 
-		}
+			// Manipulate Counters (Start-End Pointers) and their Timestamps
+			chat_recordLastNum++ // Automatic Overflow makes it "endless"
+
+			if firstCircle { // First Circle
+
+				// Change only last Element
+				chat_recordLastTimestamp = now
+
+			} else { // Circle #2, #3, ...
+
+				// Change both Elements
+				chat_recordFirstNum = chat_recordLastNum + 1
+				chat_recordLastTimestamp = now
+				chat_recordFirstTimestamp = chatRecordsList[chat_recordFirstNum].time
+
+			}
 
-		// Add Message to the List
-		chatRecordsList[chat_recordLastNum] = job.chatRecord
+			// Add Message to the List
+			chatRecordsList[chat_recordLastNum] = job.chatRecord
+			job.mid = chat_recordLastNum
 
-		job.returnChannel <- job // Send back
+			// Index it before the Author may search for it
+			search_index(job.mid, job.chatRecord, job.rid)
+
+			job.returnChannel <- job // Send back
+
+			if job.chatRecord.kind == chat_recordKindSystem {
+				atomic.AddUint64(&metrics_messagesSystem, 1)
+			} else {
+				atomic.AddUint64(&metrics_messagesUser, 1)
+			}
+			log_debug(job.rid, "Message posted", "uid", job.chatRecord.author, "mid", job.mid, "origin", job.chatRecord.origin)
+
+			webhook_emit(webhookEventMessage, job.chatRecord.author, "", job.mid, true)
+			bridge_publish(job.mid, job.chatRecord)
+		}
 
 		// Checking for Stop Signal
 		select {
 		case <-chatManagerQuit:
 			loop = false
-			log.Println("Closing Chat Manager...") //
+			log_info("", "Closing Chat Manager...") //
 		default:
 		}
 	}
@@ -275,6 +507,44 @@ func chatManager() {
 
 //------------------------------------------------------------------------------
 
+func chat_poll(poll *tChatPoll, rid string) {
+
+	// Reads the Records which the Reader has not seen yet. Only the
+	// chatManager calls it, so the Records are not written meanwhile.
+
+	var rec *tChatRecord
+	var i int
+
+	if poll.start || ((chat_recordLastNum == poll.mid) && (chat_recordLastTimestamp == poll.ts)) {
+		poll.reply.X.Mid = chat_recordLastNum
+		poll.reply.X.Ts = chat_recordLastTimestamp
+		return
+	}
+
+	delta_fill(&poll.reply, poll.mid, poll.ts, rid)
+	for i = 0; i < len(poll.reply.Messages); i++ {
+		rec = &chatRecordsList[poll.reply.Messages[i].Mid]
+		poll.authors = append(poll.authors, rec.author)
+		poll.texts = append(poll.texts, rec.text)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func chat_isActual(mid uint16) (ok bool) {
+
+	// Checks that the Message with this ID is in the List.
+	// After the first Circle all Places of the List are taken.
+
+	if firstCircle {
+		return mid <= chat_recordLastNum
+	}
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
 func loginManager() {
 
 	// Manages Logging-In Requests.
@@ -300,10 +570,15 @@ func loginManager() {
 
 		// Check (once again) that Client is not logged in
 		_, logged = activeClientsList[job.uid]
-		if logged {
+		if job.ping {
+
+			job.returnChannel <- job // Send back
+
+		} else if logged {
 
 			job.result = false
 			job.returnChannel <- job // Send back
+			log_debug(job.rid, "Already logged in", "uid", job.uid)
 
 		} else {
 
@@ -323,6 +598,11 @@ func loginManager() {
 			activeClient.log_ts = chatRecordsList[chat_recordLastNum].time
 
 			activeClient.log_mid = chat_recordLastNum // 0 at Server's Start
+			activeClient.read_mid = chat_recordLastNum
+			activeClient.typingTime = 0
+			activeClient.lastInputTime = now
+			activeClient.presence = presenceOnline
+			activeClient.status = ""
 
 			// Update List of active Clients
 			activeClientsList[job.uid] = *activeClient // this is thread-safe
@@ -331,6 +611,7 @@ func loginManager() {
 			// As we are sure that UID is unique (not logged-in), it is not read
 			// by anone else. "activeManager" can modify only existing active
 			// Clients.
+			stat_users(len(activeClientsList))
 
 			job.result = true
 			job.returnChannel <- job // Send back
@@ -339,19 +620,24 @@ func loginManager() {
 			// List of active Clients. While Deletion and Modification of
 			// active Clients is done by "activeManager", we send Signal to him.
 
+			log_info(job.rid, "User logged in", "uid", job.uid)
+
 			// Send Job
+			activeJob.rid = job.rid
 			activeManagerChan <- *activeJob
 
 			// Get Feedback
 			*activeJob = <-rcvChan
 
+			announce(job.uid, announceEventJoin)
+			webhook_emit(webhookEventJoin, job.uid, "", 0, false)
 		}
 
 		// Checking for Stop Signal
 		select {
 		case <-loginManagerQuit:
 			loop = false
-			log.Println("Closing Chat Manager...") //
+			log_info("", "Closing Log-In Manager...") //
 		default:
 		}
 	}
@@ -370,15 +656,29 @@ func registerManager() {
 
 		job = <-registerManagerChan // Get Job from Channel
 
-		job.result, job.uid = user_register(&job.name, &job.pwd)
+		if job.ping {
+
+			job.returnChannel <- job // Send back
+
+		} else {
+
+			job.result, job.uid = user_register(&job.name, &job.pwd)
 
-		job.returnChannel <- job // Send back
+			job.returnChannel <- job // Send back
+
+			if job.result {
+				log_info(job.rid, "User registered", "uid", job.uid)
+				webhook_emit(webhookEventRegister, job.uid, "", 0, false)
+			} else {
+				log_warn(job.rid, "Registration failed")
+			}
+		}
 
 		// Checking for Stop Signal
 		select {
 		case <-registerManagerQuit:
 			loop = false
-			log.Println("Closing Register Manager...") //
+			log_info("", "Closing Register Manager...") //
 		default:
 		}
 	}
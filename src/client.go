@@ -0,0 +1,845 @@
+// client.go
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	Terminal Client.
+
+		saga-mikron client [-ascii] [-asq <File>] [-uid <UID>] http://host:port
+
+	The Client speaks the same Protocol as the Chat Page: it asks for an
+	Anti-Spam Question (/q), logs in (/l), gets new Messages (/d) and the
+	List of active Users (/a), sends Messages (/s) and logs out (/x).
+
+	The Screen is split into a scrolling Area with Messages, a Status Line
+	with the Network Indicator (as on the Chat Page) and an Input Line.
+	Lines starting with '/' are Commands, see client_help.
+
+	The Password is read from the SAGA_PASSWORD Environment Variable or from
+	the Terminal.
+
+*/
+
+//------------------------------------------------------------------------------
+
+type tClient struct {
+	base string // Address of the Server, without the trailing Slash
+	web  *http.Client
+
+	// Position in the Chat, as in the Chat Page
+	mid string
+	ts  string
+
+	// Network Indicator
+	pings  []int64 // Last Pings, in Milliseconds
+	avping int64
+	lost   bool
+
+	users  []string // Names of active Users
+	typing []string // Names of typing Users
+	day    string   // Day of the last shown Message
+
+	// Screen
+	rows, cols int
+}
+
+//------------------------------------------------------------------------------
+
+const client_timeout = 10      // Timeout of a Request, in Seconds
+const client_pingsMax = 10     // Count of Pings for the Average, as net_arrMaxSize
+const client_pingOk = 100      // Good Ping, in Milliseconds, as net_avping_ok
+const client_pingKNorm = 0.1   // Part of the Update Interval for a laggy Ping, as net_knorm
+const client_asqWidth = 64     // Width of the ASQ Image, in Symbols
+const client_rows_default = 24 // Terminal Size, if unknown
+const client_cols_default = 80
+const client_passwordEnv = "SAGA_PASSWORD"
+
+// ASCII Palette from light to dark
+const client_palette = " .:-=+*#%@"
+
+// ANSI Sequences
+const ansi_reset = "\x1b[0m"
+const ansi_dim = "\x1b[2m"
+const ansi_bold = "\x1b[1m"
+const ansi_clearLine = "\x1b[2K"
+const ansi_save = "\x1b7"
+const ansi_restore = "\x1b8"
+
+//------------------------------------------------------------------------------
+
+var client_tags = regexp.MustCompile(`<[^>]*>`)
+
+//------------------------------------------------------------------------------
+
+func client_main(args []string) {
+
+	// Runs the Terminal Client.
+
+	var flags *flag.FlagSet
+	var ascii *bool
+	var asqFile, uid *string
+	var c *tClient
+	var in *bufio.Reader
+	var pwd string
+	var ok bool
+
+	flags = flag.NewFlagSet("client", flag.ExitOnError)
+	ascii = flags.Bool("ascii", false, "Show the Anti-Spam Question with ASCII Symbols only, without Colours.")
+	asqFile = flags.String("asq", "", "Also save the Anti-Spam Question as a PNG File to open it.")
+	uid = flags.String("uid", "", "UID to log in with.")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: saga-mikron client [options] http://host:port")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	c = new(tClient)
+	c.base = strings.TrimSuffix(flags.Arg(0), "/")
+	if !strings.Contains(c.base, "://") {
+		c.base = "http://" + c.base
+	}
+	c.web = &http.Client{Timeout: client_timeout * time.Second}
+	c.web.Jar, _ = cookiejar.New(nil)
+	c.mid = param_unknownVal
+	c.ts = param_unknownVal
+
+	in = bufio.NewReader(os.Stdin)
+
+	// Log-In
+	if len(*uid) == 0 {
+		*uid = client_ask(in, "UID: ")
+	}
+	pwd = os.Getenv(client_passwordEnv)
+	if len(pwd) == 0 {
+		pwd = client_askPassword(in, "Password: ")
+	}
+	ok = c.login(in, *uid, pwd, *ascii, *asqFile)
+	if !ok {
+		os.Exit(1)
+	}
+
+	c.run(in)
+}
+
+//------------------------------------------------------------------------------
+
+func client_ask(in *bufio.Reader, prompt string) (answer string) {
+
+	// Asks the User a Question in the Terminal.
+
+	fmt.Print(prompt)
+	answer, _ = in.ReadString('\n')
+
+	return strings.TrimSpace(answer)
+}
+
+//------------------------------------------------------------------------------
+
+func client_askPassword(in *bufio.Reader, prompt string) (pwd string) {
+
+	// Asks for the Password without Echo, if the Terminal allows it.
+
+	var err error
+
+	err = client_stty("-echo")
+	pwd = client_ask(in, prompt)
+	if err == nil {
+		client_stty("echo")
+		fmt.Println()
+	}
+
+	return pwd
+}
+
+//------------------------------------------------------------------------------
+
+func client_stty(args ...string) (err error) {
+
+	// Changes the Settings of the Terminal.
+
+	var cmd *exec.Cmd
+
+	cmd = exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+
+	return cmd.Run()
+}
+
+//------------------------------------------------------------------------------
+
+func client_termSize() (rows, cols int) {
+
+	// Returns the Size of the Terminal.
+
+	var cmd *exec.Cmd
+	var out []byte
+	var err error
+
+	rows, cols = client_rows_default, client_cols_default
+
+	cmd = exec.Command("stty", "size")
+	cmd.Stdin = os.Stdin
+	out, err = cmd.Output()
+	if err == nil {
+		fmt.Sscan(string(out), &rows, &cols)
+	}
+	if (rows < 5) || (cols < 20) {
+		rows, cols = client_rows_default, client_cols_default
+	}
+
+	return rows, cols
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tClient) request(method, path string, body string, contentType string, v interface{}) (code string, err error) {
+
+	// Sends a Request in the JSON Format. The Reply is decoded into "v", if
+	// it is not a Code. Each Request is counted for the Network Indicator.
+
+	var req *http.Request
+	var resp *http.Response
+	var data []byte
+	var reply tApiCode
+	var sent time.Time
+
+	req, err = http.NewRequest(method, c.base+path, strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", api_mimeJSON)
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	sent = time.Now()
+	resp, err = c.web.Do(req)
+	if err != nil {
+		c.lost = true
+		return "", err
+	}
+	defer resp.Body.Close()
+	c.ping(time.Since(sent).Milliseconds())
+
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	err = json.Unmarshal(data, &reply)
+	if err != nil {
+		return "", err
+	}
+	if reply.Error != nil {
+		return reply.Error.Code, nil
+	}
+	if len(reply.Code) > 0 {
+		return reply.Code, nil
+	}
+	if v != nil {
+		err = json.Unmarshal(data, v)
+	}
+
+	return "", err
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tClient) ping(ms int64) {
+
+	// Counts the average Ping, as process_ping of the Chat Page.
+
+	var sum, p int64
+
+	c.lost = false
+	if len(c.pings) == client_pingsMax {
+		c.pings = c.pings[1:]
+	}
+	c.pings = append(c.pings, ms)
+
+	for _, p = range c.pings {
+		sum += p
+	}
+	c.avping = sum / int64(len(c.pings))
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tClient) login(in *bufio.Reader, uid, pwd string, ascii bool, asqFile string) (ok bool) {
+
+	// Answers the Anti-Spam Question and logs in.
+
+	var asq tApiAsq
+	var img []byte
+	var answer string
+	var form url.Values
+	var resp *http.Response
+	var body []byte
+	var u *url.URL
+	var cookie *http.Cookie
+	var err error
+
+	_, err = c.request(http.MethodGet, path_asq, "", "", &asq)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error connecting to the server:", err)
+		return false
+	}
+	img, err = base64.StdEncoding.DecodeString(asq.Msg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Bad anti-spam question:", err)
+		return false
+	}
+
+	if len(asqFile) > 0 {
+		err = ioutil.WriteFile(asqFile, img, 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error saving the anti-spam question:", err)
+		} else {
+			fmt.Println("The anti-spam question is saved to", asqFile)
+		}
+	}
+	client_showAsq(img, ascii)
+	answer = client_ask(in, "How many circles are there? ")
+
+	form = url.Values{}
+	form.Set(param_login_userID, uid)
+	form.Set(param_login_password, pwd)
+	form.Set(param_qid, asq.Qid)
+	form.Set(param_qAnswer, answer)
+	resp, err = c.web.PostForm(c.base+path_login, form)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error connecting to the server:", err)
+		return false
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// The Log-In Page is HTML; Success is the Session Cookie
+	u, _ = url.Parse(c.base)
+	for _, cookie = range c.web.Jar.Cookies(u) {
+		if cookie.Name == "SID" {
+			return true
+		}
+	}
+
+	// Show the Text of the Page without its Head
+	if strings.Contains(string(body), "<body>") {
+		body = body[strings.Index(string(body), "<body>"):]
+	}
+	fmt.Fprintln(os.Stderr, client_plain(string(body)))
+	return false
+}
+
+//------------------------------------------------------------------------------
+
+func client_showAsq(data []byte, ascii bool) {
+
+	// Draws the Anti-Spam Question in the Terminal. Each Symbol is a Block
+	// of Pixels; in Colour Mode a Half-Block shows two Blocks.
+
+	var img image.Image
+	var bounds image.Rectangle
+	var scale, x, y int
+	var buf bytes.Buffer
+	var err error
+
+	img, err = png.Decode(bytes.NewReader(data))
+	if err != nil {
+		fmt.Println("Can not show the anti-spam question:", err)
+		return
+	}
+
+	bounds = img.Bounds()
+	scale = (bounds.Dx() + client_asqWidth - 1) / client_asqWidth
+
+	for y = bounds.Min.Y; y < bounds.Max.Y; y += scale * 2 {
+		for x = bounds.Min.X; x < bounds.Max.X; x += scale {
+			if ascii {
+				buf.WriteByte(client_palette[client_cover(img, x, y, scale, scale*2)*(len(client_palette)-1)/255])
+			} else {
+				// Upper Half is the Foreground, lower Half is the Background
+				fmt.Fprintf(&buf, "\x1b[38;2;%sm\x1b[48;2;%sm▀",
+					client_colour(img, x, y, scale), client_colour(img, x, y+scale, scale))
+			}
+		}
+		if !ascii {
+			buf.WriteString(ansi_reset)
+		}
+		buf.WriteByte('\n')
+	}
+
+	os.Stdout.Write(buf.Bytes())
+}
+
+//------------------------------------------------------------------------------
+
+func client_cover(img image.Image, x0, y0, w, h int) (cover int) {
+
+	// Returns how much the Block is painted, [0; 255].
+
+	var x, y, n int
+	var a uint32
+
+	for y = y0; y < y0+h; y++ {
+		for x = x0; x < x0+w; x++ {
+			_, _, _, a = img.At(x, y).RGBA()
+			cover += int(a >> 8)
+			n++
+		}
+	}
+
+	return cover / n
+}
+
+//------------------------------------------------------------------------------
+
+func client_colour(img image.Image, x0, y0, size int) (rgb string) {
+
+	// Returns the average Colour of the Block on white Paper, as ANSI
+	// "R;G;B".
+
+	var x, y, n int
+	var r, g, b, a uint32
+	var sr, sg, sb uint32
+
+	for y = y0; y < y0+size; y++ {
+		for x = x0; x < x0+size; x++ {
+			r, g, b, a = img.At(x, y).RGBA()
+			// Pre-multiplied Colour over White
+			sr += (r + 0xffff - a) >> 8
+			sg += (g + 0xffff - a) >> 8
+			sb += (b + 0xffff - a) >> 8
+			n++
+		}
+	}
+
+	return fmt.Sprintf("%d;%d;%d", sr/uint32(n), sg/uint32(n), sb/uint32(n))
+}
+
+//------------------------------------------------------------------------------
+
+func client_plain(s string) (text string) {
+
+	// Converts the HTML of a Message into plain Text.
+
+	s = strings.Replace(s, "<br>", "\n", -1)
+	return strings.TrimSpace(client_clean(html.UnescapeString(client_tags.ReplaceAllString(s, "")), true))
+}
+
+//------------------------------------------------------------------------------
+
+func client_clean(s string, newlines bool) (text string) {
+
+	// Drops Control Symbols from a received Text, so the Server or other
+	// Users can not move the Cursor or change the Terminal. New Lines are
+	// kept in Texts of Messages.
+
+	return strings.Map(func(r rune) rune {
+		if (r == '\n') && newlines {
+			return r
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tClient) run(in *bufio.Reader) {
+
+	// Runs the Chat until the User quits or the Session ends.
+
+	var lines chan string
+	var signals chan os.Signal
+	var msgTicker, userTicker *time.Ticker
+	var msgInterval, userInterval, sendDelay int
+	var afterSend <-chan time.Time
+	var line string
+	var open, loop bool
+
+	msgInterval, _ = strconv.Atoi(msgUpdateInterval_str)
+	userInterval, _ = strconv.Atoi(userUpdateInterval_str)
+	sendDelay, _ = strconv.Atoi(sendToGetDelay_str)
+
+	// Input is read in the Background, the Terminal echoes it
+	lines = make(chan string)
+	go func() {
+		var s string
+		var err error
+		for {
+			s, err = in.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- strings.TrimRight(s, "\r\n")
+		}
+	}()
+
+	signals = make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+
+	c.rows, c.cols = client_termSize()
+	c.screen_init()
+	c.print(ansi_dim + "Connected to " + c.base + ". Type /help for commands." + ansi_reset)
+
+	c.update()
+	c.updateUsers()
+
+	msgTicker = time.NewTicker(time.Duration(msgInterval) * time.Second)
+	userTicker = time.NewTicker(time.Duration(userInterval) * time.Second)
+	defer msgTicker.Stop()
+	defer userTicker.Stop()
+
+	loop = true
+	for loop {
+
+		select {
+
+		case line, open = <-lines:
+			if !open {
+				loop = false
+				break
+			}
+			c.prompt()
+			if strings.HasPrefix(line, "/") {
+				loop = c.command(line)
+			} else if len(strings.TrimSpace(line)) > 0 {
+				if c.send(line) {
+					afterSend = time.After(time.Duration(sendDelay) * time.Second)
+				}
+			}
+
+		case <-afterSend:
+			afterSend = nil
+			loop = c.update()
+
+		case <-msgTicker.C:
+			loop = c.update()
+
+		case <-userTicker.C:
+			loop = c.updateUsers()
+
+		case <-signals:
+			loop = false
+		}
+	}
+
+	c.logout()
+	c.screen_reset()
+}
+
+//------------------------------------------------------------------------------
+
+func client_help() (help []string) {
+
+	return []string{
+		"/users  - list of active users",
+		"/quit   - log out and exit",
+		"/help   - this help",
+		"Any other line is sent to the chat.",
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tClient) command(line string) (loop bool) {
+
+	// Executes a Command of the User.
+
+	var s string
+
+	switch strings.Fields(line)[0] {
+
+	case "/quit", "/q", "/exit":
+		return false
+
+	case "/users", "/who":
+		c.updateUsers()
+		c.print(ansi_dim + "Active users: " + strings.Join(c.users, ", ") + ansi_reset)
+
+	case "/help":
+		for _, s = range client_help() {
+			c.print(ansi_dim + s + ansi_reset)
+		}
+
+	default:
+		c.print(ansi_dim + "Unknown command, type /help." + ansi_reset)
+	}
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tClient) update() (ok bool) {
+
+	// Gets new Messages, as get_msgUpdate of the Chat Page.
+
+	var form url.Values
+	var delta tApiDelta
+	var code string
+	var i int
+	var err error
+
+	form = url.Values{}
+	form.Set(param_req_mid, c.mid)
+	form.Set(param_req_ts, c.ts)
+	if c.mid != param_unknownVal {
+		form.Set(param_req_read, c.mid)
+	}
+	if c.avping > 0 {
+		form.Set(param_req_ping, strconv.FormatInt(c.avping, 10))
+	}
+
+	code, err = c.request(http.MethodPost, path_news, form.Encode(),
+		"application/x-www-form-urlencoded", &delta)
+	if err != nil {
+		c.status()
+		return true
+	}
+	if code == code_NotLoggedIn {
+		c.print(ansi_bold + "You are not logged in any more." + ansi_reset)
+		return false
+	}
+	if len(code) > 0 {
+		c.print(ansi_dim + "Server: " + api_codeText[code] + ansi_reset)
+		c.status()
+		return true
+	}
+
+	c.mid = strconv.FormatUint(uint64(delta.X.Mid), 10)
+	c.ts = strconv.FormatInt(delta.X.Ts, 10)
+	c.typing = c.typing[:0]
+	for i = 0; i < len(delta.Typing); i++ {
+		c.typing = append(c.typing, client_clean(delta.Typing[i], false))
+	}
+	for i = 0; i < len(delta.Messages); i++ {
+		c.show(&delta.Messages[i])
+	}
+	c.status()
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tClient) show(m *tApiMessage) {
+
+	// Shows a Message with the local Time, and the Day when it changes.
+
+	var text, clock, day string
+	var t time.Time
+
+	clock = client_clean(m.Tim, false) // Old Servers send only the Server's Time
+	if m.Ts != 0 {
+		t = time.Unix(m.Ts, 0)
+		clock = t.Format("15:04:05")
+		day = t.Format("Monday, 2 January 2006")
+		if day != c.day {
+			c.day = day
+			c.print(ansi_dim + "--- " + day + " ---" + ansi_reset)
+		}
+	}
+
+	text = client_plain(m.Txt)
+	if m.Att != nil {
+		if len(text) > 0 {
+			text += " "
+		}
+		text += "[" + client_clean(m.Att.Name, false) + "] " + c.base + path_file + "?" + param_fid + "=" + client_clean(m.Att.Id, false)
+	}
+
+	if m.Sys {
+		c.print(ansi_dim + clock + " *** " + text + ansi_reset)
+	} else {
+		c.print(ansi_dim + clock + ansi_reset + " " + ansi_bold + client_clean(m.Atr, false) + ansi_reset + ": " + text)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tClient) updateUsers() (ok bool) {
+
+	// Gets the List of active Users.
+
+	var list tApiUsers
+	var code string
+	var i int
+	var err error
+
+	code, err = c.request(http.MethodGet, path_activeList, "", "", &list)
+	if err != nil {
+		c.status()
+		return true
+	}
+	if code == code_NotLoggedIn {
+		c.print(ansi_bold + "You are not logged in any more." + ansi_reset)
+		return false
+	}
+
+	c.users = c.users[:0]
+	for i = 0; i < len(list.Users); i++ {
+		c.users = append(c.users, client_clean(list.Users[i].Name, false))
+	}
+	c.status()
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tClient) send(text string) (ok bool) {
+
+	// Sends a Message in the Format of page_send.
+
+	var code string
+	var err error
+
+	code, err = c.request(http.MethodPost, path_send,
+		strconv.Itoa(utf8.RuneCountInString(text))+" "+text, api_contentText, nil)
+	if err != nil {
+		c.print(ansi_bold + "Message is not sent: " + err.Error() + ansi_reset)
+		c.status()
+		return false
+	}
+	if code != code_messageSent {
+		c.print(ansi_bold + "Message is not sent: " + api_codeText[code] + ansi_reset)
+		return false
+	}
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tClient) logout() {
+
+	// Logs out, the Reply does not matter.
+
+	var resp *http.Response
+	var err error
+
+	resp, err = c.web.Get(c.base + path_logout)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tClient) screen_init() {
+
+	// Clears the Screen and leaves the two last Lines for the Status and
+	// the Input.
+
+	fmt.Printf("\x1b[2J\x1b[1;%dr", c.rows-2)
+	c.status()
+	c.prompt()
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tClient) screen_reset() {
+
+	// Gives the whole Screen back to the Terminal.
+
+	fmt.Printf("\x1b[r\x1b[%d;1H\n", c.rows)
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tClient) prompt() {
+
+	// Clears the Input Line.
+
+	fmt.Printf("\x1b[%d;1H"+ansi_clearLine+"> ", c.rows)
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tClient) print(line string) {
+
+	// Adds a Line to the scrolling Area, the Input Line is not touched.
+
+	fmt.Printf(ansi_save+"\x1b[%d;1H\n%s"+ansi_restore, c.rows-2, line)
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tClient) status() {
+
+	// Draws the Status Line: the Network Indicator, as on the Chat Page,
+	// active and typing Users.
+
+	var indicator, text string
+	var msgInterval int64
+	var width int // of the List of Users
+
+	msgInterval, _ = strconv.ParseInt(msgUpdateInterval_str, 10, 64)
+
+	if c.lost {
+		indicator = "\x1b[41;97m Connection Lost! " + ansi_reset
+	} else {
+		text = " Ping " + strconv.FormatInt(c.avping, 10) + "ms "
+		if c.avping <= client_pingOk {
+			indicator = "\x1b[42;30m" + text + ansi_reset // btn_netw_ok
+		} else if float64(c.avping) <= client_pingKNorm*float64(msgInterval*1000) {
+			indicator = "\x1b[43;30m" + text + ansi_reset // btn_netw_laggy
+		} else if c.avping <= msgInterval*1000 {
+			indicator = "\x1b[45;30m" + text + ansi_reset // btn_netw_slow
+		} else {
+			indicator = "\x1b[41;97m" + text + ansi_reset // btn_netw_broken
+		}
+	}
+
+	text = " Online: " + strings.Join(c.users, ", ")
+	if len(c.typing) > 0 {
+		text += " | " + strings.Join(c.typing, ", ") + " typing..."
+	}
+	width = c.cols - 20
+	if utf8.RuneCountInString(text) > width {
+		if width < 3 {
+			width = 3
+		}
+		text = string([]rune(text)[:width-3]) + "..."
+	}
+
+	fmt.Printf(ansi_save+"\x1b[%d;1H"+ansi_clearLine+"%s%s"+ansi_restore, c.rows-1, indicator, text)
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,72 @@
+// client_test.go
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func TestClientPlain(t *testing.T) {
+
+	// Texts of Messages lose their Tags and Control Symbols, even escaped
+	// ones, but keep their Lines.
+
+	var tests = []struct {
+		name string
+		html string
+		want string
+	}{
+		{"plain", "Hello", "Hello"},
+		{"lines", "one<br>two", "one\ntwo"},
+		{"tags", "<b>bold</b> &amp; <i>it</i>", "bold & it"},
+		{"escape", "red\x1b[31m", "red[31m"},
+		{"escaped escape", "red&#27;[31m&#x1b;]0;title&#7;", "red[31m]0;title"},
+		{"carriage return", "good\rbad", "goodbad"},
+		{"C1", "a\u009bb", "ab"},
+	}
+
+	var i int
+
+	for i = 0; i < len(tests); i++ {
+		if client_plain(tests[i].html) != tests[i].want {
+			t.Errorf("%s: got %q, want %q", tests[i].name, client_plain(tests[i].html), tests[i].want)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestClientStatus(t *testing.T) {
+
+	// The Status Line with a long List of Users fits into the narrowest
+	// Terminal.
+
+	var c *tClient
+	var stdout *os.File
+	var cols int
+	var err error
+
+	stdout = os.Stdout
+	os.Stdout, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.Stdout.Close()
+		os.Stdout = stdout
+	}()
+
+	c = new(tClient)
+	c.rows = 24
+	c.users = []string{strings.Repeat("Somebody", 10)}
+	for cols = 20; cols <= 25; cols++ {
+		c.cols = cols
+		c.status()
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,294 @@
+// delta_test.go
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+const delta_testTs int64 = 1000000 // Time of the first Record in a Test Ring
+
+//------------------------------------------------------------------------------
+
+func delta_setRing(tb testing.TB, first, last uint16, wrapped bool) {
+
+	// Fills the Ring with Records from "first" to "last", one per Second.
+	// The real Ring is given back when the Test ends.
+
+	var records *tChatRecords
+	var firstNum, lastNum uint16
+	var firstTs, lastTs int64
+	var circle bool
+	var i uint16
+	var k int64
+
+	records = new(tChatRecords)
+	*records = chatRecordsList
+	firstNum, lastNum = chat_recordFirstNum, chat_recordLastNum
+	firstTs, lastTs = chat_recordFirstTimestamp, chat_recordLastTimestamp
+	circle = firstCircle
+	tb.Cleanup(func() {
+		chatRecordsList = *records
+		chat_recordFirstNum, chat_recordLastNum = firstNum, lastNum
+		chat_recordFirstTimestamp, chat_recordLastTimestamp = firstTs, lastTs
+		firstCircle = circle
+	})
+
+	i = first
+	for k = 0; ; k++ {
+		chatRecordsList[i] = tChatRecord{}
+		chatRecordsList[i].time = delta_testTs + k
+		chatRecordsList[i].text = "Record #" + strconv.Itoa(int(i))
+		chatRecordsList[i].message = chatRecordsList[i].text
+		chatRecordsList[i].author = chat_systemUserUID
+		chatRecordsList[i].kind = chat_recordKindSystem
+		if i == last {
+			break
+		}
+		i++
+	}
+
+	chat_recordFirstNum = first
+	chat_recordLastNum = last
+	chat_recordFirstTimestamp = chatRecordsList[first].time
+	chat_recordLastTimestamp = chatRecordsList[last].time
+	firstCircle = !wrapped
+}
+
+//------------------------------------------------------------------------------
+
+func TestDeltaCursor(t *testing.T) {
+
+	// Cursors of Clients against a Ring in the first Circle (Records 0..9)
+	// and against a wrapped Ring (Records 4..65535, 0..3).
+
+	var tests = []struct {
+		name      string
+		wrapped   bool
+		mid       uint16
+		ts        int64  // Relative to the first Record
+		wantFirst uint16 // First given Message
+		wantCount int    // Number of given Messages
+		wantMid   uint16 // New Cursor
+		wantTs    int64  // ~, relative to the first Record
+		noNews    bool
+	}{
+		{"at the last record", false, 9, 9, 0, 0, 9, 9, true},
+		{"in the middle", false, 5, 5, 6, 4, 9, 9, false},
+		{"at the first record", false, 0, 0, 1, 9, 9, 9, false},
+		{"newer than the last record", false, 9, 100, 0, 0, 9, 100, true},
+		{"older than the ring", false, 3, -5, 0, 10, 9, 9, false},
+		{"time does not match", false, 5, 7, 0, 0, 5, 5, false},
+
+		{"wrapped: at the last record", true, 3, 65535, 0, 0, 3, 65535, true},
+		{"wrapped: before the overflow", true, 65534, 65530, 65535, 5, 3, 65535, false},
+		{"wrapped: at the overflow", true, 65535, 65531, 0, 4, 3, 65535, false},
+		{"wrapped: after the overflow", true, 2, 65534, 3, 1, 3, 65535, false},
+		{"wrapped: at the first record", true, 4, 0, 5, 65535, 3, 65535, false},
+		{"wrapped: older than the ring", true, 100, -1, 4, 65536, 3, 65535, false},
+		{"wrapped: time does not match", true, 65535, 65530, 0, 0, 65535, 65531, false},
+	}
+
+	var reply tApiDelta
+	var i, j int
+
+	for i = 0; i < len(tests); i++ {
+		t.Run(tests[i].name, func(t *testing.T) {
+
+			if tests[i].wrapped {
+				delta_setRing(t, 4, 3, true)
+			} else {
+				delta_setRing(t, 0, 9, false)
+			}
+
+			reply = tApiDelta{}
+			delta_fill(&reply, tests[i].mid, delta_testTs+tests[i].ts, "")
+
+			if len(reply.Messages) != tests[i].wantCount {
+				t.Fatalf("%d messages, want %d", len(reply.Messages), tests[i].wantCount)
+			}
+			for j = 0; j < len(reply.Messages); j++ {
+				if reply.Messages[j].Mid != tests[i].wantFirst+uint16(j) {
+					t.Fatalf("message #%d has mid %d, want %d", j, reply.Messages[j].Mid, tests[i].wantFirst+uint16(j))
+				}
+			}
+			if (reply.X.Mid != tests[i].wantMid) || (reply.X.Ts != delta_testTs+tests[i].wantTs) {
+				t.Errorf("cursor %d/%d, want %d/%d", reply.X.Mid, reply.X.Ts-delta_testTs, tests[i].wantMid, tests[i].wantTs)
+			}
+			if reply.noNews != tests[i].noNews {
+				t.Errorf("noNews is %v, want %v", reply.noNews, tests[i].noNews)
+			}
+		})
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestDeltaRequest(t *testing.T) {
+
+	// Bad Cursors are refused by page_delta.
+
+	var tests = []struct {
+		name   string
+		mid    string
+		ts     string
+		status int
+	}{
+		{"unknown cursor", param_unknownVal, param_unknownVal, http.StatusOK},
+		{"empty mid", "", "1", http.StatusBadRequest},
+		{"empty ts", "1", "", http.StatusBadRequest},
+		{"not a number", "one", "1", http.StatusBadRequest},
+		{"negative ts", "0", "-1", http.StatusBadRequest},
+		{"ts before the log-in", "0", "1", http.StatusBadRequest},
+		{"mid out of range", "65536", "1", http.StatusBadRequest},
+		{"ts out of range", "0", "99999999999999999999", http.StatusBadRequest},
+		{"signed mid", "+1", "1", http.StatusBadRequest},
+	}
+
+	var c *tTestClient
+	var uid uint64
+	var status int
+	var reply string
+	var i int
+
+	c = harness_client(t, true)
+	uid = c.register("Erin", "erin-pwd")
+	c.login(uid, "erin-pwd")
+	defer c.get(path_logout)
+
+	for i = 0; i < len(tests); i++ {
+		t.Run(tests[i].name, func(t *testing.T) {
+			status, _, reply = c.delta(tests[i].mid, tests[i].ts)
+			if status != tests[i].status {
+				t.Errorf("status %d, want %d: %s", status, tests[i].status, reply)
+			}
+		})
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestDeltaRingWrap(t *testing.T) {
+
+	// A Client whose Cursor is just before the Overflow of the Ring gets the
+	// Messages written after it.
+
+	var c *tTestClient
+	var uid uint64
+	var status int
+	var delta tApiDelta
+	var reply, mid, ts string
+	var i int
+
+	if testing.Short() {
+		t.Skip("a whole circle of the ring is posted")
+	}
+
+	// Almost a whole Circle
+	for chat_recordLastNum != chat_recordsMaxLast-2 {
+		delta_post("filler")
+	}
+
+	c = harness_client(t, true)
+	uid = c.register("Frank", "frank-pwd")
+	c.login(uid, "frank-pwd")
+	defer c.get(path_logout)
+
+	_, delta, _ = c.delta(param_unknownVal, param_unknownVal)
+	mid = strconv.FormatUint(uint64(delta.X.Mid), 10)
+	ts = strconv.FormatInt(delta.X.Ts, 10)
+
+	// Over the Overflow
+	for i = 0; i < 5; i++ {
+		delta_post("after #" + strconv.Itoa(i))
+	}
+	if firstCircle {
+		t.Fatal("the ring has not wrapped")
+	}
+
+	status, delta, reply = c.delta(mid, ts)
+	if status != http.StatusOK {
+		t.Fatalf("delta: status %d: %s", status, reply)
+	}
+	if len(delta.Messages) != 5 {
+		t.Fatalf("%d messages, want 5: %s", len(delta.Messages), reply)
+	}
+	for i = 0; i < 5; i++ {
+		if delta.Messages[i].Txt != "after #"+strconv.Itoa(i) {
+			t.Errorf("message #%d is %q", i, delta.Messages[i].Txt)
+		}
+	}
+	if delta.X.Mid != 2 {
+		t.Errorf("cursor %d, want 2", delta.X.Mid)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func FuzzDeltaParse(f *testing.F) {
+
+	// Any Cursor is refused or gives Messages from the Ring.
+
+	f.Add("0", "0")
+	f.Add(param_unknownVal, param_unknownVal)
+	f.Add("65535", "1000009")
+	f.Add("65536", "1")
+	f.Add("", "1")
+	f.Add("1", "-1")
+	f.Add("0x10", "1e3")
+
+	delta_setRing(f, 4, 3, true)
+
+	f.Fuzz(func(t *testing.T, mid_str, ts_str string) {
+
+		var mid uint16
+		var ts int64
+		var unknown, ok bool
+		var reply tApiDelta
+		var i int
+
+		mid, ts, unknown, ok = delta_parse(mid_str, ts_str)
+		if !ok || unknown {
+			return
+		}
+		if ts < 0 {
+			t.Fatalf("ts %q is read as %d", ts_str, ts)
+		}
+
+		delta_fill(&reply, mid, ts, "")
+		if len(reply.Messages) > chat_recordsMaxLast+1 {
+			t.Fatalf("%d messages", len(reply.Messages))
+		}
+		for i = 1; i < len(reply.Messages); i++ {
+			if reply.Messages[i].Mid != reply.Messages[i-1].Mid+1 {
+				t.Fatalf("message #%d has mid %d after %d", i, reply.Messages[i].Mid, reply.Messages[i-1].Mid)
+			}
+		}
+	})
+}
+
+//------------------------------------------------------------------------------
+
+func delta_post(text string) {
+
+	// Posts a Message of the System User through the Chat Manager.
+
+	var rcvChan chan tChatJob
+	var chatJob *tChatJob
+
+	rcvChan = make(chan tChatJob)
+	chatJob = new(tChatJob)
+	chatJob.chatRecord.author = chat_systemUserUID
+	chatJob.chatRecord.kind = chat_recordKindSystem
+	chatJob.chatRecord.text = text
+	chatJob.chatRecord.message = text
+	chatJob.returnChannel = rcvChan
+	chatManagerChan <- *chatJob
+	*chatJob = <-rcvChan
+}
+
+//------------------------------------------------------------------------------
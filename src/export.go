@@ -0,0 +1,517 @@
+// export.go
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	Export of the Chat's Transcript, for Administrators.
+
+	The Endpoint "/api/v1/export" gives the Messages which the Chat still
+	keeps (see chatRecordsList) in a Time Range, in one of the Formats:
+
+		jsonl   one JSON Object per Line
+		text    plain Text, one Line per Message
+		html    a Web Page
+		mbox    one E-Mail (RFC 5322) per Message, in the "mboxrd" Format
+
+	Times are shown in the Time Zone asked by the Client (IANA Name, e.g.
+	"Europe/Moscow"), UTC by default.
+
+	The same Program downloads the Export with an API Token:
+
+		saga-mikron export -token <Token> -format mbox -from 2026-10-01 http://host:port
+
+*/
+
+//------------------------------------------------------------------------------
+
+// Exported Message, JSONL Format
+type tExportRecord struct {
+	Mid    uint16          `json:"mid"`
+	Ts     int64           `json:"ts"`
+	Time   string          `json:"time"` // RFC 3339, in the asked Time Zone
+	Uid    string          `json:"uid"`
+	Author string          `json:"author"`
+	Text   string          `json:"text"`
+	Sys    bool            `json:"sys,omitempty"`
+	Origin string          `json:"origin,omitempty"`
+	Att    *tApiAttachment `json:"att,omitempty"`
+}
+
+//------------------------------------------------------------------------------
+
+// Formats
+const export_jsonl = "jsonl"
+const export_text = "text"
+const export_html = "html"
+const export_mbox = "mbox"
+
+// Query Parameter Names
+const param_format = "format" // Export: Format
+const param_tz = "tz"         // Export: Time Zone
+
+const export_tokenEnv = "SAGA_TOKEN"
+const export_mailDomain = "saga-mikron.invalid" // Domain of Authors' E-Mail Addresses
+const export_subjectLen = 60                    // Length of E-Mails' Subject, in Symbols
+
+// Content Type & File Extension of each Format
+var export_contentType = map[string]string{
+	export_jsonl: "application/x-ndjson; charset=utf-8",
+	export_text:  api_contentText,
+	export_html:  "text/html; charset=utf-8",
+	export_mbox:  "application/mbox",
+}
+var export_ext = map[string]string{
+	export_jsonl: ".jsonl",
+	export_text:  ".txt",
+	export_html:  ".html",
+	export_mbox:  ".mbox",
+}
+
+//------------------------------------------------------------------------------
+
+func rest_export(w http.ResponseWriter, req *http.Request, uid uint64) {
+
+	// Exports the Messages of a Time Range.
+
+	var format, tz, from_str, to_str string
+	var from, to int64
+	var loc *time.Location
+	var records []tChatRecord
+	var mids []uint16
+	var download http.Handler
+	var exists bool
+	var err error
+
+	if !admin_is(uid) {
+		reply_code(w, req, code_forbidden)
+		return
+	}
+
+	format = req.FormValue(param_format)
+	if len(format) == 0 {
+		format = export_jsonl
+	}
+	_, exists = export_contentType[format]
+	if !exists {
+		reply_code(w, req, code_BadRequest) // Unknown Format
+		return
+	}
+
+	tz = req.FormValue(param_tz)
+	loc, err = time.LoadLocation(tz) // "" is UTC
+	if err != nil {
+		reply_code(w, req, code_BadRequest) // Unknown Time Zone
+		return
+	}
+
+	from_str = req.FormValue(param_from)
+	to_str = req.FormValue(param_to)
+	if len(from_str) > 0 {
+		from, err = strconv.ParseInt(from_str, 10, 64)
+		if err != nil {
+			reply_code(w, req, code_BadRequest)
+			return
+		}
+	}
+	if len(to_str) > 0 {
+		to, err = strconv.ParseInt(to_str, 10, 64)
+		if err != nil {
+			reply_code(w, req, code_BadRequest)
+			return
+		}
+	}
+
+	// The Records are copied in the Job; a slow Client reads them for long,
+	// it must not hold the Jobs Manager
+	records, mids = export_records(from, to)
+	download = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		export_write(w, format, records, mids, loc)
+	})
+	if !reply_after(w, download) {
+		download.ServeHTTP(w, req)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func export_write(w http.ResponseWriter, format string, records []tChatRecord, mids []uint16, loc *time.Location) {
+
+	// Writes the copied Records in the Format. It is run in the Go-Routine
+	// of the Request.
+
+	w.Header().Set("Content-Type", export_contentType[format])
+	w.Header().Set("Content-Disposition", "attachment; filename=\"chat-"+
+		time.Now().In(loc).Format("20060102-150405")+export_ext[format]+"\"")
+
+	switch format {
+	case export_jsonl:
+		export_writeJSONL(w, records, mids, loc)
+	case export_text:
+		export_writeText(w, records, loc)
+	case export_html:
+		export_writeHTML(w, records, loc)
+	case export_mbox:
+		export_writeMbox(w, records, mids, loc)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func export_records(from, to int64) (records []tChatRecord, mids []uint16) {
+
+	// Copies the Records of the Time Range, in chronological Order.
+	// "from" and "to" are not used if they are 0.
+
+	var i uint16
+	var rec tChatRecord
+
+	i = chat_recordFirstNum
+	for {
+
+		// The Counter may overflow, so the Check is done before Increment
+		rec = chatRecordsList[i]
+		if ((from == 0) || (rec.time >= from)) && ((to == 0) || (rec.time <= to)) && (rec.time > 0) {
+			records = append(records, rec)
+			mids = append(mids, i)
+		}
+
+		if i == chat_recordLastNum {
+			break
+		}
+		i++
+	}
+
+	return records, mids
+}
+
+//------------------------------------------------------------------------------
+
+func export_author(rec *tChatRecord) (name string) {
+
+	// Returns the Name of the Record's Author.
+
+	if rec.kind == chat_recordKindSystem {
+		return "System"
+	}
+
+	return user_name(rec.author)
+}
+
+//------------------------------------------------------------------------------
+
+func export_writeJSONL(w io.Writer, records []tChatRecord, mids []uint16, loc *time.Location) {
+
+	// One JSON Object per Message.
+
+	var encoder *json.Encoder
+	var e tExportRecord
+	var i int
+
+	encoder = json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	for i = 0; i < len(records); i++ {
+		e = tExportRecord{}
+		e.Mid = mids[i]
+		e.Ts = records[i].time
+		e.Time = time.Unix(records[i].time, 0).In(loc).Format(time.RFC3339)
+		e.Uid = strconv.FormatUint(records[i].author, 10)
+		e.Author = export_author(&records[i])
+		e.Text = records[i].text
+		e.Sys = (records[i].kind == chat_recordKindSystem)
+		e.Origin = records[i].origin
+		if len(records[i].attachment.hash) > 0 {
+			e.Att = &tApiAttachment{records[i].attachment.hash, records[i].attachment.name,
+				records[i].attachment.mime, records[i].attachment.size, records[i].attachment.thumb}
+		}
+		encoder.Encode(&e)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func export_writeText(w io.Writer, records []tChatRecord, loc *time.Location) {
+
+	// One Line per Message; Lines of long Messages are indented.
+
+	var buf *bufio.Writer
+	var rec *tChatRecord
+	var text string
+	var i int
+
+	buf = bufio.NewWriter(w)
+	defer buf.Flush()
+
+	for i = 0; i < len(records); i++ {
+
+		rec = &records[i]
+		text = strings.Replace(rec.text, "\n", "\n\t", -1)
+		if len(rec.attachment.hash) > 0 {
+			text += " [" + rec.attachment.name + "]"
+		}
+
+		fmt.Fprint(buf, time.Unix(rec.time, 0).In(loc).Format("2006-01-02 15:04:05 -0700"), " ")
+		if rec.kind == chat_recordKindSystem {
+			fmt.Fprintln(buf, "***", text)
+		} else {
+			fmt.Fprintln(buf, "<"+export_author(rec)+">", text)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func export_writeHTML(w io.Writer, records []tChatRecord, loc *time.Location) {
+
+	// A Web Page with a Table of Messages. Messages are already rendered
+	// into safe HTML.
+
+	var buf *bufio.Writer
+	var rec *tChatRecord
+	var title string
+	var i int
+
+	buf = bufio.NewWriter(w)
+	defer buf.Flush()
+
+	title = "Chat Transcript"
+	if len(records) > 0 {
+		title += ", " + time.Unix(records[0].time, 0).In(loc).Format("2006-01-02 15:04") +
+			" – " + time.Unix(records[len(records)-1].time, 0).In(loc).Format("2006-01-02 15:04 MST")
+	}
+
+	fmt.Fprint(buf, "<!DOCTYPE html>\n<html><head><meta charset='utf-8'><title>", html.EscapeString(title), "</title>\n",
+		"<style>body{font-family:sans-serif} td{padding:2px 8px;vertical-align:top} ",
+		"td.t{color:#888;white-space:nowrap} td.a{font-weight:bold} tr.s td{color:#888;font-style:italic}</style>\n",
+		"</head>\n<body>\n<h1>", html.EscapeString(title), "</h1>\n<table>\n")
+
+	for i = 0; i < len(records); i++ {
+
+		rec = &records[i]
+		if rec.kind == chat_recordKindSystem {
+			fmt.Fprint(buf, "<tr class='s'>")
+		} else {
+			fmt.Fprint(buf, "<tr>")
+		}
+		fmt.Fprint(buf, "<td class='t'>", time.Unix(rec.time, 0).In(loc).Format("2006-01-02 15:04:05"), "</td>",
+			"<td class='a'>", html.EscapeString(export_author(rec)), "</td><td>", rec.message)
+		if len(rec.attachment.hash) > 0 {
+			fmt.Fprint(buf, " <a href='", path_file, "?", param_fid, "=", rec.attachment.hash, "'>",
+				html.EscapeString(rec.attachment.name), "</a>")
+		}
+		fmt.Fprint(buf, "</td></tr>\n")
+	}
+
+	fmt.Fprint(buf, "</table>\n</body></html>\n")
+}
+
+//------------------------------------------------------------------------------
+
+func export_writeMbox(w io.Writer, records []tChatRecord, mids []uint16, loc *time.Location) {
+
+	// One E-Mail per Message. Lines of the Body which look like the
+	// Separator ("From ", ">From ", ...) get one more '>' ("mboxrd").
+
+	var buf *bufio.Writer
+	var rec *tChatRecord
+	var t time.Time
+	var subject, line, body string
+	var i int
+
+	buf = bufio.NewWriter(w)
+	defer buf.Flush()
+
+	for i = 0; i < len(records); i++ {
+
+		rec = &records[i]
+		t = time.Unix(rec.time, 0).In(loc)
+
+		body = rec.text
+		if len(rec.attachment.hash) > 0 {
+			body += "\n[" + rec.attachment.name + "]"
+		}
+
+		subject = strings.Join(strings.Fields(body), " ")
+		if len([]rune(subject)) > export_subjectLen {
+			subject = string([]rune(subject)[:export_subjectLen]) + "..."
+		}
+
+		fmt.Fprint(buf, "From ", rec.author, "@", export_mailDomain, " ", t.UTC().Format(time.ANSIC), "\n")
+		fmt.Fprint(buf, "From: ", mime.QEncoding.Encode("utf-8", export_author(rec)),
+			" <", rec.author, "@", export_mailDomain, ">\n")
+		fmt.Fprint(buf, "Date: ", t.Format(time.RFC1123Z), "\n")
+		fmt.Fprint(buf, "Subject: ", mime.QEncoding.Encode("utf-8", subject), "\n")
+		fmt.Fprint(buf, "Message-ID: <", rec.time, ".", mids[i], ".", rec.author, "@", export_mailDomain, ">\n")
+		fmt.Fprint(buf, "MIME-Version: 1.0\n")
+		fmt.Fprint(buf, "Content-Type: text/plain; charset=utf-8\n")
+		fmt.Fprint(buf, "Content-Transfer-Encoding: 8bit\n\n")
+
+		for _, line = range strings.Split(body, "\n") {
+			if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+				line = ">" + line
+			}
+			fmt.Fprint(buf, line, "\n")
+		}
+		fmt.Fprint(buf, "\n")
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func export_main(args []string) {
+
+	// Downloads the Export from a running Server.
+
+	var flags *flag.FlagSet
+	var format, from, to, tz, token, out *string
+	var loc *time.Location
+	var query url.Values
+	var req *http.Request
+	var resp *http.Response
+	var reply tApiCode
+	var file *os.File
+	var base string
+	var ts int64
+	var err error
+
+	flags = flag.NewFlagSet("export", flag.ExitOnError)
+	format = flags.String("format", export_jsonl, "Format: jsonl, text, html or mbox.")
+	from = flags.String("from", "", "Oldest Time: Unix Timestamp, YYYY-MM-DD or RFC 3339.")
+	to = flags.String("to", "", "Newest Time: Unix Timestamp, YYYY-MM-DD (the whole Day) or RFC 3339.")
+	tz = flags.String("tz", "", "Time Zone, e.g. Europe/Moscow; UTC by default.")
+	token = flags.String("token", "", "API Token of an Administrator, or the "+export_tokenEnv+" Environment Variable.")
+	out = flags.String("o", "", "Output File; the Standard Output by default.")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: saga-mikron export [options] http://host:port")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if len(*token) == 0 {
+		*token = os.Getenv(export_tokenEnv)
+	}
+	loc, err = time.LoadLocation(*tz)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unknown time zone:", err)
+		os.Exit(2)
+	}
+
+	query = url.Values{}
+	query.Set(param_format, *format)
+	query.Set(param_tz, *tz)
+	if len(*from) > 0 {
+		ts, err = export_parseTime(*from, loc, false)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Bad time:", err)
+			os.Exit(2)
+		}
+		query.Set(param_from, strconv.FormatInt(ts, 10))
+	}
+	if len(*to) > 0 {
+		ts, err = export_parseTime(*to, loc, true)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Bad time:", err)
+			os.Exit(2)
+		}
+		query.Set(param_to, strconv.FormatInt(ts, 10))
+	}
+
+	base = strings.TrimSuffix(flags.Arg(0), "/")
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	req, err = http.NewRequest(http.MethodGet, base+path_api+"/export?"+query.Encode(), nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	req.Header.Set("Authorization", token_authPrefix+*token)
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error connecting to the server:", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		json.NewDecoder(resp.Body).Decode(&reply)
+		if reply.Error != nil {
+			fmt.Fprintln(os.Stderr, "Export failed:", resp.Status, reply.Error.Text)
+		} else {
+			fmt.Fprintln(os.Stderr, "Export failed:", resp.Status)
+		}
+		os.Exit(1)
+	}
+
+	file = os.Stdout
+	if len(*out) > 0 {
+		file, err = os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer file.Close()
+	}
+
+	_, err = io.Copy(file, resp.Body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Export failed:", err)
+		os.Exit(1)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func export_parseTime(s string, loc *time.Location, dayEnd bool) (ts int64, err error) {
+
+	// Parses a Unix Timestamp, a Date or an RFC 3339 Time. A Date is the
+	// Start of the Day, or its End if "dayEnd" is set.
+
+	var t time.Time
+
+	ts, err = strconv.ParseInt(s, 10, 64)
+	if err == nil {
+		return ts, nil
+	}
+
+	t, err = time.ParseInLocation("2006-01-02", s, loc)
+	if err == nil {
+		if dayEnd {
+			t = t.AddDate(0, 0, 1).Add(-time.Second)
+		}
+		return t.Unix(), nil
+	}
+
+	t, err = time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Unix(), nil
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,159 @@
+// export_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata" // Time Zones without the System's Database
+)
+
+//------------------------------------------------------------------------------
+
+func TestExportParseTime(t *testing.T) {
+
+	// Timestamps, Dates and RFC 3339 Times; a Date is in the asked Zone.
+
+	var tests = []struct {
+		text   string
+		tz     string
+		dayEnd bool
+		want   int64
+		ok     bool
+	}{
+		{"1700000000", "", false, 1700000000, true},
+		{"1700000000", "Europe/Moscow", true, 1700000000, true},
+		{"2026-10-01", "", false, 1790812800, true},
+		{"2026-10-01", "", true, 1790812800 + 86400 - 1, true},
+		{"2026-10-01", "Europe/Moscow", false, 1790812800 - 3*3600, true},
+		{"2026-10-01", "America/New_York", true, 1790812800 + 4*3600 + 86400 - 1, true},
+		{"2026-10-01T12:00:00+02:00", "Europe/Moscow", false, 1790812800 + 10*3600, true},
+		{"2026-10-01T12:00:00Z", "", true, 1790812800 + 12*3600, true},
+		{"2026-13-01", "", false, 0, false},
+		{"yesterday", "", false, 0, false},
+		{"", "", false, 0, false},
+	}
+
+	var loc *time.Location
+	var ts int64
+	var i int
+	var err error
+
+	for i = 0; i < len(tests); i++ {
+		loc, err = time.LoadLocation(tests[i].tz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, err = export_parseTime(tests[i].text, loc, tests[i].dayEnd)
+		if (err == nil) != tests[i].ok {
+			t.Errorf("%q: error %v", tests[i].text, err)
+			continue
+		}
+		if tests[i].ok && (ts != tests[i].want) {
+			t.Errorf("%q in %q: %d, want %d", tests[i].text, tests[i].tz, ts, tests[i].want)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestExport(t *testing.T) {
+
+	// Administrators get the Messages in each Format, with Times in the asked
+	// Zone. Others get nothing.
+
+	var tests = []struct {
+		name   string
+		query  string
+		status int
+		want   []string
+		not    []string
+	}{
+		{"jsonl", "?format=jsonl&tz=Europe/Moscow", http.StatusOK,
+			[]string{`"author":"Walter"`, `"text":"Hi <all>\nFrom here\n>From there"`, `+03:00"`}, nil},
+		{"default format", "", http.StatusOK,
+			[]string{`"author":"Walter"`, `Z"`}, nil},
+		{"text", "?format=text&tz=Asia/Tokyo", http.StatusOK,
+			[]string{" +0900 <Walter> Hi <all>\n\tFrom here\n\t>From there\n"}, nil},
+		{"html", "?format=html", http.StatusOK,
+			[]string{"<td class='a'>Walter</td><td>Hi &lt;all&gt;"}, []string{"Hi <all>"}},
+		{"mbox", "?format=mbox&tz=Europe/Moscow", http.StatusOK,
+			[]string{"\nFrom: Walter <", " +0300\nSubject: Hi <all> From here >From there\n",
+				"\n\nHi <all>\n>From here\n>>From there\n\n"},
+			[]string{"\nFrom here\n"}},
+		{"unknown format", "?format=xml", http.StatusBadRequest, nil, nil},
+		{"unknown zone", "?tz=Mars/Olympus", http.StatusBadRequest, nil, nil},
+		{"bad time", "?from=yesterday", http.StatusBadRequest, nil, nil},
+		{"empty range", "?from=1&to=2", http.StatusOK, nil, []string{"Walter"}},
+	}
+
+	var c *tTestClient
+	var uid, stranger uint64
+	var token, strangerToken, reply, query string
+	var start int64
+	var status int
+	var apiReply tApiCode
+	var ok bool
+	var i, j int
+
+	harness_quiet(t)
+	c = harness_client(t, false)
+	uid = c.register("Walter", "walter-pwd")
+	stranger = c.register("Victor", "victor-pwd")
+	c.login(uid, "walter-pwd")
+	defer c.get(path_logout)
+
+	start = time.Now().Unix()
+	status, reply = c.send("Hi <all>\nFrom here\n>From there")
+	if status != http.StatusOK {
+		t.Fatalf("send: %d %s", status, reply)
+	}
+
+	token, _, ok = token_new(uid)
+	if ok {
+		strangerToken, _, ok = token_new(stranger)
+	}
+	if !ok {
+		t.Fatal("no token")
+	}
+	harness_admin(t, uid)
+
+	for i = 0; i < len(tests); i++ {
+
+		query = tests[i].query
+		if !strings.Contains(query, "from=") {
+			query += "&" + url.Values{param_from: {strconv.FormatInt(start, 10)}}.Encode()
+		}
+		query = "?" + strings.TrimLeft(query, "?&")
+
+		status, reply = rest_testGet(t, token, "/export"+query)
+		if status != tests[i].status {
+			t.Errorf("%s: status %d, want %d: %s", tests[i].name, status, tests[i].status, reply)
+			continue
+		}
+		for j = 0; j < len(tests[i].want); j++ {
+			if !strings.Contains(reply, tests[i].want[j]) {
+				t.Errorf("%s: no %q in %q", tests[i].name, tests[i].want[j], reply)
+			}
+		}
+		for j = 0; j < len(tests[i].not); j++ {
+			if strings.Contains(reply, tests[i].not[j]) {
+				t.Errorf("%s: %q in %q", tests[i].name, tests[i].not[j], reply)
+			}
+		}
+	}
+
+	// Not an Administrator
+	status, reply = rest_testGet(t, strangerToken, "/export")
+	if (status != http.StatusForbidden) || (json.Unmarshal([]byte(reply), &apiReply) != nil) ||
+		(apiReply.Error == nil) || strings.Contains(reply, "Walter") {
+		t.Errorf("stranger gets %d: %s", status, reply)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,196 @@
+// flow_test.go
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func TestFlow(t *testing.T) {
+
+	// Register -> Anti-Spam Question -> Log-In -> Send -> Delta -> Log-Out,
+	// in the JSON Format.
+
+	var alice, bob *tTestClient
+	var uid_a, uid_b uint64
+	var status int
+	var delta tApiDelta
+	var reply, mid, ts string
+	var found bool
+	var i int
+
+	alice = harness_client(t, true)
+	bob = harness_client(t, true)
+
+	uid_a = alice.register("Alice", "alice-pwd")
+	uid_b = bob.register("Bob", "bob-pwd")
+	if uid_a == uid_b {
+		t.Fatal("two users have the same UID")
+	}
+
+	reply = alice.login(uid_a, "alice-pwd")
+	if !strings.Contains(reply, "You are now logged in") {
+		t.Fatalf("login: %s", reply)
+	}
+	reply = bob.login(uid_b, "bob-pwd")
+	if !strings.Contains(reply, "You are now logged in") {
+		t.Fatalf("login: %s", reply)
+	}
+
+	// The Client does not know its Cursor yet
+	status, delta, reply = alice.delta(param_unknownVal, param_unknownVal)
+	if (status != http.StatusOK) || (len(delta.Messages) != 0) {
+		t.Fatalf("delta X: status %d: %s", status, reply)
+	}
+	mid = strconv.FormatUint(uint64(delta.X.Mid), 10)
+	ts = strconv.FormatInt(delta.X.Ts, 10)
+
+	// Nothing new since the Log-In
+	status, delta, reply = alice.delta(mid, ts)
+	if (status != http.StatusOK) || (len(delta.Messages) != 0) {
+		t.Fatalf("delta: status %d: %s", status, reply)
+	}
+
+	status, reply = bob.send("Hello, Alice!")
+	if (status != http.StatusOK) || !strings.Contains(reply, `"code":"`+code_messageSent+`"`) {
+		t.Fatalf("send: status %d: %s", status, reply)
+	}
+
+	status, delta, reply = alice.delta(mid, ts)
+	if status != http.StatusOK {
+		t.Fatalf("delta: status %d: %s", status, reply)
+	}
+	for i = 0; i < len(delta.Messages); i++ {
+		if (delta.Messages[i].Atr == "Bob") && strings.Contains(delta.Messages[i].Txt, "Hello, Alice!") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("delta: message of Bob is not found: %s", reply)
+	}
+	if delta.X.Mid != chat_recordLastNum {
+		t.Errorf("delta: cursor %d, want %d", delta.X.Mid, chat_recordLastNum)
+	}
+
+	// The new Cursor has no News
+	mid = strconv.FormatUint(uint64(delta.X.Mid), 10)
+	ts = strconv.FormatInt(delta.X.Ts, 10)
+	status, delta, reply = alice.delta(mid, ts)
+	if (status != http.StatusOK) || (len(delta.Messages) != 0) {
+		t.Fatalf("delta after news: status %d: %s", status, reply)
+	}
+
+	// Log-Out ends the Session
+	_, reply = alice.get(path_logout)
+	if !strings.Contains(reply, "You are logged off") {
+		t.Fatalf("logout: %s", reply)
+	}
+	status, _, reply = alice.delta(mid, ts)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("delta after logout: status %d: %s", status, reply)
+	}
+	status, reply = alice.send("Anybody?")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("send after logout: status %d: %s", status, reply)
+	}
+
+	bob.get(path_logout)
+}
+
+//------------------------------------------------------------------------------
+
+func TestFlowLegacy(t *testing.T) {
+
+	// Old Clients get Letters instead of JSON Objects.
+
+	var c *tTestClient
+	var uid uint64
+	var status int
+	var reply string
+
+	c = harness_client(t, false)
+	uid = c.register("Carol", "carol-pwd")
+	c.login(uid, "carol-pwd")
+
+	status, reply = c.send("Hi")
+	if (status != http.StatusOK) || (reply != code_messageSent) {
+		t.Fatalf("send: status %d: %q", status, reply)
+	}
+	status, reply = c.do(http.MethodPost, path_send, api_contentText, "5 Hi")
+	if reply != code_BadPOSTdata {
+		t.Fatalf("send with a wrong length: status %d: %q", status, reply)
+	}
+	status, reply = c.send("")
+	if reply != code_EmptyMessage {
+		t.Fatalf("send of nothing: status %d: %q", status, reply)
+	}
+
+	status, _, reply = c.delta(strconv.FormatUint(uint64(chat_recordLastNum), 10),
+		strconv.FormatInt(chatRecordsList[chat_recordLastNum].time, 10))
+	if reply != code_NoNews {
+		t.Fatalf("delta: status %d: %q", status, reply)
+	}
+
+	c.get(path_logout)
+	status, _, reply = c.delta(param_unknownVal, param_unknownVal)
+	if reply != code_NotLoggedIn {
+		t.Fatalf("delta after logout: status %d: %q", status, reply)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestLoginFailures(t *testing.T) {
+
+	// Log-In is refused for wrong Answers and Passwords, and for a User who
+	// is already logged in.
+
+	var tests = []struct {
+		name   string
+		uid    string
+		pwd    string
+		answer uint8
+		want   string
+	}{
+		{"wrong password", "", "wrong", harness_asqAnswer, "Bad UID or Password"},
+		{"wrong answer", "", "dave-pwd", harness_asqAnswer + 1, "Answer to anti-spam Question is wrong"},
+		{"unknown user", "12345", "dave-pwd", harness_asqAnswer, "Bad UID or Password"},
+		{"bad UID", "dave", "dave-pwd", harness_asqAnswer, "UID is bad"},
+		{"good", "", "dave-pwd", harness_asqAnswer, "You are now logged in"},
+		{"already logged in", "", "dave-pwd", harness_asqAnswer, "Already logged in"},
+	}
+
+	var c *tTestClient
+	var uid uint64
+	var form map[string][]string
+	var reply string
+	var i int
+
+	c = harness_client(t, false)
+	uid = c.register("Dave", "dave-pwd")
+
+	for i = 0; i < len(tests); i++ {
+		t.Run(tests[i].name, func(t *testing.T) {
+			form = map[string][]string{
+				param_login_userID:   {tests[i].uid},
+				param_login_password: {tests[i].pwd},
+				param_qid:            {c.asq()},
+				param_qAnswer:        {strconv.Itoa(int(tests[i].answer))},
+			}
+			if len(tests[i].uid) == 0 {
+				form[param_login_userID] = []string{strconv.FormatUint(uid, 10)}
+			}
+			_, reply = harness_client(t, false).postForm(path_login, form)
+			if !strings.Contains(reply, tests[i].want) {
+				t.Errorf("reply has no %q: %s", tests[i].want, reply)
+			}
+		})
+	}
+}
+
+//------------------------------------------------------------------------------
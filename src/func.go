@@ -5,6 +5,7 @@ package main
 import (
 	"crypto/rand"
 	"os"
+	"time"
 )
 
 //------------------------------------------------------------------------------
@@ -84,3 +85,12 @@ func generateRandomUint8() (rui uint8) {
 }
 
 //------------------------------------------------------------------------------
+
+func time_clock(ts int64) (clock string) {
+
+	// Formats the Unix Timestamp as a Time of the Day.
+
+	return time.Unix(ts, 0).Format("15:04:05")
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,343 @@
+// harness_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	In-Process Test Harness.
+
+	TestMain starts the whole Server, with all Managers and Revisors, on an
+	"httptest" Listener. Data Files are kept in a temporary "dat" Directory,
+	Templates are the embedded ones. Anti-Spam Questions are made by
+	harness_asqMaker, so their Answer is always harness_asqAnswer.
+
+	Tests share the Server: they must not run in parallel, and each Test
+	registers its own Users.
+
+*/
+
+//------------------------------------------------------------------------------
+
+// Client of the Test Server, with its own Cookies
+type tTestClient struct {
+	t    *testing.T
+	http *http.Client
+	json bool // Client asks for the versioned JSON Format
+}
+
+//------------------------------------------------------------------------------
+
+const harness_asqAnswer uint8 = 7 // Answer to each Anti-Spam Question
+
+//------------------------------------------------------------------------------
+
+var harness_server *httptest.Server
+var harness_uidRegexp = regexp.MustCompile(`uid = *([0-9]+) *;`)
+
+//------------------------------------------------------------------------------
+
+func TestMain(m *testing.M) {
+
+	// Starts the Server, runs the Tests and removes the Data.
+
+	var dir string
+	var code int
+	var err error
+
+	dir, err = ioutil.TempDir("", "saga-test-")
+	if err != nil {
+		panic(err)
+	}
+
+	harness_init(filepath.Join(dir, "dat"))
+	code = m.Run()
+
+	harness_server.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+//------------------------------------------------------------------------------
+
+func harness_init(dat string) {
+
+	// Does what "main" does, with Test Parameters instead of Flags.
+
+	var err error
+
+	err = os.MkdirAll(dat, 0700)
+	if err != nil {
+		panic(err)
+	}
+
+	// Parameters
+	createUserDataFile = true
+	file_userData = filepath.Join(dat, "user.dat")
+	attach_dir = filepath.Join(dat, "att")
+	file_apiTokens = filepath.Join(dat, "token.dat")
+	webhook_queueDir = filepath.Join(dat, "hook")
+	activeRevisorInterval = activeRevisorInterval_default
+	asqRevisorInterval = asqRevisorIntervalDefault
+	awayTimeout = awayTimeout_default * 60
+	log_level_str = log_levelNames[logLevelError]
+	log_format_str = log_formatLogfmt
+
+	// Known Questions
+	asq_maker = harness_asqMaker
+
+	chat_init()
+	if !logger_init() || !templates_init() || !userData_init() || !admin_init() ||
+		!attach_init() || !inhook_init() || !token_init() || !webhook_init() || !matrix_init() || !bridge_init() {
+		panic("initialization failed")
+	}
+	atomic.StoreUint32(&health_initDone, 1)
+
+	server.init()
+	server.startManagers()
+	harness_server = httptest.NewServer(server.server.Handler)
+}
+
+//------------------------------------------------------------------------------
+
+func harness_asqMaker(asq *tAntiSpamQuestion) {
+
+	// Makes a Question with a known Answer and no Image.
+
+	asq.question = []byte("test question")
+	asq.answer = harness_asqAnswer
+}
+
+//------------------------------------------------------------------------------
+
+func harness_quiet(tb testing.TB) {
+
+	// Drops the Log until the Test ends. Fuzzing feeds broken Data by the
+	// Million, each would be logged.
+
+	log_out.SetOutput(ioutil.Discard)
+	tb.Cleanup(func() {
+		log_out.SetOutput(os.Stderr)
+	})
+}
+
+//------------------------------------------------------------------------------
+
+func harness_templates(t *testing.T, restore func()) {
+
+	// Gives the Parameters of the Pages back with "restore" when the Test
+	// ends, and builds the Pages again from them.
+
+	t.Cleanup(func() {
+		restore()
+		if !templates_init() {
+			t.Fatal("templates can not be restored")
+		}
+	})
+}
+
+//------------------------------------------------------------------------------
+
+func harness_admin(t *testing.T, uid uint64) {
+
+	// Makes the User the only Administrator until the Test ends.
+
+	var admins string
+
+	admins = admins_str
+	admins_str = strconv.FormatUint(uid, 10)
+	if !admin_init() {
+		t.Fatal("administrator is refused")
+	}
+	t.Cleanup(func() {
+		admins_str = admins
+		admin_init()
+	})
+}
+
+//------------------------------------------------------------------------------
+
+func harness_client(t *testing.T, jsonFormat bool) (c *tTestClient) {
+
+	// Creates a new Client without Cookies.
+
+	var jar *cookiejar.Jar
+
+	jar, _ = cookiejar.New(nil)
+	c = new(tTestClient)
+	c.t = t
+	c.http = &http.Client{Jar: jar}
+	c.json = jsonFormat
+
+	return c
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tTestClient) do(method, path, contentType, body string) (status int, reply string) {
+
+	// Sends a Request and gives the Status and the Body of the Reply.
+
+	var req *http.Request
+	var resp *http.Response
+	var data []byte
+	var err error
+
+	req, err = http.NewRequest(method, harness_server.URL+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.json {
+		req.Header.Set("Accept", api_mimeJSON)
+	}
+
+	resp, err = c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return resp.StatusCode, string(data)
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tTestClient) get(path string) (status int, reply string) {
+
+	// Sends a GET Request.
+
+	return c.do(http.MethodGet, path, "", "")
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tTestClient) postForm(path string, form url.Values) (status int, reply string) {
+
+	// Sends a POST Request with a Form.
+
+	return c.do(http.MethodPost, path, "application/x-www-form-urlencoded", form.Encode())
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tTestClient) asq() (qid string) {
+
+	// Gets an Anti-Spam Question, its Answer is known.
+
+	var status int
+	var reply string
+	var asq tApiAsq
+
+	status, reply = c.get(path_asq)
+	if status != http.StatusOK {
+		c.t.Fatalf("asq: status %d: %s", status, reply)
+	}
+	if json.Unmarshal([]byte(reply), &asq) != nil || len(asq.Qid) == 0 {
+		c.t.Fatalf("asq: bad reply: %s", reply)
+	}
+
+	return asq.Qid
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tTestClient) register(name, pwd string) (uid uint64) {
+
+	// Registers a User and gives the UID.
+
+	var form url.Values
+	var reply string
+	var match []string
+	var err error
+
+	form = url.Values{}
+	form.Set(param_reg_userName, name)
+	form.Set(param_reg_password, pwd)
+	form.Set(param_qid, c.asq())
+	form.Set(param_qAnswer, strconv.Itoa(int(harness_asqAnswer)))
+	_, reply = c.postForm(path_register, form)
+
+	match = harness_uidRegexp.FindStringSubmatch(reply)
+	if match == nil {
+		c.t.Fatalf("register: no UID in reply: %s", reply)
+	}
+	uid, err = strconv.ParseUint(match[1], 10, 64)
+	if (err != nil) || (uid == chat_systemUserUID) {
+		c.t.Fatalf("register: bad UID %q", match[1])
+	}
+
+	return uid
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tTestClient) login(uid uint64, pwd string) (reply string) {
+
+	// Logs the User in and gives the Reply Page.
+
+	var form url.Values
+
+	form = url.Values{}
+	form.Set(param_login_userID, strconv.FormatUint(uid, 10))
+	form.Set(param_login_password, pwd)
+	form.Set(param_qid, c.asq())
+	form.Set(param_qAnswer, strconv.Itoa(int(harness_asqAnswer)))
+	_, reply = c.postForm(path_login, form)
+
+	return reply
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tTestClient) send(text string) (status int, reply string) {
+
+	// Sends a Message in the Format of the Chat Page: "<Length> <Text>".
+
+	return c.do(http.MethodPost, path_send, api_contentText,
+		strconv.Itoa(len([]rune(text)))+" "+text)
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tTestClient) delta(mid, ts string) (status int, delta tApiDelta, reply string) {
+
+	// Asks for new Messages since the Cursor.
+
+	var form url.Values
+
+	form = url.Values{}
+	form.Set(param_req_mid, mid)
+	form.Set(param_req_ts, ts)
+	status, reply = c.postForm(path_news, form)
+	if (status == http.StatusOK) && c.json {
+		json.Unmarshal([]byte(reply), &delta)
+	}
+
+	return status, delta, reply
+}
+
+//------------------------------------------------------------------------------
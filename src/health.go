@@ -0,0 +1,411 @@
+// health.go
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	Checks for Supervisors and Pages for Debugging.
+
+	"/healthz" answers "ok" while the Server serves Requests. As all Pages,
+	it is served by the Server's Jobs Manager, so a stuck Manager makes it
+	silent.
+
+	"/readyz" answers "ok" only when the Initialization (Templates, User
+	Data, ...) has succeeded and each Manager answers a Ping in Time.
+	Otherwise it answers 503 with the Names of the silent Managers.
+	Bridges are not checked: they wait for remote Servers, which are not
+	the Chat's Problem. Revisors are not checked: they only sleep.
+
+	"/debug/state" shows the Sizes of the Lists and the Counters of the
+	Ring of Chat Records, in JSON. "/debug/pprof/" serves Profiles of the
+	Server, if the "-pprof" Flag is set. Both are for Administrators only,
+	with an API Token or a Session of the Chat.
+
+*/
+
+//------------------------------------------------------------------------------
+
+// A Manager which can be pinged
+type tHealthManager struct {
+	name string
+	ping func() // Sends a Ping and waits for the Answer
+}
+
+// State of the Server (see page_debug)
+type tDebugState struct {
+	V             int   `json:"v"`
+	Uptime        int64 `json:"uptime"` // Seconds
+	Goroutines    int   `json:"goroutines"`
+	ActiveClients int   `json:"active_clients"`
+	Asqs          int   `json:"asqs"`
+	Users         int   `json:"users"`
+	Ring          struct {
+		Size    int    `json:"size"`
+		First   uint16 `json:"first"`
+		Last    uint16 `json:"last"`
+		FirstTs int64  `json:"first_ts"`
+		LastTs  int64  `json:"last_ts"`
+		Wrapped bool   `json:"wrapped"`
+		Wraps   uint64 `json:"wraps"`
+	} `json:"ring"`
+}
+
+//------------------------------------------------------------------------------
+
+const path_healthz = "/healthz"         // Liveness Check
+const path_readyz = "/readyz"           // Readiness Check
+const path_debug = "/debug/"            // Prefix of Pages for Debugging
+const path_debugState = "/debug/state"  // State of the Server
+const path_debugPprof = "/debug/pprof/" // Profiles of the Server
+
+const health_timeout = 2 // Time for all Managers to answer, in Seconds
+
+//------------------------------------------------------------------------------
+
+// Managers which must answer for the Server to be ready
+var health_managers = [...]tHealthManager{
+	{"chatManager", health_pingChat},
+	{"loginManager", health_pingLogin},
+	{"registerManager", health_pingRegister},
+	{"activeManager", health_pingActive},
+	{"asqManager", health_pingAsq},
+	{"announceManager", health_pingAnnounce},
+	{"webhookManager", health_pingWebhook},
+	{"searchManager", health_pingSearch},
+	{"statManager", health_pingStat},
+}
+
+// Pings which have not been answered yet, by Manager
+var health_pending [len(health_managers)]uint32
+
+// Internal Parameters
+var health_initDone uint32 // 1 when all Initializations have succeeded
+var health_pprof bool      // Profiles are served
+
+//------------------------------------------------------------------------------
+
+func page_healthz(w http.ResponseWriter, req *http.Request) {
+
+	// Liveness Check.
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(w, "ok\n")
+}
+
+//------------------------------------------------------------------------------
+
+func page_readyz(w http.ResponseWriter, req *http.Request) {
+
+	// Readiness Check.
+
+	var silent []string
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if atomic.LoadUint32(&health_initDone) == 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "not ready: initialization\n")
+		return
+	}
+
+	silent = health_check()
+	if len(silent) > 0 {
+		log_warn(log_rid(req), "Managers do not answer", "managers", strings.Join(silent, ","))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "not ready: %s\n", strings.Join(silent, ", "))
+		return
+	}
+
+	fmt.Fprint(w, "ok\n")
+}
+
+//------------------------------------------------------------------------------
+
+func health_check() (silent []string) {
+
+	// Pings all Managers at once and gives the Names of those which have not
+	// answered in Time.
+	// A Manager which has not answered the previous Ping is not pinged
+	// again, so that stuck Managers do not collect waiting Go-Routines.
+
+	var answers []chan bool
+	var deadline <-chan time.Time
+	var late bool
+	var i int
+
+	answers = make([]chan bool, len(health_managers))
+	for i = 0; i < len(health_managers); i++ {
+		if !atomic.CompareAndSwapUint32(&health_pending[i], 0, 1) {
+			continue
+		}
+		answers[i] = make(chan bool, 1)
+		go func(i int) {
+			health_managers[i].ping()
+			atomic.StoreUint32(&health_pending[i], 0)
+			answers[i] <- true
+		}(i)
+	}
+
+	deadline = time.After(health_timeout * time.Second)
+	for i = 0; i < len(health_managers); i++ {
+
+		if answers[i] == nil {
+			silent = append(silent, health_managers[i].name)
+			continue
+		}
+
+		if !late {
+			select {
+			case <-answers[i]:
+				continue
+			case <-deadline:
+				late = true
+			}
+		}
+
+		// Time is over, only ready Answers count
+		select {
+		case <-answers[i]:
+		default:
+			silent = append(silent, health_managers[i].name)
+		}
+	}
+
+	return silent
+}
+
+//------------------------------------------------------------------------------
+
+func health_pingChat() {
+
+	// Pings the Chat Manager.
+
+	var rcvChan chan tChatJob
+	var chatJob *tChatJob
+
+	rcvChan = make(chan tChatJob)
+	chatJob = new(tChatJob)
+	chatJob.ping = true
+	chatJob.returnChannel = rcvChan
+	chatManagerChan <- *chatJob
+	*chatJob = <-rcvChan
+}
+
+//------------------------------------------------------------------------------
+
+func health_pingLogin() {
+
+	// Pings the Log-In Manager.
+
+	var rcvChan chan tLoginJob
+	var loginJob *tLoginJob
+
+	rcvChan = make(chan tLoginJob)
+	loginJob = new(tLoginJob)
+	loginJob.ping = true
+	loginJob.returnChannel = rcvChan
+	loginManagerChan <- *loginJob
+	*loginJob = <-rcvChan
+}
+
+//------------------------------------------------------------------------------
+
+func health_pingRegister() {
+
+	// Pings the Register Manager.
+
+	var rcvChan chan tRegisterJob
+	var registerJob *tRegisterJob
+
+	rcvChan = make(chan tRegisterJob)
+	registerJob = new(tRegisterJob)
+	registerJob.ping = true
+	registerJob.returnChannel = rcvChan
+	registerManagerChan <- *registerJob
+	*registerJob = <-rcvChan
+}
+
+//------------------------------------------------------------------------------
+
+func health_pingActive() {
+
+	// Pings the Active Manager.
+
+	var rcvChan chan tActiveJob
+	var activeJob *tActiveJob
+
+	rcvChan = make(chan tActiveJob)
+	activeJob = new(tActiveJob)
+	activeJob.action = activeJobPing
+	activeJob.returnChannel = rcvChan
+	activeManagerChan <- *activeJob
+	*activeJob = <-rcvChan
+}
+
+//------------------------------------------------------------------------------
+
+func health_pingAsq() {
+
+	// Pings the ASQ Manager.
+
+	var rcvChan chan tAsqJob
+	var asqJob *tAsqJob
+
+	rcvChan = make(chan tAsqJob)
+	asqJob = new(tAsqJob)
+	asqJob.action = asqJobPing
+	asqJob.returnChannel = rcvChan
+	asqManagerChan <- *asqJob
+	*asqJob = <-rcvChan
+}
+
+//------------------------------------------------------------------------------
+
+func health_pingAnnounce() {
+
+	// Pings the Announce Manager.
+
+	var pong chan bool
+
+	pong = make(chan bool)
+	announcePingChan <- pong
+	<-pong
+}
+
+//------------------------------------------------------------------------------
+
+func health_pingWebhook() {
+
+	// Pings the Webhook Manager.
+
+	var pong chan bool
+
+	pong = make(chan bool)
+	webhookPingChan <- pong
+	<-pong
+}
+
+//------------------------------------------------------------------------------
+
+func health_pingSearch() {
+
+	// Pings the Search Manager.
+
+	var rcvChan chan tSearchJob
+	var searchJob *tSearchJob
+
+	rcvChan = make(chan tSearchJob)
+	searchJob = new(tSearchJob)
+	searchJob.action = searchJobPing
+	searchJob.returnChannel = rcvChan
+	searchManagerChan <- *searchJob
+	*searchJob = <-rcvChan
+}
+
+//------------------------------------------------------------------------------
+
+func health_pingStat() {
+
+	// Pings the Statistics Manager.
+
+	var rcvChan chan tStatJob
+	var statJob *tStatJob
+
+	rcvChan = make(chan tStatJob)
+	statJob = new(tStatJob)
+	statJob.ping = true
+	statJob.returnChannel = rcvChan
+	statManagerChan <- *statJob
+	*statJob = <-rcvChan
+}
+
+//------------------------------------------------------------------------------
+
+func page_debug(w http.ResponseWriter, req *http.Request) {
+
+	// Pages for Debugging, for Administrators.
+
+	var ok bool
+	var uid uint64
+	var state tDebugState
+
+	ok, uid = token_check(req)
+	if !ok {
+		ok, uid, _, _ = user_check(w, req)
+	}
+	if !ok {
+		reply_code(w, req, code_NotLoggedIn)
+		return
+	}
+	reply_remember(w, "", uid)
+	if !admin_is(uid) {
+		reply_code(w, req, code_forbidden)
+		return
+	}
+
+	switch {
+
+	case req.URL.Path == path_debugState:
+		state.V = api_version
+		state.Uptime = time.Now().Unix() - stat_started
+		state.Goroutines = runtime.NumGoroutine()
+		state.ActiveClients = activeList_count(log_rid(req))
+		state.Asqs = asq_count(log_rid(req))
+		state.Users = user_count()
+		state.Ring.Size = chat_recordsMaxLast + 1
+		state.Ring.First = chat_recordFirstNum
+		state.Ring.Last = chat_recordLastNum
+		state.Ring.FirstTs = chat_recordFirstTimestamp
+		state.Ring.LastTs = chat_recordLastTimestamp
+		state.Ring.Wrapped = !firstCircle
+		state.Ring.Wraps = atomic.LoadUint64(&chat_ringWraps)
+		w.Header().Set("Cache-Control", "no-store")
+		api_writeJSON(w, http.StatusOK, &state)
+
+	case health_pprof && strings.HasPrefix(req.URL.Path, path_debugPprof):
+		// Profiles take Seconds, they must not hold the Jobs Manager
+		if !reply_after(w, health_pprofHandler(req.URL.Path)) {
+			health_pprofHandler(req.URL.Path).ServeHTTP(w, req)
+		}
+
+	default:
+		reply_code(w, req, code_noSuchPath)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func health_pprofHandler(path string) (h http.Handler) {
+
+	// Handler of the Profile's Page.
+
+	switch strings.TrimPrefix(path, path_debugPprof) {
+	case "cmdline":
+		return http.HandlerFunc(pprof.Cmdline)
+	case "profile":
+		return http.HandlerFunc(pprof.Profile)
+	case "symbol":
+		return http.HandlerFunc(pprof.Symbol)
+	case "trace":
+		return http.HandlerFunc(pprof.Trace)
+	default:
+		// Index and named Profiles: heap, goroutine, ...
+		return http.HandlerFunc(pprof.Index)
+	}
+}
+
+//------------------------------------------------------------------------------
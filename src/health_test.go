@@ -0,0 +1,133 @@
+// health_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func TestReadyz(t *testing.T) {
+
+	// The Server is ready when each Manager answers; a silent Manager is
+	// named. A Manager which has not answered the last Ping is silent.
+
+	var c *tTestClient
+	var status, i int
+	var reply string
+
+	harness_quiet(t)
+	c = harness_client(t, false)
+
+	status, reply = c.get(path_readyz)
+	if (status != http.StatusOK) || (reply != "ok\n") {
+		t.Fatalf("readyz: %d %q", status, reply)
+	}
+
+	for i = 0; i < len(health_managers); i++ {
+		if health_managers[i].name == "searchManager" {
+			break
+		}
+	}
+	atomic.StoreUint32(&health_pending[i], 1)
+	status, reply = c.get(path_readyz)
+	atomic.StoreUint32(&health_pending[i], 0)
+	if (status != http.StatusServiceUnavailable) || (reply != "not ready: searchManager\n") {
+		t.Errorf("readyz with a silent manager: %d %q", status, reply)
+	}
+
+	atomic.StoreUint32(&health_initDone, 0)
+	status, reply = c.get(path_readyz)
+	atomic.StoreUint32(&health_initDone, 1)
+	if (status != http.StatusServiceUnavailable) || (reply != "not ready: initialization\n") {
+		t.Errorf("readyz before initialization: %d %q", status, reply)
+	}
+
+	status, reply = c.get(path_readyz)
+	if (status != http.StatusOK) || (reply != "ok\n") {
+		t.Errorf("readyz after all: %d %q", status, reply)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestDebug(t *testing.T) {
+
+	// Only Administrators see the State and the Profiles, and Profiles only
+	// with the "-pprof" Flag.
+
+	var tests = []struct {
+		name   string
+		path   string
+		admin  bool
+		pprof  bool
+		status int
+		want   string
+	}{
+		{"state", path_debugState, true, false, http.StatusOK, `"ring":{"size":65536,`},
+		{"state of a stranger", path_debugState, false, false, http.StatusForbidden, `"error":`},
+		{"pprof off", path_debugPprof, true, false, http.StatusNotFound, `"error":`},
+		{"pprof", path_debugPprof, true, true, http.StatusOK, "goroutine"},
+		{"named profile", path_debugPprof + "heap?debug=1", true, true, http.StatusOK, "heap profile"},
+		{"pprof of a stranger", path_debugPprof, false, true, http.StatusForbidden, `"error":`},
+		{"unknown page", "/debug/vars", true, true, http.StatusNotFound, `"error":`},
+	}
+
+	var admin, stranger, anonymous *tTestClient
+	var c *tTestClient
+	var uid, other uint64
+	var state tDebugState
+	var status, i int
+	var reply string
+
+	harness_quiet(t)
+	admin = harness_client(t, true)
+	uid = admin.register("Yusuf", "yusuf-pwd")
+	admin.login(uid, "yusuf-pwd")
+	defer admin.get(path_logout)
+	stranger = harness_client(t, true)
+	other = stranger.register("Yara", "yara-pwd")
+	stranger.login(other, "yara-pwd")
+	defer stranger.get(path_logout)
+	anonymous = harness_client(t, true)
+
+	harness_admin(t, uid)
+	t.Cleanup(func() {
+		health_pprof = false
+	})
+
+	for i = 0; i < len(tests); i++ {
+		c = stranger
+		if tests[i].admin {
+			c = admin
+		}
+		health_pprof = tests[i].pprof
+		status, reply = c.get(tests[i].path)
+		if (status != tests[i].status) || !strings.Contains(reply, tests[i].want) {
+			t.Errorf("%s: %d %.200q, want %d %q", tests[i].name, status, reply, tests[i].status, tests[i].want)
+		}
+	}
+
+	// JSON of the State
+	status, reply = admin.get(path_debugState)
+	if (status != http.StatusOK) || (json.Unmarshal([]byte(reply), &state) != nil) {
+		t.Fatalf("state: %d %s", status, reply)
+	}
+	if (state.V != api_version) || (state.ActiveClients < 2) || (state.Users < 2) || (state.Goroutines == 0) ||
+		(state.Ring.Last != chat_recordLastNum) {
+		t.Errorf("state is %+v", state)
+	}
+
+	// Not logged in
+	status, _ = anonymous.get(path_debugState)
+	if status != http.StatusUnauthorized {
+		t.Errorf("state without log-in: status %d", status)
+	}
+}
+
+//------------------------------------------------------------------------------
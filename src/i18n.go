@@ -0,0 +1,410 @@
+// i18n.go
+
+package main
+
+import (
+	"bufio"
+	"embed"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	Languages of Pages and Messages.
+
+	Each Language has a Message Catalog, "<lang>.txt", with one Text per
+	Line:
+
+		# Comment
+		login_failed = Logging failed.
+
+	English ("en.txt") is the Catalog of the Program: each Key is there.
+	Texts which are missing in another Catalog are taken from the Catalog
+	of the Default Language ("-lang"), else from the English one. Keys
+	which the English Catalog does not know are reported and ignored.
+
+	Catalogs are embedded into the Program. Files of the Directory of
+	"-lang-dir" are read after them: they change Texts of the embedded
+	Catalogs or add new Languages.
+
+	A Language is chosen for each Request: the one of the "lang" Cookie,
+	which Users set on the Index and Chat Pages, else the best one of the
+	"Accept-Language" Header, else the Default one. "pt-BR" is served by
+	"pt.txt" when there is no "pt-br.txt".
+
+	Texts with a Link have it in Brackets: "Click [here] to proceed.".
+	"{delay}" is the Delay of Redirects.
+
+*/
+
+//------------------------------------------------------------------------------
+
+// Language which a User can choose
+type tLangOption struct {
+	Code string
+	Name string
+}
+
+//------------------------------------------------------------------------------
+
+const i18n_base = "en"           // Language of the Program, its Catalog has each Key
+const i18n_cookie = "lang"       // Cookie with the Language chosen by a User
+const i18n_keyName = "lang_name" // Key of the Language's Name, in the Language
+const i18n_ext = ".txt"          // Extension of Catalog Files
+const i18n_embeddedDir = "lang"  // Directory of the embedded Catalogs
+
+//------------------------------------------------------------------------------
+
+// Default Catalogs
+//
+//go:embed lang/*.txt
+var i18n_embedded embed.FS
+
+// Parameters
+var i18n_default string = i18n_base // Language for Users who ask for none of the known ones
+var i18n_dir string                 // Directory of customized Catalogs
+
+// Catalogs: Language -> Key -> Text
+var i18n_catalogs map[string]map[string]string
+
+// Internal Parameters
+var i18n_options []tLangOption // Languages for the Choice of Users, by Code
+var i18n_delay *strings.Replacer
+
+//------------------------------------------------------------------------------
+
+func i18n_init() (ok bool) {
+
+	// Reads the Catalogs and fills their Gaps.
+
+	var names []string
+	var lang string
+	var i int
+	var err error
+
+	i18n_catalogs = make(map[string]map[string]string)
+	i18n_delay = strings.NewReplacer("{delay}", redirectDelay_str)
+
+	// Embedded Catalogs, then the customized ones
+	names, err = fs.Glob(i18n_embedded, i18n_embeddedDir+"/*"+i18n_ext)
+	if err != nil {
+		log_error("", "Error listing catalogs", "err", err) //
+		return false
+	}
+	for i = 0; i < len(names); i++ {
+		ok = i18n_readFile(names[i], tpl_embeddedPrefix+names[i], i18n_embedded.Open)
+		if !ok {
+			return false
+		}
+	}
+
+	if len(i18n_dir) > 0 {
+		names, err = filepath.Glob(filepath.Join(i18n_dir, "*"+i18n_ext))
+		if err != nil {
+			log_error("", "Error listing catalogs", "dir", i18n_dir, "err", err) //
+			return false
+		}
+		for i = 0; i < len(names); i++ {
+			ok = i18n_readFile(names[i], names[i], i18n_openFile)
+			if !ok {
+				return false
+			}
+		}
+	}
+
+	if i18n_catalogs[i18n_default] == nil {
+		log_error("", "Unknown language", "lang", i18n_default) //
+		return false
+	}
+
+	// The Default Language is filled first, the others take its Texts
+	i18n_fill(i18n_default, i18n_base)
+	i18n_options = nil
+	for lang = range i18n_catalogs {
+		if lang != i18n_default {
+			i18n_fill(lang, i18n_default)
+		}
+		i18n_options = append(i18n_options, tLangOption{lang, i18n_catalogs[lang][i18n_keyName]})
+	}
+	sort.Slice(i18n_options, func(a, b int) bool {
+		return i18n_options[a].Code < i18n_options[b].Code
+	})
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func i18n_openFile(fileName string) (file fs.File, err error) {
+
+	// Opens a Catalog on the Disk.
+
+	return os.Open(fileName)
+}
+
+//------------------------------------------------------------------------------
+
+func i18n_readFile(fileName, source string, open func(string) (fs.File, error)) (ok bool) {
+
+	// Reads a Catalog File into the Catalog of its Language.
+
+	var file fs.File
+	var lang string
+	var catalog map[string]string
+	var err error
+
+	lang = strings.ToLower(strings.TrimSuffix(path.Base(filepath.ToSlash(fileName)), i18n_ext))
+
+	file, err = open(fileName)
+	if err != nil {
+		log_error("", "Error opening file", "file", source, "err", err) //
+		return false
+	}
+	defer file.Close()
+
+	catalog = i18n_catalogs[lang]
+	if catalog == nil {
+		catalog = make(map[string]string)
+		i18n_catalogs[lang] = catalog
+	}
+
+	ok = i18n_parse(file, source, catalog)
+	if ok {
+		log_info("", "Catalog is read", "file", source, "lang", lang) //
+	}
+
+	return ok
+}
+
+//------------------------------------------------------------------------------
+
+func i18n_parse(reader io.Reader, source string, catalog map[string]string) (ok bool) {
+
+	// Reads the "key = Text" Lines of a Catalog.
+
+	var scanner *bufio.Scanner
+	var line, key, text string
+	var n int // Number of the Line
+	var found bool
+
+	scanner = bufio.NewScanner(reader)
+	for scanner.Scan() {
+
+		n++
+		line = strings.TrimSpace(scanner.Text())
+		if (len(line) == 0) || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, text, found = strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found || (len(key) == 0) || strings.ContainsAny(key, " \t") {
+			log_error("", "Bad line in catalog", "file", source, "line", n) //
+			return false
+		}
+		catalog[key] = strings.TrimSpace(text)
+	}
+	if scanner.Err() != nil {
+		log_error("", "Error reading file", "file", source, "err", scanner.Err()) //
+		return false
+	}
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func i18n_fill(lang, fallback string) {
+
+	// Fills the Gaps of a Catalog from the Fallback Language, else from the
+	// Language of the Program.
+
+	var catalog map[string]string
+	var key string
+	var missing int
+	var found bool
+
+	catalog = i18n_catalogs[lang]
+
+	for key = range catalog {
+		_, found = i18n_catalogs[i18n_base][key]
+		if !found {
+			log_warn("", "Unknown key in catalog", "lang", lang, "key", key) //
+			delete(catalog, key)
+		}
+	}
+
+	_, found = catalog[i18n_keyName]
+	if !found {
+		catalog[i18n_keyName] = lang
+	}
+
+	for key = range i18n_catalogs[i18n_base] {
+		_, found = catalog[key]
+		if found {
+			continue
+		}
+		missing++
+		catalog[key], found = i18n_catalogs[fallback][key]
+		if !found {
+			catalog[key] = i18n_catalogs[i18n_base][key]
+		}
+	}
+	if missing > 0 {
+		log_warn("", "Catalog is incomplete", "lang", lang, "missing", missing, "fallback", fallback) //
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func i18n_lang(w http.ResponseWriter, req *http.Request) (lang string) {
+
+	// Chooses the Language of the Reply and tells it in the Headers.
+
+	var cookie *http.Cookie
+	var err error
+
+	cookie, err = req.Cookie(i18n_cookie)
+	if err == nil {
+		lang = i18n_match(cookie.Value)
+	}
+	if len(lang) == 0 {
+		lang = i18n_accept(req.Header.Get("Accept-Language"))
+	}
+	if len(lang) == 0 {
+		lang = i18n_default
+	}
+
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language, Cookie")
+
+	return lang
+}
+
+//------------------------------------------------------------------------------
+
+func i18n_match(tag string) (lang string) {
+
+	// Gives the known Language of a Tag: "ru-RU" is "ru", if there is no
+	// "ru-ru".
+
+	var found bool
+
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if len(tag) == 0 {
+		return ""
+	}
+	_, found = i18n_catalogs[tag]
+	if found {
+		return tag
+	}
+	tag, _, _ = strings.Cut(tag, "-")
+	_, found = i18n_catalogs[tag]
+	if found {
+		return tag
+	}
+
+	return ""
+}
+
+//------------------------------------------------------------------------------
+
+func i18n_accept(header string) (lang string) {
+
+	// Gives the known Language with the highest Weight in an
+	// "Accept-Language" Header, e.g. "ru-RU,ru;q=0.9,en;q=0.8".
+	// Of equal Weights the first one wins.
+
+	var ranges []string
+	var tag, params, match string
+	var q, best float64
+	var i int
+	var err error
+
+	ranges = strings.Split(header, ",")
+	for i = 0; i < len(ranges); i++ {
+
+		tag, params, _ = strings.Cut(ranges[i], ";")
+		q = 1
+		params = strings.TrimSpace(params)
+		if strings.HasPrefix(params, "q=") {
+			q, err = strconv.ParseFloat(params[2:], 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q <= best {
+			continue
+		}
+
+		match = i18n_match(tag)
+		if (len(match) == 0) && (strings.TrimSpace(tag) == "*") {
+			match = i18n_default
+		}
+		if len(match) > 0 {
+			lang, best = match, q
+		}
+	}
+
+	return lang
+}
+
+//------------------------------------------------------------------------------
+
+func i18n_text(lang, key string) (text string) {
+
+	// Gives the Text of a Key in the Language. The Key itself is given for
+	// an unknown Key, so the Mistake is seen on the Page.
+
+	var found bool
+
+	text, found = i18n_catalogs[lang][key]
+	if !found {
+		text, found = i18n_catalogs[i18n_base][key]
+	}
+	if !found {
+		log_warn("", "Unknown key of text", "lang", lang, "key", key) //
+		return key
+	}
+
+	return text
+}
+
+//------------------------------------------------------------------------------
+
+func i18n_reply(w http.ResponseWriter, lang, head, link string, keys ...string) {
+
+	// Writes a "small" Page (a Redirector or an Error) with a Line for each
+	// Key. "[...]" in a Line is a Link to the Path.
+
+	var lines []string
+	var text string
+	var start, end int
+	var i int
+
+	lines = make([]string, len(keys))
+	for i = 0; i < len(keys); i++ {
+		text = html.EscapeString(i18n_delay.Replace(i18n_text(lang, keys[i])))
+		start = strings.Index(text, "[")
+		end = strings.LastIndex(text, "]")
+		if (start >= 0) && (end > start) {
+			text = text[:start] + "<a href='" + link + "'>" + text[start+1:end] + "</a>" + text[end+1:]
+		}
+		lines[i] = text
+	}
+
+	io.WriteString(w, head+strings.Join(lines, "<br>")+html_2)
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,292 @@
+// i18n_test.go
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func i18n_setDir(t *testing.T, lang string, files map[string]string) {
+
+	// Sets the Default Language and a Directory of Catalogs with the Files.
+	// The Catalogs and Pages are read again when the Test ends.
+
+	var dir, name string
+	var err error
+
+	dir = t.TempDir()
+	for name = range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(files[name]), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	i18n_default, i18n_dir = lang, dir
+	harness_templates(t, func() {
+		i18n_default, i18n_dir = i18n_base, ""
+	})
+}
+
+//------------------------------------------------------------------------------
+
+func i18n_request(t *testing.T, path string, form url.Values, acceptLanguage, cookie string) (resp *http.Response, page string) {
+
+	// Gets a Page, or posts the Form, with the Language Headers of a Browser.
+
+	var req *http.Request
+	var data []byte
+	var err error
+
+	if form == nil {
+		req, err = http.NewRequest(http.MethodGet, harness_server.URL+path, nil)
+	} else {
+		req, err = http.NewRequest(http.MethodPost, harness_server.URL+path, strings.NewReader(form.Encode()))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if len(acceptLanguage) > 0 {
+		req.Header.Set("Accept-Language", acceptLanguage)
+	}
+	if len(cookie) > 0 {
+		req.AddCookie(&http.Cookie{Name: i18n_cookie, Value: cookie})
+	}
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, string(data)
+}
+
+//------------------------------------------------------------------------------
+
+func TestLangAccept(t *testing.T) {
+
+	// The known Language with the highest Weight wins.
+
+	var tests = []struct {
+		header string
+		want   string
+	}{
+		{"ru-RU,ru;q=0.9,en-US;q=0.8,en;q=0.7", "ru"},
+		{"en-GB", "en"},
+		{"RU", "ru"},
+		{"de-DE,de;q=0.9", ""},
+		{"de, en;q=0.5", "en"},
+		{"en;q=0.2, ru;q=0.7", "ru"},
+		{"en, ru", "en"},
+		{"ru;q=0, en;q=0.1", "en"},
+		{"ru;q=zero, en;q=0.1", "en"},
+		{"de, *;q=0.5", i18n_default},
+		{"", ""},
+	}
+
+	var i int
+
+	for i = 0; i < len(tests); i++ {
+		if i18n_accept(tests[i].header) != tests[i].want {
+			t.Errorf("%q gives %q, want %q", tests[i].header, i18n_accept(tests[i].header), tests[i].want)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestLangCatalogs(t *testing.T) {
+
+	// The embedded Catalogs are complete, so no Text is taken from another
+	// Language.
+
+	var names []string
+	var catalogs [2]map[string]string
+	var key string
+	var found bool
+	var i int
+
+	names = []string{"lang/en.txt", "lang/ru.txt"}
+	for i = 0; i < len(names); i++ {
+		catalogs[i] = i18n_testParse(t, names[i])
+	}
+	for key = range catalogs[0] {
+		_, found = catalogs[1][key]
+		if !found {
+			t.Errorf("%s has no %q", names[1], key)
+		}
+	}
+	for key = range catalogs[1] {
+		_, found = catalogs[0][key]
+		if !found {
+			t.Errorf("%s has the unknown key %q", names[1], key)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func i18n_testParse(t *testing.T, name string) (catalog map[string]string) {
+
+	// Reads an embedded Catalog as it is, without the Texts of others.
+
+	var data []byte
+	var err error
+
+	data, err = i18n_embedded.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog = make(map[string]string)
+	if !i18n_parse(strings.NewReader(string(data)), name, catalog) {
+		t.Fatalf("%s is refused", name)
+	}
+
+	return catalog
+}
+
+//------------------------------------------------------------------------------
+
+func TestLangFallback(t *testing.T) {
+
+	// Missing Texts come from the Default Language, unknown Keys are
+	// dropped, Files of the Directory change the embedded Catalogs.
+
+	var found bool
+
+	harness_quiet(t)
+	i18n_setDir(t, "ru", map[string]string{
+		"de.txt": "# German\nlang_name = Deutsch\nindex_welcome = Willkommen!\nno_such_key = x\n",
+		"ru.txt": "index_statistics = Статистика чата\n",
+	})
+	if !templates_init() {
+		t.Fatal("catalogs are refused")
+	}
+
+	if i18n_text("de", "index_welcome") != "Willkommen!" {
+		t.Errorf("de: welcome is %q", i18n_text("de", "index_welcome"))
+	}
+	if i18n_text("de", "login_failed") != i18n_text("ru", "login_failed") {
+		t.Errorf("de: missing text is %q, not the default language's", i18n_text("de", "login_failed"))
+	}
+	_, found = i18n_catalogs["de"]["no_such_key"]
+	if found {
+		t.Error("de: unknown key is kept")
+	}
+	if i18n_text("ru", "index_statistics") != "Статистика чата" {
+		t.Errorf("ru: text of the directory is not used: %q", i18n_text("ru", "index_statistics"))
+	}
+	if !strings.Contains(tpl_index["de"], "Willkommen!") || !strings.Contains(tpl_index["de"], "<option value='de' selected>Deutsch</option>") {
+		t.Error("de: index page is not in German")
+	}
+	if i18n_accept("de-AT") != "de" {
+		t.Error("de: language is not chosen")
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestLangBadCatalog(t *testing.T) {
+
+	// A broken Catalog or an unknown Default Language stops the Start-Up.
+
+	harness_quiet(t)
+
+	i18n_setDir(t, i18n_base, map[string]string{"de.txt": "lang_name = Deutsch\nWillkommen!\n"})
+	if templates_init() {
+		t.Error("line without a key is accepted")
+	}
+
+	i18n_setDir(t, "xx", nil)
+	if templates_init() {
+		t.Error("unknown default language is accepted")
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestLangPages(t *testing.T) {
+
+	// Pages are served in the Language of the Cookie, else of the Browser.
+
+	var tests = []struct {
+		name   string
+		accept string
+		cookie string
+		want   string
+		text   string
+	}{
+		{"no preference", "", "", i18n_default, i18n_text(i18n_default, "index_welcome")},
+		{"browser", "ru-RU,ru;q=0.9,en;q=0.8", "", "ru", "Добро пожаловать в чат!"},
+		{"unknown browser language", "de-DE", "", i18n_default, i18n_text(i18n_default, "index_welcome")},
+		{"cookie", "ru-RU", "en", "en", "Welcome to the Chat!"},
+		{"bad cookie", "ru-RU", "xx", "ru", "Добро пожаловать в чат!"},
+	}
+
+	var resp *http.Response
+	var page string
+	var i int
+
+	for i = 0; i < len(tests); i++ {
+		t.Run(tests[i].name, func(t *testing.T) {
+			resp, page = i18n_request(t, path_index, nil, tests[i].accept, tests[i].cookie)
+			if resp.Header.Get("Content-Language") != tests[i].want {
+				t.Errorf("Content-Language is %q, want %q", resp.Header.Get("Content-Language"), tests[i].want)
+			}
+			if !strings.Contains(page, "<html lang='"+tests[i].want+"'>") || !strings.Contains(page, tests[i].text) {
+				t.Errorf("page is not in %q", tests[i].want)
+			}
+			if !strings.Contains(resp.Header.Get("Vary"), "Accept-Language") {
+				t.Error("no Vary header")
+			}
+		})
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestLangReply(t *testing.T) {
+
+	// Small Pages are translated, with their Links.
+
+	var c *tTestClient
+	var uid uint64
+	var form url.Values
+	var reply string
+
+	c = harness_client(t, false)
+	uid = c.register("Grace", "grace-pwd")
+
+	form = url.Values{}
+	form.Set(param_login_userID, strconv.FormatUint(uid, 10))
+	form.Set(param_login_password, "wrong")
+	form.Set(param_qid, c.asq())
+	form.Set(param_qAnswer, strconv.Itoa(int(harness_asqAnswer)))
+
+	_, reply = i18n_request(t, path_login, form, "ru", "")
+
+	if !strings.Contains(reply, "Вход не выполнен.<br>Неверный UID или пароль.<br>") {
+		t.Errorf("reply is not in Russian: %s", reply)
+	}
+	if !strings.Contains(reply, "Нажмите <a href='"+path_index+"'>здесь</a>, чтобы вернуться") {
+		t.Errorf("reply has no link: %s", reply)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,312 @@
+// inhook.go
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	Incoming Webhooks.
+
+	Scripts post Messages into the Chat by a secret URL, without Log-In:
+
+		curl -d 'Build #42 has passed.' http://<host>/h/<Secret>
+		curl -H 'Content-Type: application/json' -d '{"text":"..."}' http://<host>/h/<Secret>
+
+	The Configuration File has one Hook per Line, empty Lines and Lines
+	starting with '#' are ignored:
+
+		<Secret> <Name of the Bot> [rate=<Messages per Minute>]
+
+	Each Hook posts as its own Bot User. Bot Users live only in Memory, they
+	are not written into the User-Data File and they can not log in. Their
+	UIDs are made from the Secrets, so they stay the same after a Restart.
+
+*/
+
+//------------------------------------------------------------------------------
+
+type tInHook struct {
+	uid    uint64  // UID of the Bot User
+	rate   float64 // Messages per Minute
+	tokens float64 // Messages which may be posted now
+	last   int64   // Time of the last Refill of "tokens", Unix Nano
+}
+
+//------------------------------------------------------------------------------
+
+const path_hook = "/h/"        // Prefix of incoming Webhooks' URLs
+const inhook_rate_default = 10 // Messages per Minute, if not configured
+const inhook_minSecretLen = 16 // Secrets must be long enough not to be guessed
+
+const inhook_ratePrefix = "rate=" // Marks the Rate in the Configuration
+
+//------------------------------------------------------------------------------
+
+// Lists
+var inHookList map[string]*tInHook // Key = Hash of the Secret
+
+// File
+var file_inHooks string
+
+//------------------------------------------------------------------------------
+
+func inhook_init() (ok bool) {
+
+	// Reads the Configuration and creates the Bot Users.
+	// Incoming Webhooks are off when no Configuration File is set.
+
+	var file *os.File
+	var scanner *bufio.Scanner
+	var line, name, hash string
+	var fields []string
+	var hook *tInHook
+	var ud *tUserData
+	var sum [sha256.Size]byte
+	var exists bool
+	var n int // Number of the Line, the Line itself has the Secret
+	var err error
+
+	inHookList = make(map[string]*tInHook)
+
+	if len(file_inHooks) == 0 {
+		return true
+	}
+
+	file, err = os.Open(file_inHooks)
+	if err != nil {
+		log_error("", "Error opening incoming webhook config", "file", file_inHooks, "err", err) //
+		return false
+	}
+	defer file.Close()
+
+	scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+
+		n++
+		line = strings.TrimSpace(scanner.Text())
+		if (len(line) == 0) || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// The Name may have Spaces, the Rate is the last Field if it is marked
+		fields = strings.Fields(line)
+		hook = new(tInHook)
+		hook.rate = inhook_rate_default
+		if strings.HasPrefix(fields[len(fields)-1], inhook_ratePrefix) {
+			hook.rate, err = strconv.ParseFloat(strings.TrimPrefix(fields[len(fields)-1], inhook_ratePrefix), 64)
+			if (err != nil) || math.IsNaN(hook.rate) || math.IsInf(hook.rate, 0) || (hook.rate <= 0) {
+				log_error("", "Bad rate in incoming webhook config", "file", file_inHooks, "line", n) //
+				return false
+			}
+			fields = fields[:len(fields)-1]
+		}
+		if len(fields) < 2 {
+			log_error("", "Bad line in incoming webhook config", "file", file_inHooks, "line", n) //
+			return false
+		}
+		if len(fields[0]) < inhook_minSecretLen {
+			log_error("", "Too short secret in incoming webhook config", "file", file_inHooks, "line", n) //
+			return false
+		}
+		_, err = strconv.ParseFloat(fields[len(fields)-1], 64)
+		if (err == nil) && (len(fields) > 2) {
+			log_warn("", "Name of the bot ends with a number, a rate is written as rate=N", "file", file_inHooks, "line", n) //
+		}
+		hook.tokens = hook.rate
+		hook.last = time.Now().UnixNano()
+
+		name = strings.Join(fields[1:], " ")
+		if len(name) > userName_maxLen {
+			log_error("", "Too long name in incoming webhook config", "file", file_inHooks, "line", n) //
+			return false
+		}
+
+		sum = sha256.Sum256([]byte(fields[0]))
+		hash = hex.EncodeToString(sum[:])
+		hook.uid = binary.LittleEndian.Uint64(sum[:8])
+
+		_, exists = user_get(hook.uid)
+		if exists || (hook.uid == chat_systemUserUID) {
+			log_error("", "UID of the bot is taken, change the secret", "file", file_inHooks, "line", n, "name", name) //
+			return false
+		}
+
+		// Bot User, in Memory only
+		ud = new(tUserData)
+		ud.name = name
+		ud.pwd = inhook_password()
+		ud.reg_time = time.Now().Unix()
+		userDataLock.Lock()
+		userDataList[hook.uid] = *ud
+		userDataLock.Unlock()
+
+		inHookList[hash] = hook
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		log_error("", "Error reading incoming webhook config", "file", file_inHooks, "err", err) //
+		return false
+	}
+
+	log_info("", "Incoming webhooks configured", "count", len(inHookList)) //
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func inhook_password() (pwd string) {
+
+	// A random Password which is never told to anybody, so that nobody can
+	// log in as a Bot.
+
+	var buf []byte
+	var i int
+
+	buf = make([]byte, userPwd_maxLen)
+	for i = 0; i < len(buf); i++ {
+		buf[i] = generateRandomUint8()
+	}
+
+	return string(buf)
+}
+
+//------------------------------------------------------------------------------
+
+func inhook_allow(hook *tInHook) (ok bool) {
+
+	// Rate Limit: each Hook may post "rate" Messages per Minute, and as many
+	// at once after a Pause.
+
+	var now int64
+
+	now = time.Now().UnixNano()
+	hook.tokens += float64(now-hook.last) / float64(time.Minute) * hook.rate
+	if hook.tokens > hook.rate {
+		hook.tokens = hook.rate
+	}
+	hook.last = now
+
+	if hook.tokens < 1 {
+		return false
+	}
+	hook.tokens--
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func page_hook(w http.ResponseWriter, req *http.Request) {
+
+	// Processes and serves a Message sent to an incoming Webhook.
+
+	// Client sends a POST Request with the Text of the Message, either as
+	// it is, or as JSON: {"text":"..."}.
+
+	// Server replies to client (see reply_code) one of the following:
+	//		1. code_BadPOSTdata ('X')
+	//		2. code_EmptyMessage ('E')
+	//		3. code_msgTooLong ('M')
+	//		4. code_tooManyRequests ('R')
+	//		5. code_messageSent ('O')
+	// Unknown Secrets get "404 Not Found".
+
+	var sum [sha256.Size]byte
+	var hook *tInHook
+	var exists bool
+	var reqBody []byte
+	var text, mediaType string
+	var post tApiPost
+	var err error
+	var chatJob *tChatJob
+	var rcvChan chan tChatJob
+
+	sum = sha256.Sum256([]byte(strings.TrimPrefix(req.URL.Path, path_hook)))
+	hook, exists = inHookList[hex.EncodeToString(sum[:])]
+	if !exists {
+		http.NotFound(w, req)
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		reply_code(w, req, code_BadRequest) // Only POST
+		return
+	}
+
+	// JSON may escape each Symbol with up to 6 Bytes
+	req.Body = http.MaxBytesReader(w, req.Body, msgMaxSize*6+64)
+	reqBody, err = ioutil.ReadAll(req.Body)
+	if err != nil {
+		reply_code(w, req, code_msgTooLong) // Too large or broken
+		return
+	}
+
+	mediaType, _, _ = mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType == api_mimeJSON {
+		err = json.Unmarshal(reqBody, &post)
+		if err != nil {
+			reply_code(w, req, code_BadPOSTdata) // Error in Data
+			return
+		}
+		text = post.Text
+	} else {
+		text = string(reqBody)
+	}
+
+	text = strings.TrimSpace(text)
+	if !utf8.ValidString(text) {
+		reply_code(w, req, code_BadPOSTdata) // Error in Data
+		return
+	}
+	if len(text) == 0 {
+		reply_code(w, req, code_EmptyMessage) // Empty Message
+		return
+	}
+	if len(text) > msgMaxSize {
+		reply_code(w, req, code_msgTooLong) // Too long Message
+		return
+	}
+
+	if !inhook_allow(hook) {
+		reply_code(w, req, code_tooManyRequests) // Rate Limit
+		return
+	}
+
+	// Create Job for ChatManager
+	rcvChan = make(chan tChatJob)
+	chatJob = new(tChatJob)
+	chatJob.rid = log_rid(req)
+	chatJob.chatRecord.author = hook.uid
+	chatJob.chatRecord.message = markup_render(text)
+	chatJob.chatRecord.text = text
+	chatJob.returnChannel = rcvChan
+
+	// Send Job
+	chatManagerChan <- *chatJob
+
+	// Wait for Manager
+	*chatJob = <-rcvChan
+
+	reply_code(w, req, code_messageSent) // OK, Message is Sent
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,172 @@
+// inhook_test.go
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+const inhook_testSecret = "0123456789abcdef-test" // Secret of the Hook of the Tests; Bots stay, so each Test has its own
+
+//------------------------------------------------------------------------------
+
+func inhook_testConfig(t *testing.T, config string) (ok bool) {
+
+	// Reads the Configuration from a temporary File. The Hooks are removed
+	// when the Test ends.
+
+	var err error
+
+	file_inHooks = filepath.Join(t.TempDir(), "inhook.cfg")
+	err = ioutil.WriteFile(file_inHooks, []byte(config), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		file_inHooks = ""
+		inhook_init()
+	})
+
+	return inhook_init()
+}
+
+//------------------------------------------------------------------------------
+
+func inhook_testPost(t *testing.T, secret, contentType, body string) (status int, reply string) {
+
+	// Posts to the Hook as a Script does.
+
+	var resp *http.Response
+	var data []byte
+	var err error
+
+	resp, err = http.Post(harness_server.URL+path_hook+secret, contentType, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ = ioutil.ReadAll(resp.Body)
+
+	return resp.StatusCode, string(data)
+}
+
+//------------------------------------------------------------------------------
+
+func TestInhookConfig(t *testing.T) {
+
+	// The Rate is marked, so a Name may end with a Number. Rates which are
+	// not positive Numbers are refused.
+
+	var tests = []struct {
+		line string
+		ok   bool
+		name string
+		rate float64
+	}{
+		{"Deploy Bot 2", true, "Deploy Bot 2", inhook_rate_default},
+		{"Deploy Bot rate=20", true, "Deploy Bot", 20},
+		{"Bot rate=0.5", true, "Bot", 0.5},
+		{"Bot rate=NaN", false, "", 0},
+		{"Bot rate=Inf", false, "", 0},
+		{"Bot rate=+Inf", false, "", 0},
+		{"Bot rate=0", false, "", 0},
+		{"Bot rate=-1", false, "", 0},
+		{"Bot rate=fast", false, "", 0},
+		{"Bot rate=", false, "", 0},
+		{"rate=20", false, "", 0},
+		{"", false, "", 0},
+	}
+
+	var hook *tInHook
+	var i int
+
+	harness_quiet(t)
+
+	for i = 0; i < len(tests); i++ {
+		t.Run(tests[i].line, func(t *testing.T) {
+			if inhook_testConfig(t, "# Hooks\n"+inhook_testSecret+strconv.Itoa(i)+" "+tests[i].line+"\n") != tests[i].ok {
+				t.Fatalf("config is accepted: %v", !tests[i].ok)
+			}
+			if !tests[i].ok {
+				return
+			}
+			for _, hook = range inHookList {
+				if (user_name(hook.uid) != tests[i].name) || (hook.rate != tests[i].rate) {
+					t.Errorf("hook is %q at %v", user_name(hook.uid), hook.rate)
+				}
+			}
+		})
+	}
+
+	if inhook_testConfig(t, "short Bot\n") {
+		t.Error("short secret is accepted")
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestInhookPost(t *testing.T) {
+
+	// Scripts post Text or JSON of the Chat's Size, not faster than the Rate.
+	// Codes are in the legacy Format, as Scripts do not ask for JSON.
+
+	var tests = []struct {
+		name        string
+		secret      string
+		contentType string
+		body        string
+		status      int
+		reply       string
+		text        string // Text of the posted Message
+	}{
+		{"text", inhook_testSecret, "text/plain", " Build #42 has passed. ", http.StatusOK, code_messageSent, "Build #42 has passed."},
+		{"json", inhook_testSecret, api_mimeJSON, `{"text":"Deploy **done**"}`, http.StatusOK, code_messageSent, "Deploy **done**"},
+		{"longest", inhook_testSecret, "text/plain", strings.Repeat("a", msgMaxSize), http.StatusOK, code_messageSent, strings.Repeat("a", msgMaxSize)},
+		{"too long", inhook_testSecret, "text/plain", strings.Repeat("a", msgMaxSize+1), http.StatusOK, code_msgTooLong, ""},
+		{"too long json", inhook_testSecret, api_mimeJSON, `{"text":"` + strings.Repeat(`A`, msgMaxSize+1) + `"}`, http.StatusOK, code_msgTooLong, ""},
+		{"empty", inhook_testSecret, "text/plain", "  \n", http.StatusOK, code_EmptyMessage, ""},
+		{"broken json", inhook_testSecret, api_mimeJSON, `{"text":`, http.StatusOK, code_BadPOSTdata, ""},
+		{"unknown secret", inhook_testSecret + "x", "text/plain", "Hello", http.StatusNotFound, "404 page not found\n", ""},
+		{"rate", inhook_testSecret, "text/plain", "Hello", http.StatusOK, code_tooManyRequests, ""},
+	}
+
+	var status int
+	var reply string
+	var last uint16
+	var i int
+
+	harness_quiet(t)
+	if !inhook_testConfig(t, inhook_testSecret+" CI Bot rate=3\n") {
+		t.Fatal("config is refused")
+	}
+
+	for i = 0; i < len(tests); i++ {
+
+		last = chat_recordLastNum
+		status, reply = inhook_testPost(t, tests[i].secret, tests[i].contentType, tests[i].body)
+		if (status != tests[i].status) || (reply != tests[i].reply) {
+			t.Errorf("%s: reply %d %q, want %d %q", tests[i].name, status, reply, tests[i].status, tests[i].reply)
+			continue
+		}
+
+		if len(tests[i].text) == 0 {
+			if chat_recordLastNum != last {
+				t.Errorf("%s: refused message is posted", tests[i].name)
+			}
+			continue
+		}
+		if (chatRecordsList[chat_recordLastNum].text != tests[i].text) ||
+			(user_name(chatRecordsList[chat_recordLastNum].author) != "CI Bot") {
+			t.Errorf("%s: record is %+v", tests[i].name, chatRecordsList[chat_recordLastNum])
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
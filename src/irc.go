@@ -0,0 +1,971 @@
+// irc.go
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	IRC Gateway.
+
+	An optional TCP Listener which speaks enough of the IRC Protocol for
+	terminal IRC Clients. The Chat Room is one Channel. The Gateway knows
+	these Commands:
+
+		PASS, NICK, USER, JOIN, PART, PRIVMSG, NAMES, PING, PONG, QUIT.
+
+	A User logs in with his UID and Password, as on the Index Page. The UID
+	and the Password are given in the Server Password:
+
+		PASS <UID>:<Password>
+
+	Clients which can not do it may set the Password alone with PASS and the
+	UID as the Nick. After Log-In the Gateway changes the Nick to the User's
+	Name. The User becomes an active Client of the Chat, as if he had logged
+	in with a Browser, and stays active while the Connection is open.
+
+	Messages of the Chat are relayed as PRIVMSG, Announcements of the System
+	User as NOTICE. The List of active Users is the Channel's NAMES.
+
+	There are no Anti-Spam Questions in IRC, so a wrong Password closes the
+	Connection after a Delay. After several wrong Passwords from one Address
+	or for one UID, Log-Ins of the Address or the UID are refused for a
+	while without checking the Password. Log-Ins in a Browser are not
+	affected.
+
+*/
+
+//------------------------------------------------------------------------------
+
+// Connection of an IRC Client
+type tIrcSession struct {
+	conn     net.Conn
+	writer   *bufio.Writer
+	nick     string
+	pass     string
+	user     string
+	uid      uint64
+	sid      string
+	rid      string          // ID for the Log, like the ID of a Request
+	logged   bool            // Log-In is done
+	joined   bool            // Client is in the Channel
+	added    bool            // Session has added the User to the active Clients
+	mid      uint16          // Cursor of relayed Messages
+	ts       int64           // ~
+	posted   map[uint16]bool // Messages posted by this Session are not echoed
+	lastRead int64           // Time of the last Line from the Client
+	quit     bool            // Session must be closed
+}
+
+// Wrong Passwords of an Address or for a UID
+type tIrcFailure struct {
+	count int   // Wrong Passwords and Log-Ins in Progress
+	since int64 // Time of the first one
+}
+
+//------------------------------------------------------------------------------
+
+const irc_serverName = "saga-mikron" // Name of the Server in IRC Messages
+const irc_channel_default = "#chat"  // Name of the Channel
+const irc_lineMax = 8192             // Maximum Length of a received Line, in Bytes
+const irc_sendMax = 400              // Maximum Length of a sent Text, in Bytes
+const irc_pollInterval = 1           // Interval of Checks for new Messages, in Seconds
+const irc_pingInterval = 60          // Interval of PINGs to the Client, in Seconds
+const irc_timeout = 180              // Connection without any Line is closed after this Time, in Seconds
+const irc_keepAliveInterval = 30     // Interval of active Client Updates, in Seconds
+const irc_badPasswordDelay = 3       // Delay before a wrong Password is answered, in Seconds
+const irc_maxSessions = 64           // Maximum Number of Connections
+const irc_failureLimit = 5           // Wrong Passwords from one Address or for one UID, before Log-Ins are refused
+const irc_failureWindow = 600        // Time in which wrong Passwords are counted, in Seconds
+
+// Numeric Replies
+const irc_rplWelcome = "001"
+const irc_rplYourHost = "002"
+const irc_rplCreated = "003"
+const irc_rplMyInfo = "004"
+const irc_rplTopic = "332"
+const irc_rplNamReply = "353"
+const irc_rplEndOfNames = "366"
+const irc_errNoSuchChannel = "403"
+const irc_errNoTextToSend = "412"
+const irc_errUnknownCommand = "421"
+const irc_errNoMotd = "422"
+const irc_errNotRegistered = "451"
+const irc_errNeedMoreParams = "461"
+const irc_errPasswdMismatch = "464"
+
+//------------------------------------------------------------------------------
+
+// Internal Parameters
+var irc_address string
+var irc_channel string
+var irc_started int64
+
+// Listener & Channels
+var ircListener net.Listener
+var ircSlots chan int
+
+// Wrong Passwords, Key = "ip:<Address>" or "uid:<UID>"
+var ircFailures = make(map[string]tIrcFailure)
+var ircFailuresLock sync.Mutex
+
+//------------------------------------------------------------------------------
+
+func irc_start() {
+
+	// Starts the Listener, if the Gateway is enabled.
+
+	var err error
+
+	if len(irc_address) == 0 {
+		return
+	}
+
+	ircListener, err = net.Listen("tcp", irc_address)
+	if err != nil {
+		log_error("", "IRC gateway error", "err", err) //
+		return
+	}
+
+	irc_started = time.Now().Unix()
+	ircSlots = make(chan int, irc_maxSessions)
+	log_info("", "IRC gateway started", "addr", ircListener.Addr()) //
+
+	go irc_listen()
+}
+
+//------------------------------------------------------------------------------
+
+func irc_stop() {
+
+	// Closes the Listener. Open Connections end with the Server.
+
+	if ircListener != nil {
+		ircListener.Close()
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func irc_listen() {
+
+	// Accepts IRC Connections.
+
+	var conn net.Conn
+	var err error
+
+	for {
+		conn, err = ircListener.Accept()
+		if err != nil {
+			log_info("", "Closing IRC Gateway...") //
+			return
+		}
+
+		select {
+		case ircSlots <- 1:
+			go irc_serve(conn)
+		default:
+			fmt.Fprint(conn, "ERROR :Too many connections\r\n")
+			conn.Close()
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func irc_serve(conn net.Conn) {
+
+	// Serves one IRC Connection.
+	// Lines are read by a separate Go-Routine, so that new Messages can be
+	// relayed while the Client is silent. Only this Go-Routine writes.
+
+	var s *tIrcSession
+	var lines chan string
+	var line string
+	var more bool
+	var pollTicker, pingTicker, aliveTicker *time.Ticker
+
+	defer func() { <-ircSlots }()
+
+	s = new(tIrcSession)
+	s.conn = conn
+	s.rid = fmt.Sprintf("irc-%016x", generateRandomUint64())
+	s.writer = bufio.NewWriter(conn)
+	s.posted = make(map[uint16]bool)
+	s.lastRead = time.Now().Unix()
+
+	lines = make(chan string)
+	go irc_read(conn, lines)
+
+	pollTicker = time.NewTicker(irc_pollInterval * time.Second)
+	pingTicker = time.NewTicker(irc_pingInterval * time.Second)
+	aliveTicker = time.NewTicker(irc_keepAliveInterval * time.Second)
+	defer pollTicker.Stop()
+	defer pingTicker.Stop()
+	defer aliveTicker.Stop()
+
+	for !s.quit {
+
+		select {
+
+		case line, more = <-lines:
+			if !more {
+				s.quit = true
+				break
+			}
+			s.lastRead = time.Now().Unix()
+			irc_handle(s, line)
+
+		case <-pollTicker.C:
+			if s.joined {
+				irc_poll(s)
+			}
+
+		case <-pingTicker.C:
+			if time.Now().Unix()-s.lastRead > irc_timeout {
+				irc_send(s, "ERROR :Ping timeout")
+				s.quit = true
+				break
+			}
+			irc_send(s, "PING :"+irc_serverName)
+
+		case <-aliveTicker.C:
+			if s.logged {
+				irc_keepAlive(s)
+			}
+		}
+
+		s.conn.SetWriteDeadline(time.Now().Add(irc_timeout * time.Second))
+		s.writer.Flush()
+	}
+
+	irc_leave(s)
+	conn.Close()
+
+	// Let the Reader finish
+	for range lines {
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func irc_read(conn net.Conn, lines chan string) {
+
+	// Reads Lines from the Client until the Connection is closed.
+
+	var scanner *bufio.Scanner
+
+	scanner = bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 512), irc_lineMax)
+	for scanner.Scan() {
+		lines <- strings.TrimRight(scanner.Text(), "\r")
+	}
+	close(lines)
+}
+
+//------------------------------------------------------------------------------
+
+func irc_parse(line string) (cmd string, params []string) {
+
+	// Splits an IRC Line into the Command and its Parameters.
+	// The Prefix of the Client is ignored.
+
+	var trailing string
+	var hasTrailing bool
+	var i int
+
+	if strings.HasPrefix(line, ":") {
+		i = strings.Index(line, " ")
+		if i < 0 {
+			return "", nil
+		}
+		line = line[i+1:]
+	}
+
+	i = strings.Index(line, " :")
+	if i >= 0 {
+		trailing = line[i+2:]
+		hasTrailing = true
+		line = line[:i]
+	}
+
+	params = strings.Fields(line)
+	if len(params) == 0 {
+		return "", nil
+	}
+	cmd = strings.ToUpper(params[0])
+	params = params[1:]
+	if hasTrailing {
+		params = append(params, trailing)
+	}
+
+	return cmd, params
+}
+
+//------------------------------------------------------------------------------
+
+func irc_handle(s *tIrcSession, line string) {
+
+	// Processes one Command of the Client.
+
+	var cmd string
+	var params []string
+
+	cmd, params = irc_parse(line)
+
+	switch cmd {
+
+	case "":
+		return
+
+	case "CAP":
+		// No Capabilities
+		if (len(params) > 0) && (strings.ToUpper(params[0]) == "LS") {
+			irc_send(s, ":"+irc_serverName+" CAP * LS :")
+		}
+
+	case "PASS":
+		if len(params) > 0 {
+			s.pass = params[0]
+		}
+
+	case "NICK":
+		if len(params) > 0 {
+			if s.logged {
+				// The Nick is the User's Name and can not be changed
+				irc_send(s, ":"+irc_source(s)+" NICK "+s.nick)
+			} else {
+				s.nick = params[0]
+			}
+		}
+		if !s.logged && (len(s.user) > 0) {
+			irc_logIn(s)
+		}
+
+	case "USER":
+		if len(params) > 0 {
+			s.user = params[0]
+		}
+		if !s.logged && (len(s.nick) > 0) {
+			irc_logIn(s)
+		}
+
+	case "PING":
+		if len(params) > 0 {
+			irc_send(s, ":"+irc_serverName+" PONG "+irc_serverName+" :"+params[0])
+		} else {
+			irc_send(s, ":"+irc_serverName+" PONG "+irc_serverName)
+		}
+
+	case "PONG":
+		// lastRead is already updated
+
+	case "QUIT":
+		irc_send(s, "ERROR :Closing link")
+		s.quit = true
+
+	default:
+		if !s.logged {
+			irc_reply(s, irc_errNotRegistered, ":You have not registered")
+			return
+		}
+		irc_handleLogged(s, cmd, params)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func irc_handleLogged(s *tIrcSession, cmd string, params []string) {
+
+	// Processes Commands which need a Log-In.
+
+	switch cmd {
+
+	case "JOIN":
+		if len(params) == 0 {
+			irc_reply(s, irc_errNeedMoreParams, "JOIN :Not enough parameters")
+			return
+		}
+		if !strings.EqualFold(params[0], irc_channel) {
+			irc_reply(s, irc_errNoSuchChannel, params[0]+" :No such channel")
+			return
+		}
+		irc_join(s)
+
+	case "PART":
+		// The Channel is the whole Chat, leaving it means quitting
+		if s.joined {
+			irc_send(s, ":"+irc_source(s)+" PART "+irc_channel)
+			s.joined = false
+		}
+
+	case "NAMES":
+		irc_names(s)
+
+	case "PRIVMSG", "NOTICE":
+		if (len(params) < 2) || (len(params[1]) == 0) {
+			irc_reply(s, irc_errNoTextToSend, ":No text to send")
+			return
+		}
+		if !strings.EqualFold(params[0], irc_channel) {
+			irc_reply(s, irc_errNoSuchChannel, params[0]+" :No such channel")
+			return
+		}
+		irc_post(s, params[1])
+
+	case "MODE", "WHO", "USERHOST", "ISON":
+		// Clients send them on their own; there is nothing to tell
+
+	default:
+		irc_reply(s, irc_errUnknownCommand, cmd+" :Unknown command")
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func irc_logIn(s *tIrcSession) {
+
+	// Checks UID & Password and makes the User an active Client.
+
+	var uid_str, pwd string
+	var keys []string
+	var uid uint64
+	var i int
+	var err error
+
+	// "PASS <UID>:<Password>" or "PASS <Password>" & "NICK <UID>"
+	i = strings.Index(s.pass, ":")
+	if i > 0 {
+		uid_str = s.pass[:i]
+		pwd = s.pass[i+1:]
+	} else {
+		uid_str = s.nick
+		pwd = s.pass
+	}
+
+	uid, err = strconv.ParseUint(uid_str, 10, 64)
+	if err == nil {
+		uid_str = strconv.FormatUint(uid, 10) // "007" is "7"
+	}
+
+	keys = []string{"ip:" + irc_host(s.conn.RemoteAddr()), "uid:" + uid_str}
+	if !irc_reserve(keys) {
+		atomic.AddUint64(&metrics_loginPassword, 1)
+		log_warn(s.rid, "IRC log-in is refused after wrong passwords", "addr", s.conn.RemoteAddr(), "uid", uid_str) //
+		time.Sleep(irc_badPasswordDelay * time.Second)
+		irc_reply(s, irc_errPasswdMismatch, ":Password incorrect")
+		irc_send(s, "ERROR :Too many wrong passwords, try again later")
+		s.quit = true
+		return
+	}
+
+	if (err != nil) || (uid == chat_systemUserUID) || !user_isGood(uid, &pwd) {
+		// The reserved Attempt stays counted
+		atomic.AddUint64(&metrics_loginPassword, 1)
+		time.Sleep(irc_badPasswordDelay * time.Second)
+		irc_reply(s, irc_errPasswdMismatch, ":Password incorrect")
+		irc_send(s, "ERROR :Wrong UID or password")
+		s.quit = true
+		return
+	}
+	irc_release(keys)
+
+	s.uid = uid
+	s.nick = irc_nick(user_name(uid))
+	s.sid = fmt.Sprintf("irc%d", generateRandomUint64())
+	s.logged = true
+	s.added = irc_activate(s)
+
+	irc_reply(s, irc_rplWelcome, ":Welcome to the chat, "+s.nick)
+	irc_reply(s, irc_rplYourHost, ":Your host is "+irc_serverName)
+	irc_reply(s, irc_rplCreated, ":This server was created "+time.Unix(irc_started, 0).UTC().Format(time.RFC1123))
+	irc_reply(s, irc_rplMyInfo, irc_serverName+" 1 o o")
+	irc_reply(s, irc_errNoMotd, ":MOTD File is missing")
+
+	// There is only one Channel
+	irc_join(s)
+}
+
+//------------------------------------------------------------------------------
+
+func irc_reserve(keys []string) (ok bool) {
+
+	// Counts a Log-In Attempt for each Key, unless a Key has reached the
+	// Limit. Attempts are counted before the Password is checked, so that
+	// parallel Connections can not try more Passwords than the Limit.
+
+	var failure tIrcFailure
+	var key string
+	var now int64
+
+	now = time.Now().Unix()
+
+	ircFailuresLock.Lock()
+	defer ircFailuresLock.Unlock()
+
+	// Old Failures are forgotten
+	for key, failure = range ircFailures {
+		if now-failure.since >= irc_failureWindow {
+			delete(ircFailures, key)
+		}
+	}
+
+	for _, key = range keys {
+		if ircFailures[key].count >= irc_failureLimit {
+			return false
+		}
+	}
+
+	for _, key = range keys {
+		failure = ircFailures[key]
+		if failure.count == 0 {
+			failure.since = now
+		}
+		failure.count++
+		ircFailures[key] = failure
+	}
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func irc_release(keys []string) {
+
+	// Takes back the Attempts of a good Password.
+
+	var failure tIrcFailure
+	var key string
+
+	ircFailuresLock.Lock()
+	defer ircFailuresLock.Unlock()
+
+	for _, key = range keys {
+		failure = ircFailures[key]
+		failure.count--
+		if failure.count > 0 {
+			ircFailures[key] = failure
+		} else {
+			delete(ircFailures, key)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func irc_host(addr net.Addr) (host string) {
+
+	// Gives the IP Address of the Client without the Port.
+
+	var err error
+
+	host, _, err = net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
+//------------------------------------------------------------------------------
+
+func irc_activate(s *tIrcSession) (added bool) {
+
+	// Adds the User to the active Clients through the loginManager.
+	// If the User is already active (e.g. in a Browser), the Session only
+	// relays Messages.
+
+	var rcvChan chan tLoginJob
+	var loginJob *tLoginJob
+
+	rcvChan = make(chan tLoginJob)
+	loginJob = new(tLoginJob)
+	loginJob.returnChannel = rcvChan
+	loginJob.client.address = s.conn.RemoteAddr().String()
+	loginJob.client.sid = s.sid
+	loginJob.uid = s.uid
+	loginJob.rid = s.rid
+
+	// Send LoginJob
+	loginManagerChan <- *loginJob
+
+	// Get Feedback
+	*loginJob = <-rcvChan
+
+	return loginJob.result
+}
+
+//------------------------------------------------------------------------------
+
+func irc_keepAlive(s *tIrcSession) {
+
+	// Keeps the User active while the Connection is open.
+
+	var rcvChan chan tActiveJob
+	var activeJob *tActiveJob
+
+	if !s.added {
+		// Maybe the Browser Session has ended
+		s.added = irc_activate(s)
+		return
+	}
+
+	rcvChan = make(chan tActiveJob)
+	activeJob = new(tActiveJob)
+	activeJob.action = activeJobGetUser // Get User (for SID)
+	activeJob.uid = s.uid
+	activeJob.rid = s.rid
+	activeJob.returnChannel = rcvChan
+	activeManagerChan <- *activeJob
+	*activeJob = <-rcvChan
+
+	// Deleted by the Revisor or replaced ?
+	if activeJob.client.sid != s.sid {
+		s.added = irc_activate(s)
+		return
+	}
+
+	activeJob.action = activeJobUpdateUser // Update L.A.T.
+	activeManagerChan <- *activeJob
+	*activeJob = <-rcvChan
+}
+
+//------------------------------------------------------------------------------
+
+func irc_leave(s *tIrcSession) {
+
+	// Removes the User from the active Clients, if this Session has added
+	// him, like a Log-Out.
+
+	var rcvChan chan tActiveJob
+	var activeJob *tActiveJob
+
+	if !s.added {
+		return
+	}
+
+	rcvChan = make(chan tActiveJob)
+	activeJob = new(tActiveJob)
+	activeJob.action = activeJobGetUser // Get User (for SID)
+	activeJob.uid = s.uid
+	activeJob.rid = s.rid
+	activeJob.returnChannel = rcvChan
+	activeManagerChan <- *activeJob
+	*activeJob = <-rcvChan
+
+	if activeJob.client.sid != s.sid {
+		return
+	}
+
+	activeJob.action = activeJobDelete // Delete
+	activeManagerChan <- *activeJob
+	*activeJob = <-rcvChan
+
+	activeJob.action = activeJobUpdateCache // Update Cache
+	activeManagerChan <- *activeJob
+	*activeJob = <-rcvChan
+
+	announce(s.uid, announceEventLeave)
+	webhook_emit(webhookEventLeave, s.uid, webhookReasonLogout, 0, false)
+}
+
+//------------------------------------------------------------------------------
+
+func irc_join(s *tIrcSession) {
+
+	// Puts the Client into the Channel. Only new Messages are relayed.
+
+	if s.joined {
+		return
+	}
+	s.joined = true
+	irc_fetch(s, true)
+
+	irc_send(s, ":"+irc_source(s)+" JOIN "+irc_channel)
+	irc_reply(s, irc_rplTopic, irc_channel+" :"+html_headTitle)
+	irc_names(s)
+}
+
+//------------------------------------------------------------------------------
+
+func irc_names(s *tIrcSession) {
+
+	// Sends the List of active Users.
+
+	var rcvChan chan tActiveJob
+	var activeJob *tActiveJob
+	var users tApiUsers
+	var names []string
+	var line string
+	var i int
+	var err error
+
+	rcvChan = make(chan tActiveJob)
+	activeJob = new(tActiveJob)
+	activeJob.action = activeJobGetList // Get List
+	activeJob.rid = s.rid
+	activeJob.returnChannel = rcvChan
+	activeManagerChan <- *activeJob
+	*activeJob = <-rcvChan
+
+	err = json.Unmarshal([]byte(activeJob.list_v1), &users)
+	if err != nil {
+		log_error(s.rid, "Error decoding list of users", "err", err) //
+	}
+	for i = 0; i < len(users.Users); i++ {
+		names = append(names, irc_nick(users.Users[i].Name))
+	}
+
+	// Several Lines, if the List is long
+	for len(names) > 0 {
+		line = ""
+		for (len(names) > 0) && (len(line)+len(names[0]) < irc_sendMax) {
+			line += names[0] + " "
+			names = names[1:]
+		}
+		if len(line) == 0 {
+			line = names[0]
+			names = names[1:]
+		}
+		irc_reply(s, irc_rplNamReply, "= "+irc_channel+" :"+strings.TrimSpace(line))
+	}
+	irc_reply(s, irc_rplEndOfNames, irc_channel+" :End of /NAMES list")
+}
+
+//------------------------------------------------------------------------------
+
+func irc_poll(s *tIrcSession) {
+
+	// Relays new Messages of the Chat.
+
+	var poll tChatPoll
+	var m *tApiMessage
+	var i int
+	var text, line string
+
+	poll = irc_fetch(s, false)
+
+	for i = 0; i < len(poll.reply.Messages); i++ {
+
+		m = &poll.reply.Messages[i]
+		if s.posted[m.Mid] {
+			delete(s.posted, m.Mid)
+			continue
+		}
+
+		text = poll.texts[i]
+		if m.Att != nil {
+			text = "[" + strings.Join(irc_clean(m.Att.Name), " ") + "] " + path_file + "?" + param_fid + "=" + m.Att.Id
+		}
+
+		for _, line = range irc_clean(text) {
+			for _, line = range irc_split(line) {
+				if m.Sys {
+					irc_send(s, ":"+irc_serverName+" NOTICE "+irc_channel+" :"+line)
+				} else {
+					irc_send(s, ":"+irc_nick(m.Atr)+"!"+strconv.FormatUint(poll.authors[i], 10)+"@"+irc_serverName+
+						" PRIVMSG "+irc_channel+" :"+line)
+				}
+			}
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func irc_fetch(s *tIrcSession, start bool) (poll tChatPoll) {
+
+	// Gets the Messages after the Session's Cursor from the chatManager and
+	// moves the Cursor. At the Start the Cursor is only set to the last
+	// Message.
+
+	var chatJob *tChatJob
+	var rcvChan chan tChatJob
+
+	poll.mid = s.mid
+	poll.ts = s.ts
+	poll.start = start
+
+	// Create Job for ChatManager
+	rcvChan = make(chan tChatJob)
+	chatJob = new(tChatJob)
+	chatJob.poll = &poll
+	chatJob.rid = s.rid
+	chatJob.returnChannel = rcvChan
+
+	// Send Job & wait for Manager
+	chatManagerChan <- *chatJob
+	<-rcvChan
+
+	s.mid = poll.reply.X.Mid
+	s.ts = poll.reply.X.Ts
+
+	return poll
+}
+
+//------------------------------------------------------------------------------
+
+func irc_clean(text string) (lines []string) {
+
+	// Splits a Text into the Lines of IRC Messages. Every CR and LF ends a
+	// Line, Tabs become Spaces and other Control Symbols are dropped, so a
+	// Message can not add Commands or Formatting. Empty Lines are dropped.
+
+	var line string
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	for _, line = range strings.FieldsFunc(text, func(r rune) bool { return (r == '\r') || (r == '\n') }) {
+		line = strings.Map(func(r rune) rune {
+			if r == '\t' {
+				return ' '
+			}
+			if unicode.IsControl(r) {
+				return -1
+			}
+			return r
+		}, line)
+		if len(strings.TrimSpace(line)) > 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+//------------------------------------------------------------------------------
+
+func irc_post(s *tIrcSession, text string) {
+
+	// Posts the Client's Message into the Chat.
+
+	var chatJob *tChatJob
+	var rcvChan chan tChatJob
+
+	// "/me" of IRC Clients
+	if strings.HasPrefix(text, "\x01ACTION ") {
+		text = "*" + strings.TrimSuffix(text[len("\x01ACTION "):], "\x01") + "*"
+	} else if strings.HasPrefix(text, "\x01") {
+		return // other CTCP Requests are not Messages
+	}
+
+	if !utf8.ValidString(text) || (len(text) > msgMaxSize) {
+		irc_send(s, ":"+irc_serverName+" NOTICE "+s.nick+" :Message is not sent: too long or not UTF-8")
+		return
+	}
+
+	// Create Job for ChatManager
+	rcvChan = make(chan tChatJob)
+	chatJob = new(tChatJob)
+	chatJob.chatRecord.author = s.uid
+	chatJob.chatRecord.message = markup_render(text)
+	chatJob.chatRecord.text = text
+	chatJob.rid = s.rid
+	chatJob.returnChannel = rcvChan
+
+	// Send Job
+	chatManagerChan <- *chatJob
+
+	// Wait for Manager
+	*chatJob = <-rcvChan
+
+	// IRC Clients show their own Messages themselves
+	s.posted[chatJob.mid] = true
+
+	// User is not "away" any more
+	activeList_setInput(s.uid, s.rid)
+}
+
+//------------------------------------------------------------------------------
+
+func irc_nick(name string) (nick string) {
+
+	// Makes a Nick from the User's Name. IRC Nicks can not have Spaces and
+	// some other Symbols.
+
+	nick = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune(",:!@*?#&%+.$", r) {
+			return '_'
+		}
+		return r
+	}, name)
+
+	if len(nick) == 0 {
+		return "_"
+	}
+	if strings.ContainsAny(nick[:1], "0123456789-") {
+		nick = "_" + nick
+	}
+
+	return nick
+}
+
+//------------------------------------------------------------------------------
+
+func irc_split(text string) (parts []string) {
+
+	// Splits a long Text into Parts which fit into IRC Lines, on Symbol
+	// Boundaries.
+
+	var n int
+
+	for len(text) > irc_sendMax {
+		n = irc_sendMax
+		for (n > 0) && !utf8.RuneStart(text[n]) {
+			n--
+		}
+		parts = append(parts, text[:n])
+		text = text[n:]
+	}
+	parts = append(parts, text)
+
+	return parts
+}
+
+//------------------------------------------------------------------------------
+
+func irc_source(s *tIrcSession) (source string) {
+
+	// Prefix of the Client's own Messages.
+
+	return s.nick + "!" + strconv.FormatUint(s.uid, 10) + "@" + irc_serverName
+}
+
+//------------------------------------------------------------------------------
+
+func irc_reply(s *tIrcSession, numeric string, text string) {
+
+	// Sends a numeric Reply.
+
+	var nick string = s.nick
+
+	if !s.logged || (len(nick) == 0) {
+		nick = "*"
+	}
+	irc_send(s, ":"+irc_serverName+" "+numeric+" "+nick+" "+text)
+}
+
+//------------------------------------------------------------------------------
+
+func irc_send(s *tIrcSession, line string) {
+
+	// Sends a Line to the Client. Errors are found by the Reader.
+
+	s.writer.WriteString(line)
+	s.writer.WriteString("\r\n")
+}
+
+//------------------------------------------------------------------------------
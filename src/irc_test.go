@@ -0,0 +1,337 @@
+// irc_test.go
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+var irc_testOnce sync.Once // The Gateway is started once for all Tests
+
+//------------------------------------------------------------------------------
+
+type tIrcTestClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+//------------------------------------------------------------------------------
+
+func irc_testDial(t *testing.T) (c *tIrcTestClient) {
+
+	// Connects to the Gateway, which is started with the first Connection.
+	// Counted Failures are forgotten when the Test ends.
+
+	var err error
+
+	irc_testOnce.Do(func() {
+		irc_address = "127.0.0.1:0"
+		irc_channel = irc_channel_default
+		irc_start()
+	})
+	if ircListener == nil {
+		t.Fatal("IRC gateway is not started")
+	}
+
+	c = &tIrcTestClient{t: t}
+	c.conn, err = net.Dial("tcp", ircListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.reader = bufio.NewReader(c.conn)
+	t.Cleanup(func() {
+		c.conn.Close()
+		ircFailuresLock.Lock()
+		ircFailures = make(map[string]tIrcFailure)
+		ircFailuresLock.Unlock()
+	})
+
+	return c
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tIrcTestClient) send(lines ...string) {
+
+	// Sends Lines to the Gateway.
+
+	var line string
+	var err error
+
+	for _, line = range lines {
+		_, err = c.conn.Write([]byte(line + "\r\n"))
+		if err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func (c *tIrcTestClient) wait(text string) (lines []string) {
+
+	// Reads Lines until one has the Text and gives all of them.
+
+	var line string
+	var err error
+
+	c.conn.SetReadDeadline(time.Now().Add((irc_badPasswordDelay + 5) * time.Second))
+	for {
+		line, err = c.reader.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("no %q after %q: %v", text, lines, err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		lines = append(lines, line)
+		if strings.Contains(line, text) {
+			return lines
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestIrcClean(t *testing.T) {
+
+	// Texts become IRC Lines without Control Symbols.
+
+	var tests = []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"plain", "Hello", []string{"Hello"}},
+		{"lines", "one\ntwo\r\nthree", []string{"one", "two", "three"}},
+		{"bare CR", "hi\rQUIT :bye", []string{"hi", "QUIT :bye"}},
+		{"empty lines", "\n\r\n  \nx\n", []string{"x"}},
+		{"NUL", "a\x00b", []string{"ab"}},
+		{"CTCP & formatting", "\x01VERSION\x01 \x02bold\x02 \x034red", []string{"VERSION bold 4red"}},
+		{"tab", "a\tb", []string{"a b"}},
+		{"unicode", "Привет, мир", []string{"Привет, мир"}},
+	}
+
+	var i int
+
+	for i = 0; i < len(tests); i++ {
+		if !reflect.DeepEqual(irc_clean(tests[i].text), tests[i].want) {
+			t.Errorf("%s: got %q, want %q", tests[i].name, irc_clean(tests[i].text), tests[i].want)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestIrcPoll(t *testing.T) {
+
+	// New Messages are relayed as PRIVMSG, one Line each, and a CR in a
+	// Message can not start another Command.
+
+	var c *tTestClient
+	var uid uint64
+	var s *tIrcSession
+	var out bytes.Buffer
+	var lines []string
+	var status int
+
+	c = harness_client(t, false)
+	uid = c.register("Ivan", "ivan-pwd")
+	c.login(uid, "ivan-pwd")
+
+	s = new(tIrcSession)
+	s.writer = bufio.NewWriter(&out)
+	s.posted = make(map[uint16]bool)
+	s.joined = true
+	irc_fetch(s, true)
+
+	status, _ = c.send("hi\rQUIT :bye\x00")
+	if status != http.StatusOK {
+		t.Fatalf("send: status %d", status)
+	}
+	irc_poll(s)
+	s.writer.Flush()
+
+	lines = strings.Split(strings.TrimSuffix(out.String(), "\r\n"), "\r\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d lines are sent, want 2: %q", len(lines), out.String())
+	}
+	if !strings.HasSuffix(lines[0], " PRIVMSG "+irc_channel+" :hi") || !strings.HasSuffix(lines[1], " PRIVMSG "+irc_channel+" :QUIT :bye") {
+		t.Errorf("lines are %q", lines)
+	}
+
+	// No News
+	out.Reset()
+	irc_poll(s)
+	s.writer.Flush()
+	if out.Len() > 0 {
+		t.Errorf("message is sent again: %q", out.String())
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestIrcSession(t *testing.T) {
+
+	// A User logs in with his UID & Password, sees the active Users and
+	// talks with the Users of the Browser.
+
+	var c *tTestClient
+	var irc *tIrcTestClient
+	var xavier, yolanda uint64
+	var lines []string
+	var status int
+
+	harness_quiet(t)
+	c = harness_client(t, false)
+	xavier = c.register("Xavier Ray", "xavier-pwd")
+	yolanda = c.register("Yolanda", "yolanda-pwd")
+	c.login(yolanda, "yolanda-pwd")
+	defer c.get(path_logout)
+
+	irc = irc_testDial(t)
+	irc.send("PASS "+strconv.FormatUint(xavier, 10)+":xavier-pwd", "NICK xavier", "USER xavier 0 * :Xavier")
+	lines = irc.wait(" " + irc_rplNamReply + " ")
+	if !strings.Contains(strings.Join(lines, "\n"), " "+irc_rplWelcome+" Xavier_Ray :Welcome") {
+		t.Errorf("no welcome: %q", lines)
+	}
+	if !strings.Contains(lines[len(lines)-1], "Xavier_Ray") || !strings.Contains(lines[len(lines)-1], "Yolanda") {
+		t.Errorf("names are %q", lines[len(lines)-1])
+	}
+	irc.wait(" " + irc_rplEndOfNames + " ")
+
+	// Browser to IRC
+	status, _ = c.send("Hello, IRC")
+	if status != http.StatusOK {
+		t.Fatalf("send: status %d", status)
+	}
+	lines = irc.wait(" PRIVMSG " + irc_channel + " :")
+	if !strings.HasPrefix(lines[len(lines)-1], ":Yolanda!"+strconv.FormatUint(yolanda, 10)+"@") ||
+		!strings.HasSuffix(lines[len(lines)-1], " :Hello, IRC") {
+		t.Errorf("relayed line is %q", lines[len(lines)-1])
+	}
+
+	// IRC to Browser
+	irc.send("PRIVMSG "+irc_channel+" :Hello, **web**", "PING :sent")
+	irc.wait("PONG")
+	if (chatRecordsList[chat_recordLastNum].author != xavier) ||
+		(chatRecordsList[chat_recordLastNum].text != "Hello, **web**") ||
+		(chatRecordsList[chat_recordLastNum].message != "Hello, <b>web</b>") {
+		t.Errorf("record is %+v", chatRecordsList[chat_recordLastNum])
+	}
+
+	// NAMES on Request
+	irc.send("NAMES " + irc_channel)
+	lines = irc.wait(" " + irc_rplNamReply + " ")
+	if !strings.Contains(lines[len(lines)-1], "Xavier_Ray") || !strings.Contains(lines[len(lines)-1], "Yolanda") {
+		t.Errorf("names are %q", lines[len(lines)-1])
+	}
+
+	irc.send("QUIT")
+	irc.wait("ERROR :Closing link")
+}
+
+//------------------------------------------------------------------------------
+
+func TestIrcReserve(t *testing.T) {
+
+	// Attempts are counted for the Address and the UID; good Passwords are
+	// not counted and old Failures are forgotten.
+
+	var i int
+
+	t.Cleanup(func() {
+		ircFailuresLock.Lock()
+		ircFailures = make(map[string]tIrcFailure)
+		ircFailuresLock.Unlock()
+	})
+
+	for i = 0; i < irc_failureLimit; i++ {
+		if !irc_reserve([]string{"ip:a", "uid:1"}) {
+			t.Fatalf("attempt %d is refused", i+1)
+		}
+	}
+	if irc_reserve([]string{"ip:a", "uid:2"}) {
+		t.Error("address is not locked")
+	}
+	if irc_reserve([]string{"ip:b", "uid:1"}) {
+		t.Error("UID is not locked")
+	}
+	if !irc_reserve([]string{"ip:b", "uid:2"}) {
+		t.Error("other address and UID are locked")
+	}
+
+	// Good Passwords
+	for i = 0; i < 2*irc_failureLimit; i++ {
+		if !irc_reserve([]string{"ip:c", "uid:3"}) {
+			t.Fatalf("good log-in %d is refused", i+1)
+		}
+		irc_release([]string{"ip:c", "uid:3"})
+	}
+
+	// Time goes by
+	ircFailuresLock.Lock()
+	ircFailures["ip:a"] = tIrcFailure{irc_failureLimit, time.Now().Unix() - irc_failureWindow}
+	ircFailures["uid:1"] = tIrcFailure{irc_failureLimit, time.Now().Unix() - irc_failureWindow}
+	ircFailuresLock.Unlock()
+	if !irc_reserve([]string{"ip:a", "uid:1"}) {
+		t.Error("old failures are not forgotten")
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestIrcLockout(t *testing.T) {
+
+	// After the Limit of wrong Passwords even the right one is refused, also
+	// when the wrong ones come in parallel.
+
+	var c *tTestClient
+	var irc *tIrcTestClient
+	var zoe uint64
+	var wg sync.WaitGroup
+	var i int
+
+	if testing.Short() {
+		t.Skip("wrong passwords are answered after a delay")
+	}
+
+	harness_quiet(t)
+	c = harness_client(t, false)
+	zoe = c.register("Zoe", "zoe-pwd")
+
+	for i = 0; i < irc_failureLimit; i++ {
+		irc = irc_testDial(t)
+		wg.Add(1)
+		irc.send("PASS "+strconv.FormatUint(zoe, 10)+":guess", "NICK zoe", "USER zoe 0 * :Zoe")
+		go func(irc *tIrcTestClient) {
+			var data []byte
+
+			defer wg.Done()
+			// The Gateway closes the Connection
+			irc.conn.SetReadDeadline(time.Now().Add((irc_badPasswordDelay + 5) * time.Second))
+			data, _ = ioutil.ReadAll(irc.conn)
+			if !strings.Contains(string(data), "ERROR :Wrong UID or password") {
+				t.Errorf("wrong password gets %q", data)
+			}
+		}(irc)
+	}
+	wg.Wait()
+
+	irc = irc_testDial(t)
+	irc.send("PASS "+strconv.FormatUint(zoe, 10)+":zoe-pwd", "NICK zoe", "USER zoe 0 * :Zoe")
+	irc.wait("ERROR :Too many wrong passwords")
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,307 @@
+// logger.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	Leveled structured Log.
+
+	Each Line is an Event with a Level, a Message and Fields, written as
+	logfmt or JSON (see the "-logf" Flag):
+
+		time=2026-10-19T13:00:45.123Z level=info msg="User logged in" rid=5f0c1d2e3a4b5c6d uid=42
+
+		{"time":"2026-10-19T13:00:45.123Z","level":"info","msg":"User logged in","rid":"5f0c1d2e3a4b5c6d","uid":42}
+
+	Each HTTP Request gets an ID (the "rid" Field), which is also sent to the
+	Client in the "X-Request-Id" Header. Jobs for Managers carry the ID of the
+	Request which made them, so all Lines of one Request can be found. A sane
+	"X-Request-Id" from a Proxy is kept.
+
+	Standard Fields: rid, uid, endpoint, code, status, err.
+
+	Values of secret Fields (Passwords, SIDs, Tokens, Secrets) are never
+	written, whatever the Caller gives. Paths of incoming Webhooks are secret,
+	so the Endpoint is logged instead of the Path.
+
+*/
+
+//------------------------------------------------------------------------------
+
+// Writer for the standard Log, used by Libraries (e.g. net/http)
+type tLogWriter struct{}
+
+//------------------------------------------------------------------------------
+
+const logLevelDebug uint8 = 0
+const logLevelInfo uint8 = 1
+const logLevelWarn uint8 = 2
+const logLevelError uint8 = 3
+
+const log_formatLogfmt = "logfmt"
+const log_formatJSON = "json"
+
+const log_ridHeader = "X-Request-Id" // Header with the Request ID
+const log_ridMaxLen = 64             // Longer IDs from Clients are replaced
+const log_redacted = "[redacted]"    // Written instead of secret Values
+
+//------------------------------------------------------------------------------
+
+// Names of Levels, by Level
+var log_levelNames = [...]string{"debug", "info", "warn", "error"}
+
+// Fields whose Values are never written
+var log_secretKeys = map[string]bool{
+	"pwd":           true,
+	"password":      true,
+	"sid":           true,
+	"cookie":        true,
+	"token":         true,
+	"secret":        true,
+	"authorization": true,
+}
+
+// Internal Parameters
+var log_level uint8 = logLevelInfo
+var log_format string = log_formatLogfmt
+var log_level_str, log_format_str string
+var log_out = log.New(os.Stderr, "", 0) // Writes whole Lines, one at a Time
+
+//------------------------------------------------------------------------------
+
+func logger_init() (ok bool) {
+
+	// Configures the Log from Flags.
+
+	var i int
+
+	ok = false
+	for i = 0; i < len(log_levelNames); i++ {
+		if log_levelNames[i] == log_level_str {
+			log_level = uint8(i)
+			ok = true
+		}
+	}
+	if !ok {
+		log_error("", "Unknown log level", "level", log_level_str)
+		return false
+	}
+
+	if (log_format_str != log_formatLogfmt) && (log_format_str != log_formatJSON) {
+		log_error("", "Unknown log format", "format", log_format_str)
+		return false
+	}
+	log_format = log_format_str
+
+	// Lines of Libraries become Warnings
+	log.SetFlags(0)
+	log.SetOutput(tLogWriter{})
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func (lw tLogWriter) Write(p []byte) (n int, err error) {
+
+	log_write(logLevelWarn, "", strings.TrimSpace(string(p)), nil)
+
+	return len(p), nil
+}
+
+//------------------------------------------------------------------------------
+
+func log_debug(rid string, msg string, kv ...interface{}) {
+
+	// Details for Developers, off by default.
+
+	log_write(logLevelDebug, rid, msg, kv)
+}
+
+//------------------------------------------------------------------------------
+
+func log_info(rid string, msg string, kv ...interface{}) {
+
+	// Normal Events: Start, Stop, Log-In, ...
+
+	log_write(logLevelInfo, rid, msg, kv)
+}
+
+//------------------------------------------------------------------------------
+
+func log_warn(rid string, msg string, kv ...interface{}) {
+
+	// Bad Requests and other Problems which the Server survives.
+
+	log_write(logLevelWarn, rid, msg, kv)
+}
+
+//------------------------------------------------------------------------------
+
+func log_error(rid string, msg string, kv ...interface{}) {
+
+	// Failures of the Server: Files, Network, Configuration.
+
+	log_write(logLevelError, rid, msg, kv)
+}
+
+//------------------------------------------------------------------------------
+
+func log_write(level uint8, rid string, msg string, kv []interface{}) {
+
+	// Writes a Line. Fields are Pairs of a Key and a Value.
+
+	var sb strings.Builder
+	var key string
+	var i int
+
+	if level < log_level {
+		return
+	}
+
+	log_field(&sb, "time", time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+	log_field(&sb, "level", log_levelNames[level])
+	log_field(&sb, "msg", msg)
+	if len(rid) > 0 {
+		log_field(&sb, "rid", rid)
+	}
+
+	for i = 0; i+1 < len(kv); i += 2 {
+		key = fmt.Sprint(kv[i])
+		if log_secretKeys[strings.ToLower(key)] {
+			log_field(&sb, key, log_redacted)
+		} else {
+			log_field(&sb, key, kv[i+1])
+		}
+	}
+	if len(kv)%2 == 1 {
+		log_field(&sb, "extra", kv[len(kv)-1])
+	}
+
+	if log_format == log_formatJSON {
+		log_out.Print("{" + sb.String() + "}")
+	} else {
+		log_out.Print(sb.String())
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func log_field(sb *strings.Builder, key string, value interface{}) {
+
+	// Adds a Field to the Line.
+
+	var s string
+	var buf []byte
+	var err error
+
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case fmt.Stringer:
+		value = v.String()
+	case []byte:
+		value = string(v)
+	}
+
+	if log_format == log_formatJSON {
+		if sb.Len() > 0 {
+			sb.WriteByte(',')
+		}
+		buf, _ = json.Marshal(key)
+		sb.Write(buf)
+		sb.WriteByte(':')
+		buf, err = json.Marshal(value)
+		if err != nil {
+			buf, _ = json.Marshal(fmt.Sprint(value))
+		}
+		sb.Write(buf)
+		return
+	}
+
+	if sb.Len() > 0 {
+		sb.WriteByte(' ')
+	}
+	sb.WriteString(log_key(key))
+	sb.WriteByte('=')
+	if value == nil {
+		return
+	}
+	s = fmt.Sprint(value)
+	if (len(s) == 0) || strings.ContainsAny(s, " =\"\\") || (strconv.Quote(s) != "\""+s+"\"") {
+		s = strconv.Quote(s)
+	}
+	sb.WriteString(s)
+}
+
+//------------------------------------------------------------------------------
+
+func log_key(key string) string {
+
+	// Keeps only Symbols which are safe in logfmt Keys.
+
+	return strings.Map(func(r rune) rune {
+		if (r <= ' ') || (r == '=') || (r == '"') || (r > '~') {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+//------------------------------------------------------------------------------
+
+func log_newRid(req *http.Request) (rid string) {
+
+	// Gives the Request an ID: the Proxy's one, if it is sane, or a new one.
+
+	var i int
+
+	rid = req.Header.Get(log_ridHeader)
+	if (len(rid) == 0) || (len(rid) > log_ridMaxLen) {
+		return fmt.Sprintf("%016x", generateRandomUint64())
+	}
+	for i = 0; i < len(rid); i++ {
+		if !(((rid[i] >= '0') && (rid[i] <= '9')) || ((rid[i] >= 'a') && (rid[i] <= 'z')) ||
+			((rid[i] >= 'A') && (rid[i] <= 'Z')) || (rid[i] == '-') || (rid[i] == '_') || (rid[i] == '.')) {
+			return fmt.Sprintf("%016x", generateRandomUint64())
+		}
+	}
+
+	return rid
+}
+
+//------------------------------------------------------------------------------
+
+func log_withRid(req *http.Request, rid string) *http.Request {
+
+	// Puts the Request ID into the Request's Context.
+
+	return req.WithContext(context.WithValue(req.Context(), api_ctxRid, rid))
+}
+
+//------------------------------------------------------------------------------
+
+func log_rid(req *http.Request) (rid string) {
+
+	// Gives the ID of the Request, empty for Requests made by Tests.
+
+	rid, _ = req.Context().Value(api_ctxRid).(string)
+
+	return rid
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,87 @@
+// logger_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func TestLogRedaction(t *testing.T) {
+
+	// Values of secret Fields never reach the Log, in any Format and with
+	// Keys in any Case. Other Fields are written.
+
+	var tests = []struct {
+		key    string
+		value  interface{}
+		secret bool
+	}{
+		{"pwd", "hunter2-pwd", true},
+		{"SID", "0123456789-sid", true},
+		{"token", []byte("bytes-of-a-token"), true},
+		{"Secret", errors.New("error-with-a-secret"), true},
+		{"password", "password-value", true},
+		{"Authorization", "Bearer auth-value", true},
+		{"uid", 4242, false},
+		{"file", "plain-file-name", false},
+	}
+
+	var formats = []string{log_formatLogfmt, log_formatJSON}
+	var buf bytes.Buffer
+	var kv []interface{}
+	var fields map[string]interface{}
+	var line, value string
+	var data []byte
+	var ok bool
+	var i, j int
+
+	for i = 0; i < len(tests); i++ {
+		kv = append(kv, tests[i].key, tests[i].value)
+	}
+
+	log_out.SetOutput(&buf)
+	t.Cleanup(func() {
+		log_out.SetOutput(os.Stderr)
+		log_format = log_format_str
+	})
+
+	for j = 0; j < len(formats); j++ {
+
+		buf.Reset()
+		log_format = formats[j]
+		log_error("test-rid", "Test of the log", kv...)
+		line = buf.String()
+
+		for i = 0; i < len(tests); i++ {
+			value = fmt.Sprint(tests[i].value)
+			data, ok = tests[i].value.([]byte)
+			if ok {
+				value = string(data)
+			}
+			if strings.Contains(line, value) == tests[i].secret {
+				t.Errorf("%s: %s is written: %v: %s", formats[j], tests[i].key, !tests[i].secret, line)
+			}
+		}
+		if strings.Count(line, log_redacted) != 6 {
+			t.Errorf("%s: %d redacted fields, want 6: %s", formats[j], strings.Count(line, log_redacted), line)
+		}
+
+		if formats[j] == log_formatJSON {
+			fields = nil
+			if (json.Unmarshal([]byte(line), &fields) != nil) || (fields["SID"] != log_redacted) ||
+				(fields["rid"] != "test-rid") || (fields["uid"] != float64(4242)) {
+				t.Errorf("json line is %s", line)
+			}
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
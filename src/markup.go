@@ -0,0 +1,214 @@
+// markup.go
+
+package main
+
+import (
+	"bytes"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	Lightweight Message Markup.
+
+	Messages are rendered into HTML on the Server, once, when they are posted.
+	The Renderer does not "clean" any User's HTML, it escapes everything and
+	then builds the Output only from the allowed Elements:
+
+		**bold**			->	<b>bold</b>
+		*italic*			->	<i>italic</i>
+		`code`				->	<code>code</code>
+		http://... https://...	->	<a href='...'>...</a>
+
+	No other Tags and no other Attributes can ever appear in the Output. Links
+	with any other Scheme (javascript:, data:, ...) are left as plain Text.
+
+*/
+
+//------------------------------------------------------------------------------
+
+const markup_codeMark = "`" // Opens and closes a Code Span
+const markup_linkRel = "nofollow noopener noreferrer"
+
+//------------------------------------------------------------------------------
+
+var markup_reLink = regexp.MustCompile("https?://[^\\s<>\"'`]+")
+var markup_reBold = regexp.MustCompile(`\*\*((?:[^*\n]|\*[^*\n]+\*)+?)\*\*`) // May hold whole italic Spans
+var markup_reItalic = regexp.MustCompile(`\*([^*\n]+?)\*`)
+
+//------------------------------------------------------------------------------
+
+func markup_render(text string) (out string) {
+
+	// Renders the raw Text of a Message into safe HTML.
+
+	var buffer bytes.Buffer
+	var parts []string
+	var i, last int
+
+	parts = strings.Split(text, markup_codeMark)
+
+	// Odd Parts are inside Code Spans. If the last Code Span is not closed,
+	// its Mark is shown as it is.
+	last = len(parts) - 1
+	if (last%2 == 1) && (last > 0) {
+		parts[last-1] = parts[last-1] + markup_codeMark + parts[last]
+		parts = parts[:last]
+	}
+
+	for i = 0; i < len(parts); i++ {
+
+		if i%2 == 1 {
+			buffer.WriteString("<code>")
+			buffer.WriteString(html.EscapeString(parts[i]))
+			buffer.WriteString("</code>")
+		} else {
+			markup_renderInline(&buffer, parts[i])
+		}
+	}
+
+	return buffer.String()
+}
+
+//------------------------------------------------------------------------------
+
+func markup_renderInline(buffer *bytes.Buffer, text string) {
+
+	// Renders Text outside of Code Spans: Links and Emphasis.
+
+	var locs [][]int
+	var loc []int
+	var pos int
+	var link, tail string
+
+	locs = markup_reLink.FindAllStringIndex(text, -1)
+	pos = 0
+	for _, loc = range locs {
+
+		// Text before the Link
+		buffer.WriteString(markup_renderEmphasis(text[pos:loc[0]]))
+
+		// Punctuation at the End of a Sentence is not a Part of the Link
+		link = strings.TrimRight(text[loc[0]:loc[1]], ".,;:!?)")
+		tail = text[loc[0]+len(link) : loc[1]]
+
+		if markup_isSafeLink(link) {
+			buffer.WriteString("<a href='")
+			buffer.WriteString(html.EscapeString(link))
+			buffer.WriteString("' target='_blank' rel='" + markup_linkRel + "'>")
+			buffer.WriteString(html.EscapeString(link))
+			buffer.WriteString("</a>")
+		} else {
+			buffer.WriteString(html.EscapeString(link))
+		}
+		buffer.WriteString(html.EscapeString(tail))
+
+		pos = loc[1]
+	}
+	buffer.WriteString(markup_renderEmphasis(text[pos:]))
+}
+
+//------------------------------------------------------------------------------
+
+func markup_renderEmphasis(text string) (out string) {
+
+	// Escapes the Text and applies bold and italic Marks.
+	// Marks are searched in the already escaped Text, so the captured Parts
+	// can not contain any HTML. Marks do not span Lines.
+
+	var lines []string
+	var i int
+
+	lines = strings.Split(html.EscapeString(text), "\n")
+	for i = 0; i < len(lines); i++ {
+		lines[i] = markup_renderLine(lines[i])
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+//------------------------------------------------------------------------------
+
+func markup_renderLine(line string) (out string) {
+
+	// Applies the Marks to an escaped Line. Bold Spans are rendered first,
+	// with the italic Spans inside them; then each of them is one Symbol for
+	// the italic Spans around, so Spans never cross each other.
+
+	var buffer bytes.Buffer
+	var outer []byte
+	var bolds map[int]string // Rendered bold Spans, by Position in "outer"
+	var loc []int
+	var pos int
+
+	bolds = make(map[int]string)
+	for _, loc = range markup_reBold.FindAllStringSubmatchIndex(line, -1) {
+		outer = append(outer, line[pos:loc[0]]...)
+		bolds[len(outer)] = "<b>" + markup_reItalic.ReplaceAllString(line[loc[2]:loc[3]], "<i>$1</i>") + "</b>"
+		outer = append(outer, ' ')
+		pos = loc[1]
+	}
+	outer = append(outer, line[pos:]...)
+
+	pos = 0
+	for _, loc = range markup_reItalic.FindAllSubmatchIndex(outer, -1) {
+		markup_writeOuter(&buffer, outer[:loc[0]], pos, bolds)
+		buffer.WriteString("<i>")
+		markup_writeOuter(&buffer, outer[:loc[3]], loc[2], bolds)
+		buffer.WriteString("</i>")
+		pos = loc[1]
+	}
+	markup_writeOuter(&buffer, outer, pos, bolds)
+
+	return buffer.String()
+}
+
+//------------------------------------------------------------------------------
+
+func markup_writeOuter(buffer *bytes.Buffer, outer []byte, from int, bolds map[int]string) {
+
+	// Writes the Line from the Position, with the bold Spans in their Places.
+
+	var span string
+	var exists bool
+	var i int
+
+	for i = from; i < len(outer); i++ {
+		span, exists = bolds[i]
+		if exists {
+			buffer.WriteString(span)
+		} else {
+			buffer.WriteByte(outer[i])
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func markup_isSafeLink(link string) (ok bool) {
+
+	// Checks that the Link is an absolute http or https URL.
+
+	var u *url.URL
+	var err error
+
+	u, err = url.Parse(link)
+	if err != nil {
+		return false
+	}
+	if (u.Scheme != "http") && (u.Scheme != "https") {
+		return false
+	}
+	if len(u.Host) == 0 {
+		return false
+	}
+
+	return true
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,152 @@
+// markup_test.go
+
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+// Tags which the Renderer may write; Links have their Attributes in this Order
+var markup_testTag = regexp.MustCompile(`</?b>|</?i>|</?code>|` +
+	`<a href='([^'<>]*)' target='_blank' rel='` + markup_linkRel + `'>|</a>`)
+
+//------------------------------------------------------------------------------
+
+func markup_testLink(link string) string {
+
+	// HTML of a Link, as the Renderer writes it.
+
+	return "<a href='" + link + "' target='_blank' rel='" + markup_linkRel + "'>" + link + "</a>"
+}
+
+//------------------------------------------------------------------------------
+
+func TestMarkupRender(t *testing.T) {
+
+	// Raw Texts and their HTML.
+
+	var tests = []struct {
+		name string
+		text string
+		want string
+	}{
+		// Escaping
+		{"plain", "Hello, world", "Hello, world"},
+		{"specials", `<>&"'`, "&lt;&gt;&amp;&#34;&#39;"},
+		{"tag", `<script>alert("x")</script>`, "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;"},
+		{"entity", "&lt;b&gt;", "&amp;lt;b&amp;gt;"},
+		{"specials in code", "`<b>&'`", "<code>&lt;b&gt;&amp;&#39;</code>"},
+		{"specials in bold", "**<i>**", "<b>&lt;i&gt;</b>"},
+
+		// Links
+		{"link", "see https://example.com/a?b=1&c=2.", "see " + markup_testLink("https://example.com/a?b=1&amp;c=2") + "."},
+		{"link in brackets", "(http://example.com/x)", "(" + markup_testLink("http://example.com/x") + ")"},
+		{"quote ends link", "http://example.com/'onmouseover='alert(1)", markup_testLink("http://example.com/") + "&#39;onmouseover=&#39;alert(1)"},
+		{"marks in link", "http://a.b/*x*", markup_testLink("http://a.b/*x*")},
+		{"javascript", "javascript:alert(1)", "javascript:alert(1)"},
+		{"javascript in text", "click javascript://example.com/%0aalert(1)", "click javascript://example.com/%0aalert(1)"},
+		{"data", "data:text/html,<script>alert(1)</script>", "data:text/html,&lt;script&gt;alert(1)&lt;/script&gt;"},
+		{"no host", "http:///etc/passwd", "http:///etc/passwd"},
+		{"no host, query", "http://?x=1", "http://?x=1"},
+		{"link in code", "`http://example.com`", "<code>http://example.com</code>"},
+
+		// Code Spans
+		{"code", "a `b` c", "a <code>b</code> c"},
+		{"unclosed code", "a `b", "a `b"},
+		{"closed and unclosed code", "`a` `b", "<code>a</code> `b"},
+		{"third mark", "`a`b`", "<code>a</code>b`"},
+		{"empty code", "``", "<code></code>"},
+		{"marks in code", "`*a* **b**`", "<code>*a* **b**</code>"},
+
+		// Emphasis
+		{"bold", "**a**", "<b>a</b>"},
+		{"italic", "x*y*z", "x<i>y</i>z"},
+		{"bold and italic", "**a** *b*", "<b>a</b> <i>b</i>"},
+		{"bold in italic", "*it **bold** it*", "<i>it <b>bold</b> it</i>"},
+		{"italic in bold", "**bold *it* bold**", "<b>bold <i>it</i> bold</b>"},
+		{"italic at start of bold", "***it* bold**", "<b><i>it</i> bold</b>"},
+		{"crossing marks", "*a **b* c**", "<i>a </i><i>b</i> c**"},
+		{"both", "***a***", "<b><i>a</i></b>"},
+		{"unbalanced bold", "**a*", "*<i>a</i>"},
+		{"unbalanced italic", "*a**", "<i>a</i>*"},
+		{"unclosed italic", "*a", "*a"},
+		{"lone marks", "2 ** 3", "2 ** 3"},
+		{"marks over lines", "**a\nb**", "**a\nb**"},
+	}
+
+	var i int
+
+	for i = 0; i < len(tests); i++ {
+		if markup_render(tests[i].text) != tests[i].want {
+			t.Errorf("%s: %q gives %q, want %q", tests[i].name, tests[i].text, markup_render(tests[i].text), tests[i].want)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func FuzzMarkupRender(f *testing.F) {
+
+	// Any Text gives HTML with only the allowed Tags, properly nested, and
+	// Links only to http and https URLs with a Host. Everything else is
+	// escaped.
+
+	f.Add("Hello, world")
+	f.Add(`<script>alert("x")</script>`)
+	f.Add("**bold *it* bold** `code` *it **bold** it*")
+	f.Add("see https://example.com/a?b=1&c=2. or http://?x")
+	f.Add("http://example.com/'onmouseover='alert(1)")
+	f.Add("`a `b` c` ***d***")
+
+	f.Fuzz(func(t *testing.T, text string) {
+
+		var out, rest, tag, name string
+		var stack []string
+		var locs [][]int
+		var loc []int
+		var pos int
+
+		out = markup_render(text)
+
+		locs = markup_testTag.FindAllStringSubmatchIndex(out, -1)
+		for _, loc = range locs {
+
+			rest += out[pos:loc[0]]
+			pos = loc[1]
+			tag = out[loc[0]:loc[1]]
+
+			// Links
+			if loc[2] >= 0 {
+				if !markup_isSafeLink(html.UnescapeString(out[loc[2]:loc[3]])) {
+					t.Fatalf("%q gives the link %q", text, tag)
+				}
+			}
+
+			// Nesting
+			name = strings.Trim(strings.Fields(tag)[0], "</>")
+			if !strings.HasPrefix(tag, "</") {
+				stack = append(stack, name)
+				continue
+			}
+			if (len(stack) == 0) || (stack[len(stack)-1] != name) {
+				t.Fatalf("%q gives badly nested tags: %q", text, out)
+			}
+			stack = stack[:len(stack)-1]
+		}
+		rest += out[pos:]
+
+		if len(stack) > 0 {
+			t.Fatalf("%q leaves tags open: %q", text, out)
+		}
+		if strings.ContainsAny(rest, `<>"'`) {
+			t.Fatalf("%q is not escaped: %q", text, out)
+		}
+	})
+}
+
+//------------------------------------------------------------------------------
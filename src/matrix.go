@@ -0,0 +1,539 @@
+// matrix.go
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	Bridge to a Matrix Room, as an Application Service.
+
+	The Homeserver sends Events of the Room to the Chat, they are posted on
+	Behalf of Puppets (see bridge.go). Messages of the Chat are sent into the
+	Room on Behalf of "Ghosts": Matrix Users of the Application Service, one
+	for each User of the Chat, named "@<Prefix><UID>:<Server Name>".
+
+	The Configuration File has one Parameter per Line, empty Lines and Lines
+	starting with '#' are ignored:
+
+		homeserver   http://localhost:8008
+		server_name  example.org
+		room         !AbCdEf:example.org
+		as_token     <Token of the Application Service>
+		hs_token     <Token of the Homeserver>
+		bot          saga
+		prefix       saga_
+
+	"bot" and "prefix" are optional. The Application Service must be
+	registered at the Homeserver with the same Tokens, see README.
+
+*/
+
+//------------------------------------------------------------------------------
+
+type tMatrixBridge struct {
+
+	// Configuration
+	homeserver string
+	serverName string
+	room       string
+	asToken    string
+	hsToken    string
+	bot        string // Local Part of the Bot User
+	prefix     string // Local Part Prefix of Ghosts
+
+	client *http.Client
+
+	// Used by the Bridge's Go-Routine only
+	ghosts    map[uint64]string // Ghosts which are ready, Value = Display Name
+	botJoined bool
+	txnBase   string // Transaction IDs of sent Events start with it
+	txnCount  uint64
+
+	// Used by the Server's Jobs only (see serverJobsManager)
+	seenTxns  map[string]bool // Recent Transactions from the Homeserver
+	txnOrder  []string
+	nameCache map[string]string // Display Names of Matrix Users, Key = Matrix ID
+}
+
+// Transaction sent by the Homeserver
+type tMatrixTransaction struct {
+	Events []tMatrixEvent `json:"events"`
+}
+
+type tMatrixEvent struct {
+	Type     string          `json:"type"`
+	RoomId   string          `json:"room_id"`
+	Sender   string          `json:"sender"`
+	StateKey *string         `json:"state_key"`
+	Content  json.RawMessage `json:"content"`
+}
+
+type tMatrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+type tMatrixMember struct {
+	Membership  string `json:"membership"`
+	DisplayName string `json:"displayname"`
+}
+
+type tMatrixError struct {
+	ErrCode string `json:"errcode"`
+	Error   string `json:"error"`
+}
+
+//------------------------------------------------------------------------------
+
+const path_matrix = "/_matrix/app/" // Prefix of the Application Service API
+
+const matrix_bridgeName = "matrix"
+const matrix_bot_default = "saga"
+const matrix_prefix_default = "saga_"
+const matrix_timeout = 10     // Timeout of one Request to the Homeserver, in Seconds
+const matrix_attempts = 3     // Attempts of one Request to the Homeserver
+const matrix_retryDelay = 2   // Delay before a repeated Attempt, in Seconds
+const matrix_seenTxnsMax = 64 // Count of remembered Transactions
+
+//------------------------------------------------------------------------------
+
+// Bridge
+var matrixBridge *tMatrixBridge
+
+// File
+var file_matrix string
+
+//------------------------------------------------------------------------------
+
+func matrix_init() (ok bool) {
+
+	// Reads the Configuration and registers the Bridge.
+	// The Bridge is off when no Configuration File is set.
+
+	var file *os.File
+	var scanner *bufio.Scanner
+	var line string
+	var fields []string
+	var mb *tMatrixBridge
+	var n int // Number of the Line, Lines may have Tokens
+	var err error
+
+	if len(file_matrix) == 0 {
+		return true
+	}
+
+	file, err = os.Open(file_matrix)
+	if err != nil {
+		log_error("", "Error opening matrix bridge config", "file", file_matrix, "err", err) //
+		return false
+	}
+	defer file.Close()
+
+	mb = new(tMatrixBridge)
+	mb.bot = matrix_bot_default
+	mb.prefix = matrix_prefix_default
+
+	scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+
+		n++
+		line = strings.TrimSpace(scanner.Text())
+		if (len(line) == 0) || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields = strings.Fields(line)
+		if len(fields) != 2 {
+			log_error("", "Bad line in matrix bridge config", "file", file_matrix, "line", n) //
+			return false
+		}
+
+		switch fields[0] {
+		case "homeserver":
+			mb.homeserver = strings.TrimSuffix(fields[1], "/")
+		case "server_name":
+			mb.serverName = fields[1]
+		case "room":
+			mb.room = fields[1]
+		case "as_token":
+			mb.asToken = fields[1]
+		case "hs_token":
+			mb.hsToken = fields[1]
+		case "bot":
+			mb.bot = fields[1]
+		case "prefix":
+			mb.prefix = fields[1]
+		default:
+			log_error("", "Unknown parameter in matrix bridge config", "file", file_matrix, "line", n, "param", fields[0]) //
+			return false
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		log_error("", "Error reading matrix bridge config", "file", file_matrix, "err", err) //
+		return false
+	}
+
+	if (len(mb.homeserver) == 0) || (len(mb.serverName) == 0) ||
+		(len(mb.room) == 0) || (len(mb.asToken) == 0) || (len(mb.hsToken) == 0) {
+		log_error("", "Matrix bridge config must have homeserver, server_name, room, as_token and hs_token", "file", file_matrix) //
+		return false
+	}
+
+	matrixBridge = mb
+	bridge_register(mb)
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func (mb *tMatrixBridge) name() string {
+
+	return matrix_bridgeName
+}
+
+//------------------------------------------------------------------------------
+
+func (mb *tMatrixBridge) start() bool {
+
+	// Prepares the Bridge. The Homeserver is not asked here, it may be
+	// started later than the Chat.
+
+	mb.client = &http.Client{Timeout: matrix_timeout * time.Second}
+	mb.ghosts = make(map[uint64]string)
+	mb.txnBase = strconv.FormatInt(time.Now().UnixNano(), 36)
+	mb.seenTxns = make(map[string]bool)
+	mb.nameCache = make(map[string]string)
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func (mb *tMatrixBridge) stop() {
+}
+
+//------------------------------------------------------------------------------
+
+func (mb *tMatrixBridge) relay(mid uint16, r tChatRecord) {
+
+	// Sends a Record of the Chat into the Room. System Records are sent by
+	// the Bot as Notices, Messages of Users are sent by their Ghosts.
+
+	var msg tMatrixMessage
+	var as string
+	var ok bool
+
+	msg.Body = r.text
+	if len(r.attachment.hash) > 0 {
+		if len(msg.Body) > 0 {
+			msg.Body += "\n"
+		}
+		msg.Body += "[" + r.attachment.name + "]"
+	}
+	if len(msg.Body) == 0 {
+		return
+	}
+
+	if !mb.botJoined {
+		mb.botJoined = mb.join("")
+	}
+
+	if r.kind == chat_recordKindSystem {
+		msg.MsgType = "m.notice"
+	} else {
+		msg.MsgType = "m.text"
+		msg.Format = "org.matrix.custom.html"
+		msg.FormattedBody = r.message
+		as, ok = mb.ghost(r.author)
+		if !ok {
+			return
+		}
+	}
+
+	mb.txnCount++
+	ok = mb.call(http.MethodPut, "/_matrix/client/v3/rooms/"+url.PathEscape(mb.room)+
+		"/send/m.room.message/"+mb.txnBase+"-"+strconv.FormatUint(mb.txnCount, 10), as, msg)
+	if !ok {
+		log_warn("", "Message is not sent", "bridge", "matrix", "mid", mid) //
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func (mb *tMatrixBridge) ghost(uid uint64) (id string, ok bool) {
+
+	// Returns the Matrix ID of the User's Ghost. A new Ghost is registered
+	// and joins the Room; its Display Name follows the User's Name.
+
+	var name string
+	var known bool
+	var req struct {
+		Type     string `json:"type"`
+		Username string `json:"username"`
+	}
+	var dn struct {
+		DisplayName string `json:"displayname"`
+	}
+
+	req.Type = "m.login.application_service"
+	req.Username = mb.prefix + strconv.FormatUint(uid, 10)
+	id = "@" + req.Username + ":" + mb.serverName
+
+	name = user_name(uid)
+	dn.DisplayName, known = mb.ghosts[uid]
+	if known && (dn.DisplayName == name) {
+		return id, true
+	}
+
+	if !known {
+		// "M_USER_IN_USE" means that the Ghost exists since the last Start
+		if !mb.call(http.MethodPost, "/_matrix/client/v3/register", "", req) {
+			return "", false
+		}
+		if !mb.join(id) {
+			return "", false
+		}
+	}
+
+	dn.DisplayName = name
+	if !mb.call(http.MethodPut, "/_matrix/client/v3/profile/"+url.PathEscape(id)+"/displayname", id, dn) {
+		return "", false
+	}
+
+	mb.ghosts[uid] = name
+	return id, true
+}
+
+//------------------------------------------------------------------------------
+
+func (mb *tMatrixBridge) join(as string) (ok bool) {
+
+	// Joins the Room as the Ghost, or as the Bot if "as" is empty.
+
+	return mb.call(http.MethodPost, "/_matrix/client/v3/join/"+url.PathEscape(mb.room), as, struct{}{})
+}
+
+//------------------------------------------------------------------------------
+
+func (mb *tMatrixBridge) call(method, path, as string, body interface{}) (ok bool) {
+
+	// Sends a Request to the Homeserver on Behalf of the Ghost "as", or of
+	// the Bot. Busy Homeservers get more Attempts.
+
+	var data, reply []byte
+	var req *http.Request
+	var resp *http.Response
+	var mxErr tMatrixError
+	var target string
+	var attempt int
+	var err error
+
+	data, err = json.Marshal(body)
+	if err != nil {
+		log_error("", "Error encoding JSON", "bridge", "matrix", "err", err) //
+		return false
+	}
+
+	target = mb.homeserver + path
+	if len(as) > 0 {
+		target += "?user_id=" + url.QueryEscape(as)
+	}
+
+	for attempt = 1; attempt <= matrix_attempts; attempt++ {
+
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt*matrix_retryDelay) * time.Second)
+		}
+
+		req, err = http.NewRequest(method, target, bytes.NewReader(data))
+		if err != nil {
+			log_error("", "Bad request", "bridge", "matrix", "err", err) //
+			return false
+		}
+		req.Header.Set("Authorization", "Bearer "+mb.asToken)
+		req.Header.Set("Content-Type", api_mimeJSON)
+
+		resp, err = mb.client.Do(req)
+		if err != nil {
+			log_warn("", "Homeserver is not reachable", "bridge", "matrix", "err", err) //
+			continue
+		}
+		reply, _ = ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+
+		if (resp.StatusCode >= 200) && (resp.StatusCode < 300) {
+			return true
+		}
+
+		mxErr.ErrCode = ""
+		json.Unmarshal(reply, &mxErr)
+		if mxErr.ErrCode == "M_USER_IN_USE" {
+			return true
+		}
+		if (resp.StatusCode == http.StatusTooManyRequests) || (resp.StatusCode >= 500) {
+			log_warn("", "Homeserver is busy", "bridge", "matrix", "method", method, "path", path, "status", resp.StatusCode) //
+			continue
+		}
+
+		log_warn("", "Homeserver refused the request", "bridge", "matrix", "method", method, "path", path,
+			"status", resp.StatusCode, "code", mxErr.ErrCode, "err", mxErr.Error) //
+		return false
+	}
+
+	return false
+}
+
+//------------------------------------------------------------------------------
+
+func page_matrix(w http.ResponseWriter, req *http.Request) {
+
+	// Serves the Application Service API for the Homeserver.
+
+	// The Homeserver sends Transactions with a PUT Request to
+	// "/_matrix/app/v1/transactions/<ID>" and gets an empty JSON Object.
+	// Each Transaction is processed only once, as the Homeserver repeats
+	// it until it is confirmed. Queries for Users and Room Aliases are not
+	// supported: Ghosts are registered by the Bridge itself.
+
+	var mb *tMatrixBridge
+	var token, txn string
+	var reqBody []byte
+	var tr tMatrixTransaction
+	var i int
+	var err error
+
+	mb = matrixBridge
+	if mb == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	token = strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if len(token) == 0 {
+		token = req.URL.Query().Get("access_token") // Older Homeservers
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(mb.hsToken)) != 1 {
+		api_writeJSON(w, http.StatusForbidden, tMatrixError{"M_FORBIDDEN", "Bad token"})
+		return
+	}
+
+	txn = strings.TrimPrefix(req.URL.Path, path_matrix+"v1/transactions/")
+	if (txn == req.URL.Path) || (req.Method != http.MethodPut) {
+		api_writeJSON(w, http.StatusNotFound, tMatrixError{"M_NOT_FOUND", "Not supported"})
+		return
+	}
+
+	if !mb.seenTxns[txn] {
+
+		req.Body = http.MaxBytesReader(w, req.Body, 1<<20)
+		reqBody, err = ioutil.ReadAll(req.Body)
+		if err == nil {
+			err = json.Unmarshal(reqBody, &tr)
+		}
+		if err != nil {
+			api_writeJSON(w, http.StatusBadRequest, tMatrixError{"M_NOT_JSON", "Bad transaction"})
+			return
+		}
+
+		for i = 0; i < len(tr.Events); i++ {
+			mb.event(&tr.Events[i], log_rid(req))
+		}
+
+		// Remember the Transaction
+		mb.seenTxns[txn] = true
+		mb.txnOrder = append(mb.txnOrder, txn)
+		if len(mb.txnOrder) > matrix_seenTxnsMax {
+			delete(mb.seenTxns, mb.txnOrder[0])
+			mb.txnOrder = mb.txnOrder[1:]
+		}
+	}
+
+	api_writeJSON(w, http.StatusOK, struct{}{})
+}
+
+//------------------------------------------------------------------------------
+
+func (mb *tMatrixBridge) event(ev *tMatrixEvent, rid string) {
+
+	// Processes one Event from the Homeserver. Events of the Bridge's own
+	// Users are the Echo of the Chat, they are ignored.
+
+	var msg tMatrixMessage
+	var member tMatrixMember
+	var name, text string
+	var uid uint64
+	var ok bool
+
+	if ev.RoomId != mb.room {
+		return
+	}
+	if (ev.Sender == "@"+mb.bot+":"+mb.serverName) || strings.HasPrefix(ev.Sender, "@"+mb.prefix) {
+		return
+	}
+
+	switch ev.Type {
+
+	case "m.room.member":
+		if (ev.StateKey == nil) || (json.Unmarshal(ev.Content, &member) != nil) {
+			return
+		}
+		if member.Membership == "leave" || member.Membership == "ban" {
+			delete(mb.nameCache, *ev.StateKey)
+		} else if len(member.DisplayName) > 0 {
+			mb.nameCache[*ev.StateKey] = member.DisplayName
+		}
+
+	case "m.room.message":
+		if json.Unmarshal(ev.Content, &msg) != nil {
+			return
+		}
+		text = strings.TrimSpace(msg.Body)
+		if len(text) == 0 {
+			return
+		}
+
+		name = mb.nameCache[ev.Sender]
+		if len(name) == 0 {
+			// Local Part of the Matrix ID
+			name = strings.TrimPrefix(strings.SplitN(ev.Sender, ":", 2)[0], "@")
+		}
+
+		switch msg.MsgType {
+		case "m.text", "m.notice":
+		case "m.emote":
+			text = "* " + text
+		default:
+			// Images, Files, ...
+			text = "[" + text + "]"
+		}
+
+		uid, ok = bridge_puppet(mb, ev.Sender, name)
+		if !ok {
+			return
+		}
+		bridge_inject(mb, uid, text, rid)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,217 @@
+// matrix_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+// Request which the Bridge has sent to the fake Homeserver
+type tTestHsCall struct {
+	method string
+	path   string
+	as     string // Ghost, empty for the Bot
+	auth   string
+	body   string
+}
+
+// Fake Homeserver, it answers each Request with an empty JSON Object
+type tTestHomeserver struct {
+	server *httptest.Server
+	lock   sync.Mutex
+	calls  []tTestHsCall
+}
+
+//------------------------------------------------------------------------------
+
+const matrix_testRoom = "!room:example.org"
+const matrix_testHsToken = "hs-secret"
+const matrix_testAsToken = "as-secret"
+
+//------------------------------------------------------------------------------
+
+func matrix_testBridge(t *testing.T) (mb *tMatrixBridge, hs *tTestHomeserver) {
+
+	// Starts a fake Homeserver and a Bridge to it, for the Test only.
+
+	hs = new(tTestHomeserver)
+	hs.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		var body []byte
+
+		body, _ = ioutil.ReadAll(req.Body)
+		hs.lock.Lock()
+		hs.calls = append(hs.calls, tTestHsCall{req.Method, req.URL.EscapedPath(),
+			req.URL.Query().Get("user_id"), req.Header.Get("Authorization"), string(body)})
+		hs.lock.Unlock()
+		w.Header().Set("Content-Type", api_mimeJSON)
+		w.Write([]byte("{}"))
+	}))
+
+	mb = new(tMatrixBridge)
+	mb.homeserver = hs.server.URL
+	mb.serverName = "example.org"
+	mb.room = matrix_testRoom
+	mb.asToken = matrix_testAsToken
+	mb.hsToken = matrix_testHsToken
+	mb.bot = matrix_bot_default
+	mb.prefix = matrix_prefix_default
+	mb.start()
+
+	matrixBridge = mb
+	t.Cleanup(func() {
+		matrixBridge = nil
+		hs.server.Close()
+	})
+
+	return mb, hs
+}
+
+//------------------------------------------------------------------------------
+
+func matrix_testPut(t *testing.T, txn, token string, events ...string) (status int) {
+
+	// Sends a Transaction to the Chat, as the Homeserver does.
+
+	var req *http.Request
+	var resp *http.Response
+	var err error
+
+	req, err = http.NewRequest(http.MethodPut, harness_server.URL+path_matrix+"v1/transactions/"+txn,
+		strings.NewReader(`{"events":[`+strings.Join(events, ",")+`]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+//------------------------------------------------------------------------------
+
+func matrix_testMessage(sender, body string) (event string) {
+
+	// Makes a Message Event of the Room.
+
+	return `{"type":"m.room.message","room_id":"` + matrix_testRoom + `","sender":"` + sender +
+		`","content":{"msgtype":"m.text","body":"` + body + `"}}`
+}
+
+//------------------------------------------------------------------------------
+
+func TestMatrixIncoming(t *testing.T) {
+
+	// Messages of the Room become Records of the Chat, once per Transaction.
+	// Echoes of the Bot and of Ghosts are ignored.
+
+	var last uint16
+	var rec tChatRecord
+
+	matrix_testBridge(t)
+
+	if matrix_testPut(t, "t0", "wrong", matrix_testMessage("@eve:example.org", "intruder")) != http.StatusForbidden {
+		t.Error("bad token is accepted")
+	}
+
+	last = chat_recordLastNum
+	if matrix_testPut(t, "t1", matrix_testHsToken, matrix_testMessage("@alice:example.org", "hello from matrix")) != http.StatusOK {
+		t.Fatal("transaction is refused")
+	}
+	if chat_recordLastNum != last+1 {
+		t.Fatalf("%d records are added, want 1", chat_recordLastNum-last)
+	}
+	rec = chatRecordsList[chat_recordLastNum]
+	if (rec.text != "hello from matrix") || (rec.origin != matrix_bridgeName) {
+		t.Errorf("record is %q from %q", rec.text, rec.origin)
+	}
+	if user_name(rec.author) != "alice ("+matrix_bridgeName+")" {
+		t.Errorf("puppet is named %q", user_name(rec.author))
+	}
+
+	// The Homeserver repeats a Transaction until it is confirmed
+	last = chat_recordLastNum
+	matrix_testPut(t, "t1", matrix_testHsToken, matrix_testMessage("@alice:example.org", "hello from matrix"))
+	if chat_recordLastNum != last {
+		t.Error("repeated transaction is processed again")
+	}
+
+	// Echoes
+	matrix_testPut(t, "t2", matrix_testHsToken,
+		matrix_testMessage("@"+matrix_bot_default+":example.org", "notice of the bot"),
+		matrix_testMessage("@"+matrix_prefix_default+"123:example.org", "message of a ghost"))
+	if chat_recordLastNum != last {
+		t.Error("echo is posted")
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestMatrixRelay(t *testing.T) {
+
+	// A Message of the Chat is sent into the Room by the Ghost of its Author,
+	// who is registered and joins the Room first.
+
+	var tests = []struct {
+		method string
+		path   string
+		as     string
+	}{
+		{http.MethodPost, "/_matrix/client/v3/join/", ""},
+		{http.MethodPost, "/_matrix/client/v3/register", ""},
+		{http.MethodPost, "/_matrix/client/v3/join/", "ghost"},
+		{http.MethodPut, "/_matrix/client/v3/profile/", "ghost"},
+		{http.MethodPut, "/_matrix/client/v3/rooms/" + url.PathEscape(matrix_testRoom) + "/send/m.room.message/", "ghost"},
+	}
+
+	var mb *tMatrixBridge
+	var hs *tTestHomeserver
+	var uid uint64
+	var ghost string
+	var msg tMatrixMessage
+	var i int
+
+	mb, hs = matrix_testBridge(t)
+	uid = harness_client(t, false).register("Heidi", "heidi-pwd")
+	ghost = "@" + matrix_prefix_default + strconv.FormatUint(uid, 10) + ":example.org"
+
+	mb.relay(1, tChatRecord{author: uid, kind: chat_recordKindUser, text: "hi, room", message: "hi, room"})
+
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	if len(hs.calls) != len(tests) {
+		t.Fatalf("%d calls, want %d: %v", len(hs.calls), len(tests), hs.calls)
+	}
+	for i = 0; i < len(tests); i++ {
+		if tests[i].as == "ghost" {
+			tests[i].as = ghost
+		}
+		if (hs.calls[i].method != tests[i].method) || !strings.HasPrefix(hs.calls[i].path, tests[i].path) ||
+			(hs.calls[i].as != tests[i].as) || (hs.calls[i].auth != "Bearer "+matrix_testAsToken) {
+			t.Errorf("call #%d is %s %s as %q, want %s %s as %q", i,
+				hs.calls[i].method, hs.calls[i].path, hs.calls[i].as, tests[i].method, tests[i].path, tests[i].as)
+		}
+	}
+	if !strings.Contains(hs.calls[1].body, `"username":"`+matrix_prefix_default+strconv.FormatUint(uid, 10)+`"`) {
+		t.Errorf("register: %s", hs.calls[1].body)
+	}
+	if (json.Unmarshal([]byte(hs.calls[4].body), &msg) != nil) || (msg.Body != "hi, room") || (msg.MsgType != "m.text") {
+		t.Errorf("send: %s", hs.calls[4].body)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,244 @@
+// metrics.go
+
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	Metrics in the Prometheus Text Format, served at "/metrics".
+
+	Counters are changed by many Go-Routines, so they are changed atomically
+	and read without any Manager. The Gauges are read at the Moment of the
+	Request.
+
+	If the "-mtok" Flag is set, the Scraper must send the Token:
+
+		Authorization: Bearer <Token>
+
+*/
+
+//------------------------------------------------------------------------------
+
+// Histogram of Durations
+type tHistogram struct {
+	buckets [len(metrics_buckets) + 1]uint64 // Last Bucket is "+Inf"
+	sum     uint64                           // Sum of Durations, in Nanoseconds
+}
+
+//------------------------------------------------------------------------------
+
+const path_metrics = "/metrics" // Page with Metrics
+
+const metrics_prefix = "saga_"
+
+//------------------------------------------------------------------------------
+
+// Upper Bounds of the Histogram's Buckets, in Seconds
+var metrics_buckets = [...]float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Names of Endpoints, by Action Number (see tServer.init)
+var metrics_endpoints = [srv_actionsCount]string{
+	path_news, path_send, path_activeList, path_index, path_chat, path_stat,
+	path_login, path_logout, path_register, path_asq, path_upload, path_file,
+	path_typing, path_presence, path_api, path_hook, path_matrix, path_search,
+	path_metrics, path_healthz, path_readyz, path_debug,
+}
+
+// Counters
+var metrics_messagesUser uint64   // Messages posted by Users
+var metrics_messagesSystem uint64 // System Records (Announcements)
+var metrics_asqIssued uint64
+var metrics_asqSolved uint64
+var metrics_asqFailed uint64  // Wrong Answers
+var metrics_asqExpired uint64 // Questions removed after their Timeout
+var metrics_asqLate uint64    // Answers after the Question has expired
+var metrics_loginBadRequest uint64
+var metrics_loginAsq uint64 // Wrong or outdated Answer to the Question
+var metrics_loginPassword uint64
+var metrics_loginAlready uint64
+var metrics_requests [srv_actionsCount]tHistogram
+
+// Internal Parameters
+var metrics_token string // Token of the Scraper, no Token is needed if empty
+
+//------------------------------------------------------------------------------
+
+func metrics_observe(actionNum uint8, d time.Duration) {
+
+	// Counts a served Request.
+
+	var h *tHistogram
+	var s float64
+	var i int
+
+	h = &metrics_requests[actionNum]
+	s = d.Seconds()
+	for i = 0; i < len(metrics_buckets); i++ {
+		if s <= metrics_buckets[i] {
+			break
+		}
+	}
+	atomic.AddUint64(&h.buckets[i], 1)
+	atomic.AddUint64(&h.sum, uint64(d.Nanoseconds()))
+}
+
+//------------------------------------------------------------------------------
+
+func page_metrics(w http.ResponseWriter, req *http.Request) {
+
+	// Serves the Metrics.
+
+	var buf *bufio.Writer
+	var h *tHistogram
+	var i, j int
+	var cumulative uint64
+	var wrapped int
+
+	if (len(metrics_token) > 0) &&
+		(subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(token_authPrefix+metrics_token)) != 1) {
+		w.Header().Set("WWW-Authenticate", strings.TrimSpace(token_authPrefix))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	buf = bufio.NewWriter(w)
+	defer buf.Flush()
+
+	// Messages
+	metrics_head(buf, "messages_posted_total", "counter", "Records added to the Chat.")
+	metrics_value(buf, "messages_posted_total", `kind="user"`, atomic.LoadUint64(&metrics_messagesUser))
+	metrics_value(buf, "messages_posted_total", `kind="system"`, atomic.LoadUint64(&metrics_messagesSystem))
+
+	// Sessions
+	metrics_head(buf, "active_sessions", "gauge", "Logged-in Users.")
+	metrics_value(buf, "active_sessions", "", uint64(len(activeClientsList)))
+
+	// Anti-Spam Questions
+	metrics_head(buf, "asq_issued_total", "counter", "Anti-Spam Questions given to Clients.")
+	metrics_value(buf, "asq_issued_total", "", atomic.LoadUint64(&metrics_asqIssued))
+	metrics_head(buf, "asq_solved_total", "counter", "Right Answers in Time.")
+	metrics_value(buf, "asq_solved_total", "", atomic.LoadUint64(&metrics_asqSolved))
+	metrics_head(buf, "asq_failed_total", "counter", "Wrong Answers.")
+	metrics_value(buf, "asq_failed_total", "", atomic.LoadUint64(&metrics_asqFailed))
+	metrics_head(buf, "asq_expired_total", "counter", "Questions removed after their Timeout.")
+	metrics_value(buf, "asq_expired_total", "", atomic.LoadUint64(&metrics_asqExpired))
+	metrics_head(buf, "asq_late_total", "counter", "Answers after the Question has expired.")
+	metrics_value(buf, "asq_late_total", "", atomic.LoadUint64(&metrics_asqLate))
+
+	// Log-In
+	metrics_head(buf, "login_failures_total", "counter", "Failed Log-In Attempts.")
+	metrics_value(buf, "login_failures_total", `reason="bad_request"`, atomic.LoadUint64(&metrics_loginBadRequest))
+	metrics_value(buf, "login_failures_total", `reason="asq"`, atomic.LoadUint64(&metrics_loginAsq))
+	metrics_value(buf, "login_failures_total", `reason="password"`, atomic.LoadUint64(&metrics_loginPassword))
+	metrics_value(buf, "login_failures_total", `reason="already_logged_in"`, atomic.LoadUint64(&metrics_loginAlready))
+
+	// Requests
+	metrics_head(buf, "http_request_duration_seconds", "histogram",
+		"Time from the Request until the Reply, with the Time in the Queue.")
+	for i = 0; i < srv_actionsCount; i++ {
+		h = &metrics_requests[i]
+		cumulative = 0
+		for j = 0; j < len(metrics_buckets); j++ {
+			cumulative += atomic.LoadUint64(&h.buckets[j])
+			metrics_value(buf, "http_request_duration_seconds_bucket",
+				`endpoint="`+metrics_endpoints[i]+`",le="`+strconv.FormatFloat(metrics_buckets[j], 'g', -1, 64)+`"`,
+				cumulative)
+		}
+		cumulative += atomic.LoadUint64(&h.buckets[len(metrics_buckets)])
+		metrics_value(buf, "http_request_duration_seconds_bucket", `endpoint="`+metrics_endpoints[i]+`",le="+Inf"`, cumulative)
+		fmt.Fprintf(buf, "%shttp_request_duration_seconds_sum{endpoint=%q} %g\n", metrics_prefix,
+			metrics_endpoints[i], float64(atomic.LoadUint64(&h.sum))/float64(time.Second))
+		metrics_value(buf, "http_request_duration_seconds_count", `endpoint="`+metrics_endpoints[i]+`"`, cumulative)
+	}
+
+	// Ring of Chat Records
+	if !firstCircle {
+		wrapped = 1
+	}
+	metrics_head(buf, "chat_ring_size", "gauge", "Capacity of the List of Chat Records.")
+	metrics_value(buf, "chat_ring_size", "", chat_recordsMaxLast+1)
+	metrics_head(buf, "chat_ring_first", "gauge", "Index of the first actual Record.")
+	metrics_value(buf, "chat_ring_first", "", uint64(chat_recordFirstNum))
+	metrics_head(buf, "chat_ring_last", "gauge", "Index of the last Record.")
+	metrics_value(buf, "chat_ring_last", "", uint64(chat_recordLastNum))
+	metrics_head(buf, "chat_ring_wrapped", "gauge", "1 if old Records are being re-written.")
+	metrics_value(buf, "chat_ring_wrapped", "", uint64(wrapped))
+	metrics_head(buf, "chat_ring_wraps_total", "counter", "Full Circles of the List of Chat Records.")
+	metrics_value(buf, "chat_ring_wraps_total", "", atomic.LoadUint64(&chat_ringWraps))
+
+	// Queues of Managers
+	metrics_head(buf, "queue_length", "gauge", "Jobs waiting in the Channel of a Manager.")
+	metrics_queues(buf, false)
+	metrics_head(buf, "queue_capacity", "gauge", "Buffer Length of the Channel of a Manager.")
+	metrics_queues(buf, true)
+}
+
+//------------------------------------------------------------------------------
+
+func metrics_queues(buf *bufio.Writer, capacity bool) {
+
+	// Writes the Length or the Capacity of each Manager's Channel.
+
+	var names []string
+	var lens, caps []int
+	var i int
+
+	names = []string{"serverJobsChan", "chatManagerChan", "loginManagerChan", "registerManagerChan",
+		"activeManagerChan", "asqManagerChan", "announceChan", "webhookChan", "searchManagerChan"}
+	lens = []int{len(serverJobsChan), len(chatManagerChan), len(loginManagerChan), len(registerManagerChan),
+		len(activeManagerChan), len(asqManagerChan), len(announceChan), len(webhookChan), len(searchManagerChan)}
+	caps = []int{cap(serverJobsChan), cap(chatManagerChan), cap(loginManagerChan), cap(registerManagerChan),
+		cap(activeManagerChan), cap(asqManagerChan), cap(announceChan), cap(webhookChan), cap(searchManagerChan)}
+
+	// Each Bridge has its own Channel
+	for i = 0; i < len(bridgeList); i++ {
+		names = append(names, "bridge_"+bridgeList[i].name())
+		lens = append(lens, len(bridgeChans[i]))
+		caps = append(caps, cap(bridgeChans[i]))
+	}
+
+	for i = 0; i < len(names); i++ {
+		if capacity {
+			metrics_value(buf, "queue_capacity", `channel="`+names[i]+`"`, uint64(caps[i]))
+		} else {
+			metrics_value(buf, "queue_length", `channel="`+names[i]+`"`, uint64(lens[i]))
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func metrics_head(buf *bufio.Writer, name, kind, help string) {
+
+	// Writes the Description of a Metric.
+
+	fmt.Fprintf(buf, "# HELP %s%s %s\n# TYPE %s%s %s\n", metrics_prefix, name, help, metrics_prefix, name, kind)
+}
+
+//------------------------------------------------------------------------------
+
+func metrics_value(buf *bufio.Writer, name, labels string, value uint64) {
+
+	// Writes a Value of a Metric.
+
+	if len(labels) > 0 {
+		fmt.Fprintf(buf, "%s%s{%s} %d\n", metrics_prefix, name, labels, value)
+	} else {
+		fmt.Fprintf(buf, "%s%s %d\n", metrics_prefix, name, value)
+	}
+}
+
+//------------------------------------------------------------------------------
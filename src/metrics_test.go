@@ -0,0 +1,67 @@
+// metrics_test.go
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func TestMetricsToken(t *testing.T) {
+
+	// With a Token only the Scraper which sends it gets the Metrics.
+
+	var tests = []struct {
+		name   string
+		auth   string
+		status int
+	}{
+		{"no header", "", http.StatusUnauthorized},
+		{"wrong token", token_authPrefix + "wrong", http.StatusUnauthorized},
+		{"prefix of the token", token_authPrefix + "scrape", http.StatusUnauthorized},
+		{"token without the scheme", "scraper-token", http.StatusUnauthorized},
+		{"token", token_authPrefix + "scraper-token", http.StatusOK},
+	}
+
+	var req *http.Request
+	var resp *http.Response
+	var body []byte
+	var i int
+	var err error
+
+	metrics_token = "scraper-token"
+	t.Cleanup(func() {
+		metrics_token = ""
+	})
+
+	for i = 0; i < len(tests); i++ {
+
+		req, err = http.NewRequest(http.MethodGet, harness_server.URL+path_metrics, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tests[i].auth) > 0 {
+			req.Header.Set("Authorization", tests[i].auth)
+		}
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tests[i].status {
+			t.Errorf("%s: status %d, want %d", tests[i].name, resp.StatusCode, tests[i].status)
+		}
+		if (resp.StatusCode == http.StatusOK) &&
+			(!strings.Contains(string(body), "\nsaga_asq_expired_total ") || !strings.Contains(string(body), "\nsaga_asq_late_total ")) {
+			t.Errorf("%s: no counters of questions", tests[i].name)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
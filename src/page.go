@@ -5,12 +5,12 @@ package main
 import (
 	"encoding/base64"
 	"fmt"
-	"html"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 )
@@ -25,12 +25,19 @@ func page_delta(w http.ResponseWriter, req *http.Request) {
 	// Client sends a Request as a 'application/x-www-form-urlencoded'.
 	// A standard Parser decodes the Request.
 
-	// Server replies to client one of the following:
+	// Server replies to client (see reply_code, reply_delta) one of the
+	// following:
 	//		1. code_NotLoggedIn ('L'),
 	//		2. code_BadPOSTdata ('X'),
 	//		3. code_BadRequest ('B'),
 	//		4. JSON (new_messages),
-	//		5. nothing ('').
+	//		5. code_NoNews ('N'), only in the legacy Format.
+	// JSON may also be sent without new Messages when other Users are typing.
+
+	// Client may report the last Message it has shown to the User in the
+	// "rd" Parameter. This is the User's Read Mark seen by other Users.
+	// Client may also report its average Ping in the "png" Parameter, for
+	// the Statistics (see page_stat).
 
 	var req_mid uint16 // Requested "mid"
 	// ID of the last seen Message or of the last Message before Log-In
@@ -41,80 +48,145 @@ func page_delta(w http.ResponseWriter, req *http.Request) {
 	var log_mid uint16 // Client's "mid"
 	var log_ts int64   // Client's "ts"
 
-	var i uint16
-	var ok bool
-	var err, err2 error
-	var req_mid_str, req_ts_str string
-	var req_mid_uint64, req_ts_uint64 uint64
+	var uid uint64
+	var rcvChan chan tActiveJob
+	var activeJob *tActiveJob
 
-	var author, time_str, msg string
-	var outMsgFirst, outMsgLast uint16 // Indexes of Messages which to give the Client
+	var ok, unknown bool
+	var err error
+	var req_mid_str, req_ts_str, req_read_str string
+	var req_read_uint64, req_ping_uint64 uint64
+
+	var reply tApiDelta
 
 	// Correct Cookies & Not Idle ?  & update User's Last Activity Time
-	ok, _, log_mid, log_ts = user_check(w, req)
+	ok, uid, log_mid, log_ts = user_check(w, req)
 
 	// Logged in ?
 	if !ok {
-		fmt.Fprint(w, code_NotLoggedIn) // Not Logged In
+		reply_code(w, req, code_NotLoggedIn) // Not Logged In
 		return
 	}
 
 	// Reading Client's Request
 	err = req.ParseForm()
 	if err != nil {
-		log.Println("Error Reading POST Form:", err) //
-		fmt.Fprint(w, code_BadPOSTdata)              // POST Error
+		log_warn(log_rid(req), "Error Reading POST Form", "err", err) //
+		reply_code(w, req, code_BadPOSTdata)                          // POST Error
 		ok = false
 		return
 	}
 	req_mid_str = req.PostFormValue(param_req_mid)
 	req_ts_str = req.PostFormValue(param_req_ts)
+	req_read_str = req.PostFormValue(param_req_read)
 
-	// Empty LMS
-	if (len(req_mid_str) == 0) || (len(req_ts_str) == 0) {
-		log.Println("Empty Request.")  //
-		fmt.Fprint(w, code_BadRequest) // Bad Request
+	// Ping, optional
+	req_ping_uint64, err = strconv.ParseUint(req.PostFormValue(param_req_ping), 10, 64)
+	if err == nil {
+		stat_ping(req_ping_uint64)
+	}
+
+	// Decoding the Cursor
+	req_mid, req_ts, unknown, ok = delta_parse(req_mid_str, req_ts_str)
+	if !ok {
+		log_warn(log_rid(req), "Bad Request", "mid", req_mid_str, "ts", req_ts_str) //
+		reply_code(w, req, code_BadRequest)                                         // Bad Request
 		return
 	}
 
 	// Known or un-Known ?
-	if (req_mid_str == param_unknownVal) || (req_ts_str == param_unknownVal) { // 'X'
+	if unknown {
 
 		// If Client does not know, then tell him Values (No Messages are sent).
-		fmt.Fprint(w, "{\"messages\":[], \"x\":{\"", param_req_mid, "\":\"",
-			log_mid, "\", \"", param_req_ts, "\":\"", log_ts, "\"} }")
+		reply.X.Mid = log_mid
+		reply.X.Ts = log_ts
+		reply_delta(w, req, &reply)
 		return
 	}
 
-	// Requested "mid" and "ts" are known, are supposed to be numeric
-	req_mid_uint64, err = strconv.ParseUint(req_mid_str, 10, 64)
-	req_ts_uint64, err2 = strconv.ParseUint(req_ts_str, 10, 64)
-	if (err != nil) || (err2 != nil) {
-		log.Println("Bad Request:", err, err2) //
-		fmt.Fprint(w, code_BadRequest)         // Bad Request
-		return
+	rcvChan = make(chan tActiveJob)
+	activeJob = new(tActiveJob)
+	activeJob.rid = log_rid(req)
+	activeJob.uid = uid
+	activeJob.returnChannel = rcvChan
+
+	// Read Mark, optional
+	req_read_uint64, err = strconv.ParseUint(req_read_str, 10, 16)
+	if err == nil {
+		activeJob.action = activeJobSetRead // Set Read Mark
+		activeJob.client.read_mid = uint16(req_read_uint64)
+		activeManagerChan <- *activeJob
+		*activeJob = <-rcvChan
 	}
-	req_mid = uint16(req_mid_uint64)
-	req_ts = int64(req_ts_uint64)
 
-	// Any News?
-	if chat_recordLastTimestamp < req_ts {
+	// Who is typing ?
+	activeJob.action = activeJobGetTyping // Get typing Users
+	activeManagerChan <- *activeJob
+	*activeJob = <-rcvChan
+	reply.Typing = activeJob.names
 
-		fmt.Fprint(w, code_NoNews) // No News
+	if req_ts < chatRecordsList[log_mid].time {
+		log_warn(log_rid(req), "Bad Request: req_ts is out of Range", "ts", req_ts) //
+		reply_code(w, req, code_BadRequest)                                         // Bad Request
 		return
 	}
 
-	if req_mid > chat_recordsMaxLast { // no Sub-Zero Check as uint is always > 0.
+	delta_fill(&reply, req_mid, req_ts, log_rid(req))
 
-		log.Println("Bad Request: req_mid is out of Range.") //
-		fmt.Fprint(w, code_BadRequest)                       // Bad Request
-		return
+	reply_delta(w, req, &reply)
+}
+
+//------------------------------------------------------------------------------
+
+func delta_parse(mid_str, ts_str string) (mid uint16, ts int64, unknown, ok bool) {
+
+	// Decodes the Cursor of a Delta Request.
+	// "unknown" is set when the Client does not know its Cursor yet ('X').
+	// Any other Value must be a Number in the Range of its Type.
+
+	var mid_uint64 uint64
+	var err error
+
+	// Empty LMS
+	if (len(mid_str) == 0) || (len(ts_str) == 0) {
+		return 0, 0, false, false
 	}
 
-	if req_ts < chatRecordsList[log_mid].time {
-		log.Println("Bad Request: req_ts is out of Range.") //
-		fmt.Fprint(w, code_BadRequest)                      // Bad Request
-		return
+	if (mid_str == param_unknownVal) || (ts_str == param_unknownVal) {
+		return 0, 0, true, true
+	}
+
+	mid_uint64, err = strconv.ParseUint(mid_str, 10, 16)
+	if err != nil {
+		return 0, 0, false, false
+	}
+	ts, err = strconv.ParseInt(ts_str, 10, 64)
+	if (err != nil) || (ts < 0) {
+		return 0, 0, false, false
+	}
+
+	return uint16(mid_uint64), ts, false, true
+}
+
+//------------------------------------------------------------------------------
+
+func delta_fill(reply *tApiDelta, req_mid uint16, req_ts int64, rid string) {
+
+	// Puts into the Reply all Messages which the Client has not seen yet,
+	// and the new "mid" & "ts" of the Client.
+	// If there are no new Messages, the Client keeps its "mid" and "ts".
+
+	var i uint16
+	var outMsgFirst, outMsgLast uint16 // Indexes of Messages which to give the Client
+
+	// No News: Client keeps its "mid" and "ts"
+	reply.X.Mid = req_mid
+	reply.X.Ts = req_ts
+	reply.noNews = true
+
+	// Any News?
+	if chat_recordLastTimestamp < req_ts {
+		return // No News
 	}
 
 	if req_ts < chat_recordFirstTimestamp {
@@ -130,41 +202,23 @@ func page_delta(w http.ResponseWriter, req *http.Request) {
 
 			// Client is non-synchronized or crazy. Or it is a cool h4X0R...
 			// We do not reject even crazy Clients :D
-			log.Println("Synchronizing crazy Client...") //
-			fmt.Fprint(w, "{\"messages\":[], \"x\":{\"", param_req_mid, "\":\"",
-				req_mid, "\", \"", param_req_ts, "\":\"",
-				chatRecordsList[req_mid].time, "\"} }")
+			log_debug(rid, "Synchronizing crazy Client", "mid", req_mid, "ts", req_ts) //
+			reply.X.Ts = chatRecordsList[req_mid].time
+			reply.noNews = false
 			return
 		}
 
 		// Simple Situation
+		if req_mid == chat_recordLastNum {
+			return // No News
+		}
 		outMsgFirst = req_mid + 1
 		outMsgLast = chat_recordLastNum
+		// After the first Circle "outMsgFirst" may be greater than
+		// "outMsgLast", the Loop below goes through the Overflow.
 
 	}
 
-	if outMsgLast < outMsgFirst {
-
-		fmt.Fprint(w, code_NoNews) // No News
-		return
-	}
-
-	// Writing in JSON Format
-	/*
-		{
-		 "messages":
-				[
-					{"mid":"123", "tim":"00", "atr":"Вася", "txt":"AU8Xv745cd=="},
-					{"mid":"124", "tim":"00", "atr":"Петя", "txt":"BU8Xv745cd=="},
-					{"mid":"125", "tim":"00", "atr":"Коля", "txt":"CU8Xv745cd=="}
-				],
-		 "x":
-				{"mid":"125", "ts":"1234567"}
-		}
-	*/
-
-	fmt.Fprint(w, "{\"messages\": [")
-
 	/*
 
 		Notes:
@@ -178,43 +232,27 @@ func page_delta(w http.ResponseWriter, req *http.Request) {
 		to ask for new Messages once in a Year, it may happen... but in
 		this case this Web Chat has no Sense at all :D
 
-		2. "userDataList" is thread-safe to read because it is not modified.
+		2. "userDataList" gets new Users, Bots and Puppets of Bridges while
+		it is read, so it is read through "user_get" and "user_name".
 
 	*/
 
 	i = outMsgFirst
 	for {
 
-		// Write all except the last Message
-		if i >= outMsgLast {
+		reply.Messages = append(reply.Messages, api_message(i))
+
+		// The Counter may overflow, so the Check is done before Increment
+		if i == outMsgLast {
 			break
 		}
-
-		time_str = time.Unix(chatRecordsList[i].time, 0).Format("15:04:05")
-		author = base64.StdEncoding.EncodeToString([]byte(userDataList[chatRecordsList[i].author].name))
-		msg = base64.StdEncoding.EncodeToString([]byte(chatRecordsList[i].message))
-
-		fmt.Fprintf(w, "{\"mid\":\"%d\",\"tim\":\"%s\",\"atr\":\"%s\",\"txt\":\"%s\"},",
-			i, time_str, author, msg)
-
 		i++
-
-	}
-
-	// Write last Message; last element, without ","
-	if i == outMsgLast {
-
-		time_str = time.Unix(chatRecordsList[i].time, 0).Format("15:04:05")
-		author = base64.StdEncoding.EncodeToString([]byte(userDataList[chatRecordsList[i].author].name))
-		msg = base64.StdEncoding.EncodeToString([]byte(chatRecordsList[i].message))
-
-		fmt.Fprintf(w, "{\"mid\":\"%d\",\"tim\":\"%s\",\"atr\":\"%s\",\"txt\":\"%s\"}",
-			i, time_str, author, msg)
 	}
 
 	// Updated "mid" & "ts"
-	fmt.Fprint(w, "], \"x\":{\"", param_req_mid, "\":\"",
-		chat_recordLastNum, "\", \"", param_req_ts, "\":\"", chat_recordLastTimestamp, "\"} }")
+	reply.X.Mid = chat_recordLastNum
+	reply.X.Ts = chat_recordLastTimestamp
+	reply.noNews = false
 }
 
 //------------------------------------------------------------------------------
@@ -242,67 +280,47 @@ func page_send(w http.ResponseWriter, req *http.Request) {
 	var uid uint64
 	var reqBody []byte
 	var err error
-	var reqBody_str, p1, p2, txt_safe string
-	var spaceIndex int
-	var p1_int64 int64
+	var text, txt_html, code string
 	var chatJob *tChatJob
 	var rcvChan chan tChatJob
 
 	// Correct Cookies & Not Idle ?  & update User's Last Activity Time
 	ok, uid, _, _ = user_check(w, req)
 	if !ok {
-		fmt.Fprint(w, code_NotLoggedIn) // Error: Not Logged In or Idle
+		reply_code(w, req, code_NotLoggedIn) // Error: Not Logged In or Idle
 		return
 	}
 
 	// Reading Client's message
+	// Too long Messages still get code_msgTooLong, much longer Bodies are cut
+	req.Body = http.MaxBytesReader(w, req.Body, msgMaxSize*2+32)
 	reqBody, err = ioutil.ReadAll(req.Body)
 	if err != nil {
-		log.Printf("Error reading body: %v", err)
-		fmt.Fprint(w, code_BadPOSTdata) // Error in Data
+		log_warn(log_rid(req), "Error reading body", "err", err) //
+		reply_code(w, req, code_BadPOSTdata)                     // Error in Data
 		return
 	}
 
-	// Empty Body ?
-	if reqBody == nil {
-		log.Printf("nil body. header=[%s].", req.Header) //
-		fmt.Fprint(w, code_BadPOSTdata)                  // Error in Data
-	}
-
 	// Decoding Contents
-	reqBody_str = string(reqBody)
-	spaceIndex = strings.Index(reqBody_str, " ")
-	p1 = reqBody_str[0:spaceIndex]
-	p2 = reqBody_str[spaceIndex+1:]
-	p1_int64, err = strconv.ParseInt(p1, 10, 64)
-	if err != nil {
-		log.Printf("Error reading body: %v", err)
-		fmt.Fprint(w, code_BadPOSTdata) // Error in Data
-		return
-	}
-
-	// Message's Length ?
-	if len(p2) > msgMaxSize {
-		log.Printf("Too long Message, %d Bytes.", len(p2))
-		fmt.Fprint(w, code_msgTooLong) // Too long Message
-		return
-	}
-
-	// Size Match
-	if utf8.RuneCountInString(p2) != int(p1_int64) {
-		log.Print("Size does not match.")
-		fmt.Fprint(w, code_BadPOSTdata) // The Size does not match the Contents!
+	text, code = send_parse(string(reqBody))
+	if len(code) > 0 {
+		if code != code_EmptyMessage {
+			log_warn(log_rid(req), "Bad Message", "code", code, "bytes", len(reqBody)) //
+		}
+		reply_code(w, req, code)
 		return
 	}
 
-	// HTML safe Text
-	txt_safe = html.EscapeString(p2)
+	// HTML safe Text, the raw Text is kept as well
+	txt_html = markup_render(text)
 
 	// Create Job for ChatManager
 	rcvChan = make(chan tChatJob)
 	chatJob = new(tChatJob)
+	chatJob.rid = log_rid(req)
 	chatJob.chatRecord.author = uid
-	chatJob.chatRecord.message = txt_safe
+	chatJob.chatRecord.message = txt_html
+	chatJob.chatRecord.text = text
 	chatJob.returnChannel = rcvChan
 
 	// Send Job
@@ -311,8 +329,163 @@ func page_send(w http.ResponseWriter, req *http.Request) {
 	// Wait for Manager
 	*chatJob = <-rcvChan
 
-	fmt.Fprint(w, code_messageSent) // OK, Message is Sent
+	// User is not "away" any more
+	activeList_setInput(uid, log_rid(req))
+
+	reply_code(w, req, code_messageSent) // OK, Message is Sent
+
+}
+
+//------------------------------------------------------------------------------
+
+func send_parse(body string) (text string, code string) {
+
+	// Decodes the Body of a Send Request: "<Letters Count> <Text>".
+	// Gives the Text, or the Code of the Error for the Client.
+
+	var spaceIndex int
+	var count int64
+	var err error
+
+	spaceIndex = strings.IndexByte(body, ' ')
+	if spaceIndex < 0 {
+		return "", code_BadPOSTdata // No Count
+	}
+	count, err = strconv.ParseInt(body[:spaceIndex], 10, 64)
+	if err != nil {
+		return "", code_BadPOSTdata // Bad Count
+	}
+	text = body[spaceIndex+1:]
+
+	// Empty Message ?
+	if len(text) == 0 {
+		return "", code_EmptyMessage
+	}
+
+	// Message's Length ?
+	if len(text) > msgMaxSize {
+		return "", code_msgTooLong
+	}
+
+	// Letters are UTF-8, and their Count must match the Contents
+	if !utf8.ValidString(text) || (int64(utf8.RuneCountInString(text)) != count) {
+		return "", code_BadPOSTdata
+	}
+
+	return text, ""
+}
+
+//------------------------------------------------------------------------------
+
+func page_typing(w http.ResponseWriter, req *http.Request) {
+
+	// Processes and serves User's Signal that the User is typing a Message.
+
+	// Client sends an empty GET Request, not more often than once in
+	// typingInterval_str Seconds.
+
+	// Server replies to client one of the following:
+	//		1. code_NotLoggedIn ('L'),
+	//		2. code_messageSent ('O').
+
+	var ok bool
+	var uid uint64
+	var rcvChan chan tActiveJob
+	var activeJob *tActiveJob
+
+	// Correct Cookies & Not Idle ?  & update User's Last Activity Time
+	ok, uid, _, _ = user_check(w, req)
+	if !ok {
+		reply_code(w, req, code_NotLoggedIn) // Not Logged In
+		return
+	}
+
+	// Create Job
+	rcvChan = make(chan tActiveJob)
+	activeJob = new(tActiveJob)
+	activeJob.rid = log_rid(req)
+	activeJob.action = activeJobSetTyping // Typing
+	activeJob.uid = uid
+	activeJob.returnChannel = rcvChan
+
+	// Send Job
+	activeManagerChan <- *activeJob
+
+	// Get Feedback
+	*activeJob = <-rcvChan
+
+	reply_code(w, req, code_messageSent) // OK
+}
+
+//------------------------------------------------------------------------------
+
+func page_presence(w http.ResponseWriter, req *http.Request) {
+
+	// Processes and serves User's Request to change the Presence and Status.
+
+	// Client sends a Request as a 'application/x-www-form-urlencoded' with
+	// Presence ("online" or "dnd") and Status Text.
+
+	// Server replies to client one of the following:
+	//		1. code_NotLoggedIn ('L'),
+	//		2. code_BadPOSTdata ('X'),
+	//		3. code_msgTooLong ('M'),
+	//		4. code_messageSent ('O').
+
+	var ok bool
+	var uid uint64
+	var err error
+	var presence, status string
+	var rcvChan chan tActiveJob
+	var activeJob *tActiveJob
+
+	// Correct Cookies & Not Idle ?  & update User's Last Activity Time
+	ok, uid, _, _ = user_check(w, req)
+	if !ok {
+		reply_code(w, req, code_NotLoggedIn) // Not Logged In
+		return
+	}
+
+	// Reading Client's Request
+	err = req.ParseForm()
+	if err != nil {
+		log_warn(log_rid(req), "Error Reading POST Form", "err", err) //
+		reply_code(w, req, code_BadPOSTdata)                          // POST Error
+		return
+	}
+	presence = req.PostFormValue(param_presence)
+	status = req.PostFormValue(param_status)
+
+	if utf8.RuneCountInString(status) > status_maxLen {
+		reply_code(w, req, code_msgTooLong) // Too long Status
+		return
+	}
+
+	// Create Job
+	rcvChan = make(chan tActiveJob)
+	activeJob = new(tActiveJob)
+	activeJob.rid = log_rid(req)
+	activeJob.action = activeJobSetPresence // Presence
+	activeJob.uid = uid
+	activeJob.client.status = status
+	activeJob.returnChannel = rcvChan
+
+	if presence == presence_dnd {
+		activeJob.client.presence = presenceDnd
+	} else if presence == presence_online {
+		activeJob.client.presence = presenceOnline
+	} else {
+		reply_code(w, req, code_BadPOSTdata) // Unknown Presence
+		return
+	}
+
+	// Send Job
+	activeManagerChan <- *activeJob
 
+	// Get Feedback
+	*activeJob = <-rcvChan
+
+	reply_code(w, req, code_messageSent) // OK
 }
 
 //------------------------------------------------------------------------------
@@ -326,7 +499,7 @@ func page_activeList(w http.ResponseWriter, req *http.Request) {
 
 	// Server replies to client one of the following:
 	//		1. code_NotLoggedIn ('L'),
-	//		2. JSON (list_of_active_clients).
+	//		2. JSON (list_of_active_clients, their read Marks, Presence).
 
 	var ok bool
 	var rcvChan chan tActiveJob
@@ -338,7 +511,7 @@ func page_activeList(w http.ResponseWriter, req *http.Request) {
 
 	// Logged in ?
 	if !ok {
-		fmt.Fprint(w, code_NotLoggedIn) // Not Logged In
+		reply_code(w, req, code_NotLoggedIn) // Not Logged In
 		return
 	}
 
@@ -346,6 +519,7 @@ func page_activeList(w http.ResponseWriter, req *http.Request) {
 	// Create Job
 	rcvChan = make(chan tActiveJob)
 	activeJob = new(tActiveJob)
+	activeJob.rid = log_rid(req)
 	activeJob.action = activeJobGetList // Get List
 	activeJob.returnChannel = rcvChan
 
@@ -356,7 +530,7 @@ func page_activeList(w http.ResponseWriter, req *http.Request) {
 	*activeJob = <-rcvChan
 
 	// Send to Client
-	fmt.Fprint(w, activeJob.client.address) // List was packed into "address"
+	reply_users(w, req, activeJob.client.address, activeJob.list_v1) // List was packed into "address"
 
 }
 
@@ -367,18 +541,20 @@ func page_index(w http.ResponseWriter, req *http.Request) {
 	// Processes and serves User's Request of Index Page.
 
 	var ok bool
+	var lang string
+
+	lang = i18n_lang(w, req)
 
 	// Correct Cookies & Not Idle ?  & update User's Last Activity Time
 	ok, _, _, _ = user_check(w, req)
 
 	if ok {
 		// User is logged-in (cookie matches) & active
-		fmt.Fprintf(w, "%sYou are already logged in.<br>This page refreshes in %s seconds.<br>Click <a href='%s'>here</a> to proceed, if your web browser does not support redirects.%s",
-			html_1_toChat, redirectDelay_str, path_chat, html_2) //
+		i18n_reply(w, lang, html_1_toChat, path_chat, "already_loggedIn", "refresh", "link_proceed")
 		return
 	}
 
-	fmt.Fprint(w, tpl_index)
+	reply_page(w, req, tpl_index[lang], tpl_indexGzip[lang])
 }
 
 //------------------------------------------------------------------------------
@@ -388,27 +564,68 @@ func page_chat(w http.ResponseWriter, req *http.Request) {
 	// Process and serve User's Request of Chat Page.
 
 	var ok bool
+	var lang string
+
+	lang = i18n_lang(w, req)
 
 	// Correct Cookies & Not Idle ?  & update User's Last Activity Time
 	ok, _, _, _ = user_check(w, req)
 
 	if !ok {
-		fmt.Fprintf(w, "%sCan not enter the Chat.<br>If your previous Session has not been properly closed, then, please, wait for it to be automatically terminated.<br>Click <a href='%s'>here</a> to return to index Page.%s",
-			html_1, path_index, html_2) //
+		i18n_reply(w, lang, html_1, path_index, "chat_cantEnter", "chat_cantEnterHint", "link_index")
 		return
 	}
 
-	fmt.Fprint(w, tpl_chat)
+	reply_page(w, req, tpl_chat[lang], tpl_chatGzip[lang])
+}
+
+//------------------------------------------------------------------------------
+
+func reply_page(w http.ResponseWriter, req *http.Request, page string, packed []byte) {
+
+	// Sends a Page, compressed if the Browser accepts it. The Chat Page is
+	// several Times smaller so.
+
+	w.Header().Set("Content-Type", api_contentHTML)
+	w.Header().Add("Vary", "Accept-Encoding")
+	if (len(packed) > 0) && page_acceptsGzip(req.Header.Get("Accept-Encoding")) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(packed)
+		return
+	}
 
+	io.WriteString(w, page)
 }
 
 //------------------------------------------------------------------------------
 
-func page_stat(w http.ResponseWriter, req *http.Request) {
+func page_acceptsGzip(header string) (accepts bool) {
 
-	// Statistics of the Server.
+	// Checks whether the "Accept-Encoding" Header has "gzip" without a zero
+	// Weight.
 
-	fmt.Fprint(w, tpl_registeredUsersList) //
+	var part, name, weight string
+	var pos int
+
+	for _, part = range strings.Split(header, ",") {
+		name = part
+		weight = ""
+		pos = strings.Index(part, ";")
+		if pos >= 0 {
+			name = part[:pos]
+			weight = strings.ReplaceAll(part[pos+1:], " ", "")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "gzip" {
+			continue
+		}
+		if strings.HasPrefix(weight, "q=0") && (strings.Trim(weight[3:], ".0") == "") {
+			continue // Not acceptable
+		}
+		return true
+	}
+
+	return false
 }
 
 //------------------------------------------------------------------------------
@@ -432,13 +649,16 @@ func page_login(w http.ResponseWriter, req *http.Request) {
 	var delay int64
 	var ok bool
 	var sid uint32
+	var lang string
+
+	lang = i18n_lang(w, req)
 
 	// Parse Form
 	err = req.ParseForm()
 	if err != nil {
-		log.Println("Error Reading POST Form:", err) //
-		fmt.Fprintf(w, "%sBad POST Data. If this error repeats, contact the administrator of this chat.%s",
-			html_1, html_2) //
+		log_warn(log_rid(req), "Error Reading POST Form", "err", err) //
+		atomic.AddUint64(&metrics_loginBadRequest, 1)
+		i18n_reply(w, lang, html_1, "", "bad_postData")
 		return
 	}
 
@@ -451,27 +671,27 @@ func page_login(w http.ResponseWriter, req *http.Request) {
 	// Check UID
 	uid, err = strconv.ParseUint(uid_str, 10, 64)
 	if err != nil {
-		log.Println("Bad UID:", err) //
-		fmt.Fprintf(w, "%sError. UID is bad.<br>Click <a href='%s'>here</a> to return to main page. %s",
-			html_1, path_index, html_2) //
+		log_warn(log_rid(req), "Bad UID", "err", err) //
+		atomic.AddUint64(&metrics_loginBadRequest, 1)
+		i18n_reply(w, lang, html_1, path_index, "login_badUid", "link_index")
 		return
 	}
 
 	// Check QID
 	qid, err = strconv.ParseUint(qid_str, 10, 64)
 	if err != nil {
-		log.Println("Error in QID.", err) //
-		fmt.Fprintf(w, "%sLogging failed.<br>Click <a href='%s'>here</a> to return to main Page.%s",
-			html_1, path_index, html_2) //
+		log_warn(log_rid(req), "Error in QID", "err", err) //
+		atomic.AddUint64(&metrics_loginBadRequest, 1)
+		i18n_reply(w, lang, html_1, path_index, "login_failed", "link_index")
 		return
 	}
 
 	// Check QA
 	qa_uint64, err = strconv.ParseUint(qa_str, 10, 64)
 	if err != nil {
-		log.Println("Error in QAnswer.", err) //
-		fmt.Fprintf(w, "%sLogging failed.<br>Click <a href='%s'>here</a> to return to main Page.%s",
-			html_1, path_index, html_2) //
+		log_warn(log_rid(req), "Error in QAnswer", "err", err) //
+		atomic.AddUint64(&metrics_loginBadRequest, 1)
+		i18n_reply(w, lang, html_1, path_index, "login_failed", "link_index")
 		return
 	}
 	qa = uint8(qa_uint64)
@@ -479,9 +699,9 @@ func page_login(w http.ResponseWriter, req *http.Request) {
 	// Check Anti-Spam Answer
 	_, exists = asqsList[qid]
 	if !exists {
-		log.Println("UnExisting QID!") //
-		fmt.Fprintf(w, "%sLogging failed.<br>Click <a href='%s'>here</a> to return to main Page.%s",
-			html_1, path_index, html_2) //
+		log_warn(log_rid(req), "UnExisting QID", "qid", qid) //
+		atomic.AddUint64(&metrics_loginAsq, 1)
+		i18n_reply(w, lang, html_1, path_index, "login_failed", "link_index")
 		return
 	}
 
@@ -489,6 +709,7 @@ func page_login(w http.ResponseWriter, req *http.Request) {
 	// Create Job
 	rcvChan = make(chan tAsqJob)
 	asqJob = new(tAsqJob)
+	asqJob.rid = log_rid(req)
 	asqJob.action = asqJobGet // Get
 	asqJob.qid = qid
 	asqJob.returnChannel = rcvChan
@@ -501,33 +722,36 @@ func page_login(w http.ResponseWriter, req *http.Request) {
 
 	correctAnswer = asqJob.asq.answer // instead of thread-unsafe: asqsList[qid].answer
 	if qa != correctAnswer {
-		fmt.Fprintf(w, "%sLogging failed.<br>The Answer to anti-spam Question is wrong.<br>Click <a href='%s'>here</a> to return to main Page.%s",
-			html_1, path_index, html_2) //
+		atomic.AddUint64(&metrics_asqFailed, 1)
+		atomic.AddUint64(&metrics_loginAsq, 1)
+		i18n_reply(w, lang, html_1, path_index, "login_failed", "asq_wrong", "link_index")
 		return
 	}
 
 	// Check Question Timeout
 	delay = time.Now().Unix() - asqJob.asq.timeOfCreation // instead of thread-unsafe: asqsList[qid].timeOfCreation
 	if delay > asqTimeout {
-		fmt.Fprintf(w, "%sLogging failed.<br>The Answer is outdated.<br>Click <a href='%s'>here</a> to return to main Page.%s",
-			html_1, path_index, html_2) //
+		atomic.AddUint64(&metrics_asqLate, 1)
+		atomic.AddUint64(&metrics_loginAsq, 1)
+		i18n_reply(w, lang, html_1, path_index, "login_failed", "asq_outdated", "link_index")
 		return
 	}
+	atomic.AddUint64(&metrics_asqSolved, 1)
 
 	// Session Exists ?
 	_, exists = activeClientsList[uid]
 	if exists {
 		// Already Logged In!
-		fmt.Fprintf(w, "%sLogging failed.<br>Already logged in!<br>Click <a href='%s'>here</a> to return to Chat page. %s",
-			html_1_toChat, path_chat, html_2) //
+		atomic.AddUint64(&metrics_loginAlready, 1)
+		i18n_reply(w, lang, html_1_toChat, path_chat, "login_failed", "login_already", "link_chat")
 		return
 	}
 
 	// Check UID:PWD Combination
 	ok = user_isGood(uid, &pwd) // User exists & Passowrd is correct
 	if !ok {
-		fmt.Fprintf(w, "%sLogging failed.<br>Bad UID or Password.<br>Click <a href='%s'>here</a> to return to main page. %s",
-			html_1, path_index, html_2) //
+		atomic.AddUint64(&metrics_loginPassword, 1)
+		i18n_reply(w, lang, html_1, path_index, "login_failed", "login_badPassword", "link_index")
 		return
 	}
 
@@ -540,6 +764,7 @@ func page_login(w http.ResponseWriter, req *http.Request) {
 	// Create LoginJob
 	rcv2Chan = make(chan tLoginJob)
 	loginJob = new(tLoginJob)
+	loginJob.rid = log_rid(req)
 	loginJob.returnChannel = rcv2Chan
 	loginJob.client.address = req.RemoteAddr
 	loginJob.client.sid = sid_b64
@@ -553,8 +778,8 @@ func page_login(w http.ResponseWriter, req *http.Request) {
 
 	if loginJob.result != true {
 		// Already Logged In!
-		fmt.Fprintf(w, "%sLogging failed.<br>Already logged in!<br>Click <a href='%s'>here</a> to return to Chat page. %s",
-			html_1_toChat, path_chat, html_2) //
+		atomic.AddUint64(&metrics_loginAlready, 1)
+		i18n_reply(w, lang, html_1_toChat, path_chat, "login_failed", "login_already", "link_chat")
 		return
 	}
 
@@ -572,8 +797,7 @@ func page_login(w http.ResponseWriter, req *http.Request) {
 	http.SetCookie(w, &cookie_2)
 
 	// HTML
-	fmt.Fprintf(w, "%sYou are now logged in.<br>This page refreshes in %s seconds.<br>Click <a href='%s'>here</a> to proceed, if your web browser does not support redirects.%s",
-		html_1_toChat, redirectDelay_str, path_chat, html_2) //
+	i18n_reply(w, lang, html_1_toChat, path_chat, "login_done", "refresh", "link_proceed")
 }
 
 //------------------------------------------------------------------------------
@@ -591,6 +815,9 @@ func page_logout(w http.ResponseWriter, req *http.Request) {
 	var exists bool
 	var rcvChan chan tActiveJob
 	var activeJob *tActiveJob
+	var lang string
+
+	lang = i18n_lang(w, req)
 
 	// Read Client's Cookies
 	cookie_1, err_1 = req.Cookie("UID")
@@ -605,7 +832,7 @@ func page_logout(w http.ResponseWriter, req *http.Request) {
 	client_sid = cookie_2.Value
 	uid, err_3 = strconv.ParseUint(client_uid, 10, 64)
 	if err_3 != nil {
-		log.Println("Bad UID in Cookie:", err_3) //
+		log_warn(log_rid(req), "Bad UID in Cookie", "err", err_3) //
 		return
 	}
 
@@ -618,6 +845,7 @@ func page_logout(w http.ResponseWriter, req *http.Request) {
 		// Create Job
 		rcvChan = make(chan tActiveJob)
 		activeJob = new(tActiveJob)
+		activeJob.rid = log_rid(req)
 		activeJob.action = activeJobGetUser // Get User (for SID)
 		activeJob.uid = uid
 		activeJob.returnChannel = rcvChan
@@ -649,6 +877,8 @@ func page_logout(w http.ResponseWriter, req *http.Request) {
 			activeManagerChan <- *activeJob
 			*activeJob = <-rcvChan
 
+			announce(uid, announceEventLeave)
+			webhook_emit(webhookEventLeave, uid, webhookReasonLogout, 0, false)
 		}
 	}
 
@@ -668,8 +898,7 @@ func page_logout(w http.ResponseWriter, req *http.Request) {
 	cookie_2.Expires = time.Unix(0, 0)
 	http.SetCookie(w, cookie_2)
 
-	fmt.Fprintf(w, "%sYou are logged off.<br>This page refreshes in %s seconds.<br>Click <a href='%s'>here</a> to proceed, if your web browser does not support redirects.%s",
-		html_1_toIndex, redirectDelay_str, path_index, html_2) //
+	i18n_reply(w, lang, html_1_toIndex, path_index, "logout_done", "refresh", "link_proceed")
 }
 
 //------------------------------------------------------------------------------
@@ -690,13 +919,15 @@ func page_register(w http.ResponseWriter, req *http.Request) {
 	var asqJob *tAsqJob
 	var regJob *tRegisterJob
 	var delay int64
+	var lang string
+
+	lang = i18n_lang(w, req)
 
 	// Parse Form
 	err = req.ParseForm()
 	if err != nil {
-		log.Println("Error Reading POST Form:", err) //
-		fmt.Fprintf(w, "%sRegistration failed.<br>Click <a href='%s'>here</a> to return to main Page.%s",
-			html_1, path_index, html_2) //
+		log_warn(log_rid(req), "Error Reading POST Form", "err", err) //
+		i18n_reply(w, lang, html_1, path_index, "reg_failed", "link_index")
 		return
 	}
 
@@ -707,27 +938,24 @@ func page_register(w http.ResponseWriter, req *http.Request) {
 	qa_str = req.PostFormValue(param_qAnswer)
 
 	if (len(userName) > userName_maxLen) || (len(pwd) > userPwd_maxLen) {
-		log.Println("Too long Name or Password") //
-		fmt.Fprintf(w, "%sRegistration failed.<br>Name or Password is too long.<br>Click <a href='%s'>here</a> to return to main Page.%s",
-			html_1, path_index, html_2) //
+		log_warn(log_rid(req), "Too long Name or Password") //
+		i18n_reply(w, lang, html_1, path_index, "reg_failed", "reg_tooLong", "link_index")
 		return
 	}
 
 	// Check QID
 	qid, err = strconv.ParseUint(qid_str, 10, 64)
 	if err != nil {
-		log.Println("Error in QID. qid=[", qid_str, "].", err) //
-		fmt.Fprintf(w, "%sRegistration failed.<br>Click <a href='%s'>here</a> to return to main Page.%s",
-			html_1, path_index, html_2) //
+		log_warn(log_rid(req), "Error in QID", "qid", qid_str, "err", err) //
+		i18n_reply(w, lang, html_1, path_index, "reg_failed", "link_index")
 		return
 	}
 
 	// Check QA
 	qa_uint64, err = strconv.ParseUint(qa_str, 10, 64)
 	if err != nil {
-		log.Println("Error in QAnswer.", err) //
-		fmt.Fprintf(w, "%sRegistration failed.<br>Click <a href='%s'>here</a> to return to main Page.%s",
-			html_1, path_index, html_2) //
+		log_warn(log_rid(req), "Error in QAnswer", "err", err) //
+		i18n_reply(w, lang, html_1, path_index, "reg_failed", "link_index")
 		return
 	}
 	qa = uint8(qa_uint64)
@@ -737,15 +965,15 @@ func page_register(w http.ResponseWriter, req *http.Request) {
 	// ASQ exists ?
 	_, exists = asqsList[qid]
 	if !exists {
-		log.Println("UnExisting QID!") //
-		fmt.Fprintf(w, "%sRegistration failed.<br>Click <a href='%s'>here</a> to return to main Page.%s",
-			html_1, path_index, html_2) //
+		log_warn(log_rid(req), "UnExisting QID", "qid", qid) //
+		i18n_reply(w, lang, html_1, path_index, "reg_failed", "link_index")
 		return
 	}
 
 	// Create Job
 	rcvChan = make(chan tAsqJob)
 	asqJob = new(tAsqJob)
+	asqJob.rid = log_rid(req)
 	asqJob.action = asqJobGet // Get
 	asqJob.qid = qid
 	asqJob.returnChannel = rcvChan
@@ -758,24 +986,26 @@ func page_register(w http.ResponseWriter, req *http.Request) {
 
 	correctAnswer = asqJob.asq.answer // instead of thread-unsafe: asqsList[qid].answer
 	if qa != correctAnswer {
-		fmt.Fprintf(w, "%sRegistration failed.<br>The Answer to anti-spam Question is wrong.<br>Click <a href='%s'>here</a> to return to main Page.%s",
-			html_1, path_index, html_2) //
+		atomic.AddUint64(&metrics_asqFailed, 1)
+		i18n_reply(w, lang, html_1, path_index, "reg_failed", "asq_wrong", "link_index")
 		return
 	}
 
 	// Check Question Timeout
 	delay = time.Now().Unix() - asqJob.asq.timeOfCreation // instead of thread-unsafe: asqsList[qid].timeOfCreation
 	if delay > asqTimeout {
-		fmt.Fprintf(w, "%sRegistration failed.<br>The Answer is outdated.<br>Click <a href='%s'>here</a> to return to main Page.%s",
-			html_1, path_index, html_2) //
+		atomic.AddUint64(&metrics_asqLate, 1)
+		i18n_reply(w, lang, html_1, path_index, "reg_failed", "asq_outdated", "link_index")
 		return
 	}
+	atomic.AddUint64(&metrics_asqSolved, 1)
 
 	// Register
 
 	// Create Job
 	rcv2Chan = make(chan tRegisterJob)
 	regJob = new(tRegisterJob)
+	regJob.rid = log_rid(req)
 	regJob.name = userName
 	regJob.pwd = pwd
 	regJob.returnChannel = rcv2Chan
@@ -789,17 +1019,12 @@ func page_register(w http.ResponseWriter, req *http.Request) {
 	uid = regJob.uid
 
 	if !ok {
-		fmt.Fprintf(w, "%sRegistration failed.<br>Click <a href='%s'>here</a> to return to main Page.%s",
-			html_1, path_index, html_2) //
+		i18n_reply(w, lang, html_1, path_index, "reg_failed", "link_index")
+		return
 	}
 
-	// Saves all the registered Users to a string
-	saveRegisteredUsersToTpl()
-
 	// Reply to the Client
-	fmt.Fprint(w, tpl_userRegistered_p1,
-		fmt.Sprintf(tpl_userRegistered_p2, uid),
-		tpl_userRegistered_p3) //
+	template_userRegisteredWrite(w, lang, uid)
 }
 
 //------------------------------------------------------------------------------
@@ -811,18 +1036,19 @@ func page_asq(w http.ResponseWriter, req *http.Request) {
 	var qid uint64
 	var rcvChan chan tAsqJob
 	var asqJob *tAsqJob
-	var msg string
+	var reply tApiAsq
 
 	// Client sends an empty GET Request.
 
-	// Server replies to client following:
+	// Server replies to client (see reply_asq) following:
 	//		1. JSON (question).
 
-	qid = asq_create() // QID given by asqManager is unique
+	qid = asq_create(log_rid(req)) // QID given by asqManager is unique
 
 	// Create Job
 	rcvChan = make(chan tAsqJob)
 	asqJob = new(tAsqJob)
+	asqJob.rid = log_rid(req)
 	asqJob.action = asqJobGet // Get
 	asqJob.qid = qid
 	asqJob.returnChannel = rcvChan
@@ -833,15 +1059,15 @@ func page_asq(w http.ResponseWriter, req *http.Request) {
 	// Wait for Feedback
 	*asqJob = <-rcvChan
 
-	msg = base64.StdEncoding.EncodeToString(asqJob.asq.question)
+	reply.Qid = strconv.FormatUint(qid, 10)
+	reply.Msg = base64.StdEncoding.EncodeToString(asqJob.asq.question)
 	// instead of thread-unsafe: asqsList[qid].question
 
 	// Server's Reply in JSON Format
-	// {"qid":"123","msg":"AbRaKaDaBrA="}
-	fmt.Fprint(w, "{\"qid\":\"", qid, "\",\"msg\":\"", msg, "\"}")
+	reply_asq(w, req, &reply)
 
 	// Clear Question Data from ASQ
-	asq_clearQuestionData(qid)
+	asq_clearQuestionData(qid, log_rid(req))
 }
 
 //------------------------------------------------------------------------------
@@ -0,0 +1,705 @@
+// rest.go
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	REST API for Bots and Integrations.
+
+	All Paths are under "/api/v1". Requests are authorized with an API Token
+	in the "Authorization: Bearer <Token>" Header, no Cookies and no Anti-Spam
+	Questions are needed. Tokens are managed by a logged-in User (see
+	token.go); the Token Endpoints accept the Chat's Session Cookies as well.
+
+	Replies are always in the versioned JSON Format (see api.go).
+
+	Each Endpoint is described once, in the Route Table. The Table is used
+	both for Dispatching and for the OpenAPI Description, which is served at
+	"/api/v1/openapi.json", so the Description can not drift away from the
+	Code.
+
+*/
+
+//------------------------------------------------------------------------------
+
+// Endpoint of the REST API
+type tRestRoute struct {
+	method  string
+	path    string // Path after path_api
+	summary string
+	auth    uint8
+	params  []tRestParam
+	body    interface{} // Example of the Request's Body, nil if there is none
+	reply   interface{} // Example of the Reply
+	handler func(w http.ResponseWriter, req *http.Request, uid uint64)
+}
+
+// Query Parameter of an Endpoint
+type tRestParam struct {
+	name     string
+	kind     string // Type in the OpenAPI Terms: "integer", "string"
+	required bool
+	desc     string
+}
+
+// Bodies of Requests & Replies
+type tApiPost struct {
+	Text string `json:"text"`
+}
+
+type tApiPosted struct {
+	V   int    `json:"v"`
+	Mid uint16 `json:"mid"`
+	Ts  int64  `json:"ts"`
+}
+
+type tApiHistory struct {
+	V        int           `json:"v"`
+	Messages []tApiMessage `json:"messages"`
+	More     bool          `json:"more"` // Older Messages are available
+}
+
+type tApiTokenNew struct {
+	V       int    `json:"v"`
+	Id      string `json:"id"`
+	Token   string `json:"token"` // Shown only once
+	Created int64  `json:"created"`
+}
+
+type tApiTokenList struct {
+	V      int              `json:"v"`
+	Tokens []tApiTokenEntry `json:"tokens"`
+}
+
+type tApiTokenEntry struct {
+	Id      string `json:"id"`
+	Created int64  `json:"created"`
+}
+
+//------------------------------------------------------------------------------
+
+const path_api = "/api/v1" // Prefix of the REST API
+
+// Authorization of an Endpoint
+const rest_authNone uint8 = 0    // Anybody
+const rest_authToken uint8 = 1   // API Token only
+const rest_authSession uint8 = 2 // API Token or Session Cookies
+
+// History
+const rest_historyLimit_default = 50 // Messages in one Page of History
+const rest_historyLimit_max = 500    // Maximum Messages in one Page of History
+
+// Query Parameter Names
+const param_before = "before" // History: Messages before this "mid"
+const param_limit = "limit"   // History: Number of Messages
+const param_tokenId = "id"    // Public ID of a Token
+
+//------------------------------------------------------------------------------
+
+// Route Table
+var rest_routes []tRestRoute
+
+//------------------------------------------------------------------------------
+
+func rest_init() {
+
+	// Fills the Route Table.
+	// It can not be done in the Declaration, as the OpenAPI Handler reads the
+	// Table itself.
+
+	rest_routes = []tRestRoute{
+		{
+			method:  http.MethodPost,
+			path:    "/messages",
+			summary: "Post a Message into the Chat.",
+			auth:    rest_authToken,
+			body:    tApiPost{},
+			reply:   tApiPosted{},
+			handler: rest_post,
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/delta",
+			summary: "Get new Messages since the Cursor. Without a Cursor, the current Cursor is returned.",
+			auth:    rest_authToken,
+			params: []tRestParam{
+				{param_req_mid, "integer", false, "ID of the last known Message, from the previous Cursor."},
+				{param_req_ts, "integer", false, "Timestamp of the last known Message, from the previous Cursor."},
+			},
+			reply:   tApiDelta{},
+			handler: rest_delta,
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/history",
+			summary: "Get older Messages, newest Page first. Messages in a Page are in chronological Order.",
+			auth:    rest_authToken,
+			params: []tRestParam{
+				{param_before, "integer", false, "Messages before this Message ID. Without it, the Page ends with the last Message."},
+				{param_limit, "integer", false, "Number of Messages, " + strconv.Itoa(rest_historyLimit_default) +
+					" by default, at most " + strconv.Itoa(rest_historyLimit_max) + "."},
+			},
+			reply:   tApiHistory{},
+			handler: rest_history,
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/search",
+			summary: "Search Messages. The Reply has the Shape of a Delta, its Cursor is the Chat's last Message.",
+			auth:    rest_authToken,
+			params: []tRestParam{
+				{param_query, "string", false, "Words; quoted Words must follow one another."},
+				{param_author, "string", false, "UID or Name of the Author."},
+				{param_from, "integer", false, "Oldest Time, Unix Timestamp."},
+				{param_to, "integer", false, "Newest Time, Unix Timestamp."},
+				{param_limit, "integer", false, "Number of the newest Messages, " + strconv.Itoa(search_limit_default) +
+					" by default, at most " + strconv.Itoa(search_limit_max) + "."},
+			},
+			reply:   tApiDelta{},
+			handler: rest_search,
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/export",
+			summary: "Export the Messages of a Time Range. For Administrators only.",
+			auth:    rest_authSession,
+			params: []tRestParam{
+				{param_format, "string", false, "Format: jsonl (by default), text, html or mbox."},
+				{param_from, "integer", false, "Oldest Time, Unix Timestamp."},
+				{param_to, "integer", false, "Newest Time, Unix Timestamp."},
+				{param_tz, "string", false, "Time Zone, IANA Name; UTC by default."},
+			},
+			reply:   "",
+			handler: rest_export,
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/users",
+			summary: "List the active Users.",
+			auth:    rest_authToken,
+			reply:   tApiUsers{},
+			handler: rest_users,
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/tokens",
+			summary: "List own API Tokens.",
+			auth:    rest_authSession,
+			reply:   tApiTokenList{},
+			handler: rest_tokenList,
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/tokens",
+			summary: "Create a new API Token. The Token is shown only in this Reply.",
+			auth:    rest_authSession,
+			reply:   tApiTokenNew{},
+			handler: rest_tokenNew,
+		},
+		{
+			method:  http.MethodDelete,
+			path:    "/tokens",
+			summary: "Revoke an own API Token.",
+			auth:    rest_authSession,
+			params: []tRestParam{
+				{param_tokenId, "string", true, "Public ID of the Token."},
+			},
+			reply:   tApiCode{},
+			handler: rest_tokenRevoke,
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/openapi.json",
+			summary: "This Description.",
+			auth:    rest_authNone,
+			reply:   map[string]interface{}{},
+			handler: rest_openapi,
+		},
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func page_api(w http.ResponseWriter, req *http.Request) {
+
+	// Finds the Endpoint of the REST API, checks the Authorization and calls
+	// the Handler.
+
+	var path string
+	var route *tRestRoute
+	var i int
+	var ok bool
+	var uid uint64
+
+	path = strings.TrimPrefix(req.URL.Path, path_api)
+	for i = 0; i < len(rest_routes); i++ {
+		if (rest_routes[i].path == path) && (rest_routes[i].method == req.Method) {
+			route = &rest_routes[i]
+			break
+		}
+	}
+	if route == nil {
+		reply_code(w, req, code_noSuchPath)
+		return
+	}
+
+	switch route.auth {
+
+	case rest_authToken:
+		ok, uid = token_check(req)
+
+	case rest_authSession:
+		ok, uid = token_check(req)
+		if !ok {
+			ok, uid, _, _ = user_check(w, req)
+		}
+
+	default:
+		ok = true
+	}
+
+	if !ok {
+		w.Header().Set("WWW-Authenticate", strings.TrimSpace(token_authPrefix))
+		reply_code(w, req, code_NotLoggedIn)
+		return
+	}
+	reply_remember(w, "", uid)
+
+	route.handler(w, req, uid)
+}
+
+//------------------------------------------------------------------------------
+
+func rest_post(w http.ResponseWriter, req *http.Request, uid uint64) {
+
+	// Posts a Message on Behalf of the Token's Owner.
+	// The Text has the same Markup and the same Limits as in the Chat.
+
+	var post tApiPost
+	var reply tApiPosted
+	var err error
+	var chatJob *tChatJob
+	var rcvChan chan tChatJob
+
+	// JSON may escape each Symbol with up to 6 Bytes
+	req.Body = http.MaxBytesReader(w, req.Body, msgMaxSize*6+64)
+	err = json.NewDecoder(req.Body).Decode(&post)
+	if err != nil {
+		reply_code(w, req, code_BadPOSTdata) // Error in Data
+		return
+	}
+	if len(post.Text) == 0 {
+		reply_code(w, req, code_EmptyMessage) // Empty Message
+		return
+	}
+	if len(post.Text) > msgMaxSize {
+		reply_code(w, req, code_msgTooLong) // Too long Message
+		return
+	}
+
+	// Create Job for ChatManager
+	rcvChan = make(chan tChatJob)
+	chatJob = new(tChatJob)
+	chatJob.chatRecord.author = uid
+	chatJob.chatRecord.message = markup_render(post.Text)
+	chatJob.chatRecord.text = post.Text
+	chatJob.rid = log_rid(req)
+	chatJob.returnChannel = rcvChan
+
+	// Send Job
+	chatManagerChan <- *chatJob
+
+	// Wait for Manager
+	*chatJob = <-rcvChan
+
+	reply.V = api_version
+	reply.Mid = chatJob.mid
+	reply.Ts = chatJob.chatRecord.time
+	api_writeJSON(w, http.StatusOK, &reply)
+}
+
+//------------------------------------------------------------------------------
+
+func rest_delta(w http.ResponseWriter, req *http.Request, uid uint64) {
+
+	// Gives new Messages since the Cursor, like the Chat's Delta Page.
+	// A Bot has no Log-In Time, so without a Cursor it starts from now.
+
+	var reply tApiDelta
+	var mid_str, ts_str string
+	var mid uint16
+	var ts int64
+	var unknown, ok bool
+
+	mid_str = req.FormValue(param_req_mid)
+	ts_str = req.FormValue(param_req_ts)
+
+	if (len(mid_str) == 0) || (len(ts_str) == 0) {
+		reply.X.Mid = chat_recordLastNum
+		reply.X.Ts = chat_recordLastTimestamp
+		reply_delta(w, req, &reply)
+		return
+	}
+
+	mid, ts, unknown, ok = delta_parse(mid_str, ts_str)
+	if !ok {
+		reply_code(w, req, code_BadRequest) // Bad Request
+		return
+	}
+	if unknown {
+		reply.X.Mid = chat_recordLastNum
+		reply.X.Ts = chat_recordLastTimestamp
+		reply_delta(w, req, &reply)
+		return
+	}
+
+	delta_fill(&reply, mid, ts, log_rid(req))
+
+	reply_delta(w, req, &reply)
+}
+
+//------------------------------------------------------------------------------
+
+func rest_history(w http.ResponseWriter, req *http.Request, uid uint64) {
+
+	// Gives a Page of older Messages which are still in the List.
+
+	var reply tApiHistory
+	var before_str, limit_str string
+	var before_uint64 uint64
+	var limit, n, j int
+	var i uint16
+	var err error
+
+	before_str = req.FormValue(param_before)
+	limit_str = req.FormValue(param_limit)
+
+	limit = rest_historyLimit_default
+	if len(limit_str) > 0 {
+		limit, err = strconv.Atoi(limit_str)
+		if (err != nil) || (limit < 1) {
+			reply_code(w, req, code_BadRequest) // Bad Request
+			return
+		}
+		if limit > rest_historyLimit_max {
+			limit = rest_historyLimit_max
+		}
+	}
+
+	reply.V = api_version
+	reply.Messages = []tApiMessage{}
+
+	if len(before_str) > 0 {
+		before_uint64, err = strconv.ParseUint(before_str, 10, 16)
+		if (err != nil) || !chat_isActual(uint16(before_uint64)) {
+			reply_code(w, req, code_BadRequest) // Bad Request
+			return
+		}
+		if uint16(before_uint64) == chat_recordFirstNum {
+			api_writeJSON(w, http.StatusOK, &reply) // Nothing before the first Message
+			return
+		}
+		i = uint16(before_uint64) - 1
+	} else {
+		i = chat_recordLastNum
+	}
+
+	// Backwards, the Counter may overflow
+	for {
+		reply.Messages = append(reply.Messages, api_message(i))
+		n++
+		if i == chat_recordFirstNum {
+			break
+		}
+		if n == limit {
+			reply.More = true
+			break
+		}
+		i--
+	}
+
+	// Chronological Order
+	for j = 0; j < len(reply.Messages)/2; j++ {
+		reply.Messages[j], reply.Messages[len(reply.Messages)-1-j] =
+			reply.Messages[len(reply.Messages)-1-j], reply.Messages[j]
+	}
+
+	api_writeJSON(w, http.StatusOK, &reply)
+}
+
+//------------------------------------------------------------------------------
+
+func rest_users(w http.ResponseWriter, req *http.Request, uid uint64) {
+
+	// Gives the List of active Users, the same as the Chat gets.
+
+	var rcvChan chan tActiveJob
+	var activeJob *tActiveJob
+
+	// Create Job
+	rcvChan = make(chan tActiveJob)
+	activeJob = new(tActiveJob)
+	activeJob.action = activeJobGetList // Get List
+	activeJob.rid = log_rid(req)
+	activeJob.returnChannel = rcvChan
+
+	// Send Job
+	activeManagerChan <- *activeJob
+
+	// Get Feedback
+	*activeJob = <-rcvChan
+
+	reply_users(w, req, activeJob.client.address, activeJob.list_v1)
+}
+
+//------------------------------------------------------------------------------
+
+func rest_tokenList(w http.ResponseWriter, req *http.Request, uid uint64) {
+
+	// Lists the User's Tokens. Tokens themselves are not known to the Server.
+
+	var reply tApiTokenList
+	var t tApiToken
+
+	reply.V = api_version
+	reply.Tokens = []tApiTokenEntry{}
+	for _, t = range token_listOf(uid) {
+		reply.Tokens = append(reply.Tokens, tApiTokenEntry{token_id(t.hash), t.created})
+	}
+
+	api_writeJSON(w, http.StatusOK, &reply)
+}
+
+//------------------------------------------------------------------------------
+
+func rest_tokenNew(w http.ResponseWriter, req *http.Request, uid uint64) {
+
+	// Creates a Token for the User.
+
+	var reply tApiTokenNew
+	var t tApiToken
+	var ok bool
+
+	if len(token_listOf(uid)) >= token_maxPerUser {
+		reply_code(w, req, code_BadRequest) // Too many Tokens
+		return
+	}
+
+	reply.Token, t, ok = token_new(uid)
+	if !ok {
+		reply_code(w, req, code_BadRequest)
+		return
+	}
+
+	reply.V = api_version
+	reply.Id = token_id(t.hash)
+	reply.Created = t.created
+	api_writeJSON(w, http.StatusOK, &reply)
+}
+
+//------------------------------------------------------------------------------
+
+func rest_tokenRevoke(w http.ResponseWriter, req *http.Request, uid uint64) {
+
+	// Revokes one of the User's Tokens.
+
+	if !token_revoke(uid, req.FormValue(param_tokenId)) {
+		reply_code(w, req, code_BadRequest) // No such Token
+		return
+	}
+
+	reply_code(w, req, code_messageSent) // OK
+}
+
+//------------------------------------------------------------------------------
+
+func rest_openapi(w http.ResponseWriter, req *http.Request, uid uint64) {
+
+	// Serves the OpenAPI Description of the REST API, made from the Route
+	// Table and from the Types of Requests and Replies.
+
+	var doc, paths, schemas, op, item map[string]interface{}
+	var params []interface{}
+	var security []interface{}
+	var route *tRestRoute
+	var p tRestParam
+	var i int
+	var exists bool
+
+	paths = make(map[string]interface{})
+	schemas = make(map[string]interface{})
+
+	for i = 0; i < len(rest_routes); i++ {
+
+		route = &rest_routes[i]
+
+		params = []interface{}{}
+		for _, p = range route.params {
+			params = append(params, map[string]interface{}{
+				"name":        p.name,
+				"in":          "query",
+				"required":    p.required,
+				"description": p.desc,
+				"schema":      map[string]interface{}{"type": p.kind},
+			})
+		}
+
+		switch route.auth {
+		case rest_authToken:
+			security = []interface{}{map[string]interface{}{"bearer": []string{}}}
+		case rest_authSession:
+			security = []interface{}{
+				map[string]interface{}{"bearer": []string{}},
+				map[string]interface{}{"session": []string{}},
+			}
+		default:
+			security = []interface{}{}
+		}
+
+		op = map[string]interface{}{
+			"summary":    route.summary,
+			"parameters": params,
+			"security":   security,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "OK",
+					"content": map[string]interface{}{
+						api_mimeJSON: map[string]interface{}{
+							"schema": openapi_schema(reflect.TypeOf(route.reply), schemas),
+						},
+					},
+				},
+				"default": map[string]interface{}{
+					"description": "Error, with one of the Chat's Codes",
+					"content": map[string]interface{}{
+						api_mimeJSON: map[string]interface{}{
+							"schema": openapi_schema(reflect.TypeOf(tApiCode{}), schemas),
+						},
+					},
+				},
+			},
+		}
+		if route.body != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					api_mimeJSON: map[string]interface{}{
+						"schema": openapi_schema(reflect.TypeOf(route.body), schemas),
+					},
+				},
+			}
+		}
+
+		_, exists = paths[route.path]
+		if !exists {
+			paths[route.path] = make(map[string]interface{})
+		}
+		item = paths[route.path].(map[string]interface{})
+		item[strings.ToLower(route.method)] = op
+	}
+
+	doc = map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Web Chat «SAGA MIKRON» REST API",
+			"version": strconv.Itoa(api_version),
+		},
+		"servers": []interface{}{map[string]interface{}{"url": path_api}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearer":  map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"session": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": "SID"},
+			},
+		},
+	}
+
+	api_writeJSON(w, http.StatusOK, doc)
+}
+
+//------------------------------------------------------------------------------
+
+func openapi_schema(t reflect.Type, schemas map[string]interface{}) (schema map[string]interface{}) {
+
+	// Describes the Type in the OpenAPI Terms. Structures are put into the
+	// Components and referenced by Name ("tApiDelta" -> "Delta").
+
+	var name, tag, field string
+	var props map[string]interface{}
+	var required []string
+	var f reflect.StructField
+	var i int
+	var exists bool
+
+	switch t.Kind() {
+
+	case reflect.Ptr:
+		return openapi_schema(t.Elem(), schemas)
+
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": openapi_schema(t.Elem(), schemas)}
+
+	case reflect.Struct:
+		name = strings.TrimPrefix(t.Name(), "tApi")
+		_, exists = schemas[name]
+		if !exists {
+
+			schemas[name] = nil // against endless Recursion
+			props = make(map[string]interface{})
+			required = []string{}
+			for i = 0; i < t.NumField(); i++ {
+
+				f = t.Field(i)
+				if len(f.PkgPath) > 0 {
+					continue // not exported, not in JSON
+				}
+				tag = f.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				field = strings.Split(tag, ",")[0]
+				if len(field) == 0 {
+					field = f.Name
+				}
+				props[field] = openapi_schema(f.Type, schemas)
+				if !strings.Contains(tag, ",omitempty") {
+					required = append(required, field)
+				}
+			}
+			schemas[name] = map[string]interface{}{
+				"type":       "object",
+				"properties": props,
+				"required":   required,
+			}
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+
+	return map[string]interface{}{"type": "object"}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,144 @@
+// rest_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func rest_testGet(t *testing.T, token, path string) (status int, reply string) {
+
+	// Sends a GET Request of the REST API with the Token.
+
+	var req *http.Request
+	var resp *http.Response
+	var data []byte
+	var err error
+
+	req, err = http.NewRequest(http.MethodGet, harness_server.URL+path_api+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", token_authPrefix+token)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ = ioutil.ReadAll(resp.Body)
+
+	return resp.StatusCode, string(data)
+}
+
+//------------------------------------------------------------------------------
+
+func TestRestDelta(t *testing.T) {
+
+	// Cursors are checked as those of the Chat Page. Without a Cursor the
+	// current one is given.
+
+	var tests = []struct {
+		name    string
+		query   string
+		status  int
+		current bool // The current Cursor is given, without Messages
+	}{
+		{"no cursor", "", http.StatusOK, true},
+		{"unknown cursor", "?mid=X&ts=X", http.StatusOK, true},
+		{"cursor", "?mid=0&ts=0", http.StatusOK, false},
+		{"negative ts", "?mid=0&ts=-1", http.StatusBadRequest, false},
+		{"mid out of range", "?mid=65536&ts=0", http.StatusBadRequest, false},
+		{"signed mid", "?mid=%2B1&ts=0", http.StatusBadRequest, false},
+		{"not a number", "?mid=one&ts=0", http.StatusBadRequest, false},
+	}
+
+	var uid uint64
+	var token, reply string
+	var status int
+	var delta tApiDelta
+	var ok bool
+	var i int
+
+	harness_quiet(t)
+	uid = harness_client(t, false).register("Kevin", "kevin-pwd")
+	token, _, ok = token_new(uid)
+	if !ok {
+		t.Fatal("no token")
+	}
+
+	for i = 0; i < len(tests); i++ {
+		status, reply = rest_testGet(t, token, "/delta"+tests[i].query)
+		if status != tests[i].status {
+			t.Errorf("%s: status %d, want %d: %s", tests[i].name, status, tests[i].status, reply)
+		}
+		if tests[i].current {
+			delta = tApiDelta{}
+			if (json.Unmarshal([]byte(reply), &delta) != nil) || (len(delta.Messages) != 0) || (delta.X.Mid != chat_recordLastNum) {
+				t.Errorf("%s: reply %s, want the current cursor", tests[i].name, reply)
+			}
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestTokenRevoke(t *testing.T) {
+
+	// A Token which can not be removed from the File stays valid.
+
+	var uid uint64
+	var token, id, file string
+	var tok tApiToken
+	var data []byte
+	var status int
+	var ok bool
+	var err error
+
+	harness_quiet(t)
+	uid = harness_client(t, false).register("Laura", "laura-pwd")
+	token, tok, ok = token_new(uid)
+	if !ok {
+		t.Fatal("no token")
+	}
+	id = token_id(tok.hash)
+
+	file = file_apiTokens
+	file_apiTokens = filepath.Join(t.TempDir(), "no", "such", "dir", "token.dat")
+	ok = token_revoke(uid, id)
+	file_apiTokens = file
+	if ok {
+		t.Fatal("token is revoked without the file")
+	}
+	_, ok = apiTokenList[tok.hash]
+	if !ok {
+		t.Fatal("token is deleted, but stays in the file")
+	}
+
+	if token_revoke(uid+1, id) {
+		t.Error("token of another user is revoked")
+	}
+	if !token_revoke(uid, id) {
+		t.Fatal("token is not revoked")
+	}
+	_, ok = apiTokenList[tok.hash]
+	if ok {
+		t.Error("revoked token is kept")
+	}
+	data, err = ioutil.ReadFile(file_apiTokens)
+	if (err != nil) || strings.Contains(string(data), tok.hash) {
+		t.Errorf("token file: %v", err)
+	}
+	status, _ = rest_testGet(t, token, "/delta")
+	if status != http.StatusUnauthorized {
+		t.Errorf("revoked token gives %d", status)
+	}
+}
+
+//------------------------------------------------------------------------------
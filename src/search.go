@@ -0,0 +1,570 @@
+// search.go
+
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	Full-Text Search over the Chat's History.
+
+	The History is the List of Chat Records (see chatRecordsList), so the
+	Index holds the same Records: each new Record is indexed by the
+	chatManager before the Author gets the Reply, and a Record which is
+	re-written by the next Circle is removed from the Index.
+
+	Texts are split into Tokens at each Symbol which is neither a Letter nor
+	a Digit, Tokens are in lower Case, so "Вася" and "вася" are the same.
+	The Russian "ё" is the same as "е". Chinese and Japanese Texts have no
+	Spaces, so each of their Symbols is a Token.
+
+	Query Syntax:
+
+		привет мир         both Words, in any Place
+		"добрый вечер"     Words one after another
+		привет "мир труд"  both Conditions
+
+	Filters: Author (UID or Name), Time Range (Unix Timestamps).
+
+*/
+
+//------------------------------------------------------------------------------
+
+type tSearchJob struct {
+	action        uint8
+	mid           uint16       // Add: ID of the new Record
+	record        tChatRecord  // Add: the new Record
+	query         tSearchQuery // Find: the Query
+	mids          []uint16     // Find: found Records in chronological Order
+	returnChannel chan tSearchJob
+	rid           string // ID of the Request which made the Job, for the Log
+}
+
+type tSearchQuery struct {
+	terms   []string        // All of these Tokens must be found
+	phrases [][]string      // Each of these Sequences of Tokens must be found
+	authors map[uint64]bool // Any Author, if nil
+	from    int64           // Oldest Time, if not 0
+	to      int64           // Newest Time, if not 0
+	limit   int             // Newest Records are found first
+}
+
+// Indexed Record
+type tSearchEntry struct {
+	seq    uint64 // Order of Records, 0 if there is no Record
+	author uint64
+	time   int64
+	tokens []string
+}
+
+//------------------------------------------------------------------------------
+
+const path_search = "/search" // Page for searching Messages
+
+const searchJobAdd = 1  // Action Code for Search Manager to index a new Record
+const searchJobFind = 2 // Action Code for Search Manager to find Records
+const searchJobPing = 3 // Action Code for Search Manager to only answer (Health Check)
+
+const searchManagerChanBufferLen = 256 // Buffer Length of the Search Manager's Channel
+
+const search_limit_default = 50 // Found Messages in one Reply
+const search_limit_max = 500    // Maximum found Messages in one Reply
+
+// Query Parameter Names
+const param_query = "q"       // Search: Query
+const param_author = "author" // Search: UID or Name of the Author
+const param_from = "from"     // Search: oldest Time, Unix Timestamp
+const param_to = "to"         // Search: newest Time, Unix Timestamp
+
+//------------------------------------------------------------------------------
+
+// Index, used by the searchManager only
+var searchIndex map[string]map[uint16]bool // Key = Token, Value = Set of Record IDs
+var searchEntries [chat_recordsMaxLast + 1]tSearchEntry
+var searchSeq uint64
+
+// Channels
+var searchManagerChan chan tSearchJob
+var searchManagerQuit chan int
+
+//------------------------------------------------------------------------------
+
+func searchManager() {
+
+	// Manages the Search Index.
+
+	var loop bool = true
+	var job tSearchJob
+
+	searchIndex = make(map[string]map[uint16]bool)
+
+	for loop {
+
+		job = <-searchManagerChan // Get Job from Channel
+
+		if job.action == searchJobAdd { // Add
+
+			search_remove(job.mid)
+			search_add(job.mid, &job.record)
+
+		} else if job.action == searchJobFind { // Find
+
+			job.mids = search_find(&job.query)
+			log_debug(job.rid, "Search done", "found", len(job.mids))
+			job.returnChannel <- job // Feedback
+
+		} else if job.action == searchJobPing { // Ping
+
+			job.returnChannel <- job // Feedback
+
+		}
+
+		// Checking for Stop Signal
+		select {
+		case <-searchManagerQuit:
+			loop = false
+			log_info("", "Closing Search Manager...") //
+		default:
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func search_index(mid uint16, record tChatRecord, rid string) {
+
+	// Gives a new Record to the searchManager. The Manager gives no Feedback,
+	// the Order of Jobs keeps the Index in Step with the Chat.
+
+	var searchJob *tSearchJob
+
+	// Create Job
+	searchJob = new(tSearchJob)
+	searchJob.rid = rid
+	searchJob.action = searchJobAdd // Add
+	searchJob.mid = mid
+	searchJob.record = record
+
+	// Send Job
+	searchManagerChan <- *searchJob
+}
+
+//------------------------------------------------------------------------------
+
+func search_add(mid uint16, record *tChatRecord) {
+
+	// Indexes the Record.
+
+	var entry *tSearchEntry
+	var token string
+	var set map[uint16]bool
+	var exists bool
+
+	searchSeq++
+	entry = &searchEntries[mid]
+	entry.seq = searchSeq
+	entry.author = record.author
+	entry.time = record.time
+	entry.tokens = search_tokens(record.text)
+	if len(record.attachment.hash) > 0 {
+		entry.tokens = append(entry.tokens, search_tokens(record.attachment.name)...)
+	}
+
+	for _, token = range entry.tokens {
+		set, exists = searchIndex[token]
+		if !exists {
+			set = make(map[uint16]bool)
+			searchIndex[token] = set
+		}
+		set[mid] = true
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func search_remove(mid uint16) {
+
+	// Removes the old Record with this ID from the Index.
+
+	var entry *tSearchEntry
+	var token string
+
+	entry = &searchEntries[mid]
+	if entry.seq == 0 {
+		return
+	}
+
+	for _, token = range entry.tokens {
+		delete(searchIndex[token], mid)
+		if len(searchIndex[token]) == 0 {
+			delete(searchIndex, token)
+		}
+	}
+
+	*entry = tSearchEntry{}
+}
+
+//------------------------------------------------------------------------------
+
+func search_tokens(text string) (tokens []string) {
+
+	// Splits the Text into Tokens.
+
+	var word []rune
+	var r rune
+
+	for _, r = range strings.ToLower(text) {
+
+		if r == 'ё' {
+			r = 'е'
+		}
+
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Thai) {
+			// Scripts without Spaces: a Symbol is a Word
+			if len(word) > 0 {
+				tokens = append(tokens, string(word))
+				word = word[:0]
+			}
+			tokens = append(tokens, string(r))
+			continue
+		}
+
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || (unicode.IsMark(r) && (len(word) > 0)) {
+			word = append(word, r)
+			continue
+		}
+
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+
+	if len(word) > 0 {
+		tokens = append(tokens, string(word))
+	}
+
+	return tokens
+}
+
+//------------------------------------------------------------------------------
+
+func search_parseQuery(q string) (terms []string, phrases [][]string) {
+
+	// Parses the Query Text. Quoted Parts are Phrases. A Word which is split
+	// into several Tokens ("e-mail", "東京") is a Phrase as well.
+
+	var parts []string
+	var tokens []string
+	var i int
+	var word string
+
+	parts = strings.Split(q, "\"")
+	for i = 0; i < len(parts); i++ {
+
+		if i%2 == 1 {
+			// Inside Quotes
+			tokens = search_tokens(parts[i])
+			if len(tokens) == 1 {
+				terms = append(terms, tokens[0])
+			} else if len(tokens) > 1 {
+				phrases = append(phrases, tokens)
+			}
+			continue
+		}
+
+		for _, word = range strings.Fields(parts[i]) {
+			tokens = search_tokens(word)
+			if len(tokens) == 1 {
+				terms = append(terms, tokens[0])
+			} else if len(tokens) > 1 {
+				phrases = append(phrases, tokens)
+			}
+		}
+	}
+
+	return terms, phrases
+}
+
+//------------------------------------------------------------------------------
+
+func search_find(query *tSearchQuery) (mids []uint16) {
+
+	// Finds the newest Records which match the Query.
+
+	var required []string
+	var phrase []string
+	var candidates map[uint16]bool
+	var found []uint16
+	var token string
+	var mid uint16
+	var i int
+	var entry *tSearchEntry
+	var ok bool
+
+	required = append(required, query.terms...)
+	for _, phrase = range query.phrases {
+		required = append(required, phrase...)
+	}
+
+	if len(required) > 0 {
+
+		// The rarest Token gives the fewest Candidates
+		for _, token = range required {
+			if (candidates == nil) || (len(searchIndex[token]) < len(candidates)) {
+				candidates = searchIndex[token]
+			}
+		}
+
+		for mid = range candidates {
+			found = append(found, mid)
+		}
+
+	} else {
+
+		// Only Filters
+		for i = 0; i < len(searchEntries); i++ {
+			if searchEntries[i].seq > 0 {
+				found = append(found, uint16(i))
+			}
+		}
+	}
+
+	mids = found[:0]
+	for _, mid = range found {
+
+		entry = &searchEntries[mid]
+
+		if (query.authors != nil) && !query.authors[entry.author] {
+			continue
+		}
+		if ((query.from != 0) && (entry.time < query.from)) ||
+			((query.to != 0) && (entry.time > query.to)) {
+			continue
+		}
+
+		ok = true
+		for _, token = range required {
+			if !searchIndex[token][mid] {
+				ok = false
+				break
+			}
+		}
+		for _, phrase = range query.phrases {
+			if !ok {
+				break
+			}
+			ok = search_hasPhrase(entry.tokens, phrase)
+		}
+
+		if ok {
+			mids = append(mids, mid)
+		}
+	}
+
+	// Newest first, then the Limit, then in chronological Order
+	sort.Slice(mids, func(a, b int) bool {
+		return searchEntries[mids[a]].seq > searchEntries[mids[b]].seq
+	})
+	if len(mids) > query.limit {
+		mids = mids[:query.limit]
+	}
+	for i = 0; i < len(mids)/2; i++ {
+		mids[i], mids[len(mids)-1-i] = mids[len(mids)-1-i], mids[i]
+	}
+
+	return mids
+}
+
+//------------------------------------------------------------------------------
+
+func search_hasPhrase(tokens []string, phrase []string) (ok bool) {
+
+	// Checks whether the Tokens of the Phrase follow one another.
+
+	var i, j int
+
+	for i = 0; i+len(phrase) <= len(tokens); i++ {
+		for j = 0; j < len(phrase); j++ {
+			if tokens[i+j] != phrase[j] {
+				break
+			}
+		}
+		if j == len(phrase) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//------------------------------------------------------------------------------
+
+func search_request(req *http.Request, query *tSearchQuery) (code string) {
+
+	// Reads the Query from the Request's Parameters.
+	// Returns a Code if the Request is bad.
+
+	var q, author, from_str, to_str, limit_str string
+	var uid uint64
+	var key uint64
+	var ud tUserData
+	var exists bool
+	var err error
+
+	q = req.FormValue(param_query)
+	author = strings.TrimSpace(req.FormValue(param_author))
+	from_str = req.FormValue(param_from)
+	to_str = req.FormValue(param_to)
+	limit_str = req.FormValue(param_limit)
+
+	if len(q) > msgMaxSize {
+		return code_msgTooLong
+	}
+	query.terms, query.phrases = search_parseQuery(q)
+
+	// Author: UID or Name, Names may be not unique
+	if len(author) > 0 {
+		query.authors = make(map[uint64]bool)
+		uid, err = strconv.ParseUint(author, 10, 64)
+		_, exists = user_get(uid)
+		if (err == nil) && exists {
+			query.authors[uid] = true
+		} else {
+			userDataLock.RLock()
+			for key, ud = range userDataList {
+				if strings.EqualFold(ud.name, author) {
+					query.authors[key] = true
+				}
+			}
+			userDataLock.RUnlock()
+		}
+	}
+
+	if len(from_str) > 0 {
+		query.from, err = strconv.ParseInt(from_str, 10, 64)
+		if err != nil {
+			return code_BadRequest
+		}
+	}
+	if len(to_str) > 0 {
+		query.to, err = strconv.ParseInt(to_str, 10, 64)
+		if err != nil {
+			return code_BadRequest
+		}
+	}
+
+	query.limit = search_limit_default
+	if len(limit_str) > 0 {
+		query.limit, err = strconv.Atoi(limit_str)
+		if (err != nil) || (query.limit < 1) {
+			return code_BadRequest
+		}
+		if query.limit > search_limit_max {
+			query.limit = search_limit_max
+		}
+	}
+
+	// Something must be asked
+	if (len(query.terms) == 0) && (len(query.phrases) == 0) && (query.authors == nil) &&
+		(query.from == 0) && (query.to == 0) {
+		return code_EmptyMessage
+	}
+
+	return ""
+}
+
+//------------------------------------------------------------------------------
+
+func search_reply(w http.ResponseWriter, req *http.Request) {
+
+	// Searches and sends the found Messages in the Shape of a Delta. The
+	// Cursor is the Chat's last Message, as the Search does not move the
+	// Client's Cursor.
+
+	var query tSearchQuery
+	var reply tApiDelta
+	var code string
+	var mid uint16
+	var rcvChan chan tSearchJob
+	var searchJob *tSearchJob
+
+	code = search_request(req, &query)
+	if len(code) > 0 {
+		reply_code(w, req, code)
+		return
+	}
+
+	// Create Job
+	rcvChan = make(chan tSearchJob)
+	searchJob = new(tSearchJob)
+	searchJob.rid = log_rid(req)
+	searchJob.action = searchJobFind // Find
+	searchJob.query = query
+	searchJob.returnChannel = rcvChan
+
+	// Send Job
+	searchManagerChan <- *searchJob
+
+	// Wait for Feedback
+	*searchJob = <-rcvChan
+
+	for _, mid = range searchJob.mids {
+		reply.Messages = append(reply.Messages, api_message(mid))
+	}
+	reply.X.Mid = chat_recordLastNum
+	reply.X.Ts = chat_recordLastTimestamp
+	reply.noNews = (len(reply.Messages) == 0)
+
+	reply_delta(w, req, &reply)
+}
+
+//------------------------------------------------------------------------------
+
+func page_search(w http.ResponseWriter, req *http.Request) {
+
+	// Processes and serves User's Search Request.
+
+	// Client sends a GET or POST Request with the Parameters: "q" (Query),
+	// "author", "from", "to", "limit". At least one of the first four is
+	// needed.
+
+	// Server replies to client (see reply_code, reply_delta) one of the
+	// following:
+	//		1. code_NotLoggedIn ('L'),
+	//		2. code_BadRequest ('B'),
+	//		3. code_EmptyMessage ('E'), if nothing is asked,
+	//		4. code_msgTooLong ('M'),
+	//		5. JSON (found_messages),
+	//		6. code_NoNews ('N'), only in the legacy Format.
+
+	var ok bool
+
+	// Correct Cookies & Not Idle ?  & update User's Last Activity Time
+	ok, _, _, _ = user_check(w, req)
+	if !ok {
+		reply_code(w, req, code_NotLoggedIn) // Not Logged In
+		return
+	}
+
+	search_reply(w, req)
+}
+
+//------------------------------------------------------------------------------
+
+func rest_search(w http.ResponseWriter, req *http.Request, uid uint64) {
+
+	// Searches Messages, the same as the Chat's Search Page.
+
+	search_reply(w, req)
+}
+
+//------------------------------------------------------------------------------
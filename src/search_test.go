@@ -0,0 +1,246 @@
+// search_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+func TestSearchTokens(t *testing.T) {
+
+	// Words in lower Case, "ё" as "е", a Symbol of Chinese or Japanese is a
+	// Word, Punctuation splits Words.
+
+	var tests = []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"words", "Hello, World!", []string{"hello", "world"}},
+		{"russian", "Вася ПУПКИН", []string{"вася", "пупкин"}},
+		{"yo", "Ёлка и ёж", []string{"елка", "и", "еж"}},
+		{"chinese", "东京大学", []string{"东", "京", "大", "学"}},
+		{"japanese", "東京です", []string{"東", "京", "で", "す"}},
+		{"mixed", "Go言語で", []string{"go", "言", "語", "で"}},
+		{"punctuation", "e-mail: a.b@c.d", []string{"e", "mail", "a", "b", "c", "d"}},
+		{"digits", "v2.0 #42", []string{"v2", "0", "42"}},
+		{"combining mark", "и\u0306од", []string{"и\u0306од"}},
+		{"leading mark", "\u0301a", []string{"a"}},
+		{"only punctuation", "... !!! ---", nil},
+		{"empty", "", nil},
+	}
+
+	var i int
+
+	for i = 0; i < len(tests); i++ {
+		if !reflect.DeepEqual(search_tokens(tests[i].text), tests[i].want) {
+			t.Errorf("%s: got %q, want %q", tests[i].name, search_tokens(tests[i].text), tests[i].want)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestSearchParseQuery(t *testing.T) {
+
+	// Quoted Parts and Words of several Tokens are Phrases.
+
+	var tests = []struct {
+		name    string
+		q       string
+		terms   []string
+		phrases [][]string
+	}{
+		{"words", "привет Мир", []string{"привет", "мир"}, nil},
+		{"phrase", `"добрый вечер"`, nil, [][]string{{"добрый", "вечер"}}},
+		{"both", `привет "мир труд"`, []string{"привет"}, [][]string{{"мир", "труд"}}},
+		{"quoted word", `"Ёж"`, []string{"еж"}, nil},
+		{"hyphen", "e-mail", nil, [][]string{{"e", "mail"}}},
+		{"cjk word", "東京 tower", []string{"tower"}, [][]string{{"東", "京"}}},
+		{"punctuation", "hello, world!", []string{"hello", "world"}, nil},
+		{"open quote", `a "b c`, []string{"a"}, [][]string{{"b", "c"}}},
+		{"empty quotes", `"" !!`, nil, nil},
+	}
+
+	var terms []string
+	var phrases [][]string
+	var i int
+
+	for i = 0; i < len(tests); i++ {
+		terms, phrases = search_parseQuery(tests[i].q)
+		if !reflect.DeepEqual(terms, tests[i].terms) || !reflect.DeepEqual(phrases, tests[i].phrases) {
+			t.Errorf("%s: got %q %q, want %q %q", tests[i].name, terms, phrases, tests[i].terms, tests[i].phrases)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func search_testFind(c *tTestClient, query url.Values) (status int, texts []string, reply string) {
+
+	// Searches and gives the Texts of the found Messages.
+
+	var delta tApiDelta
+	var i int
+
+	status, reply = c.get(path_search + "?" + query.Encode())
+	if status != http.StatusOK {
+		return status, nil, reply
+	}
+	if json.Unmarshal([]byte(reply), &delta) != nil {
+		c.t.Fatalf("search: %s", reply)
+	}
+	for i = 0; i < len(delta.Messages); i++ {
+		texts = append(texts, delta.Messages[i].Txt)
+	}
+
+	return status, texts, reply
+}
+
+//------------------------------------------------------------------------------
+
+func TestSearch(t *testing.T) {
+
+	// Messages are found by Words, Phrases, Author and Time, the newest
+	// ones within the Limit, in chronological Order.
+
+	var tests = []struct {
+		name   string
+		query  url.Values
+		since  bool // Messages since the Start of the Test
+		status int
+		want   []string
+	}{
+		{"word", url.Values{param_query: {"пожалуйста"}}, false, http.StatusOK,
+			[]string{"Пришли e-mail, пожалуйста"}},
+		{"yo", url.Values{param_query: {"елки"}}, false, http.StatusOK,
+			[]string{"Ёлки в парке Горького", "Горького парк закрыт, ёлки там же"}},
+		{"phrase", url.Values{param_query: {`"парке горького"`}}, false, http.StatusOK,
+			[]string{"Ёлки в парке Горького"}},
+		{"reversed phrase", url.Values{param_query: {`"горького парке"`}}, false, http.StatusOK, nil},
+		{"cjk", url.Values{param_query: {"東京"}}, false, http.StatusOK,
+			[]string{"Встреча в 東京 у башни"}},
+		{"cjk symbol", url.Values{param_query: {"京"}}, false, http.StatusOK,
+			[]string{"Встреча в 東京 у башни"}},
+		{"hyphen", url.Values{param_query: {"E-Mail"}}, false, http.StatusOK,
+			[]string{"Пришли e-mail, пожалуйста"}},
+		{"author by name", url.Values{param_query: {"Горького"}, param_author: {"umar"}}, false, http.StatusOK,
+			[]string{"Горького парк закрыт, ёлки там же"}},
+		{"limit", url.Values{param_author: {"Ursula"}, param_limit: {"2"}}, false, http.StatusOK,
+			[]string{"Встреча в 東京 у башни", "Пришли e-mail, пожалуйста"}},
+		{"time", url.Values{param_query: {"горького"}}, true, http.StatusOK,
+			[]string{"Ёлки в парке Горького", "Горького парк закрыт, ёлки там же"}},
+		{"time before", url.Values{param_query: {"горького"}, param_to: {"1"}}, false, http.StatusOK, nil},
+		{"nothing found", url.Values{param_query: {"кенгуру"}}, false, http.StatusOK, nil},
+		{"nothing asked", url.Values{param_query: {`"" ,`}}, false, http.StatusBadRequest, nil},
+		{"bad limit", url.Values{param_query: {"елки"}, param_limit: {"0"}}, false, http.StatusBadRequest, nil},
+		{"bad time", url.Values{param_query: {"елки"}, param_from: {"today"}}, false, http.StatusBadRequest, nil},
+	}
+
+	var c, other *tTestClient
+	var ursula, umar uint64
+	var status, i int
+	var messages, texts []string
+	var query url.Values
+	var reply, start, key string
+
+	harness_quiet(t)
+	c = harness_client(t, true)
+	ursula = c.register("Ursula", "ursula-pwd")
+	c.login(ursula, "ursula-pwd")
+	defer c.get(path_logout)
+	other = harness_client(t, true)
+	umar = other.register("Umar", "umar-pwd")
+	other.login(umar, "umar-pwd")
+	defer other.get(path_logout)
+
+	start = strconv.FormatInt(time.Now().Unix(), 10)
+	messages = []string{"Ёлки в парке Горького", "Встреча в 東京 у башни", "Пришли e-mail, пожалуйста"}
+	for i = 0; i < len(messages); i++ {
+		c.send(messages[i])
+	}
+	other.send("Горького парк закрыт, ёлки там же")
+
+	for i = 0; i < len(tests); i++ {
+		query = url.Values{}
+		for key = range tests[i].query {
+			query[key] = tests[i].query[key]
+		}
+		if tests[i].since {
+			query.Set(param_from, start)
+		}
+		status, texts, reply = search_testFind(c, query)
+		if status != tests[i].status {
+			t.Errorf("%s: status %d, want %d: %s", tests[i].name, status, tests[i].status, reply)
+			continue
+		}
+		if !reflect.DeepEqual(texts, tests[i].want) {
+			t.Errorf("%s: found %q, want %q", tests[i].name, texts, tests[i].want)
+		}
+	}
+
+	// Author by UID
+	_, texts, reply = search_testFind(c, url.Values{param_author: {strconv.FormatUint(ursula, 10)}})
+	if !reflect.DeepEqual(texts, messages) {
+		t.Errorf("author by UID: %s", reply)
+	}
+
+	// Not logged in
+	status, _ = harness_client(t, true).get(path_search + "?q=ёлки")
+	if status != http.StatusUnauthorized {
+		t.Errorf("search without log-in: status %d", status)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestSearchRingWrap(t *testing.T) {
+
+	// A Record which is re-written by the next Circle is not found any more.
+
+	var c *tTestClient
+	var uid uint64
+	var texts []string
+	var reply string
+	var i int
+
+	if testing.Short() {
+		t.Skip("a whole circle of the ring is posted")
+	}
+
+	harness_quiet(t)
+	c = harness_client(t, true)
+	uid = c.register("Umberto", "umberto-pwd")
+	c.login(uid, "umberto-pwd")
+	defer c.get(path_logout)
+
+	c.send("Квокка улыбается")
+	_, texts, reply = search_testFind(c, url.Values{param_query: {"квокка"}})
+	if !reflect.DeepEqual(texts, []string{"Квокка улыбается"}) {
+		t.Fatalf("new message is not found: %s", reply)
+	}
+
+	// The whole Ring
+	for i = 0; i <= chat_recordsMaxLast; i++ {
+		delta_post("filler")
+	}
+
+	_, texts, reply = search_testFind(c, url.Values{param_query: {"квокка"}})
+	if len(texts) > 0 {
+		t.Errorf("re-written message is found: %s", reply)
+	}
+	_, texts, reply = search_testFind(c, url.Values{param_query: {"filler"}, param_limit: {"3"}})
+	if len(texts) != 3 {
+		t.Errorf("%d new messages are found, want 3: %s", len(texts), reply)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,126 @@
+// send_test.go
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+//------------------------------------------------------------------------------
+
+func TestSendParse(t *testing.T) {
+
+	// Bodies of Send Requests and the Codes for them.
+
+	var tests = []struct {
+		name string
+		body string
+		text string
+		code string
+	}{
+		{"good", "5 Hello", "Hello", ""},
+		{"letters, not bytes", "6 Привет", "Привет", ""},
+		{"spaces in text", "3 a b", "a b", ""},
+		{"empty body", "", "", code_BadPOSTdata},
+		{"no space", "Hello", "", code_BadPOSTdata},
+		{"no count", " Hello", "", code_BadPOSTdata},
+		{"bad count", "five Hello", "", code_BadPOSTdata},
+		{"wrong count", "4 Hello", "", code_BadPOSTdata},
+		{"negative count", "-5 Hello", "", code_BadPOSTdata},
+		{"huge count", "99999999999999999999 Hello", "", code_BadPOSTdata},
+		{"empty text", "0 ", "", code_EmptyMessage},
+		{"bad utf-8", "1 \xff", "", code_BadPOSTdata},
+		{"too long", strconv.Itoa(msgMaxSize+1) + " " + strings.Repeat("a", msgMaxSize+1), "", code_msgTooLong},
+	}
+
+	var text, code string
+	var i int
+
+	for i = 0; i < len(tests); i++ {
+		text, code = send_parse(tests[i].body)
+		if (text != tests[i].text) || (code != tests[i].code) {
+			t.Errorf("%s: got %q/%q, want %q/%q", tests[i].name, text, code, tests[i].text, tests[i].code)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestSendMalformed(t *testing.T) {
+
+	// Malformed Bodies are answered, the Chat Manager keeps working.
+
+	var bodies = []string{"", "Hello", " ", "x y", "1 \xff", strings.Repeat("9", 100)}
+
+	var c *tTestClient
+	var uid uint64
+	var status int
+	var reply string
+	var i int
+
+	c = harness_client(t, false)
+	uid = c.register("Grace", "grace-pwd")
+	c.login(uid, "grace-pwd")
+	defer c.get(path_logout)
+
+	for i = 0; i < len(bodies); i++ {
+		status, reply = c.do(http.MethodPost, path_send, api_contentText, bodies[i])
+		if reply != code_BadPOSTdata {
+			t.Errorf("body %q: status %d: %q", bodies[i], status, reply)
+		}
+	}
+
+	status, reply = c.send("Still here")
+	if reply != code_messageSent {
+		t.Fatalf("send after malformed bodies: status %d: %q", status, reply)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func FuzzSendParse(f *testing.F) {
+
+	// Any Body gives either a Code or a Text which matches its Count and can
+	// be rendered.
+
+	f.Add("5 Hello")
+	f.Add("6 Привет")
+	f.Add("Hello")
+	f.Add("")
+	f.Add(" ")
+	f.Add("0 ")
+	f.Add("-1 x")
+	f.Add("3 *a*")
+	f.Add("1 \xff")
+
+	f.Fuzz(func(t *testing.T, body string) {
+
+		var text, code, again string
+
+		text, code = send_parse(body)
+		if len(code) > 0 {
+			if len(text) > 0 {
+				t.Fatalf("code %q with text %q", code, text)
+			}
+			return
+		}
+
+		if (len(text) == 0) || (len(text) > msgMaxSize) || !utf8.ValidString(text) {
+			t.Fatalf("bad text %q is accepted", text)
+		}
+		if !strings.HasSuffix(body, " "+text) {
+			t.Fatalf("text %q is not the end of body %q", text, body)
+		}
+		again, _ = send_parse(strconv.Itoa(utf8.RuneCountInString(text)) + " " + text)
+		if again != text {
+			t.Fatalf("text %q is not given back, got %q", text, again)
+		}
+		markup_render(text)
+	})
+}
+
+//------------------------------------------------------------------------------
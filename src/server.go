@@ -3,8 +3,9 @@
 package main
 
 import (
-	"log"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,6 +25,16 @@ type tServerJob struct {
 	returnChannel chan tServerJob
 }
 
+// Writer which remembers the Reply for the Statistics and the Log
+type tReplyWriter struct {
+	http.ResponseWriter
+	bytes  uint64       // Bytes served
+	status int          // HTTP Status, 0 if not set (200)
+	code   string       // Server's Reply Code, if any (see reply_code)
+	uid    uint64       // UID of the logged-in User, if any (see user_check)
+	after  http.Handler // Serves the Reply after the Job, if set (see reply_after)
+}
+
 // Actions
 type tActions [srv_actionsCount]func(http.ResponseWriter, *http.Request)
 
@@ -35,13 +46,14 @@ const srv_ipAddress_default = "0.0.0.0" // Default IP Address of the Server
 const srv_protocol = "http://"          // Protocol of the Server
 
 // Actions
-const srv_actionsCount = 10 // Possible Actions to do with the Client's Request
+const srv_actionsCount = 22 // Possible Actions to do with the Client's Request
 
 // Client Behaviour
 const redirectDelay_str = "0"       // Delay of Page Redirect, in Seconds
 const sendToGetDelay_str = "1"      // Delay between sent Message and getting Updates, in Seconds
 const msgUpdateInterval_str = "10"  // Interval between last and next Update Requests for New Messages
 const userUpdateInterval_str = "45" // Interval between last and next Update Requests for User List
+const typingInterval_str = "3"      // Minimal Interval between "typing" Signals of a Client
 
 // URL Path
 const path_index = "/"       // Path to Index Page (may differ from Root!)
@@ -54,15 +66,24 @@ const path_send = "/s"       // Page for sending Messages to Server
 const path_activeList = "/a" // Page for List of active Users
 const path_stat = "/t"       // Statistics Page
 const path_asq = "/q"        // Path for requesting Anti-Spam Question
+const path_upload = "/u"     // Page for uploading Files to Server
+const path_file = "/f"       // Page for downloading attached Files
+const path_typing = "/w"     // Page for Signals that User is typing ("writing")
+const path_presence = "/p"   // Page for changing User's Presence and Status
 
 // Server's Reply Codes
-const code_messageSent = "O"  // Server's Reply if Message is sent
-const code_NotLoggedIn = "L"  // Server's Reply if User is Not Logged In or Idle
-const code_EmptyMessage = "E" // Server's Reply if User is sending an empty Message
-const code_BadPOSTdata = "X"  // Server's Reply if Error in POST Data
-const code_BadRequest = "B"   // Server's Reply if Error in LMS Parameter
-const code_NoNews = "N"       // Server's Reply if No New Messages Found
-const code_msgTooLong = "M"   // Server's Reply if Client's Message is too long
+const code_messageSent = "O"     // Server's Reply if Message is sent
+const code_NotLoggedIn = "L"     // Server's Reply if User is Not Logged In or Idle
+const code_EmptyMessage = "E"    // Server's Reply if User is sending an empty Message
+const code_BadPOSTdata = "X"     // Server's Reply if Error in POST Data
+const code_BadRequest = "B"      // Server's Reply if Error in LMS Parameter
+const code_NoNews = "N"          // Server's Reply if No New Messages Found
+const code_msgTooLong = "M"      // Server's Reply if Client's Message is too long
+const code_badFileType = "T"     // Server's Reply if Client's File has a forbidden Type
+const code_noSuchPath = "P"      // Server's Reply if the API has no such Endpoint
+const code_tooManyRequests = "R" // Server's Reply if Client posts too often
+const code_forbidden = "F"       // Server's Reply if the User may not do it
+const code_serverError = "S"     // Server's Reply if the Server has failed to make the Reply
 
 // Client's HTML Form Parameter Names, POST/GET Variable Names
 const param_login_userID = "luid" // UID during Logging-In
@@ -74,6 +95,10 @@ const param_qAnswer = "qa"        // Answer to an Anti-Spam Question
 const param_unknownVal = "X"      // Such Value shows that Client does not know his Parameter
 const param_req_mid = "mid"       // ID of last Message known
 const param_req_ts = "ts"         // Last known Timestamp
+const param_req_read = "rd"       // ID of last Message seen by User
+const param_req_ping = "png"      // Average Ping measured by Client, in Milliseconds
+const param_presence = "st"       // Presence chosen by User
+const param_status = "stx"        // Status Text of User
 
 // Size Limits
 const userName_maxLen = 255       // Maximum Length of the Name for Registration
@@ -101,7 +126,9 @@ func (srv *tServer) init() {
 	// Server Port & Address
 	srv.server.Addr = srv.ipAddress + ":" + srv.port
 	srv.server.IdleTimeout = 30 * time.Second
-	http.Handle("/", http.HandlerFunc(httpHandler))
+	// Own Handler, not the default one: Libraries (e.g. "net/http/pprof")
+	// add their Pages to the default one, without any Authorization.
+	srv.server.Handler = http.HandlerFunc(httpHandler)
 
 	// Actions, Array of "Pointers" to Functions
 	action[0] = page_delta
@@ -114,6 +141,21 @@ func (srv *tServer) init() {
 	action[7] = page_logout
 	action[8] = page_register
 	action[9] = page_asq
+	action[10] = page_upload
+	action[11] = page_file
+	action[12] = page_typing
+	action[13] = page_presence
+	action[14] = page_api
+	action[15] = page_hook
+	action[16] = page_matrix
+	action[17] = page_search
+	action[18] = page_metrics
+	action[19] = page_healthz
+	action[20] = page_readyz
+	action[21] = page_debug
+
+	// REST API
+	rest_init()
 
 	// Server Manager
 	serverJobsChan = make(chan tServerJob, serverJobsBufferSize)
@@ -146,6 +188,29 @@ func (srv *tServer) init() {
 	// Register Manager
 	registerManagerChan = make(chan tRegisterJob, registerManagerChanBufferLen)
 	registerManagerQuit = make(chan int)
+
+	// Announce Manager
+	announceChan = make(chan tAnnounceJob, announceChanBufferLen)
+	announcePingChan = make(chan chan bool)
+	announceManagerQuit = make(chan int)
+
+	// Webhook Manager
+	webhookChan = make(chan tWebhookEvent, webhookChanBufferLen)
+	webhookResultChan = make(chan tWebhookResult, 1)
+	webhookPingChan = make(chan chan bool)
+	webhookManagerQuit = make(chan int)
+
+	// Bridges
+	bridgeQuit = make(chan int)
+
+	// Search Manager
+	searchManagerChan = make(chan tSearchJob, searchManagerChanBufferLen)
+	searchManagerQuit = make(chan int)
+
+	// Statistics Manager
+	statManagerChan = make(chan tStatJob, statManagerChanBufferLen)
+	statManagerQuit = make(chan int)
+	stat_started = time.Now().Unix()
 }
 
 //------------------------------------------------------------------------------
@@ -157,6 +222,16 @@ func (srv *tServer) start() {
 	// Server
 	go srv.startServerRoutine()
 
+	srv.startManagers()
+}
+
+//------------------------------------------------------------------------------
+
+func (srv *tServer) startManagers() {
+
+	// Starts all Managers, Revisors, Bridges and the IRC Gateway, without
+	// the Listener (Tests have their own one).
+
 	// Server Manager
 	go serverJobsManager()
 
@@ -180,6 +255,24 @@ func (srv *tServer) start() {
 
 	// Register Manager
 	go registerManager()
+
+	// Announce Manager
+	go announceManager()
+
+	// Webhook Manager
+	go webhookManager()
+
+	// Search Manager
+	go searchManager()
+
+	// Statistics Manager
+	go statManager()
+
+	// Bridges
+	bridge_start()
+
+	// IRC Gateway
+	irc_start()
 }
 
 //------------------------------------------------------------------------------
@@ -190,10 +283,10 @@ func (srv *tServer) startServerRoutine() {
 
 	var err error
 
-	log.Println("Server started at", srv.server.Addr) //
+	log_info("", "Server started", "addr", srv.server.Addr) //
 	err = srv.server.ListenAndServe()
 	if err != nil {
-		log.Println("Server Error:", err) //
+		log_error("", "Server error", "err", err) //
 		return
 	}
 }
@@ -208,9 +301,10 @@ func (srv *tServer) stop() {
 
 	err = srv.server.Shutdown(nil)
 	if err != nil {
-		log.Println("Error during Server Shutdown:", err) //
+		log_error("", "Error during Server Shutdown", "err", err) //
 		return
 	}
+	irc_stop()
 
 	// Stop Go-Routines
 	serverJobsManagerQuit <- 1
@@ -221,8 +315,13 @@ func (srv *tServer) stop() {
 	chatManagerQuit <- 1
 	loginManagerQuit <- 1
 	registerManagerQuit <- 1
+	announceManagerQuit <- 1
+	webhookManagerQuit <- 1
+	searchManagerQuit <- 1
+	statManagerQuit <- 1
+	bridge_stop()
 
-	log.Print("Server Stopped.\n\n") //
+	log_info("", "Server Stopped.") //
 }
 
 //------------------------------------------------------------------------------
@@ -234,6 +333,18 @@ func httpHandler(w http.ResponseWriter, req *http.Request) {
 	var actionNum uint8
 	var rcvChan chan tServerJob
 	var job *tServerJob
+	var start time.Time
+	var rw *tReplyWriter
+	var rid string
+	var status int
+
+	// Request ID, for the Log
+	rid = log_newRid(req)
+	req = log_withRid(req, rid)
+	w.Header().Set(log_ridHeader, rid)
+
+	// Old Clients may ask for the legacy Format explicitly
+	req = api_stripLegacyPrefix(req)
 
 	switch req.URL.Path {
 
@@ -267,16 +378,52 @@ func httpHandler(w http.ResponseWriter, req *http.Request) {
 	case path_asq:
 		actionNum = 9
 
+	case path_upload:
+		actionNum = 10
+
+	case path_file:
+		actionNum = 11
+
+	case path_typing:
+		actionNum = 12
+
+	case path_presence:
+		actionNum = 13
+
+	case path_search:
+		actionNum = 17
+
+	case path_metrics:
+		actionNum = 18
+
+	case path_healthz:
+		actionNum = 19
+
+	case path_readyz:
+		actionNum = 20
+
 	default:
-		actionNum = 3 // page_index
+		if strings.HasPrefix(req.URL.Path, path_api+"/") {
+			actionNum = 14 // page_api
+		} else if strings.HasPrefix(req.URL.Path, path_hook) {
+			actionNum = 15 // page_hook
+		} else if strings.HasPrefix(req.URL.Path, path_matrix) {
+			actionNum = 16 // page_matrix
+		} else if strings.HasPrefix(req.URL.Path, path_debug) {
+			actionNum = 21 // page_debug
+		} else {
+			actionNum = 3 // page_index
+		}
 	}
 
 	// Creating a Job
+	start = time.Now()
+	rw = &tReplyWriter{ResponseWriter: w}
 	rcvChan = make(chan tServerJob)
 	job = new(tServerJob)
 	job.actionType = actionNum
 	job.req = req
-	job.w = w
+	job.w = rw
 	job.returnChannel = rcvChan // Writing "return-to Address"
 
 	// Send a Job
@@ -284,6 +431,86 @@ func httpHandler(w http.ResponseWriter, req *http.Request) {
 
 	// Wait for Reply
 	*job = <-rcvChan
+
+	// Long Replies are not served by the Jobs Manager, as they would hold
+	// all other Requests
+	if rw.after != nil {
+		rw.after.ServeHTTP(rw, req)
+	}
+
+	metrics_observe(actionNum, time.Since(start))
+	atomic.AddUint64(&stat_bytes[actionNum], rw.bytes)
+
+	status = rw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status >= http.StatusInternalServerError {
+		log_warn(rid, "Request served", "endpoint", metrics_endpoints[actionNum], "method", req.Method,
+			"uid", rw.uid, "code", rw.code, "status", status, "bytes", rw.bytes, "ms", time.Since(start).Milliseconds())
+	} else {
+		log_debug(rid, "Request served", "endpoint", metrics_endpoints[actionNum], "method", req.Method,
+			"uid", rw.uid, "code", rw.code, "status", status, "bytes", rw.bytes, "ms", time.Since(start).Milliseconds())
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func (rw *tReplyWriter) Write(b []byte) (n int, err error) {
+
+	n, err = rw.ResponseWriter.Write(b)
+	rw.bytes += uint64(n)
+
+	return n, err
+}
+
+//------------------------------------------------------------------------------
+
+func (rw *tReplyWriter) WriteHeader(status int) {
+
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+//------------------------------------------------------------------------------
+
+func reply_remember(w http.ResponseWriter, code string, uid uint64) {
+
+	// Remembers the Reply Code or the User for the Log of the Request.
+
+	var rw *tReplyWriter
+	var ok bool
+
+	rw, ok = w.(*tReplyWriter)
+	if !ok {
+		return
+	}
+	if len(code) > 0 {
+		rw.code = code
+	}
+	if uid != 0 {
+		rw.uid = uid
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func reply_after(w http.ResponseWriter, h http.Handler) (ok bool) {
+
+	// Makes the Handler serve the Reply after the Job, in the Go-Routine of
+	// the Request. Gives false if the Writer is not the Server's one (Tests).
+
+	var rw *tReplyWriter
+
+	rw, ok = w.(*tReplyWriter)
+	if !ok {
+		return false
+	}
+	rw.after = h
+
+	return true
 }
 
 //------------------------------------------------------------------------------
@@ -307,7 +534,7 @@ func serverJobsManager() {
 		select {
 		case <-serverJobsManagerQuit:
 			loop = false
-			log.Println("Closing Jobs Manager...") //
+			log_info("", "Closing Jobs Manager...") //
 		default:
 		}
 	}
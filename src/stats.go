@@ -0,0 +1,458 @@
+// stats.go
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	Statistics of the Server, shown at "/t" to Administrators only.
+
+	Counters are changed atomically by the Go-Routines which serve Clients.
+	Once a Minute the statManager takes the Changes of the Counters and puts
+	them into a Ring of Intervals, which keeps the last Day. The Page is
+	plain HTML: Charts are Tables with Bars made of Symbols.
+
+	Pings are measured by Clients and reported in the "png" Parameter of the
+	Request for new Messages (see page_delta).
+
+*/
+
+//------------------------------------------------------------------------------
+
+// Interval of the Statistics
+type tStatSlot struct {
+	time      int64  // Start of the Interval, Unix Timestamp
+	messages  uint64 // Messages posted
+	usersPeak uint64 // Most Users logged in at the same Time
+	bytes     uint64 // Bytes served
+	pingSum   uint64 // Sum of reported Pings, in Milliseconds
+	pingCount uint64 // Count of reported Pings
+}
+
+type tStatJob struct {
+	slots         []tStatSlot // Closed Intervals in chronological Order
+	returnChannel chan tStatJob
+	rid           string // ID of the Request which made the Job, for the Log
+	ping          bool   // Health Check: the Manager only answers
+}
+
+//------------------------------------------------------------------------------
+
+const stat_interval = 60            // Length of an Interval, in Seconds
+const stat_slotsCount = 24 * 60     // Intervals in the Ring, one Day
+const stat_pingMax = 60 * 1000      // Greater Pings are Errors of Clients, in Milliseconds
+const stat_barWidth = 50            // Width of the longest Bar, in Symbols
+const stat_timeFormat = "15:04"     // Time of an Interval in Charts
+const statManagerChanBufferLen = 16 // Buffer Length of the Statistics Manager's Channel
+
+//------------------------------------------------------------------------------
+
+// Counters
+var stat_bytes [srv_actionsCount]uint64 // Bytes served, by Action Number
+var stat_pingSum uint64
+var stat_pingCount uint64
+var stat_usersPeak uint64     // Most Users in the current Interval
+var stat_usersPeakEver uint64 // Most Users since the Start
+var stat_started int64        // Time of the Start, Unix Timestamp
+
+// Ring of Intervals, used by the statManager only
+var statSlots [stat_slotsCount]tStatSlot
+var statSlotsUsed int // Count of Intervals in the Ring
+var statSlotNext int  // Index of the Place for the next Interval
+
+// Channels
+var statManagerChan chan tStatJob
+var statManagerQuit chan int
+
+//------------------------------------------------------------------------------
+
+func statManager() {
+
+	// Closes an Interval each Minute and gives the Intervals to the Page.
+
+	var loop bool = true
+	var job tStatJob
+	var ticker *time.Ticker
+	var last, now tStatSlot // Values of Counters at the Start and at the End of the Interval
+
+	ticker = time.NewTicker(stat_interval * time.Second)
+	defer ticker.Stop()
+
+	last = stat_counters()
+	last.time = time.Now().Unix()
+
+	for loop {
+
+		select {
+
+		case <-ticker.C:
+			now = stat_counters()
+			now.time = time.Now().Unix()
+			statSlots[statSlotNext] = tStatSlot{
+				time:      last.time,
+				messages:  now.messages - last.messages,
+				usersPeak: atomic.SwapUint64(&stat_usersPeak, uint64(activeList_count(""))),
+				bytes:     now.bytes - last.bytes,
+				pingSum:   now.pingSum - last.pingSum,
+				pingCount: now.pingCount - last.pingCount,
+			}
+			statSlotNext = (statSlotNext + 1) % stat_slotsCount
+			if statSlotsUsed < stat_slotsCount {
+				statSlotsUsed++
+			}
+			last = now
+
+		case job = <-statManagerChan:
+			if !job.ping {
+				job.slots = stat_slots()
+			}
+			job.returnChannel <- job // Feedback
+
+		case <-statManagerQuit:
+			loop = false
+			log_info("", "Closing Statistics Manager...") //
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func stat_counters() (s tStatSlot) {
+
+	// Reads the Counters, which only grow.
+
+	var i int
+
+	s.messages = atomic.LoadUint64(&metrics_messagesUser) + atomic.LoadUint64(&metrics_messagesSystem)
+	for i = 0; i < srv_actionsCount; i++ {
+		s.bytes += atomic.LoadUint64(&stat_bytes[i])
+	}
+	s.pingSum = atomic.LoadUint64(&stat_pingSum)
+	s.pingCount = atomic.LoadUint64(&stat_pingCount)
+
+	return s
+}
+
+//------------------------------------------------------------------------------
+
+func stat_slots() (slots []tStatSlot) {
+
+	// Copies the Intervals from the Ring in chronological Order.
+
+	var i, first int
+
+	first = statSlotNext - statSlotsUsed
+	if first < 0 {
+		first += stat_slotsCount
+	}
+
+	slots = make([]tStatSlot, 0, statSlotsUsed)
+	for i = 0; i < statSlotsUsed; i++ {
+		slots = append(slots, statSlots[(first+i)%stat_slotsCount])
+	}
+
+	return slots
+}
+
+//------------------------------------------------------------------------------
+
+func stat_users(count int) {
+
+	// Remembers the Count of logged-in Users if it is a Peak.
+
+	stat_peak(&stat_usersPeak, uint64(count))
+	stat_peak(&stat_usersPeakEver, uint64(count))
+}
+
+//------------------------------------------------------------------------------
+
+func stat_peak(peak *uint64, value uint64) {
+
+	var old uint64
+
+	for {
+		old = atomic.LoadUint64(peak)
+		if (value <= old) || atomic.CompareAndSwapUint64(peak, old, value) {
+			return
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func stat_ping(ms uint64) {
+
+	// Counts a Ping reported by a Client.
+
+	if (ms == 0) || (ms > stat_pingMax) {
+		return
+	}
+
+	atomic.AddUint64(&stat_pingSum, ms)
+	atomic.AddUint64(&stat_pingCount, 1)
+}
+
+//------------------------------------------------------------------------------
+
+func page_stat(w http.ResponseWriter, req *http.Request) {
+
+	// Statistics of the Server, for Administrators.
+
+	var ok bool
+	var uid uint64
+	var rcvChan chan tStatJob
+	var statJob *tStatJob
+	var buf *bytes.Buffer
+	var lastHour []tStatSlot
+	var lang string
+
+	// Correct Cookies & Not Idle ?  & update User's Last Activity Time
+	ok, uid, _, _ = user_check(w, req)
+	if !ok || !admin_is(uid) {
+		lang = i18n_lang(w, req)
+		w.WriteHeader(http.StatusForbidden)
+		i18n_reply(w, lang, html_1, path_index, "stat_adminsOnly", "link_index")
+		return
+	}
+
+	// Create Job
+	rcvChan = make(chan tStatJob)
+	statJob = new(tStatJob)
+	statJob.rid = log_rid(req)
+	statJob.returnChannel = rcvChan
+
+	// Send Job
+	statManagerChan <- *statJob
+
+	// Wait for Feedback
+	*statJob = <-rcvChan
+
+	lastHour = statJob.slots
+	if len(lastHour) > 60 {
+		lastHour = lastHour[len(lastHour)-60:]
+	}
+
+	buf = bytes.NewBuffer(nil)
+	buf.WriteString(html_1)
+	stat_writeSummary(buf, statJob.slots, lastHour, activeList_count(statJob.rid))
+	stat_writeTraffic(buf)
+	stat_writeChart(buf, "Last Day, by Hours", stat_hours(statJob.slots))
+	stat_writeChart(buf, "Last Hour, by Minutes", lastHour)
+	buf.WriteString(html_2)
+
+	w.Write(buf.Bytes())
+}
+
+//------------------------------------------------------------------------------
+
+func stat_writeSummary(buf *bytes.Buffer, slots []tStatSlot, lastHour []tStatSlot, online int) {
+
+	// Writes the main Values. Users online are counted by the activeManager.
+
+	var day, hour tStatSlot
+	var now int64
+	var rate string
+
+	now = time.Now().Unix()
+	day = stat_sum(slots)
+	hour = stat_sum(lastHour)
+
+	rate = "-"
+	if len(lastHour) > 0 {
+		rate = fmt.Sprintf("%.2f", float64(hour.messages)/float64(len(lastHour)))
+	}
+
+	buf.WriteString("<h3>Server</h3>\n<table cellspacing='0' cellpadding='2' border='1' bordercolor='black'>\n")
+	fmt.Fprintf(buf, "<tr><td>Started</td><td>%s UTC</td></tr>\n", time.Unix(stat_started, 0).UTC().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(buf, "<tr><td>Uptime</td><td>%s</td></tr>\n", stat_duration(now-stat_started))
+	fmt.Fprintf(buf, "<tr><td>Messages since the Start</td><td>%d</td></tr>\n",
+		atomic.LoadUint64(&metrics_messagesUser)+atomic.LoadUint64(&metrics_messagesSystem))
+	fmt.Fprintf(buf, "<tr><td>Messages in the last Day</td><td>%d</td></tr>\n", day.messages)
+	fmt.Fprintf(buf, "<tr><td>Messages in the last Hour</td><td>%d</td></tr>\n", hour.messages)
+	fmt.Fprintf(buf, "<tr><td>Messages per Minute, last Hour</td><td>%s</td></tr>\n", rate)
+	fmt.Fprintf(buf, "<tr><td>Users online</td><td>%d</td></tr>\n", online)
+	fmt.Fprintf(buf, "<tr><td>Peak of Users, last Day</td><td>%d</td></tr>\n", day.usersPeak)
+	fmt.Fprintf(buf, "<tr><td>Peak of Users since the Start</td><td>%d</td></tr>\n", atomic.LoadUint64(&stat_usersPeakEver))
+	fmt.Fprintf(buf, "<tr><td>Registered Users</td><td>%d</td></tr>\n", user_count())
+	fmt.Fprintf(buf, "<tr><td>Average Ping, last Hour</td><td>%s</td></tr>\n", stat_avgPing(hour.pingSum, hour.pingCount))
+	fmt.Fprintf(buf, "<tr><td>Average Ping since the Start</td><td>%s</td></tr>\n",
+		stat_avgPing(atomic.LoadUint64(&stat_pingSum), atomic.LoadUint64(&stat_pingCount)))
+	buf.WriteString("</table>\n")
+}
+
+//------------------------------------------------------------------------------
+
+func stat_writeTraffic(buf *bytes.Buffer) {
+
+	// Writes Bytes served per Endpoint since the Start.
+
+	var i, j int
+	var requests, served, total uint64
+
+	buf.WriteString("<h3>Traffic since the Start</h3>\n<table cellspacing='0' cellpadding='2' border='1' bordercolor='black'>\n")
+	buf.WriteString("<tr><td><b>Endpoint</b></td><td align='right'><b>Requests</b></td><td align='right'><b>Served</b></td>" +
+		"<td align='right'><b>Per Request</b></td></tr>\n")
+
+	for i = 0; i < srv_actionsCount; i++ {
+
+		requests = 0
+		for j = 0; j < len(metrics_requests[i].buckets); j++ {
+			requests += atomic.LoadUint64(&metrics_requests[i].buckets[j])
+		}
+		if requests == 0 {
+			continue
+		}
+		served = atomic.LoadUint64(&stat_bytes[i])
+		total += served
+
+		fmt.Fprintf(buf, "<tr><td>%s</td><td align='right'>%d</td><td align='right'>%s</td><td align='right'>%s</td></tr>\n",
+			metrics_endpoints[i], requests, stat_size(served), stat_size(served/requests))
+	}
+
+	fmt.Fprintf(buf, "<tr><td><b>Total</b></td><td></td><td align='right'><b>%s</b></td><td></td></tr>\n", stat_size(total))
+	buf.WriteString("</table>\n")
+}
+
+//------------------------------------------------------------------------------
+
+func stat_writeChart(buf *bytes.Buffer, title string, slots []tStatSlot) {
+
+	// Writes a Chart of Messages: a Table with a Bar in each Row.
+
+	var i int
+	var max uint64
+	var bar int
+
+	fmt.Fprintf(buf, "<h3>%s</h3>\n", title)
+	if len(slots) == 0 {
+		buf.WriteString("No data yet.\n")
+		return
+	}
+
+	for i = 0; i < len(slots); i++ {
+		if slots[i].messages > max {
+			max = slots[i].messages
+		}
+	}
+
+	buf.WriteString("<table cellspacing='0' cellpadding='2' border='1' bordercolor='black'>\n")
+	buf.WriteString("<tr><td><b>UTC</b></td><td align='right'><b>Messages</b></td><td align='right'><b>Users</b></td>" +
+		"<td align='right'><b>Ping</b></td><td align='right'><b>Served</b></td><td></td></tr>\n")
+
+	for i = 0; i < len(slots); i++ {
+
+		bar = 0
+		if slots[i].messages > 0 {
+			bar = int(slots[i].messages * stat_barWidth / max)
+			if bar == 0 {
+				bar = 1
+			}
+		}
+
+		fmt.Fprintf(buf, "<tr><td>%s</td><td align='right'>%d</td><td align='right'>%d</td><td align='right'>%s</td>"+
+			"<td align='right'>%s</td><td><tt>%s</tt></td></tr>\n",
+			time.Unix(slots[i].time, 0).UTC().Format(stat_timeFormat), slots[i].messages, slots[i].usersPeak,
+			stat_avgPing(slots[i].pingSum, slots[i].pingCount), stat_size(slots[i].bytes), strings.Repeat("#", bar))
+	}
+
+	buf.WriteString("</table>\n")
+}
+
+//------------------------------------------------------------------------------
+
+func stat_hours(slots []tStatSlot) (hours []tStatSlot) {
+
+	// Joins the Intervals of each Hour.
+
+	var i int
+	var h *tStatSlot
+
+	for i = 0; i < len(slots); i++ {
+
+		if (len(hours) == 0) || (hours[len(hours)-1].time != slots[i].time-slots[i].time%3600) {
+			hours = append(hours, tStatSlot{time: slots[i].time - slots[i].time%3600})
+		}
+
+		h = &hours[len(hours)-1]
+		h.messages += slots[i].messages
+		h.bytes += slots[i].bytes
+		h.pingSum += slots[i].pingSum
+		h.pingCount += slots[i].pingCount
+		if slots[i].usersPeak > h.usersPeak {
+			h.usersPeak = slots[i].usersPeak
+		}
+	}
+
+	return hours
+}
+
+//------------------------------------------------------------------------------
+
+func stat_sum(slots []tStatSlot) (s tStatSlot) {
+
+	// Joins all the Intervals.
+
+	var i int
+
+	for i = 0; i < len(slots); i++ {
+		s.messages += slots[i].messages
+		s.bytes += slots[i].bytes
+		s.pingSum += slots[i].pingSum
+		s.pingCount += slots[i].pingCount
+		if slots[i].usersPeak > s.usersPeak {
+			s.usersPeak = slots[i].usersPeak
+		}
+	}
+
+	return s
+}
+
+//------------------------------------------------------------------------------
+
+func stat_avgPing(sum uint64, count uint64) string {
+
+	if count == 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%d ms", sum/count)
+}
+
+//------------------------------------------------------------------------------
+
+func stat_size(n uint64) string {
+
+	// Formats a Count of Bytes.
+
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+
+	return fmt.Sprintf("%d B", n)
+}
+
+//------------------------------------------------------------------------------
+
+func stat_duration(seconds int64) string {
+
+	// Formats the Uptime.
+
+	return fmt.Sprintf("%dd %02dh %02dm %02ds", seconds/86400, seconds%86400/3600, seconds%3600/60, seconds%60)
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,59 @@
+// stats_test.go
+
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func TestStatPage(t *testing.T) {
+
+	// Administrators see the Statistics with the Users online. Others get
+	// only a Refusal, without any Numbers of the Server.
+
+	var admin, stranger, anonymous, c *tTestClient
+	var uid, other uint64
+	var online *regexp.Regexp
+	var match []string
+	var status, count int
+	var page string
+
+	harness_quiet(t)
+	admin = harness_client(t, false)
+	uid = admin.register("Zelda", "zelda-pwd")
+	admin.login(uid, "zelda-pwd")
+	defer admin.get(path_logout)
+	stranger = harness_client(t, false)
+	other = stranger.register("Zack", "zack-pwd")
+	stranger.login(other, "zack-pwd")
+	defer stranger.get(path_logout)
+	anonymous = harness_client(t, false)
+	harness_admin(t, uid)
+
+	for _, c = range []*tTestClient{stranger, anonymous} {
+		status, page = c.get(path_stat)
+		if (status != http.StatusForbidden) || strings.Contains(page, "Users online") ||
+			strings.Contains(page, strconv.FormatUint(uid, 10)) || strings.Contains(page, strconv.FormatUint(other, 10)) {
+			t.Errorf("non-admin gets %d: %s", status, page)
+		}
+	}
+
+	status, page = admin.get(path_stat)
+	online = regexp.MustCompile(`<tr><td>Users online</td><td>([0-9]+)</td></tr>`)
+	match = online.FindStringSubmatch(page)
+	if (status != http.StatusOK) || (match == nil) {
+		t.Fatalf("admin gets %d: %s", status, page)
+	}
+	count, _ = strconv.Atoi(match[1])
+	if count < 2 {
+		t.Errorf("%d users online, want 2 at least", count)
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,173 @@
+// theme.go
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	Themes and Branding of the Web Client.
+
+	A Theme is a Palette of CSS Variables (--head, --bg, ...). Pages use
+	only the Variables, so a Theme changes all Colours at once. The Palettes
+	are written into each Page (see theme_css): the first one is the
+	Default, the others apply to <html data-theme='name'>.
+
+	The Theme "auto" follows the Colour Scheme of the User's System: it is
+	the first Palette, or the "dark" one for a dark System.
+
+	Users choose their Theme on the Chat Page. The Choice is kept in the
+	"theme" Cookie, which the Pages read before they are shown. Without the
+	Cookie, the Theme set by the "-theme" Flag is used.
+
+	Operators set the Title ("-title"), the Text of the Logo in the Corner
+	("-logo") and a Message of the Day ("-motd") with Flags. The Message of
+	the Day has the Markup of Messages.
+
+*/
+
+//------------------------------------------------------------------------------
+
+// Palette of a Theme, in the Order of theme_vars
+type tTheme struct {
+	name    string
+	title   string
+	scheme  string // "light" or "dark", for Controls of the Browser
+	palette [len(theme_vars)]string
+}
+
+// Theme which a User can choose
+type tThemeOption struct {
+	Name  string
+	Title string
+}
+
+//------------------------------------------------------------------------------
+
+const theme_default_default = "green" // Theme for Users who have not chosen one
+const theme_auto = "auto"             // Theme which follows the User's System
+const theme_dark = "dark"             // Theme for dark Systems, in "auto"
+const theme_cookie = "theme"          // Cookie with the Theme chosen by a User
+
+//------------------------------------------------------------------------------
+
+// Names of CSS Variables
+var theme_vars = [...]string{
+	"head",      // Header and Footer
+	"head-text", // Text on Header and Footer
+	"bg",        // Messages, Buttons
+	"bg-alt",    // Every second Message, Code
+	"side",      // List of Users
+	"fg",        // Text of Controls and Users
+	"muted",     // System Messages, Users who are away
+	"author",    // Authors and Times of Messages
+	"text",      // Text of Messages
+	"link",      // Links in Messages
+	"dnd",       // Users who do not want to be disturbed
+}
+
+// Themes, the first one is the Default of the CSS
+var theme_list = [...]tTheme{
+	{"green", "Green", "light", [len(theme_vars)]string{
+		"#009933", "#ffffff", "#ecf8ec", "#d9f2d9", "#b3e5b3", "#003311",
+		"#5c8a5c", "#308230", "#153815", "#006622", "#993300"}},
+	{"blue", "Blue", "light", [len(theme_vars)]string{
+		"#1f5fa8", "#ffffff", "#eef4fb", "#dde8f5", "#b9cfe8", "#0d2740",
+		"#5b7290", "#2f5f8f", "#12263d", "#1a4f8a", "#993300"}},
+	{"dark", "Dark", "dark", [len(theme_vars)]string{
+		"#1e2a23", "#d8e8dc", "#121815", "#1a231e", "#24332a", "#cfe3d4",
+		"#7f9a87", "#79c28c", "#e2efe5", "#8fd6a3", "#e0915c"}},
+}
+
+// Parameters
+var theme_default string = theme_default_default
+var theme_motd string // Message of the Day, raw Text
+
+// Internal Parameters
+var theme_styles template.CSS    // Palettes, see theme_css
+var theme_options []tThemeOption // Themes for the Choice of Users
+var theme_motdHtml template.HTML // Message of the Day, safe HTML
+
+//------------------------------------------------------------------------------
+
+func theme_init() (ok bool) {
+
+	// Checks the Parameters and prepares the Palettes for the Pages.
+
+	var i int
+
+	ok = (theme_default == theme_auto)
+	theme_options = nil
+	for i = 0; i < len(theme_list); i++ {
+		theme_options = append(theme_options, tThemeOption{theme_list[i].name, theme_list[i].title})
+		if theme_list[i].name == theme_default {
+			ok = true
+		}
+	}
+	theme_options = append(theme_options, tThemeOption{theme_auto, "Auto"})
+	if !ok {
+		log_error("", "Unknown theme", "theme", theme_default) //
+		return false
+	}
+
+	theme_styles = theme_css()
+	theme_motdHtml = template.HTML(markup_render(theme_motd)) // markup_render escapes the Text
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func theme_css() (css template.CSS) {
+
+	// Writes the Palettes as Rules of CSS Variables:
+	//
+	//	:root{--head:#009933;...}
+	//	:root[data-theme='blue']{--head:#1f5fa8;...}
+	//	@media (prefers-color-scheme:dark){:root[data-theme='auto']{...}}
+
+	var buffer bytes.Buffer
+	var i int
+
+	for i = 0; i < len(theme_list); i++ {
+		if i == 0 {
+			buffer.WriteString(":root")
+		} else {
+			buffer.WriteString(":root[data-theme='" + theme_list[i].name + "']")
+		}
+		theme_rule(&buffer, &theme_list[i])
+	}
+
+	for i = 0; i < len(theme_list); i++ {
+		if theme_list[i].name == theme_dark {
+			buffer.WriteString("@media (prefers-color-scheme:dark){:root[data-theme='" + theme_auto + "']")
+			theme_rule(&buffer, &theme_list[i])
+			buffer.WriteString("}")
+		}
+	}
+
+	// The Palettes are Constants of the Program
+	return template.CSS(buffer.String())
+}
+
+//------------------------------------------------------------------------------
+
+func theme_rule(buffer *bytes.Buffer, theme *tTheme) {
+
+	// Writes the Block of a Palette.
+
+	var i int
+
+	buffer.WriteString("{color-scheme:" + theme.scheme + ";")
+	for i = 0; i < len(theme_vars); i++ {
+		buffer.WriteString("--" + theme_vars[i] + ":" + theme.palette[i] + ";")
+	}
+	buffer.WriteString("}\n")
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,172 @@
+// theme_test.go
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func theme_set(t *testing.T, title, logo, motd, theme string) {
+
+	// Sets the Branding and rebuilds the Pages.
+	// The Defaults are given back when the Test ends.
+
+	harness_templates(t, func() {
+		html_headTitle = html_headTitle_default
+		html_tdTitle = ""
+		theme_motd = ""
+		theme_default = theme_default_default
+	})
+
+	html_headTitle = title
+	html_tdTitle = logo
+	theme_motd = motd
+	theme_default = theme
+}
+
+//------------------------------------------------------------------------------
+
+func TestThemes(t *testing.T) {
+
+	// Each Theme has a Palette and an Option on the Chat Page.
+
+	var i int
+
+	for i = 0; i < len(theme_list); i++ {
+		if (i > 0) && !strings.Contains(string(theme_styles), "[data-theme='"+theme_list[i].name+"']{") {
+			t.Errorf("no palette of %s", theme_list[i].name)
+		}
+		if !strings.Contains(tpl_chat[i18n_base], "<option value='"+theme_list[i].name+"'>") {
+			t.Errorf("no option for %s", theme_list[i].name)
+		}
+	}
+	if !strings.Contains(string(theme_styles), "@media (prefers-color-scheme:dark){:root[data-theme='auto']{") {
+		t.Error("no palette for dark systems")
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestThemeUnknown(t *testing.T) {
+
+	// An unknown Theme stops the Start-Up.
+
+	harness_quiet(t)
+	theme_set(t, "Chat", "", "", "purple")
+	if templates_init() {
+		t.Error("unknown theme is accepted")
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestBranding(t *testing.T) {
+
+	// Title, Logo and Message of the Day are escaped on all Pages.
+
+	var tests = []struct {
+		name string
+		page string
+		want string
+	}{
+		{"index title", "index", "<title>Tom &amp; Jerry</title>"},
+		{"index logo", "index", `td_head_text = "\u003cTJ\u003e";`},
+		{"index motd", "index", "<div class='motd'>Be <b>nice</b> &lt;script&gt;</div>"},
+		{"index theme", "index", `theme_default = "dark";`},
+		{"chat motd", "chat", "<div id='div_motd' class='motd'>Be <b>nice</b> &lt;script&gt;</div>"},
+		{"small pages", "small", "<title>Tom &amp; Jerry</title>"},
+	}
+
+	var pages map[string]string
+	var i int
+
+	theme_set(t, "Tom & Jerry", "<TJ>", "Be **nice** <script>", "dark")
+	if !templates_init() {
+		t.Fatal("templates are refused")
+	}
+
+	// Pages are built again by templates_init
+	pages = map[string]string{"index": tpl_index[i18n_base], "chat": tpl_chat[i18n_base], "small": html_1}
+	for i = 0; i < len(tests); i++ {
+		if !strings.Contains(pages[tests[i].page], tests[i].want) {
+			t.Errorf("%s: page has no %q", tests[i].name, tests[i].want)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestPageBudget(t *testing.T) {
+
+	// The Index and the Chat Page, with all Themes, are sent in about 20 KiB
+	// to Browsers which accept compressed Pages.
+
+	const budget = 20 * 1024
+
+	var tests = []struct {
+		header string
+		gzip   bool
+	}{
+		{"gzip, deflate, br", true},
+		{"br;q=1.0, GZIP;q=0.5", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.000", false},
+		{"deflate", false},
+		{"", false},
+	}
+
+	var req *http.Request
+	var resp *http.Response
+	var zr *gzip.Reader
+	var data []byte
+	var size, i int
+	var err error
+
+	for i = 0; i < len(i18n_options); i++ {
+		size = len(tpl_indexGzip[i18n_options[i].Code]) + len(tpl_chatGzip[i18n_options[i].Code])
+		t.Logf("%s: %d bytes, %d compressed", i18n_options[i].Code,
+			len(tpl_index[i18n_options[i].Code])+len(tpl_chat[i18n_options[i].Code]), size)
+		if (size == 0) || (size > budget) {
+			t.Errorf("%s: pages are %d bytes compressed", i18n_options[i].Code, size)
+		}
+	}
+
+	for i = 0; i < len(tests); i++ {
+		if page_acceptsGzip(tests[i].header) != tests[i].gzip {
+			t.Errorf("%q: gzip %v, want %v", tests[i].header, !tests[i].gzip, tests[i].gzip)
+		}
+	}
+
+	// The Browser gets the same Page
+	req, err = http.NewRequest(http.MethodGet, harness_server.URL+path_index, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.AddCookie(&http.Cookie{Name: i18n_cookie, Value: i18n_base})
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ = ioutil.ReadAll(resp.Body)
+	if (resp.Header.Get("Content-Encoding") != "gzip") || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("page is sent as %q, %q", resp.Header.Get("Content-Encoding"), resp.Header.Get("Content-Type"))
+	}
+	zr, err = gzip.NewReader(bytes.NewReader(data))
+	if err == nil {
+		data, err = ioutil.ReadAll(zr)
+	}
+	if (err != nil) || (string(data) != tpl_index[i18n_base]) {
+		t.Errorf("compressed page differs: %v", err)
+	}
+}
+
+//------------------------------------------------------------------------------
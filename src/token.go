@@ -0,0 +1,295 @@
+// token.go
+
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	API Tokens.
+
+	A Token lets a Bot or a Script use the REST API without Cookies and
+	without answering Anti-Spam Questions. Tokens are created by a logged-in
+	User and act on his Behalf.
+
+	The Token itself is shown only once, when it is created. The Server keeps
+	only the SHA-256 Hash of it, so a stolen Token File can not be used to
+	log in. The File is a Text File, one Token per Line:
+
+		<Hash, hex> <UID> <Time of Creation, Unix Timestamp>
+
+	New Tokens are appended to the File. The File is re-written only when a
+	Token is revoked.
+
+*/
+
+//------------------------------------------------------------------------------
+
+// Lists
+type tApiToken struct {
+	hash    string // SHA-256 of the Token, hex
+	uid     uint64 // Owner of the Token
+	created int64  // Time of Creation, Unix Timestamp
+}
+type tApiTokens map[string]tApiToken // Key = Hash
+
+//------------------------------------------------------------------------------
+
+const file_apiTokens_default = "dat/token.dat" // Path to File with API Tokens
+const token_len = 32                           // Length of a Token, in random Bytes
+const token_idLen = 12                         // Length of a Token's public ID, in hex Symbols
+const token_maxPerUser = 8                     // Maximum Number of Tokens of one User
+const token_authPrefix = "Bearer "             // Prefix of the "Authorization" Header
+
+//------------------------------------------------------------------------------
+
+// Lists
+var apiTokenList tApiTokens
+
+// File
+var file_apiTokens string
+
+//------------------------------------------------------------------------------
+
+func token_init() (ok bool) {
+
+	// Reads the Token File into Memory.
+	// A missing File is not an Error, it is created with the first Token.
+
+	var file *os.File
+	var scanner *bufio.Scanner
+	var fields []string
+	var token *tApiToken
+	var err error
+
+	apiTokenList = make(tApiTokens)
+
+	file, err = os.Open(file_apiTokens)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true
+		}
+		log_error("", "Error opening token file", "file", file_apiTokens, "err", err) //
+		return false
+	}
+	defer func() {
+		err = file.Close()
+		if err != nil {
+			log_error("", "Error closing file", "file", file_apiTokens, "err", err) //
+		}
+	}()
+
+	scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+
+		fields = strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if (len(fields) != 3) || (len(fields[0]) != sha256.Size*2) {
+			log_error("", "Bad line in token file", "file", file_apiTokens) //
+			return false
+		}
+
+		token = new(tApiToken)
+		token.hash = fields[0]
+		token.uid, err = strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			log_error("", "Bad UID in token file", "file", file_apiTokens, "err", err) //
+			return false
+		}
+		token.created, err = strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			log_error("", "Bad time in token file", "file", file_apiTokens, "err", err) //
+			return false
+		}
+		apiTokenList[token.hash] = *token
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		log_error("", "Error reading token file", "file", file_apiTokens, "err", err) //
+		return false
+	}
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func token_hash(token string) (hash string) {
+
+	// Hash of the Token, as it is stored on the Server.
+
+	var sum [sha256.Size]byte
+
+	sum = sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+//------------------------------------------------------------------------------
+
+func token_new(uid uint64) (token string, t tApiToken, ok bool) {
+
+	// Creates a new Token for the User and saves its Hash.
+
+	var buf []byte
+	var file *os.File
+	var err error
+
+	buf = make([]byte, token_len)
+	_, err = rand.Read(buf)
+	if err != nil {
+		log_error("", "Error generating token", "err", err) //
+		return "", t, false
+	}
+	token = hex.EncodeToString(buf)
+
+	t.hash = token_hash(token)
+	t.uid = uid
+	t.created = time.Now().Unix()
+
+	file, err = os.OpenFile(file_apiTokens, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		log_error("", "Error opening token file", "file", file_apiTokens, "err", err) //
+		return "", t, false
+	}
+	defer func() {
+		err = file.Close()
+		if err != nil {
+			log_error("", "Error closing file", "file", file_apiTokens, "err", err) //
+		}
+	}()
+
+	_, err = fmt.Fprintf(file, "%s %d %d\n", t.hash, t.uid, t.created)
+	if err != nil {
+		log_error("", "Error writing token file", "file", file_apiTokens, "err", err) //
+		return "", t, false
+	}
+
+	apiTokenList[t.hash] = t
+
+	return token, t, true
+}
+
+//------------------------------------------------------------------------------
+
+func token_revoke(uid uint64, id string) (ok bool) {
+
+	// Re-writes the Token File without the User's Token with the given
+	// public ID, then deletes the Token. If the File can not be written, the
+	// Token stays valid, as it would be after a Restart.
+
+	var t, revoked tApiToken
+	var found bool
+	var buffer strings.Builder
+	var tmp string
+	var err error
+
+	for _, t = range apiTokenList {
+		if (t.uid == uid) && (token_id(t.hash) == id) {
+			revoked = t
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	for _, t = range apiTokenList {
+		if t.hash != revoked.hash {
+			fmt.Fprintf(&buffer, "%s %d %d\n", t.hash, t.uid, t.created)
+		}
+	}
+
+	// Write into a temporary File, then rename, so that a half-written File
+	// never replaces the good one.
+	tmp = file_apiTokens + ".tmp"
+	err = ioutil.WriteFile(tmp, []byte(buffer.String()), 0600)
+	if err != nil {
+		log_error("", "Error writing token file", "file", tmp, "err", err) //
+		return false
+	}
+	err = os.Rename(tmp, file_apiTokens)
+	if err != nil {
+		log_error("", "Error renaming token file", "file", tmp, "err", err) //
+		return false
+	}
+	delete(apiTokenList, revoked.hash)
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func token_id(hash string) (id string) {
+
+	// Public ID of a Token, safe to be shown. It is a Part of the Hash, not of
+	// the Token.
+
+	return hash[:token_idLen]
+}
+
+//------------------------------------------------------------------------------
+
+func token_listOf(uid uint64) (list []tApiToken) {
+
+	// Tokens of the User.
+
+	var t tApiToken
+
+	for _, t = range apiTokenList {
+		if t.uid == uid {
+			list = append(list, t)
+		}
+	}
+
+	return list
+}
+
+//------------------------------------------------------------------------------
+
+func token_check(req *http.Request) (ok bool, uid uint64) {
+
+	// Checks the "Authorization: Bearer ..." Header of the Request.
+
+	var header string
+	var t tApiToken
+	var exists bool
+
+	header = req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, token_authPrefix) {
+		return false, 0
+	}
+
+	t, exists = apiTokenList[token_hash(strings.TrimSpace(header[len(token_authPrefix):]))]
+	if !exists {
+		return false, 0
+	}
+
+	// Owner must still exist
+	_, exists = user_get(t.uid)
+	if !exists {
+		return false, 0
+	}
+
+	return true, t.uid
+}
+
+//------------------------------------------------------------------------------
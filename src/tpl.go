@@ -4,43 +4,177 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"embed"
 	"fmt"
 	"html"
+	"html/template"
+	"io"
 	"io/ioutil"
-	"log"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
 )
 
 //------------------------------------------------------------------------------
 
-// Configuration-Template Files
+/*
+
+	Templates of Pages.
+
+	Templates use "html/template" with named Fields, e.g.
+
+		<title>{{.HeadTitle}}</title>
+		path_index = {{.PathIndex}};
+
+	Values are escaped for their Context: in a Script a String becomes a
+	quoted JavaScript String, so the Template has no Quotes around it.
+	Each Page has its own Data Structure (tIndexPage, tChatPage,
+	tUserRegisteredPage). A Field which is not in the Structure stops the
+	Server at Start-Up: the Index and Chat Pages are built once at Start-Up,
+	the 'User Registered' Page is tried with a sample UID.
+
+	Texts of the Pages are in the Message Catalogs (see i18n.go), e.g.
+	{{.T.index_welcome}}. The Index and Chat Pages are built for each
+	Language.
+
+	Compatibility Mode. A Template with the "//#//" Separator is in the old
+	Format: the Part before the (first) Separator is filled by Position with
+	"fmt.Sprintf", as in the old Versions, and nothing is escaped. Such a
+	Page is the same in all Languages.
+
+	Default Templates are embedded into the Program. A Template is read from
+	a File instead when its own Flag ("-if", "-cf", "-urf") is set, or when
+	the Directory of "-tpl-dir" has a File with its Name. "-dump-templates"
+	writes the embedded Templates into that Directory, for Editing.
+
+*/
+
+//------------------------------------------------------------------------------
+
+// Data for all Pages
+type tPageCommon struct {
+	HeadTitle   string // Contents of the <head><title>...</title></head>
+	TdTitle     string // Contents of the Upper-Left Corner Cell on Pages
+	Motd        template.HTML
+	Protocol    string
+	PathIndex   string
+	Theme       string            // Default Theme
+	ThemeCookie string            // Name of the Cookie with the User's Theme
+	ThemeCSS    template.CSS      // Palettes
+	Themes      []tThemeOption    // Themes for the Choice of Users
+	Lang        string            // Language of the Page
+	LangCookie  string            // Name of the Cookie with the User's Language
+	Langs       []tLangOption     // Languages for the Choice of Users
+	T           map[string]string // Texts in the Language of the Page
+}
+
+// Data for the Index Page
+type tIndexPage struct {
+	tPageCommon
+	ParamLoginUserID   string
+	ParamLoginPassword string
+	ParamRegUserName   string
+	ParamRegPassword   string
+	ParamQid           string
+	ParamQAnswer       string
+	PathLogin          string
+	PathRegister       string
+	PathStat           string
+	PathAsq            string
+}
+
+// Data for the Chat Page
+type tChatPage struct {
+	tPageCommon
+	PathNews           string
+	PathSend           string
+	PathActiveList     string
+	PathLogout         string
+	PathUpload         string
+	PathFile           string
+	PathTyping         string
+	PathPresence       string
+	PathApi            string
+	CodeNoNews         string
+	CodeBadPOSTdata    string
+	CodeBadRequest     string
+	CodeNotLoggedIn    string
+	CodeEmptyMessage   string
+	CodeMessageSent    string
+	CodeMsgTooLong     string
+	CodeBadFileType    string
+	RedirectDelay      string // Seconds
+	SendToGetDelay     string // Seconds
+	MsgUpdateInterval  string // Seconds
+	UserUpdateInterval string // Seconds
+	TypingInterval     string // Seconds
+	MsgMaxSize         int    // Bytes
+	AttachMaxSize      int    // Bytes
+	StatusMaxLen       int    // Symbols
+	ParamReqMid        string
+	ParamReqTs         string
+	ParamUnknownVal    string
+	ParamReqRead       string
+	ParamReqPing       string
+	ParamFile          string
+	ParamFid           string
+	ParamThumb         string
+	ParamPresence      string
+	ParamStatus        string
+}
+
+// Data for the 'User Registered' Page
+type tUserRegisteredPage struct {
+	tPageCommon
+	Uid uint64
+}
+
+//------------------------------------------------------------------------------
+
+// Configuration-Template Files, Paths inside the embedded Templates
 const file_index_default = "tpl/index.html"                    // Path to Index Page Template
 const file_chat_default = "tpl/chat.html"                      // Path to Chat Page Template
 const file_userRegistered_default = "tpl/user_registered.html" // Path to 'User Registered' Page Template
-const tpl_sep = "//#//"                                        // Separator of variable Part
+const tpl_sep = "//#//"                                        // Separator of variable Part, in the old Format
+const tpl_dir_default = "tpl"                                  // Directory for dumped Templates
+const tpl_embeddedPrefix = "embedded:"                         // Source of embedded Templates, in the Log
 
 // These are HTML-Parts for "small" Pages (Redirectors or Errors).
-const html_headTitle = "Chat" // Contents of the <head><title>...</title></head>
-const html_tdTitle = "Chat"   // Contents of the Upper-Left Corner Cell on Pages
-const html_1 = "<html><head><meta charset='utf-8'><title>" + html_headTitle + "</title></head>\n<body>\n"
-const html_1_toChat = "<html><head><meta charset='utf-8'><title>" + html_headTitle + "</title>" +
-	"<meta http-equiv='refresh' content='" + redirectDelay_str + "; url=" + path_chat + "'/></head>\n<body>\n"
-const html_1_toIndex = "<html><head><meta charset='utf-8'><title>" + html_headTitle + "</title>" +
-	"<meta http-equiv='refresh' content='" + redirectDelay_str + "; url=" + path_index + "'/></head>\n<body>\n"
+const html_headTitle_default = "Chat" // Contents of the <head><title>...</title></head>
 const html_2 = "</body></html>"
 
 //------------------------------------------------------------------------------
 
-// Contents of a File, Template
-var tpl_index, tpl_registeredUsersList string
-var tpl_chat string
-var tpl_userRegistered_p1, tpl_userRegistered_p2, tpl_userRegistered_p3 string // 3 Parts
+// Branding
+var html_headTitle string = html_headTitle_default // Contents of the <head><title>...</title></head>
+var html_tdTitle string                            // Contents of the Upper-Left Corner Cell on Pages, the Title if empty
+
+// HTML-Parts for "small" Pages, with the Title (see template_html)
+var html_1, html_1_toChat, html_1_toIndex string
+
+// Default Templates
+//
+//go:embed tpl/*.html
+var tpl_embedded embed.FS
+
+// Contents of a File, Template; Pages by Language
+var tpl_index map[string]string
+var tpl_chat map[string]string
+var tpl_indexGzip, tpl_chatGzip map[string][]byte // Pages compressed for Browsers which accept it
+var tpl_userRegistered *template.Template
+var tpl_userRegistered_p1, tpl_userRegistered_p2, tpl_userRegistered_p3 string // 3 Parts, in the old Format
 
 // Internal Parameters
 var tpl_sep_len int
+var tpl_userRegisteredLegacy bool // 'User Registered' Template is in the old Format
+var tpl_userRegdFile string       // Source of the 'User Registered' Template, for the Log
+var tpl_dump bool                 // Embedded Templates are written out instead of starting the Server
 
-// Path to File
+// Path to File, empty for the Default
 var file_indexTemplate, file_chatTemplate, file_userRegdTemplate string
+var tpl_dir string // Directory of customized Templates
 
 //------------------------------------------------------------------------------
 
@@ -52,6 +186,17 @@ func templates_init() (ok bool) {
 
 	tpl_sep_len = len(tpl_sep)
 
+	// Languages and Themes, before the Pages
+	ok = i18n_init()
+	if !ok {
+		return ok
+	}
+	ok = theme_init()
+	if !ok {
+		return ok
+	}
+	template_html()
+
 	ok = template_index()
 	if !ok {
 		return ok
@@ -62,6 +207,10 @@ func templates_init() (ok bool) {
 		return ok
 	}
 
+	// Pages are compressed once, not for each Request
+	tpl_indexGzip = template_gzip(tpl_index)
+	tpl_chatGzip = template_gzip(tpl_chat)
+
 	ok = template_userRegistered()
 	if !ok {
 		return ok
@@ -72,22 +221,353 @@ func templates_init() (ok bool) {
 
 //------------------------------------------------------------------------------
 
-func template_index() (ok bool) {
+func template_gzip(pages map[string]string) (packed map[string][]byte) {
 
-	// Prepares the Template of Index Page.
+	// Compresses the Pages. A Page which can not be compressed is sent as it
+	// is.
+
+	var lang string
+	var buffer bytes.Buffer
+	var zw *gzip.Writer
+	var err error
+
+	packed = make(map[string][]byte)
+	for lang = range pages {
+		buffer.Reset()
+		zw, _ = gzip.NewWriterLevel(&buffer, gzip.BestCompression)
+		_, err = zw.Write([]byte(pages[lang]))
+		if err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			log_warn("", "Error compressing page", "lang", lang, "err", err) //
+			continue
+		}
+		packed[lang] = append([]byte(nil), buffer.Bytes()...)
+	}
+
+	return packed
+}
+
+//------------------------------------------------------------------------------
+
+func template_read(fileName, embedded string) (text, source string, legacy, ok bool) {
+
+	// Reads a Template: from its own File if it is set, else from the
+	// Directory of Templates if the File is there, else the embedded one.
+	// Tells whether the Template is in the old Format.
 
 	var buffer []byte
 	var err error
-	var tpl_tmp, tpl_part1_tmp, tpl_part_1, tpl_part_2 string
-	var tpl_sep_pos int
+
+	source = fileName
+	if (len(source) == 0) && (len(tpl_dir) > 0) {
+		source = filepath.Join(tpl_dir, path.Base(embedded))
+		_, err = os.Stat(source)
+		if os.IsNotExist(err) {
+			source = "" // Not customized
+		}
+	}
 
 	// File -> []byte -> string
-	buffer, err = ioutil.ReadFile(file_indexTemplate)
+	if len(source) > 0 {
+		buffer, err = ioutil.ReadFile(source)
+	} else {
+		source = tpl_embeddedPrefix + embedded
+		buffer, err = tpl_embedded.ReadFile(embedded)
+	}
 	if err != nil {
-		log.Println("Error reading file", file_indexTemplate, err) //
+		log_error("", "Error reading file", "file", source, "err", err) //
+		return "", "", false, false
+	}
+	text = string(buffer)
+
+	legacy = strings.Contains(text, tpl_sep)
+	if legacy {
+		log_warn("", "Template is in the old format, nothing is escaped", "file", source) //
+	}
+
+	return text, source, legacy, true
+}
+
+//------------------------------------------------------------------------------
+
+func template_parse(fileName, text string) (tpl *template.Template, ok bool) {
+
+	// Parses a Template in the Format of "html/template".
+
+	var err error
+
+	tpl, err = template.New(fileName).Option("missingkey=error").Parse(text)
+	if err != nil {
+		log_error("", "Error parsing template", "file", fileName, "err", err) //
+		return nil, false
+	}
+
+	return tpl, true
+}
+
+//------------------------------------------------------------------------------
+
+func template_render(fileName, text string, data interface{}) (out string, ok bool) {
+
+	// Parses a Template and fills it with the Data.
+
+	var tpl *template.Template
+	var buffer bytes.Buffer
+	var err error
+
+	tpl, ok = template_parse(fileName, text)
+	if !ok {
+		return "", false
+	}
+
+	err = tpl.Execute(&buffer, data)
+	if err != nil {
+		log_error("", "Error in template", "file", fileName, "err", err) //
+		return "", false
+	}
+
+	return buffer.String(), true
+}
+
+//------------------------------------------------------------------------------
+
+func template_html() {
+
+	// Prepares the HTML-Parts of "small" Pages.
+
+	var title string
+
+	if len(html_tdTitle) == 0 {
+		html_tdTitle = html_headTitle
+	}
+
+	title = html.EscapeString(html_headTitle)
+	html_1 = "<html><head><meta charset='utf-8'><title>" + title + "</title></head>\n<body>\n"
+	html_1_toChat = "<html><head><meta charset='utf-8'><title>" + title + "</title>" +
+		"<meta http-equiv='refresh' content='" + redirectDelay_str + "; url=" + path_chat + "'/></head>\n<body>\n"
+	html_1_toIndex = "<html><head><meta charset='utf-8'><title>" + title + "</title>" +
+		"<meta http-equiv='refresh' content='" + redirectDelay_str + "; url=" + path_index + "'/></head>\n<body>\n"
+}
+
+//------------------------------------------------------------------------------
+
+func template_common(lang string) (common tPageCommon) {
+
+	// Data for all Pages, in the Language.
+
+	var i int
+
+	common.HeadTitle = html_headTitle
+	common.TdTitle = html_tdTitle
+	common.Motd = theme_motdHtml
+	common.Protocol = srv_protocol
+	common.PathIndex = path_index
+	common.Theme = theme_default
+	common.ThemeCookie = theme_cookie
+	common.ThemeCSS = theme_styles
+	common.Themes = make([]tThemeOption, len(theme_options))
+	for i = 0; i < len(theme_options); i++ {
+		common.Themes[i].Name = theme_options[i].Name
+		common.Themes[i].Title = i18n_text(lang, "theme_"+theme_options[i].Name)
+	}
+	common.Lang = lang
+	common.LangCookie = i18n_cookie
+	common.Langs = i18n_options
+	common.T = i18n_catalogs[lang]
+
+	return common
+}
+
+//------------------------------------------------------------------------------
+
+func template_index() (ok bool) {
+
+	// Prepares the Index Page.
+
+	var text, source, page string
+	var legacy bool
+	var data tIndexPage
+	var i int
+
+	text, source, legacy, ok = template_read(file_indexTemplate, file_index_default)
+	if !ok {
+		return false
+	}
+	tpl_index = make(map[string]string)
+	if legacy {
+		page = template_indexLegacy(text)
+		for i = 0; i < len(i18n_options); i++ {
+			tpl_index[i18n_options[i].Code] = page
+		}
+		return true
+	}
+
+	data.ParamLoginUserID = param_login_userID
+	data.ParamLoginPassword = param_login_password
+	data.ParamRegUserName = param_reg_userName
+	data.ParamRegPassword = param_reg_password
+	data.ParamQid = param_qid
+	data.ParamQAnswer = param_qAnswer
+	data.PathLogin = path_login
+	data.PathRegister = path_register
+	data.PathStat = path_stat
+	data.PathAsq = path_asq
+
+	for i = 0; i < len(i18n_options); i++ {
+		data.tPageCommon = template_common(i18n_options[i].Code)
+		tpl_index[i18n_options[i].Code], ok = template_render(source, text, &data)
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func template_chat() (ok bool) {
+
+	// Prepares the Chat Page.
+
+	var text, source, page string
+	var legacy bool
+	var data tChatPage
+	var i int
+
+	text, source, legacy, ok = template_read(file_chatTemplate, file_chat_default)
+	if !ok {
+		return false
+	}
+	tpl_chat = make(map[string]string)
+	if legacy {
+		page = template_chatLegacy(text)
+		for i = 0; i < len(i18n_options); i++ {
+			tpl_chat[i18n_options[i].Code] = page
+		}
+		return true
+	}
+
+	data.PathNews = path_news
+	data.PathSend = path_send
+	data.PathActiveList = path_activeList
+	data.PathLogout = path_logout
+	data.PathUpload = path_upload
+	data.PathFile = path_file
+	data.PathTyping = path_typing
+	data.PathPresence = path_presence
+	data.PathApi = path_api
+	data.CodeNoNews = code_NoNews
+	data.CodeBadPOSTdata = code_BadPOSTdata
+	data.CodeBadRequest = code_BadRequest
+	data.CodeNotLoggedIn = code_NotLoggedIn
+	data.CodeEmptyMessage = code_EmptyMessage
+	data.CodeMessageSent = code_messageSent
+	data.CodeMsgTooLong = code_msgTooLong
+	data.CodeBadFileType = code_badFileType
+	data.RedirectDelay = redirectDelay_str
+	data.SendToGetDelay = sendToGetDelay_str
+	data.MsgUpdateInterval = msgUpdateInterval_str
+	data.UserUpdateInterval = userUpdateInterval_str
+	data.TypingInterval = typingInterval_str
+	data.MsgMaxSize = msgMaxSize
+	data.AttachMaxSize = attach_maxSize
+	data.StatusMaxLen = status_maxLen
+	data.ParamReqMid = param_req_mid
+	data.ParamReqTs = param_req_ts
+	data.ParamUnknownVal = param_unknownVal
+	data.ParamReqRead = param_req_read
+	data.ParamReqPing = param_req_ping
+	data.ParamFile = param_file
+	data.ParamFid = param_fid
+	data.ParamThumb = param_thumb
+	data.ParamPresence = param_presence
+	data.ParamStatus = param_status
+
+	for i = 0; i < len(i18n_options); i++ {
+		data.tPageCommon = template_common(i18n_options[i].Code)
+		tpl_chat[i18n_options[i].Code], ok = template_render(source, text, &data)
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func template_userRegistered() (ok bool) {
+
+	// Prepares the Template of 'User Registered' Page.
+	// The Page is filled for each Registration, so it is tried once here,
+	// in each Language.
+
+	var text string
+	var data tUserRegisteredPage
+	var i int
+	var err error
+
+	text, tpl_userRegdFile, tpl_userRegisteredLegacy, ok = template_read(file_userRegdTemplate, file_userRegistered_default)
+	if !ok {
 		return false
 	}
-	tpl_tmp = string(buffer) // Buffer -> string
+	if tpl_userRegisteredLegacy {
+		return template_userRegisteredLegacy(text)
+	}
+
+	tpl_userRegistered, ok = template_parse(tpl_userRegdFile, text)
+	if !ok {
+		return false
+	}
+
+	data.Uid = chat_systemUserUID
+	for i = 0; i < len(i18n_options); i++ {
+		data.tPageCommon = template_common(i18n_options[i].Code)
+		err = tpl_userRegistered.Execute(ioutil.Discard, &data)
+		if err != nil {
+			log_error("", "Error in template", "file", tpl_userRegdFile, "lang", i18n_options[i].Code, "err", err) //
+			return false
+		}
+	}
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func template_userRegisteredWrite(w io.Writer, lang string, uid uint64) {
+
+	// Writes the 'User Registered' Page for the new User, in the Language.
+
+	var data tUserRegisteredPage
+	var err error
+
+	if tpl_userRegisteredLegacy {
+		fmt.Fprint(w, tpl_userRegistered_p1,
+			fmt.Sprintf(tpl_userRegistered_p2, uid),
+			tpl_userRegistered_p3) //
+		return
+	}
+
+	data.tPageCommon = template_common(lang)
+	data.Uid = uid
+	err = tpl_userRegistered.Execute(w, &data)
+	if err != nil {
+		log_warn("", "Error writing template", "file", tpl_userRegdFile, "err", err) //
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func template_indexLegacy(tpl_tmp string) (tpl string) {
+
+	// Fills the Index Page in the old Format.
+
+	var tpl_part1_tmp, tpl_part_1, tpl_part_2 string
+	var tpl_sep_pos int
 
 	// Find Sepatator
 	tpl_sep_pos = strings.Index(tpl_tmp, tpl_sep)
@@ -113,30 +593,19 @@ func template_index() (ok bool) {
 	tpl_part_2 = tpl_tmp[tpl_sep_pos:]
 
 	// Join Parts
-	tpl_index = tpl_part_1 + tpl_part_2
-
-	return true
+	return tpl_part_1 + tpl_part_2
 }
 
 //------------------------------------------------------------------------------
 
-func template_chat() (ok bool) {
+func template_chatLegacy(tpl_tmp string) (tpl string) {
 
-	// Prepares the Template of Chat Page.
+	// Fills the Chat Page in the old Format. Old Pages know only these
+	// Values: Features added later need the new Format.
 
-	var buffer []byte
-	var err error
-	var tpl_tmp, tpl_part1_tmp, tpl_part_1, tpl_part_2 string
+	var tpl_part1_tmp, tpl_part_1, tpl_part_2 string
 	var tpl_sep_pos int
 
-	// File -> Buffer -> string
-	buffer, err = ioutil.ReadFile(file_chatTemplate)
-	if err != nil {
-		log.Println("Error reading file", file_chatTemplate, err) //
-		return false
-	}
-	tpl_tmp = string(buffer) // Buffer -> string
-
 	// Find Sepatator
 	tpl_sep_pos = strings.Index(tpl_tmp, tpl_sep)
 
@@ -171,30 +640,18 @@ func template_chat() (ok bool) {
 	tpl_part_2 = tpl_tmp[tpl_sep_pos:]
 
 	// Join strings
-	tpl_chat = tpl_part_1 + tpl_part_2
-
-	return true
+	return tpl_part_1 + tpl_part_2
 }
 
 //------------------------------------------------------------------------------
 
-func template_userRegistered() (ok bool) {
+func template_userRegisteredLegacy(tpl_tmp string) (ok bool) {
 
-	// Prepares the Template of 'User Registered' Page.
+	// Prepares the 'User Registered' Page in the old Format.
 
-	var buffer []byte
-	var err error
-	var tpl_tmp, tpl_part1_tmp string
+	var tpl_part1_tmp string
 	var tpl_sep1_pos, tpl_sep2_pos int
 
-	// File -> Buffer -> string
-	buffer, err = ioutil.ReadFile(file_userRegdTemplate)
-	if err != nil {
-		log.Println("Error reading file", file_userRegdTemplate, err) //
-		return false
-	}
-	tpl_tmp = string(buffer) // Buffer -> string
-
 	// Since there is no built-in 'charAt' function for UTF-8 strings in Go,
 	// we use owr own ideas. We do not use the built-in 'strings.Index'
 	// function while we need to find more than one strings and do not want to
@@ -203,6 +660,13 @@ func template_userRegistered() (ok bool) {
 	// Find First Sepatator
 	tpl_sep1_pos = strings.Index(tpl_tmp, tpl_sep)
 
+	// Find Second Sepatator
+	tpl_sep2_pos = strings.Index(tpl_tmp[tpl_sep1_pos+tpl_sep_len:], tpl_sep)
+	if tpl_sep2_pos < 0 {
+		log_error("", "Template in the old format needs two separators", "file", tpl_userRegdFile) //
+		return false
+	}
+
 	// Split first Part from string and fill it
 	tpl_part1_tmp = tpl_tmp[:tpl_sep1_pos]
 	tpl_userRegistered_p1 = fmt.Sprintf(tpl_part1_tmp,
@@ -211,9 +675,6 @@ func template_userRegistered() (ok bool) {
 		path_index,
 		html_tdTitle)
 
-	// Find Second Sepatator
-	tpl_sep2_pos = strings.Index(tpl_tmp[tpl_sep1_pos+tpl_sep_len:], tpl_sep)
-
 	// Split second Part, without filling
 	tpl_userRegistered_p2 = tpl_tmp[tpl_sep1_pos : tpl_sep1_pos+tpl_sep_len+tpl_sep2_pos]
 	// tpl_userRegistered_p2 will be filled during each User's Registration
@@ -226,27 +687,66 @@ func template_userRegistered() (ok bool) {
 
 //------------------------------------------------------------------------------
 
-func saveRegisteredUsersToTpl() {
+func templates_dump() (ok bool) {
 
-	// Saves all the registered Users to a string.
-	// ! Must be run after userData_init() !
+	// Writes the embedded Templates into the Directory of Templates, for
+	// Editing. Existing Files are not overwritten.
+
+	var dir, fileName string
+	var names []string
+	var buffer []byte
+	var file *os.File
+	var err error
+	var i int
 
-	var buffer *bytes.Buffer
-	var i uint64
-	var v tUserData
+	dir = tpl_dir
+	if len(dir) == 0 {
+		dir = tpl_dir_default
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		log_error("", "Error creating directory", "dir", dir, "err", err) //
+		return false
+	}
 
-	// Save Template
-	buffer = bytes.NewBuffer(nil)
-	buffer.WriteString(html_1)
-	buffer.WriteString("<table cellspacing='0' cellpadding='2' border='1' bordercolor='black'>")
-	buffer.WriteString("<tr><td align='right'><b>UID</b></td><td width='5'></td><td><b>Name</b></td></tr>")
-	for i, v = range userDataList {
-		buffer.WriteString(fmt.Sprintf("<tr><td align='right'>%d</td><td></td><td>%s</td></tr>",
-			i, html.EscapeString(v.name)))
+	names = []string{file_index_default, file_chat_default, file_userRegistered_default}
+	ok = true
+	for i = 0; i < len(names); i++ {
+
+		buffer, err = tpl_embedded.ReadFile(names[i])
+		if err != nil {
+			log_error("", "Error reading file", "file", tpl_embeddedPrefix+names[i], "err", err) //
+			return false
+		}
+
+		fileName = filepath.Join(dir, path.Base(names[i]))
+		file, err = os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			if os.IsExist(err) {
+				log_error("", "Template file exists, it is not overwritten", "file", fileName) //
+			} else {
+				log_error("", "Error creating file", "file", fileName, "err", err) //
+			}
+			ok = false
+			continue
+		}
+		_, err = file.Write(buffer)
+		if err != nil {
+			log_error("", "Error writing file", "file", fileName, "err", err) //
+			ok = false
+			file.Close()
+			continue
+		}
+		err = file.Close()
+		if err != nil {
+			log_error("", "Error closing file", "file", fileName, "err", err) //
+			ok = false
+			continue
+		}
+		log_info("", "Template is written", "file", fileName) //
 	}
-	buffer.WriteString("</table>")
-	buffer.WriteString(html_2)
-	tpl_registeredUsersList = buffer.String()
+
+	return ok
 }
 
 //------------------------------------------------------------------------------
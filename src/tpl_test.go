@@ -0,0 +1,240 @@
+// tpl_test.go
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func tpl_testFile(t *testing.T, file *string, text string) {
+
+	// Points the Template's Path to a temporary File with the Text.
+	// The real Path is given back when the Test ends.
+
+	var real string
+	var err error
+
+	real = *file
+	harness_templates(t, func() {
+		*file = real
+	})
+	*file = filepath.Join(t.TempDir(), "page.html")
+	err = ioutil.WriteFile(*file, []byte(text), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestTemplates(t *testing.T) {
+
+	// Pages have the Values of the Server in their Scripts.
+
+	var tests = []struct {
+		name string
+		page string
+		want string
+	}{
+		{"index title", tpl_index[i18n_base], "<title>" + html_headTitle + "</title>"},
+		{"index path", tpl_index[i18n_base], "path_login = " + strconv.Quote(path_login) + ";"},
+		{"chat path", tpl_chat[i18n_base], "get_postfix = " + strconv.Quote(path_news) + ";"},
+		{"chat number", tpl_chat[i18n_base], "msgMaxSize =  " + strconv.Itoa(msgMaxSize) + " ;"},
+	}
+
+	var buf bytes.Buffer
+	var i int
+
+	for i = 0; i < len(tests); i++ {
+		if !strings.Contains(tests[i].page, tests[i].want) {
+			t.Errorf("%s: page has no %q", tests[i].name, tests[i].want)
+		}
+	}
+
+	template_userRegisteredWrite(&buf, i18n_base, 1234567)
+	if !strings.Contains(buf.String(), "uid =  1234567 ;") {
+		t.Errorf("user registered: no UID in %s", buf.String())
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestTemplateEscaping(t *testing.T) {
+
+	// Values are escaped for their Context.
+
+	var data tPageCommon
+	var out string
+	var ok bool
+
+	data.HeadTitle = "<b>Chat</b>"
+	data.TdTitle = `"</script><script>alert(1)//`
+	out, ok = template_render("test", "<title>{{.HeadTitle}}</title><script>t = {{.TdTitle}};</script>", &data)
+	if !ok {
+		t.Fatal("template is refused")
+	}
+	if strings.Contains(out, "<b>") || (strings.Count(out, "<script>") != 1) {
+		t.Errorf("values are not escaped: %s", out)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestTemplateUnknownField(t *testing.T) {
+
+	// A Field which is not in the Page's Data stops the Start-Up.
+
+	var tests = []struct {
+		name string
+		file *string
+		init func() bool
+	}{
+		{"index", &file_indexTemplate, template_index},
+		{"chat", &file_chatTemplate, template_chat},
+		{"user registered", &file_userRegdTemplate, template_userRegistered},
+	}
+
+	var i int
+
+	harness_quiet(t)
+	for i = 0; i < len(tests); i++ {
+		t.Run(tests[i].name, func(t *testing.T) {
+			tpl_testFile(t, tests[i].file, "<title>{{.HeadTitle}}</title><script>x = {{.NoSuchField}};</script>")
+			if tests[i].init() {
+				t.Error("template with an unknown field is accepted")
+			}
+		})
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestTemplateLegacy(t *testing.T) {
+
+	// Templates in the old Format are filled by Position.
+
+	var buf bytes.Buffer
+
+	harness_quiet(t)
+	tpl_testFile(t, &file_userRegdTemplate,
+		"<title>%s</title>\n<script>\nprotocol = '%s'; path_index = '%s'; td_head_text = '%s';\n"+
+			"//#//\nfunction init_2() { uid = '%d'; }\n//#//\n</script>\n<!-- 100% -->\n")
+	if !template_userRegistered() {
+		t.Fatal("template in the old format is refused")
+	}
+
+	template_userRegisteredWrite(&buf, i18n_base, 42)
+	if buf.String() != "<title>"+html_headTitle+"</title>\n<script>\nprotocol = '"+srv_protocol+"'; path_index = '"+path_index+
+		"'; td_head_text = '"+html_tdTitle+"';\n//#//\nfunction init_2() { uid = '42'; }\n//#//\n</script>\n<!-- 100% -->\n" {
+		t.Errorf("page is %q", buf.String())
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestTemplateLegacyChat(t *testing.T) {
+
+	// The Chat Page of the first Version is filled with exactly its Values.
+
+	var data []byte
+	var page string
+	var err error
+
+	data, err = ioutil.ReadFile(filepath.Join("testdata", "chat_legacy.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	harness_quiet(t)
+	tpl_testFile(t, &file_chatTemplate, string(data))
+	if !template_chat() {
+		t.Fatal("template in the old format is refused")
+	}
+
+	page = tpl_chat[i18n_base]
+	if strings.Contains(page, "%!") {
+		t.Errorf("page has formatting errors: %q", page[strings.Index(page, "%!"):])
+	}
+	if !strings.Contains(page, "param_unknownVal = '"+param_unknownVal+"';") ||
+		!strings.Contains(page, "msgMaxSize = eval('"+strconv.Itoa(msgMaxSize)+"');") {
+		t.Error("page has not the values")
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestTemplateDir(t *testing.T) {
+
+	// A Template in the Directory replaces the embedded one, the others stay.
+
+	var dir, chat string
+	var err error
+
+	dir = t.TempDir()
+	err = ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("<p>{{.TdTitle}}</p>"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chat = tpl_chat[i18n_base]
+	tpl_dir = dir
+	harness_templates(t, func() {
+		tpl_dir = ""
+	})
+
+	if !templates_init() {
+		t.Fatal("templates are refused")
+	}
+	if tpl_index[i18n_base] != "<p>"+html_tdTitle+"</p>" {
+		t.Errorf("index is %q", tpl_index[i18n_base])
+	}
+	if tpl_chat[i18n_base] != chat {
+		t.Error("chat is not the embedded one")
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestTemplatesDump(t *testing.T) {
+
+	// Dumped Templates are the embedded ones, and they are not overwritten.
+
+	var names = []string{file_index_default, file_chat_default, file_userRegistered_default}
+
+	var dumped, embedded []byte
+	var err error
+	var i int
+
+	tpl_dir = filepath.Join(t.TempDir(), "tpl")
+	t.Cleanup(func() {
+		tpl_dir = ""
+	})
+	harness_quiet(t)
+
+	if !templates_dump() {
+		t.Fatal("templates are not dumped")
+	}
+	for i = 0; i < len(names); i++ {
+		dumped, err = ioutil.ReadFile(filepath.Join(tpl_dir, filepath.Base(names[i])))
+		if err != nil {
+			t.Fatal(err)
+		}
+		embedded, _ = tpl_embedded.ReadFile(names[i])
+		if !bytes.Equal(dumped, embedded) {
+			t.Errorf("%s differs from the embedded one", names[i])
+		}
+	}
+
+	if templates_dump() {
+		t.Error("existing templates are overwritten")
+	}
+}
+
+//------------------------------------------------------------------------------
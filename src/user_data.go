@@ -6,10 +6,12 @@ import (
 	"bufio"
 	"encoding/binary"
 	"io"
-	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -30,7 +32,12 @@ const file_userData_default = "dat/user.dat" // Path to File with User Data
 //------------------------------------------------------------------------------
 
 // Lists
-var userDataList tUserDatas
+var userDataList tUserDatas // Guarded by userDataLock, see user_get
+var userDataLock sync.RWMutex
+var adminList map[uint64]bool // UIDs of Administrators
+
+// Internal Parameters
+var admins_str string // Administrators, as set by the Flag
 
 // File
 var file_userData string
@@ -56,14 +63,14 @@ func userData_init() (ok bool) {
 				userData_create(&file_userData)
 			} else {
 
-				log.Println("User Data File not found.", file_userData) //
+				log_error("", "User Data File not found", "file", file_userData) //
 				return false
 			}
 
 		} else {
 
 			// Other Error
-			log.Println("Error with File Stat", file_userData) //
+			log_error("", "Error with File Stat", "file", file_userData, "err", err) //
 			return false
 		}
 	}
@@ -72,11 +79,13 @@ func userData_init() (ok bool) {
 	ptr = userData_read(file_userData)
 	if ptr == nil {
 
-		log.Println("Error getting userData") //
+		log_error("", "Error getting userData", "file", file_userData) //
 		return false
 	}
 
+	userDataLock.Lock()
 	userDataList = *ptr
+	userDataLock.Unlock()
 	return true
 }
 
@@ -93,17 +102,24 @@ func userData_create(fileName *string) {
 	var file *os.File
 	var err error
 
+	// The Directory may be missing at first Start
+	err = os.MkdirAll(filepath.Dir(*fileName), 0755)
+	if err != nil {
+		log_error("", "Error creating directory", "file", *fileName, "err", err) //
+		return
+	}
+
 	// Create a new file if none exists
 	file, err = os.OpenFile(*fileName, os.O_CREATE, 0755)
 	if err != nil {
-		log.Println("Error creating config at", fileName, err) //
+		log_error("", "Error creating config", "file", *fileName, "err", err) //
 		return
 	}
 
 	// Close File immediately
 	err = file.Close()
 	if err != nil {
-		log.Println("Error closing file", fileName, err) //
+		log_error("", "Error closing file", "file", *fileName, "err", err) //
 		return
 	}
 
@@ -120,139 +136,91 @@ func userData_read(fileName string) (ud *tUserDatas) {
 
 	var file *os.File
 	var err error
-	var reader *bufio.Reader
-	var t1buf, t2buf, t3buf, t4buf, t5buf, t6buf []byte
-	var n int
-	var t1 uint64
-	var t2 int64
-	var t3, t5 uint8
-	var t4, t6 string
-	var udt tUserDatas
-	var userData *tUserData
 
 	file, err = os.Open(fileName)
 	if err != nil {
-		log.Println("Error opening user data file at", fileName, err) //
+		log_error("", "Error opening user data file", "file", fileName, "err", err) //
 		return nil
 	}
 	defer func() {
 		err = file.Close()
 		if err != nil {
-			log.Println("Error closing file", fileName, err) //
+			log_error("", "Error closing file", "file", fileName, "err", err) //
 		}
 	}()
 
-	reader = bufio.NewReader(file)
+	return userData_decode(bufio.NewReader(file), fileName)
+}
+
+//------------------------------------------------------------------------------
+
+func userData_decode(reader io.Reader, fileName string) (ud *tUserDatas) {
+
+	// Decodes User Data, Record after Record, up to the End of Data.
+	// Each Field is read fully: a Reader may give less than asked, and a
+	// Record which is cut (e.g. by a full Disk) means broken Data.
+	// If Errors occur, the function returns nil Pointer.
+
+	var err error
+	var t1buf, t2buf, t3buf, t4buf, t5buf, t6buf []byte
+	var t1 uint64
+	var t2 int64
+	var t3, t5 uint8
+	var t4, t6 string
+	var udt tUserDatas
+	var userData *tUserData
+
 	t1buf = make([]byte, 8)
 	t2buf = make([]byte, 8)
 	t3buf = make([]byte, 1)
-	t4buf = []byte{}
 	t5buf = make([]byte, 1)
-	t6buf = []byte{}
 
 	udt = make(tUserDatas)
 
 	for {
 		// Read UID [8 Bytes]
-		n, err = reader.Read(t1buf)
-		if err != nil {
-			if err != io.EOF {
-				log.Println("Error reading from file.", err) //
-				return nil
-			} else {
-				break
-			}
+		_, err = io.ReadFull(reader, t1buf)
+		if err == io.EOF {
+			break // No more Records
 		}
-
-		if n < 8 {
-			log.Println("Read Portion is Less than it should be!") //
+		if !userData_readOk(err, fileName) {
 			return nil
 		}
 		t1 = binary.LittleEndian.Uint64(t1buf)
 
 		// Read RegTime [8 Bytes]
-		n, err = reader.Read(t2buf)
-		if err != nil {
-			if err != io.EOF {
-				log.Println("Error reading from file.", err) //
-				return nil
-			} else {
-				break
-			}
-		}
-
-		if n < 8 {
-			log.Println("Read Portion is Less than it should be!") //
+		_, err = io.ReadFull(reader, t2buf)
+		if !userData_readOk(err, fileName) {
 			return nil
 		}
 		t2 = int64(binary.LittleEndian.Uint64(t2buf))
 
 		// Read Length of PWD [1 Byte]
-		n, err = reader.Read(t3buf)
-		if err != nil {
-			if err != io.EOF {
-				log.Println("Error reading from file.", err) //
-				return nil
-			} else {
-				break
-			}
-		}
-
-		if n < 1 {
-			log.Println("Read Portion is Less than it should be!") //
+		_, err = io.ReadFull(reader, t3buf)
+		if !userData_readOk(err, fileName) {
 			return nil
 		}
 		t3 = t3buf[0]
 
 		// Read PWD [Several Bytes]
 		t4buf = make([]byte, t3)
-		n, err = reader.Read(t4buf)
-		if err != nil {
-			if err != io.EOF {
-				log.Println("Error reading from file.", err) //
-				return nil
-			} else {
-				break
-			}
-		}
-
-		if n < int(t3) {
-			log.Println("Read Portion is Less than it should be!") //
+		_, err = io.ReadFull(reader, t4buf)
+		if !userData_readOk(err, fileName) {
 			return nil
 		}
 		t4 = string(t4buf)
 
 		// Read Length of Name [1 Byte]
-		n, err = reader.Read(t5buf)
-		if err != nil {
-			if err != io.EOF {
-				log.Println("Error reading from file.", err) //
-				return nil
-			} else {
-				break
-			}
-		}
-
-		if n < 1 {
-			log.Println("Read Portion is Less than it should be!") //
+		_, err = io.ReadFull(reader, t5buf)
+		if !userData_readOk(err, fileName) {
 			return nil
 		}
 		t5 = t5buf[0]
 
 		// Read Name [Several Bytes]
 		t6buf = make([]byte, t5)
-		n, err = reader.Read(t6buf)
-		if err != nil {
-			if err != io.EOF {
-				log.Println("Error reading from file.", err) //
-				return nil
-			} else {
-				break
-			}
-		}
-
-		if n < int(t5) {
-			log.Println("Read Portion is Less than it should be!") //
+		_, err = io.ReadFull(reader, t6buf)
+		if !userData_readOk(err, fileName) {
 			return nil
 		}
 		t6 = string(t6buf)
@@ -267,7 +235,24 @@ func userData_read(fileName string) (ud *tUserDatas) {
 
 	ud = &udt
 	return ud
+}
+
+//------------------------------------------------------------------------------
+
+func userData_readOk(err error, fileName string) (ok bool) {
 
+	// Checks the Error of a Field's Read.
+	// The End of Data inside a Record means a cut Record.
+
+	if err == nil {
+		return true
+	}
+	if (err == io.EOF) || (err == io.ErrUnexpectedEOF) {
+		log_error("", "Record is cut", "file", fileName) //
+		return false
+	}
+	log_error("", "Error reading from file", "file", fileName, "err", err) //
+	return false
 }
 
 //------------------------------------------------------------------------------
@@ -310,19 +295,19 @@ func userData_creatSysUser(fileName *string) {
 	// Open the File
 	file, err = os.OpenFile(*fileName, os.O_WRONLY|os.O_APPEND, 0755)
 	if err != nil {
-		log.Println("Error opening config at", file_userData, err) //
+		log_error("", "Error opening config", "file", file_userData, "err", err) //
 		return
 	}
 	defer func() {
 		err = file.Close()
 		if err != nil {
-			log.Println("Error closing file", file_userData, err) //
+			log_error("", "Error closing file", "file", file_userData, "err", err) //
 		}
 	}()
 
 	ok = userData_write(ud, chat_systemUserUID, file)
 	if !ok {
-		log.Println("Error during writing user to file") //
+		log_error("", "Error during writing user to file", "file", file_userData) //
 		return
 	}
 }
@@ -345,7 +330,7 @@ func userData_write(ud *tUserData, uid uint64, file io.Writer) (ok bool) {
 	if t4_len <= 255 {
 		t3 = uint8(t4_len)
 	} else {
-		log.Println("Too long pwd") //
+		log_warn("", "Too long pwd", "uid", uid) //
 		return false
 	}
 
@@ -354,39 +339,39 @@ func userData_write(ud *tUserData, uid uint64, file io.Writer) (ok bool) {
 	if t6_len <= 255 {
 		t5 = uint8(t6_len)
 	} else {
-		log.Println("Too long Name") //
+		log_warn("", "Too long Name", "uid", uid) //
 		return false
 	}
 
 	// Output to File
 	err = binary.Write(file, binary.LittleEndian, t1)
 	if err != nil {
-		log.Println("Error printing to file", file_userData, err) //
+		log_error("", "Error printing to file", "file", file_userData, "err", err) //
 		return false
 	}
 	err = binary.Write(file, binary.LittleEndian, t2)
 	if err != nil {
-		log.Println("Error printing to file", file_userData, err) //
+		log_error("", "Error printing to file", "file", file_userData, "err", err) //
 		return false
 	}
 	err = binary.Write(file, binary.LittleEndian, t3)
 	if err != nil {
-		log.Println("Error printing to file", file_userData, err) //
+		log_error("", "Error printing to file", "file", file_userData, "err", err) //
 		return false
 	}
 	err = binary.Write(file, binary.LittleEndian, t4)
 	if err != nil {
-		log.Println("Error printing to file", file_userData, err) //
+		log_error("", "Error printing to file", "file", file_userData, "err", err) //
 		return false
 	}
 	err = binary.Write(file, binary.LittleEndian, t5)
 	if err != nil {
-		log.Println("Error printing to file", file_userData, err) //
+		log_error("", "Error printing to file", "file", file_userData, "err", err) //
 		return false
 	}
 	err = binary.Write(file, binary.LittleEndian, t6)
 	if err != nil {
-		log.Println("Error printing to file", file_userData, err) //
+		log_error("", "Error printing to file", "file", file_userData, "err", err) //
 		return false
 	}
 	return true
@@ -408,11 +393,18 @@ func user_register(name, pwd *string) (ok bool, uid uint64) {
 	var file *os.File
 
 	if (len(*name) > userName_maxLen) || (len(*pwd) > userPwd_maxLen) {
-		log.Println("Too long Name or Password") //
+		log_warn("", "Too long Name or Password") //
 		return false, 0
 	}
 
-	// Generating random UID
+	// Create a Struct
+	ud = new(tUserData)
+	ud.name = *name
+	ud.pwd = *pwd
+	ud.reg_time = time.Now().Unix()
+
+	// Generating random UID and Adding to the List, no other Writer between
+	userDataLock.Lock()
 	for exists {
 		tmp_uid = generateRandomUint64()
 		_, exists = userDataList[tmp_uid]
@@ -422,24 +414,19 @@ func user_register(name, pwd *string) (ok bool, uid uint64) {
 			break
 		}
 	}
-
-	// Create a Struct and Add to the List
-	ud = new(tUserData)
-	ud.name = *name
-	ud.pwd = *pwd
-	ud.reg_time = time.Now().Unix()
 	userDataList[tmp_uid] = *ud
+	userDataLock.Unlock()
 
 	// Adding to File
 	file, err = os.OpenFile(file_userData, os.O_WRONLY|os.O_APPEND, 0755)
 	if err != nil {
-		log.Println("Error opening config at", file_userData, err) //
+		log_error("", "Error opening config", "file", file_userData, "err", err) //
 		return false, 0
 	}
 	defer func() {
 		err = file.Close()
 		if err != nil {
-			log.Println("Error closing file", file_userData, err) //
+			log_error("", "Error closing file", "file", file_userData, "err", err) //
 		}
 	}()
 
@@ -449,6 +436,47 @@ func user_register(name, pwd *string) (ok bool, uid uint64) {
 
 //------------------------------------------------------------------------------
 
+func user_get(uid uint64) (ud tUserData, exists bool) {
+
+	// Gives the Data of a User.
+	// The List is read by many Go-Routines while Users, Puppets of Bridges
+	// and Bots are added, so each Access goes through userDataLock.
+
+	userDataLock.RLock()
+	ud, exists = userDataList[uid]
+	userDataLock.RUnlock()
+
+	return ud, exists
+}
+
+//------------------------------------------------------------------------------
+
+func user_name(uid uint64) (name string) {
+
+	// Gives the Name of a User, empty for an unknown one.
+
+	var ud tUserData
+
+	ud, _ = user_get(uid)
+
+	return ud.name
+}
+
+//------------------------------------------------------------------------------
+
+func user_count() (n int) {
+
+	// Gives the Number of Users.
+
+	userDataLock.RLock()
+	n = len(userDataList)
+	userDataLock.RUnlock()
+
+	return n
+}
+
+//------------------------------------------------------------------------------
+
 func user_isGood(uid uint64, pwd *string) (ok bool) {
 
 	// Checks if User exists and User's Password matches the Actual Password.
@@ -456,7 +484,7 @@ func user_isGood(uid uint64, pwd *string) (ok bool) {
 	var ud tUserData
 	var exists bool
 
-	ud, exists = userDataList[uid]
+	ud, exists = user_get(uid)
 	if exists {
 		if ud.pwd == *pwd {
 			return true
@@ -488,7 +516,6 @@ func user_check(w http.ResponseWriter, req *http.Request) (cookies_ok bool, user
 	cookie_uid, err_1 = req.Cookie("UID")
 	cookie_sid, err_2 = req.Cookie("SID")
 	if (err_1 != nil) || (err_2 != nil) {
-		//log.Println("No Cookies:", err_1, err_2) //dbg
 		// No Cookies
 		return false, 0, 0, 0
 	}
@@ -498,14 +525,14 @@ func user_check(w http.ResponseWriter, req *http.Request) (cookies_ok bool, user
 	cookie_sid_str = cookie_sid.Value
 	uid, err_3 = strconv.ParseUint(cookie_uid_str, 10, 64)
 	if err_3 != nil {
-		log.Println("user_check: Bad UID in Cookie:", err_3) //dbg
+		log_warn(log_rid(req), "Bad UID in Cookie", "err", err_3) //
 		return false, 0, 0, 0
 	}
 
 	// Active Client Existance
 	_, exists = activeClientsList[uid]
 	if !exists {
-		//log.Println("User is not active") //dbg
+		log_debug(log_rid(req), "User is not active", "uid", uid) //
 		return false, 0, 0, 0
 	}
 
@@ -517,6 +544,7 @@ func user_check(w http.ResponseWriter, req *http.Request) (cookies_ok bool, user
 	activeJob.action = activeJobGetUser // Get User
 	activeJob.returnChannel = rcvChan
 	activeJob.uid = uid
+	activeJob.rid = log_rid(req)
 	//
 	// Send Job
 	activeManagerChan <- *activeJob
@@ -526,7 +554,7 @@ func user_check(w http.ResponseWriter, req *http.Request) (cookies_ok bool, user
 
 	// SID Match
 	if cookie_sid_str != activeJob.client.sid { // instead of thread-unsafe: activeClientsList[uid].sid
-		//log.Println("SID does not match. Cookie has", cookie_sid_str, ", needed", activeJob.client.sid) //dbg
+		log_debug(log_rid(req), "SID does not match", "uid", uid) //
 		return false, 0, 0, 0
 	}
 
@@ -549,12 +577,13 @@ func user_check(w http.ResponseWriter, req *http.Request) (cookies_ok bool, user
 		log_mid = activeJob.client.log_mid // instead of thread-unsafe: lmid = activeClientsList[uid].log_mid
 		log_ts = activeJob.client.log_ts
 
+		reply_remember(w, "", uid)
 		return true, uid, log_mid, log_ts
 
 	} else {
 
 		// User is idle
-		//log.Println("User is idle") //dbg
+		log_debug(log_rid(req), "User is idle", "uid", uid) //
 		// Delete from Active List -> activeManager
 
 		// Create Job
@@ -563,6 +592,7 @@ func user_check(w http.ResponseWriter, req *http.Request) (cookies_ok bool, user
 		activeJob.action = activeJobDelete // Delete
 		activeJob.returnChannel = rcvChan
 		activeJob.uid = uid
+		activeJob.rid = log_rid(req)
 
 		// Send Job
 		activeManagerChan <- *activeJob
@@ -570,9 +600,58 @@ func user_check(w http.ResponseWriter, req *http.Request) (cookies_ok bool, user
 		// Get Feedback
 		*activeJob = <-rcvChan
 
+		announce(uid, announceEventTimeout)
+		webhook_emit(webhookEventLeave, uid, webhookReasonTimeout, 0, false)
+
 		// Session has ended
 		return false, 0, 0, 0
 	}
 }
 
 //------------------------------------------------------------------------------
+
+func admin_init() (ok bool) {
+
+	// Reads the List of Administrators: UIDs separated by Commas.
+	// Must be run after userData_init() !
+
+	var field string
+	var uid uint64
+	var exists bool
+	var err error
+
+	adminList = make(map[uint64]bool)
+
+	for _, field = range strings.Split(admins_str, ",") {
+
+		field = strings.TrimSpace(field)
+		if len(field) == 0 {
+			continue
+		}
+
+		uid, err = strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			log_error("", "Bad UID of an administrator", "uid", field) //
+			return false
+		}
+		_, exists = user_get(uid)
+		if !exists {
+			log_warn("", "Administrator is not registered", "uid", uid) //
+		}
+
+		adminList[uid] = true
+	}
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func admin_is(uid uint64) (ok bool) {
+
+	// Checks if the User is an Administrator.
+
+	return adminList[uid]
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,110 @@
+// user_data_test.go
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//------------------------------------------------------------------------------
+
+func userData_encode(tb testing.TB, udt tUserDatas) (data []byte) {
+
+	// Writes the Users as the User-Data File does.
+
+	var buf bytes.Buffer
+	var uid uint64
+	var ud tUserData
+
+	for uid, ud = range udt {
+		if !userData_write(&ud, uid, &buf) {
+			tb.Fatalf("user %d can not be written", uid)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+//------------------------------------------------------------------------------
+
+func TestUserDataDecode(t *testing.T) {
+
+	// Good Data is decoded whole, broken Data is refused.
+
+	var users, many tUserDatas
+	var data []byte
+	var ud *tUserDatas
+	var i uint64
+
+	users = tUserDatas{
+		1:    {name: "System", pwd: "\x00\xff secret", reg_time: 1500000000},
+		42:   {name: "Алиса", pwd: "", reg_time: 1600000000},
+		1234: {name: "", pwd: strings.Repeat("p", 255), reg_time: -1},
+	}
+	data = userData_encode(t, users)
+
+	ud = userData_decode(bytes.NewReader(data), "test")
+	if (ud == nil) || !reflect.DeepEqual(*ud, users) {
+		t.Fatalf("decoded %v, want %v", ud, users)
+	}
+
+	ud = userData_decode(bytes.NewReader(nil), "test")
+	if (ud == nil) || (len(*ud) != 0) {
+		t.Fatalf("empty data: decoded %v", ud)
+	}
+
+	// Cut anywhere inside a Record
+	harness_quiet(t)
+	for i = 1; i < 8+8+1+1+1; i++ {
+		ud = userData_decode(bytes.NewReader(data[:i]), "test")
+		if ud != nil {
+			t.Errorf("data cut at %d is decoded: %v", i, *ud)
+		}
+	}
+
+	// Records across the Buffer of a small Reader
+	many = make(tUserDatas)
+	for i = 100; i < 200; i++ {
+		many[i] = tUserData{name: strings.Repeat("n", int(i)), pwd: strings.Repeat("p", 255-int(i)), reg_time: int64(i)}
+	}
+	ud = userData_decode(bufio.NewReaderSize(bytes.NewReader(userData_encode(t, many)), 16), "test")
+	if (ud == nil) || !reflect.DeepEqual(*ud, many) {
+		t.Fatal("records across the buffer are not decoded")
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func FuzzUserDataDecode(f *testing.F) {
+
+	// Whatever is on the Disk, Decoding does not panic, and what is decoded
+	// is written back the same.
+
+	f.Add(userData_encode(f, tUserDatas{1: {name: "System", pwd: "x", reg_time: 1}}))
+	f.Add(userData_encode(f, tUserDatas{7: {name: "Bob", pwd: "", reg_time: 0}, 8: {name: "", pwd: "y", reg_time: -5}}))
+	f.Add([]byte{})
+	f.Add([]byte{1, 2, 3})
+	f.Add(bytes.Repeat([]byte{0xff}, 40))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+
+		var ud, again *tUserDatas
+
+		harness_quiet(t)
+		ud = userData_decode(bytes.NewReader(data), "fuzz")
+		if ud == nil {
+			return
+		}
+
+		again = userData_decode(bytes.NewReader(userData_encode(t, *ud)), "fuzz")
+		if (again == nil) || !reflect.DeepEqual(*again, *ud) {
+			t.Fatalf("decoded %v, written and decoded again %v", *ud, again)
+		}
+	})
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,679 @@
+// webhook.go
+
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+/*
+
+	Outgoing Webhooks.
+
+	On Chat Events the Server POSTs a JSON Object to the configured URLs.
+	The Configuration File has one Hook per Line, empty Lines and Lines
+	starting with '#' are ignored:
+
+		<URL> <Secret> [<Event>,<Event>,...]
+
+	Events are "message", "join", "leave" and "register". Without a List a
+	Hook gets all Events.
+
+	The Body is signed with HMAC-SHA256 using the Hook's Secret. The Receiver
+	checks the Signature in the Header:
+
+		X-Saga-Signature: sha256=<hex>
+
+	Each Delivery is saved into the Queue Directory before it is sent, so
+	that it survives a Restart. A failed Delivery is retried with growing
+	Delays. The Queue is bounded: when it is full, the oldest Delivery is
+	dropped.
+
+	Each Hook gets its Deliveries in their Order, one at a Time. Hooks do
+	not wait for each other: while a Receiver is slow or its oldest
+	Delivery waits for the next Attempt, other Hooks are served.
+
+	Events are reported by the Chat's Managers and Pages, which must never
+	wait for a slow Receiver. If the Webhook Manager can not keep up, Events
+	are dropped and this is logged.
+
+*/
+
+//------------------------------------------------------------------------------
+
+// Configured Hook
+type tWebhook struct {
+	url    string
+	secret string
+	events map[string]bool // nil = all Events
+}
+
+// Event, as it is sent to the Receiver
+type tWebhookEvent struct {
+	V       int          `json:"v"`
+	Event   string       `json:"event"`
+	Ts      int64        `json:"ts"`
+	Uid     string       `json:"uid"`
+	Name    string       `json:"name"`
+	Reason  string       `json:"reason,omitempty"` // "logout" or "timeout" for "leave"
+	Message *tApiMessage `json:"message,omitempty"`
+	Text    string       `json:"text,omitempty"` // Raw Text of the Message
+}
+
+// Delivery of an Event to one Hook, as it is kept in the Queue
+type tWebhookDelivery struct {
+	Id       string `json:"id"`
+	Url      string `json:"url"`
+	Event    string `json:"event"`
+	Body     string `json:"body"`
+	Created  int64  `json:"created"`
+	Seq      uint64 `json:"seq"` // Order of Creation, "created" has only Seconds
+	Attempts int    `json:"attempts"`
+	Next     int64  `json:"next"` // Time of the next Attempt, Unix Timestamp
+}
+
+type tWebhookResult struct {
+	id  string
+	url string
+	ok  bool
+}
+
+//------------------------------------------------------------------------------
+
+const webhookEventMessage = "message"
+const webhookEventJoin = "join"
+const webhookEventLeave = "leave"
+const webhookEventRegister = "register"
+const webhookReasonLogout = "logout"
+const webhookReasonTimeout = "timeout"
+
+const webhook_queueDir_default = "dat/hook" // Directory of the Delivery Queue
+const webhook_queueMax = 1024               // Maximum Number of queued Deliveries
+const webhook_maxAttempts = 10              // Deliveries are dropped after so many Attempts
+const webhook_retryBase = 5                 // Delay after the first failed Attempt, in Seconds
+const webhook_retryMax = 3600               // Maximum Delay between Attempts, in Seconds
+const webhook_timeout = 10                  // Timeout of one Attempt, in Seconds
+const webhook_tick = 1                      // Interval of Queue Checks, in Seconds
+const webhookChanBufferLen = 256            // Buffer Length of the Webhook Manager's Channel
+const webhook_header = "X-Saga-Signature"   // Header with the Signature
+const webhook_headerEvent = "X-Saga-Event"  // Header with the Event's Name
+const webhook_headerId = "X-Saga-Delivery"  // Header with the Delivery's ID
+const webhook_fileExt = ".json"             // Extension of queued Deliveries
+
+//------------------------------------------------------------------------------
+
+// Lists
+var webhookList []tWebhook
+var webhookQueue map[string]*tWebhookDelivery // Key = ID
+
+// Internal Parameters
+var file_webhooks string
+var webhook_queueDir string
+var webhook_client *http.Client
+var webhook_seq uint64 // Number of the last created Delivery
+
+// Channels
+var webhookChan chan tWebhookEvent
+var webhookResultChan chan tWebhookResult
+var webhookPingChan chan chan bool // Health Check
+var webhookManagerQuit chan int
+
+//------------------------------------------------------------------------------
+
+func webhook_init() (ok bool) {
+
+	// Reads the Configuration and the Queue left from the previous Run.
+	// Webhooks are off when no Configuration File is set.
+
+	webhookQueue = make(map[string]*tWebhookDelivery)
+	webhook_client = &http.Client{Timeout: webhook_timeout * time.Second}
+
+	if len(file_webhooks) == 0 {
+		return true
+	}
+
+	ok = webhook_readConfig()
+	if !ok {
+		return false
+	}
+
+	return webhook_readQueue()
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_readConfig() (ok bool) {
+
+	// Reads the List of Hooks.
+
+	var file *os.File
+	var scanner *bufio.Scanner
+	var line, ev string
+	var fields []string
+	var hook *tWebhook
+	var n int // Number of the Line, the Line itself has the Secret
+	var err error
+
+	file, err = os.Open(file_webhooks)
+	if err != nil {
+		log_error("", "Error opening webhook config", "file", file_webhooks, "err", err) //
+		return false
+	}
+	defer file.Close()
+
+	scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+
+		n++
+		line = strings.TrimSpace(scanner.Text())
+		if (len(line) == 0) || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields = strings.Fields(line)
+		if (len(fields) < 2) || (len(fields) > 3) {
+			log_error("", "Bad line in webhook config", "file", file_webhooks, "line", n) //
+			return false
+		}
+		if !strings.HasPrefix(fields[0], "http://") && !strings.HasPrefix(fields[0], "https://") {
+			log_error("", "Bad URL in webhook config", "file", file_webhooks, "line", n) //
+			return false
+		}
+
+		hook = new(tWebhook)
+		hook.url = fields[0]
+		hook.secret = fields[1]
+		if len(fields) == 3 {
+			hook.events = make(map[string]bool)
+			for _, ev = range strings.Split(fields[2], ",") {
+				switch ev {
+				case webhookEventMessage, webhookEventJoin, webhookEventLeave, webhookEventRegister:
+					hook.events[ev] = true
+				default:
+					log_error("", "Unknown event in webhook config", "file", file_webhooks, "line", n, "event", ev) //
+					return false
+				}
+			}
+		}
+		webhookList = append(webhookList, *hook)
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		log_error("", "Error reading webhook config", "file", file_webhooks, "err", err) //
+		return false
+	}
+
+	log_info("", "Webhooks configured", "count", len(webhookList)) //
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_readQueue() (ok bool) {
+
+	// Loads the Deliveries which were not done before the Restart.
+
+	var files []os.FileInfo
+	var fi os.FileInfo
+	var data []byte
+	var d *tWebhookDelivery
+	var err error
+
+	err = os.MkdirAll(webhook_queueDir, 0700)
+	if err != nil {
+		log_error("", "Error creating webhook queue directory", "dir", webhook_queueDir, "err", err) //
+		return false
+	}
+
+	files, err = ioutil.ReadDir(webhook_queueDir)
+	if err != nil {
+		log_error("", "Error reading webhook queue directory", "dir", webhook_queueDir, "err", err) //
+		return false
+	}
+
+	for _, fi = range files {
+
+		if !strings.HasSuffix(fi.Name(), webhook_fileExt) {
+			continue
+		}
+		data, err = ioutil.ReadFile(filepath.Join(webhook_queueDir, fi.Name()))
+		if err != nil {
+			log_error("", "Error reading queued delivery", "dir", webhook_queueDir, "file", fi.Name(), "err", err) //
+			continue
+		}
+		d = new(tWebhookDelivery)
+		err = json.Unmarshal(data, d)
+		if (err != nil) || (d.Id+webhook_fileExt != fi.Name()) {
+			log_error("", "Bad queued delivery", "dir", webhook_queueDir, "file", fi.Name(), "err", err) //
+			continue
+		}
+		webhookQueue[d.Id] = d
+		if d.Seq > webhook_seq {
+			webhook_seq = d.Seq
+		}
+
+		// Hooks may have been changed since the Delivery was created
+		_, ok = webhook_secret(d.Url)
+		if !ok {
+			webhook_drop(d.Id, "hook is not configured any more")
+		}
+	}
+
+	if len(webhookQueue) > 0 {
+		log_info("", "Webhook deliveries queued", "count", len(webhookQueue)) //
+	}
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_emit(event string, uid uint64, reason string, mid uint16, isMessage bool) {
+
+	// Reports an Event to the Webhook Manager.
+	// Never waits: if the Manager is busy, the Event is dropped.
+
+	var ev tWebhookEvent
+	var msg tApiMessage
+
+	if len(webhookList) == 0 {
+		return
+	}
+
+	ev.V = api_version
+	ev.Event = event
+	ev.Ts = time.Now().Unix()
+	ev.Uid = strconv.FormatUint(uid, 10)
+	ev.Name = user_name(uid)
+	ev.Reason = reason
+	if isMessage {
+		msg = api_message(mid)
+		ev.Message = &msg
+		ev.Text = chatRecordsList[mid].text
+		ev.Ts = chatRecordsList[mid].time
+	}
+
+	select {
+	case webhookChan <- ev:
+	default:
+		log_warn("", "Webhook manager is busy, event dropped", "event", event, "uid", uid) //
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func webhookManager() {
+
+	// Turns Events into Deliveries and sends them, one at a Time for each
+	// Hook.
+
+	var loop bool = true
+	var ev tWebhookEvent
+	var result tWebhookResult
+	var ticker *time.Ticker
+	var busy map[string]bool // URLs of Hooks which are being sent a Delivery
+	var pong chan bool
+
+	busy = make(map[string]bool)
+	ticker = time.NewTicker(webhook_tick * time.Second)
+	defer ticker.Stop()
+
+	for loop {
+
+		select {
+
+		case ev = <-webhookChan:
+			webhook_enqueue(&ev)
+
+		case <-ticker.C:
+			webhook_start(busy)
+
+		case result = <-webhookResultChan:
+			delete(busy, result.url)
+			webhook_done(result)
+			webhook_start(busy)
+
+		case pong = <-webhookPingChan:
+			pong <- true
+
+		case <-webhookManagerQuit:
+			loop = false
+			log_info("", "Closing Webhook Manager...") //
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_enqueue(ev *tWebhookEvent) {
+
+	// Creates a Delivery for each Hook which wants the Event.
+
+	var body []byte
+	var hook tWebhook
+	var d *tWebhookDelivery
+	var now int64
+	var err error
+
+	body, err = json.Marshal(ev)
+	if err != nil {
+		log_error("", "Error encoding webhook event", "err", err) //
+		return
+	}
+
+	now = time.Now().Unix()
+	for _, hook = range webhookList {
+
+		if (hook.events != nil) && !hook.events[ev.Event] {
+			continue
+		}
+
+		// Bounded Queue
+		if len(webhookQueue) >= webhook_queueMax {
+			webhook_drop(webhook_oldest(), "queue is full")
+		}
+
+		webhook_seq++
+		d = new(tWebhookDelivery)
+		d.Id = fmt.Sprintf("%016x", generateRandomUint64())
+		d.Url = hook.url
+		d.Event = ev.Event
+		d.Body = string(body)
+		d.Created = now
+		d.Seq = webhook_seq
+		d.Next = now
+		webhookQueue[d.Id] = d
+		webhook_save(d)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_start(busy map[string]bool) {
+
+	// Starts the due Deliveries of the Hooks which are not busy.
+
+	var d *tWebhookDelivery
+
+	for _, d = range webhook_due(busy) {
+		busy[d.Url] = true
+		go func(d tWebhookDelivery) {
+			webhookResultChan <- tWebhookResult{d.Id, d.Url, webhook_send(&d)}
+		}(*d)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_due(busy map[string]bool) (due []*tWebhookDelivery) {
+
+	// Finds the Deliveries which should be sent now: the oldest one of each
+	// Hook which is not busy, if its Time has come. A Hook whose oldest
+	// Delivery waits for the next Attempt gets nothing, so its Order is
+	// kept.
+
+	var oldest map[string]*tWebhookDelivery // Key = URL
+	var d, o *tWebhookDelivery
+	var now int64
+
+	oldest = make(map[string]*tWebhookDelivery)
+	for _, d = range webhookQueue {
+		if busy[d.Url] {
+			continue
+		}
+		o = oldest[d.Url]
+		if (o == nil) || webhook_before(d, o) {
+			oldest[d.Url] = d
+		}
+	}
+
+	now = time.Now().Unix()
+	for _, d = range oldest {
+		if d.Next <= now {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return webhook_before(due[i], due[j])
+	})
+
+	return due
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_before(a, b *tWebhookDelivery) bool {
+
+	// Tells whether the Delivery "a" was created before "b".
+
+	if a.Created != b.Created {
+		return a.Created < b.Created
+	}
+	return a.Seq < b.Seq
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_oldest() (id string) {
+
+	// ID of the oldest Delivery in the Queue.
+
+	var d, o *tWebhookDelivery
+
+	for _, d = range webhookQueue {
+		if (o == nil) || webhook_before(d, o) {
+			o = d
+		}
+	}
+	if o == nil {
+		return ""
+	}
+
+	return o.Id
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_send(d *tWebhookDelivery) (ok bool) {
+
+	// Makes one Attempt of the Delivery.
+
+	var req *http.Request
+	var resp *http.Response
+	var secret string
+	var found bool
+	var urlErr *url.Error
+	var err error
+
+	// The Secret is not saved in the Queue
+	secret, found = webhook_secret(d.Url)
+	if !found {
+		return false
+	}
+
+	req, err = http.NewRequest(http.MethodPost, d.Url, strings.NewReader(d.Body))
+	if err != nil {
+		log_error("", "Error creating webhook request", "host", webhook_host(d.Url), "err", err) //
+		return false
+	}
+	req.Header.Set("Content-Type", api_contentJSON)
+	req.Header.Set(webhook_header, "sha256="+webhook_sign(secret, []byte(d.Body)))
+	req.Header.Set(webhook_headerEvent, d.Event)
+	req.Header.Set(webhook_headerId, d.Id)
+
+	resp, err = webhook_client.Do(req)
+	if err != nil {
+		// The Error has the whole URL, which may be secret
+		urlErr, found = err.(*url.Error)
+		if found {
+			err = urlErr.Err
+		}
+		log_warn("", "Webhook delivery failed", "host", webhook_host(d.Url), "delivery", d.Id, "err", err) //
+		return false
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+
+	if (resp.StatusCode < 200) || (resp.StatusCode > 299) {
+		log_warn("", "Webhook delivery failed", "host", webhook_host(d.Url), "delivery", d.Id, "status", resp.StatusCode) //
+		return false
+	}
+
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_secret(url string) (secret string, found bool) {
+
+	// Secret of the configured Hook with this URL.
+
+	var hook tWebhook
+
+	for _, hook = range webhookList {
+		if hook.url == url {
+			return hook.secret, true
+		}
+	}
+
+	return "", false
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_host(rawUrl string) string {
+
+	// Host of the Hook for the Log. Paths and Queries of Hooks often have
+	// Secrets, so they are never logged.
+
+	var u *url.URL
+	var err error
+
+	u, err = url.Parse(rawUrl)
+	if err != nil {
+		return "?"
+	}
+
+	return u.Host
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_sign(secret string, body []byte) (signature string) {
+
+	// HMAC-SHA256 of the Body, hex.
+
+	var mac hash.Hash
+
+	mac = hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_done(result tWebhookResult) {
+
+	// Removes a delivered Delivery or plans the next Attempt.
+
+	var d *tWebhookDelivery
+	var exists bool
+	var delay int64
+	var i int
+
+	d, exists = webhookQueue[result.id]
+	if !exists {
+		return // dropped while it was being sent
+	}
+
+	if result.ok {
+		webhook_drop(d.Id, "")
+		return
+	}
+
+	d.Attempts++
+	if d.Attempts >= webhook_maxAttempts {
+		webhook_drop(d.Id, "too many attempts")
+		return
+	}
+
+	// Exponential Backoff
+	delay = webhook_retryBase
+	for i = 1; (i < d.Attempts) && (delay < webhook_retryMax); i++ {
+		delay *= 2
+	}
+	if delay > webhook_retryMax {
+		delay = webhook_retryMax
+	}
+	d.Next = time.Now().Unix() + delay
+	webhook_save(d)
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_drop(id string, reason string) {
+
+	// Removes the Delivery from the Queue and from the Disk.
+
+	var err error
+
+	if len(reason) > 0 {
+		log_warn("", "Webhook delivery dropped", "host", webhook_host(webhookQueue[id].Url), "delivery", id, "reason", reason) //
+	}
+
+	delete(webhookQueue, id)
+	err = os.Remove(filepath.Join(webhook_queueDir, id+webhook_fileExt))
+	if (err != nil) && !os.IsNotExist(err) {
+		log_error("", "Error removing queued delivery", "delivery", id, "err", err) //
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_save(d *tWebhookDelivery) {
+
+	// Writes the Delivery into the Queue Directory.
+
+	var data []byte
+	var path, tmp string
+	var err error
+
+	data, err = json.Marshal(d)
+	if err != nil {
+		log_error("", "Error encoding delivery", "err", err) //
+		return
+	}
+
+	// Write into a temporary File, then rename, so that a half-written File
+	// is never read after a Restart.
+	path = filepath.Join(webhook_queueDir, d.Id+webhook_fileExt)
+	tmp = path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		log_error("", "Error writing delivery", "file", tmp, "err", err) //
+		return
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		log_error("", "Error renaming delivery", "file", tmp, "err", err) //
+	}
+}
+
+//------------------------------------------------------------------------------
@@ -0,0 +1,405 @@
+// webhook_test.go
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+
+// Request which a Receiver has got
+type tTestHookCall struct {
+	header http.Header
+	body   []byte
+}
+
+// Receiver of Webhooks, it answers with the Statuses in Turn, then with 200
+type tTestReceiver struct {
+	server   *httptest.Server
+	lock     sync.Mutex
+	calls    []tTestHookCall
+	statuses []int
+	block    chan int // Answers wait until it is closed, if it is set
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_testSetup(t *testing.T, urls ...string) {
+
+	// Stops the Webhook Manager, so the Test works with the Queue alone, and
+	// configures a Hook for each URL with the Secret "secret-<N>". The Queue
+	// is in a temporary Directory. The Manager is started again when the
+	// Test ends. The other Managers read the List of Hooks, they are pinged
+	// around the Changes to order them.
+
+	var queueDir string
+	var i int
+
+	health_check()
+	webhookManagerQuit <- 1
+	queueDir = webhook_queueDir
+
+	webhookList = nil
+	for i = 0; i < len(urls); i++ {
+		webhookList = append(webhookList, tWebhook{url: urls[i], secret: "secret-" + strconv.Itoa(i)})
+	}
+	webhookQueue = make(map[string]*tWebhookDelivery)
+	webhook_queueDir = t.TempDir()
+	webhook_seq = 0
+
+	t.Cleanup(func() {
+		webhookList = nil
+		webhookQueue = make(map[string]*tWebhookDelivery)
+		webhook_queueDir = queueDir
+		go webhookManager()
+		health_check()
+	})
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_testReceiver(t *testing.T, block chan int, statuses ...int) (r *tTestReceiver) {
+
+	// Starts a Receiver which records the Requests.
+
+	r = new(tTestReceiver)
+	r.statuses = statuses
+	r.block = block
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		var body []byte
+		var status int = http.StatusOK
+
+		body, _ = ioutil.ReadAll(req.Body)
+		r.lock.Lock()
+		r.calls = append(r.calls, tTestHookCall{req.Header.Clone(), body})
+		if len(r.statuses) > 0 {
+			status = r.statuses[0]
+			r.statuses = r.statuses[1:]
+		}
+		r.lock.Unlock()
+		if r.block != nil {
+			<-r.block
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.server.Close)
+
+	return r
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_testEvent(text string) (ev *tWebhookEvent) {
+
+	// Makes a Message Event.
+
+	ev = new(tWebhookEvent)
+	ev.V = api_version
+	ev.Event = webhookEventMessage
+	ev.Ts = time.Now().Unix()
+	ev.Uid = "1"
+	ev.Name = "Alice"
+	ev.Text = text
+
+	return ev
+}
+
+//------------------------------------------------------------------------------
+
+func webhook_testFiles(t *testing.T) (count int) {
+
+	// Counts the queued Deliveries on the Disk.
+
+	var names []string
+	var err error
+
+	names, err = filepath.Glob(filepath.Join(webhook_queueDir, "*"+webhook_fileExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(names)
+}
+
+//------------------------------------------------------------------------------
+
+func TestWebhookSignature(t *testing.T) {
+
+	// The Receiver can check the Body with the Secret of its Hook.
+	// A Hook gets only the Events of its List.
+
+	var r *tTestReceiver
+	var due []*tWebhookDelivery
+	var mac hash.Hash
+	var ev tWebhookEvent
+	var err error
+
+	r = webhook_testReceiver(t, nil)
+	webhook_testSetup(t, r.server.URL+"/hook", "http://127.0.0.1:1/joins")
+	webhookList[1].events = map[string]bool{webhookEventJoin: true}
+
+	webhook_enqueue(webhook_testEvent("hello, hooks"))
+	due = webhook_due(map[string]bool{})
+	if (len(due) != 1) || (due[0].Url != webhookList[0].url) {
+		t.Fatalf("due deliveries: %v", due)
+	}
+	if !webhook_send(due[0]) {
+		t.Fatal("delivery failed")
+	}
+
+	if len(r.calls) != 1 {
+		t.Fatalf("%d calls, want 1", len(r.calls))
+	}
+	mac = hmac.New(sha256.New, []byte("secret-0"))
+	mac.Write(r.calls[0].body)
+	if r.calls[0].header.Get(webhook_header) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("signature %q does not match the body", r.calls[0].header.Get(webhook_header))
+	}
+	if (r.calls[0].header.Get(webhook_headerEvent) != webhookEventMessage) || (r.calls[0].header.Get(webhook_headerId) != due[0].Id) {
+		t.Errorf("headers: %v", r.calls[0].header)
+	}
+	err = json.Unmarshal(r.calls[0].body, &ev)
+	if (err != nil) || (ev.Text != "hello, hooks") {
+		t.Errorf("body: %s", r.calls[0].body)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestWebhookRetry(t *testing.T) {
+
+	// A Delivery which got 5xx is sent again after growing Delays, and is
+	// removed when it is delivered.
+
+	var r *tTestReceiver
+	var d *tWebhookDelivery
+	var delays []int64
+	var delay int64
+	var ok bool
+	var i int
+
+	harness_quiet(t)
+	r = webhook_testReceiver(t, nil, http.StatusServiceUnavailable, http.StatusInternalServerError)
+	webhook_testSetup(t, r.server.URL)
+
+	webhook_enqueue(webhook_testEvent("retry me"))
+	delays = []int64{webhook_retryBase, webhook_retryBase * 2}
+
+	for i = 0; i < 3; i++ {
+
+		if len(webhook_due(map[string]bool{})) != 1 {
+			t.Fatalf("attempt #%d: delivery is not due", i+1)
+		}
+		d = webhook_due(map[string]bool{})[0]
+		ok = webhook_send(d)
+		webhook_done(tWebhookResult{d.Id, d.Url, ok})
+		if i == 2 {
+			break
+		}
+
+		if ok {
+			t.Fatalf("attempt #%d: 5xx is taken as delivered", i+1)
+		}
+		if d.Attempts != i+1 {
+			t.Errorf("attempt #%d: %d attempts are counted", i+1, d.Attempts)
+		}
+		delay = d.Next - time.Now().Unix()
+		if (delay < delays[i]-1) || (delay > delays[i]) {
+			t.Errorf("attempt #%d: next one in %ds, want %ds", i+1, delay, delays[i])
+		}
+		if len(webhook_due(map[string]bool{})) != 0 {
+			t.Errorf("attempt #%d: delivery is due before its delay", i+1)
+		}
+		d.Next = time.Now().Unix() // the Delay is over
+	}
+
+	if !ok {
+		t.Fatal("last attempt failed")
+	}
+	if len(r.calls) != 3 {
+		t.Errorf("%d calls, want 3", len(r.calls))
+	}
+	if (len(webhookQueue) != 0) || (webhook_testFiles(t) != 0) {
+		t.Error("delivered delivery is still queued")
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestWebhookQueueBound(t *testing.T) {
+
+	// A full Queue drops its oldest Delivery for a new one, also on the Disk.
+
+	var first string
+	var i int
+
+	harness_quiet(t)
+	webhook_testSetup(t, "http://127.0.0.1:1/")
+
+	for i = 0; i <= webhook_queueMax; i++ {
+		webhook_enqueue(webhook_testEvent("#" + strconv.Itoa(i)))
+		if i == 0 {
+			first = webhook_oldest()
+		}
+	}
+
+	if len(webhookQueue) != webhook_queueMax {
+		t.Fatalf("%d deliveries are queued, want %d", len(webhookQueue), webhook_queueMax)
+	}
+	if webhookQueue[first] != nil {
+		t.Error("oldest delivery is kept")
+	}
+	if webhook_testFiles(t) != webhook_queueMax {
+		t.Errorf("%d deliveries are on the disk, want %d", webhook_testFiles(t), webhook_queueMax)
+	}
+	if webhookQueue[webhook_oldest()].Seq != 2 {
+		t.Errorf("oldest delivery is #%d, want #2", webhookQueue[webhook_oldest()].Seq)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestWebhookReload(t *testing.T) {
+
+	// Deliveries survive a Restart with their Attempts. Deliveries of Hooks
+	// which are not configured any more and broken Files are dropped.
+
+	var before map[string]tWebhookDelivery
+	var d *tWebhookDelivery
+	var gone tWebhookDelivery
+	var data []byte
+	var id string
+	var err error
+
+	harness_quiet(t)
+	webhook_testSetup(t, "http://127.0.0.1:1/a", "http://127.0.0.1:1/b")
+
+	webhook_enqueue(webhook_testEvent("one"))
+	webhook_enqueue(webhook_testEvent("two"))
+	d = webhook_due(map[string]bool{})[0]
+	webhook_done(tWebhookResult{d.Id, d.Url, false})
+
+	before = make(map[string]tWebhookDelivery)
+	for id, d = range webhookQueue {
+		before[id] = *d
+	}
+
+	gone = tWebhookDelivery{Id: "00000000000000ff", Url: "http://127.0.0.1:1/gone", Event: webhookEventMessage, Seq: 99}
+	data, _ = json.Marshal(gone)
+	err = ioutil.WriteFile(filepath.Join(webhook_queueDir, gone.Id+webhook_fileExt), data, 0600)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(webhook_queueDir, "broken"+webhook_fileExt), []byte("{"), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Restart
+	webhookQueue = make(map[string]*tWebhookDelivery)
+	webhook_seq = 0
+	if !webhook_readQueue() {
+		t.Fatal("queue is not read")
+	}
+
+	if len(webhookQueue) != len(before) {
+		t.Fatalf("%d deliveries are read, want %d", len(webhookQueue), len(before))
+	}
+	for id = range before {
+		if (webhookQueue[id] == nil) || (*webhookQueue[id] != before[id]) {
+			t.Errorf("delivery %s is read as %v, want %v", id, webhookQueue[id], before[id])
+		}
+	}
+	_, err = os.Stat(filepath.Join(webhook_queueDir, gone.Id+webhook_fileExt))
+	if !os.IsNotExist(err) {
+		t.Error("delivery of an unknown hook is kept on the disk")
+	}
+
+	// New Deliveries go after the read ones
+	webhook_enqueue(webhook_testEvent("three"))
+	if webhook_seq <= gone.Seq {
+		t.Errorf("new delivery is #%d, not after #%d", webhook_seq, gone.Seq)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestWebhookDeadHook(t *testing.T) {
+
+	// A Hook whose Receiver hangs or which waits for the next Attempt does
+	// not delay the other Hooks.
+
+	var dead, good *tTestReceiver
+	var block chan int
+	var busy map[string]bool
+	var due []*tWebhookDelivery
+	var result tWebhookResult
+	var d *tWebhookDelivery
+
+	harness_quiet(t)
+	block = make(chan int)
+	dead = webhook_testReceiver(t, block)
+	t.Cleanup(func() {
+		select {
+		case <-block:
+		default:
+			close(block)
+		}
+	})
+	good = webhook_testReceiver(t, nil)
+	webhook_testSetup(t, dead.server.URL, good.server.URL)
+
+	webhook_enqueue(webhook_testEvent("one"))
+
+	// Hanging Receiver
+	busy = make(map[string]bool)
+	webhook_start(busy)
+	select {
+	case result = <-webhookResultChan:
+	case <-time.After(webhook_timeout / 2 * time.Second):
+		t.Fatal("good hook waits for the dead one")
+	}
+	if (result.url != good.server.URL) || !result.ok {
+		t.Errorf("result is %v, want delivered to the good hook", result)
+	}
+	delete(busy, result.url)
+	webhook_done(result)
+	close(block)
+	result = <-webhookResultChan
+	delete(busy, result.url)
+	webhook_done(tWebhookResult{result.id, result.url, false})
+
+	// Backing off: the Dead Hook's oldest Delivery is not due, so the newer
+	// one waits too
+	webhook_enqueue(webhook_testEvent("two"))
+	due = webhook_due(busy)
+	if (len(due) != 1) || (due[0].Url != good.server.URL) {
+		t.Fatalf("due deliveries: %v", due)
+	}
+
+	// Busy
+	for _, d = range webhookQueue {
+		d.Next = 0
+	}
+	busy[good.server.URL] = true
+	due = webhook_due(busy)
+	if (len(due) != 1) || (due[0].Url != dead.server.URL) || (due[0].Attempts != 1) {
+		t.Errorf("due deliveries: %v", due)
+	}
+}
+
+//------------------------------------------------------------------------------